@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fritzrepo/stockportfolio/internal/config"
 	"github.com/fritzrepo/stockportfolio/internal/portfolio"
 	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
 )
 
 func main() {
@@ -14,6 +25,25 @@ func main() {
 	var fillDb = false
 	var compute = false
 	var readTransaktions = false
+	var readRealizedGains = false
+	var allocation = false
+	var rebalance = false
+	var updateTransaction = false
+	var removeTransaction = false
+	var holdings = false
+	var migrate = false
+	var audit = false
+	var backup = false
+	var restore = false
+	var encrypt = false
+	var rotateKey = false
+	var verify = false
+	var validate = false
+	// Optionen werden als key=value angegeben, z.B. cash=1000
+	options := make(map[string]string)
+	//Mit Strg+C werden laufende Store-Zugriffe abgebrochen und die Store-Transaktion zurückgerollt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	// the first argument is always program name
 	argLength := len(os.Args[1:])
@@ -30,9 +60,66 @@ func main() {
 		if a == "readTransactions" {
 			readTransaktions = true
 		}
+		if a == "readRealizedGains" {
+			readRealizedGains = true
+		}
 		if a == "compute" {
 			compute = true
 		}
+		if a == "allocation" {
+			allocation = true
+		}
+		if a == "rebalance" {
+			rebalance = true
+		}
+		if a == "updateTransaction" {
+			updateTransaction = true
+		}
+		if a == "removeTransaction" {
+			removeTransaction = true
+		}
+		if a == "holdings" {
+			holdings = true
+		}
+		if a == "migrate" {
+			migrate = true
+		}
+		if a == "audit" {
+			audit = true
+		}
+		if a == "backup" {
+			backup = true
+		}
+		if a == "restore" {
+			restore = true
+		}
+		if a == "encrypt" {
+			encrypt = true
+		}
+		if a == "rotateKey" {
+			rotateKey = true
+		}
+		if a == "verify" {
+			verify = true
+		}
+		if a == "validate" {
+			validate = true
+		}
+		if a == "status" || a == "up" || a == "down" {
+			options["migrate"] = a
+		}
+		if key, value, found := strings.Cut(a, "="); found {
+			options[key] = value
+		}
+		if a == "noSell" {
+			options["noSell"] = "true"
+		}
+		if a == "desc" {
+			options["desc"] = "true"
+		}
+		if a == "repair" {
+			options["repair"] = "true"
+		}
 	}
 
 	config, err := config.LoadConfigFromJSON("../../configs/appConfig.json")
@@ -42,10 +129,31 @@ func main() {
 		panic(err)
 	}
 
+	//Bestehende Datenbanken werden beim Start automatisch auf das aktuelle Schema gebracht
+	if !buildDb && !migrate && !restore && !encrypt && !rotateKey {
+		upgradeDatabase(ctx, config)
+	}
+
+	if migrate {
+		store := openStore(config)
+		defer store.Close()
+		migrator, ok := store.(storage.Migrator)
+		if !ok {
+			panic("database does not support migrations")
+		}
+		err := runMigration(ctx, migrator, options)
+		if err != nil {
+			fmt.Println("Error migrating database")
+			panic(err)
+		}
+		return
+	}
+
 	if buildDb {
 		fmt.Println("Building database")
-		store := storage.GetFileDatabase(config.DatabaseFilePath)
-		err := store.CreateDatabase()
+		store := openStore(config)
+		defer store.Close()
+		err := store.CreateDatabase(ctx)
 		if err != nil {
 			fmt.Println("Database not created or already exists")
 			panic(err)
@@ -53,54 +161,195 @@ func main() {
 		fmt.Println("Database created")
 	}
 
+	if validate {
+		store := openTransactionFile(config)
+		transactions, err := store.ReadAllTransactions(ctx)
+		if err != nil {
+			fmt.Println("Error loading transactions")
+			panic(err)
+		}
+		dbStore := openStore(config)
+		defer dbStore.Close()
+		err = validateImport(ctx, config, dbStore, transactions)
+		if err != nil {
+			fmt.Printf("Invalid transactions in %s:\n%v\n", config.TransactionFilePath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d transactions are valid\n", len(transactions))
+		return
+	}
+
 	if fillDb {
 		fmt.Println("Fill up database")
-		store := storage.GetCsvStorage(config.TransactionFilePath)
-		transactions, err := store.ReadAllTransactions()
+		store := openTransactionFile(config)
+		transactions, err := store.ReadAllTransactions(ctx)
 		if err != nil {
 			// Fehlerbehandlung
 			fmt.Println("Error loading transactions")
 			panic(err)
 		}
 
-		dbStore := storage.GetFileDatabase(config.DatabaseFilePath)
+		dbStore := openStore(config)
+		defer dbStore.Close()
+
+		//Es wird nur importiert, wenn alle Transaktionen gültig sind
+		err = validateImport(ctx, config, dbStore, transactions)
+		if err != nil {
+			fmt.Println("Invalid transactions, nothing imported")
+			panic(err)
+		}
 
 		for _, transaction := range transactions {
 			fmt.Println(transaction)
-			err := dbStore.AddTransaction(&transaction)
+			err := dbStore.AddTransaction(ctx, &transaction)
 			if err != nil {
 				fmt.Println("Database not created or already exists")
 				panic(err)
 			}
 			fmt.Println("Inserted transaction")
 		}
+		if auditLog, ok := dbStore.(storage.AuditLog); ok {
+			details, _ := json.Marshal(map[string]any{"file": config.TransactionFilePath, "count": len(transactions)})
+			err = auditLog.AddAuditEntry(ctx, &storage.AuditEntry{Operation: "Import", Entity: "transaction", After: details})
+			if err != nil {
+				fmt.Println("Error writing audit log")
+				panic(err)
+			}
+		}
+	}
+
+	if backup {
+		err := writeBackup(ctx, config, options)
+		if err != nil {
+			fmt.Println("Error writing backup")
+			panic(err)
+		}
+		return
+	}
+
+	if restore {
+		err := restoreBackup(ctx, config, options)
+		if err != nil {
+			fmt.Println("Error restoring backup")
+			panic(err)
+		}
+		return
+	}
+
+	if verify {
+		consistent, err := verifyStore(ctx, config, options["repair"] == "true")
+		if err != nil {
+			fmt.Println("Error verifying store")
+			panic(err)
+		}
+		//Für den nächtlichen Lauf: Exit-Code 1, wenn Abweichungen nicht behoben wurden
+		if !consistent {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if encrypt {
+		err := encryptDatabase(config, options)
+		if err != nil {
+			fmt.Println("Error encrypting database")
+			panic(err)
+		}
+		return
+	}
+
+	if rotateKey {
+		err := rotateDatabaseKey(config)
+		if err != nil {
+			fmt.Println("Error rotating key")
+			panic(err)
+		}
+		return
+	}
+
+	if audit {
+		store := openStore(config)
+		defer store.Close()
+		auditLog, ok := store.(storage.AuditLog)
+		if !ok {
+			panic("database does not support an audit log")
+		}
+		err := printAuditLog(ctx, auditLog, options)
+		if err != nil {
+			fmt.Println("Error reading audit log")
+			panic(err)
+		}
+		return
 	}
 
 	if readTransaktions {
 		fmt.Println("Reading transactions from database")
-		store := storage.GetFileDatabase(config.DatabaseFilePath)
+		store := openStore(config)
+		defer store.Close()
 
-		transactions, err := store.ReadAllTransactions()
+		query, err := transactionQueryFromOptions(options)
+		if err != nil {
+			fmt.Println("Invalid query")
+			panic(err)
+		}
+		page, err := store.QueryTransactions(ctx, query)
 		if err != nil {
 			// Fehlerbehandlung
 			fmt.Println("Error reading transactions")
 			panic(err)
 		}
 
-		for _, transaction := range transactions {
+		for _, transaction := range page.Transactions {
 			fmt.Println(transaction)
 		}
+		if page.NextCursor != "" {
+			fmt.Printf("More transactions with cursor=%s\n", page.NextCursor)
+		}
 		fmt.Println("End of transactions")
 		return
 	}
 
+	if readRealizedGains {
+		store := openStore(config)
+		defer store.Close()
+		err := printRealizedGains(ctx, store, options)
+		if err != nil {
+			fmt.Println("Error reading realized gains")
+			panic(err)
+		}
+		return
+	}
+
 	if compute {
 		fmt.Println("Computing transactions")
-		store := storage.GetCsvStorage(config.TransactionFilePath)
+		transactions, err := openTransactionFile(config).ReadAllTransactions(ctx)
+		if err != nil {
+			fmt.Println("Error loading transactions")
+			panic(err)
+		}
 
-		dep := portfolio.GetDepot(&store)
+		//Die Transaktionsdatei bleibt unverändert, abgerechnet wird in einer Datenbank im Speicher
+		store := storage.GetMemoryDatabase()
+		err = store.Open()
+		if err == nil {
+			err = store.CreateDatabase(ctx)
+		}
+		if err != nil {
+			fmt.Println("Error creating database in memory")
+			panic(err)
+		}
+		defer store.Close()
+		for _, transaction := range transactions {
+			err = store.AddTransaction(ctx, &transaction)
+			if err != nil {
+				fmt.Println("Error loading transactions")
+				panic(err)
+			}
+		}
 
-		err = dep.ComputeAllTransactions()
+		dep := portfolio.GetDepot(store)
+
+		err = dep.ComputeAllTransactions(ctx)
 		if err != nil {
 			// Fehlerbehandlung
 			fmt.Println("Error computing transactions")
@@ -109,9 +358,566 @@ func main() {
 		fmt.Println("Depot:")
 		fmt.Println(dep.GetEntries())
 		fmt.Println("Realized Gains:")
-		realizedGains, _ := dep.GetAllRealizedGains()
+		realizedGains, _ := dep.GetAllRealizedGains(ctx)
 		fmt.Println(realizedGains)
 		fmt.Println("End")
 	}
 
+	if allocation {
+		dep := loadDepot(ctx, config)
+		for _, groupBy := range []string{portfolio.GroupByAssetType, portfolio.GroupByCurrency, portfolio.GroupByCategory} {
+			result, err := dep.GetAllocation(groupBy, config.Allocation.Categories, nil, config.Allocation.Rates())
+			if err != nil {
+				fmt.Println("Error computing allocation")
+				panic(err)
+			}
+			fmt.Printf("Allocation by %s (total %.2f %s):\n", groupBy, result.TotalValue, result.Currency)
+			for _, entry := range result.Entries {
+				fmt.Printf("  %-20s %12.2f %6.2f%%  %v\n", entry.Key, entry.Value, entry.Weight*100, entry.TickerSymbols)
+			}
+		}
+	}
+
+	if rebalance {
+		request := portfolio.RebalanceRequest{
+			Categories:    config.Allocation.Categories,
+			TargetWeights: config.Allocation.TargetWeights,
+			NoSell:        options["noSell"] == "true",
+			ExchangeRates: config.Allocation.Rates(),
+		}
+		if cash, exists := options["cash"]; exists {
+			request.Cash, err = strconv.ParseFloat(cash, 64)
+			if err != nil {
+				fmt.Println("Invalid value for cash")
+				panic(err)
+			}
+		}
+
+		dep := loadDepot(ctx, config)
+		plan, err := dep.Rebalance(request)
+		if err != nil {
+			fmt.Println("Error computing rebalancing orders")
+			panic(err)
+		}
+		fmt.Printf("Depot value including cash: %.2f %s\n", plan.TotalValue, plan.Current.Currency)
+		if len(plan.Orders) == 0 {
+			fmt.Println("Depot is balanced, no orders needed")
+		}
+		for _, order := range plan.Orders {
+			fmt.Printf("%-4s %-20s %-8s %10.4f x %10.2f = %12.2f %s\n", order.TransactionType, order.Category,
+				order.TickerSymbol, order.Quantity, order.Price, order.Amount, order.Currency)
+		}
+	}
+
+	if updateTransaction {
+		dep := loadDepot(ctx, config)
+		id, err := uuid.Parse(options["id"])
+		if err != nil {
+			fmt.Println("Missing or invalid transaction id. Use id=<uuid>")
+			panic(err)
+		}
+		transaction, err := dep.GetTransaction(ctx, id)
+		if err != nil {
+			fmt.Println("Error loading transaction")
+			panic(err)
+		}
+		err = applyTransactionOptions(transaction, options)
+		if err != nil {
+			fmt.Println("Invalid transaction values")
+			panic(err)
+		}
+		err = dep.UpdateTransaction(ctx, *transaction)
+		if err != nil {
+			fmt.Println("Error updating transaction")
+			panic(err)
+		}
+		fmt.Println("Transaction updated:")
+		fmt.Println(*transaction)
+	}
+
+	if removeTransaction {
+		dep := loadDepot(ctx, config)
+		id, err := uuid.Parse(options["id"])
+		if err != nil {
+			fmt.Println("Missing or invalid transaction id. Use id=<uuid>")
+			panic(err)
+		}
+		err = dep.RemoveTransaction(ctx, id)
+		if err != nil {
+			fmt.Println("Error removing transaction")
+			panic(err)
+		}
+		fmt.Println("Transaction removed")
+	}
+
+	if holdings {
+		dep := loadDepot(ctx, config)
+		entries := dep.GetEntries()
+		title := "Current holdings:"
+		if value, exists := options["date"]; exists {
+			date, err := time.Parse("02.01.2006", value)
+			if err != nil {
+				fmt.Println("Invalid date. Use date=31.12.2024")
+				panic(err)
+			}
+			entries, err = dep.GetEntriesAsOf(ctx, date)
+			if err != nil {
+				fmt.Println("Error computing holdings")
+				panic(err)
+			}
+			title = fmt.Sprintf("Holdings as of %s:", value)
+		}
+
+		fmt.Println(title)
+		tickerSymbols := make([]string, 0, len(entries))
+		for tickerSymbol := range entries {
+			tickerSymbols = append(tickerSymbols, tickerSymbol)
+		}
+		sort.Strings(tickerSymbols)
+		for _, tickerSymbol := range tickerSymbols {
+			entry := entries[tickerSymbol]
+			fmt.Printf("%-8s %-20s %12.4f x %10.4f = %12.2f %s\n", entry.TickerSymbol, entry.Asset,
+				entry.Quantity, entry.Price, entry.TotalPrice(), entry.Currency)
+		}
+	}
+}
+
+// applyTransactionOptions überschreibt die Felder der Transaktion mit den angegebenen Optionen,
+// z.B. price=101.5 oder date=01.10.2025
+func applyTransactionOptions(transaction *storage.Transaction, options map[string]string) error {
+	var err error
+	for key, value := range options {
+		switch key {
+		case "date":
+			transaction.Date, err = time.Parse("02.01.2006", value)
+		case "type":
+			transaction.TransactionType = value
+		case "assetType":
+			transaction.AssetType = value
+		case "asset":
+			transaction.Asset = value
+		case "tickerSymbol":
+			transaction.TickerSymbol = value
+		case "quantity":
+			transaction.Quantity, err = strconv.ParseFloat(value, 64)
+		case "price":
+			transaction.Price, err = strconv.ParseFloat(value, 64)
+		case "fees":
+			transaction.Fees, err = strconv.ParseFloat(value, 64)
+		case "currency":
+			transaction.Currency = value
+		case "sequence":
+			transaction.Sequence, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// loadDepot lädt das Depot aus der Datenbank
+func loadDepot(ctx context.Context, cfg *config.Config) *portfolio.Depot {
+	store := openStore(cfg)
+	dep := portfolio.GetDepot(store)
+	err := dep.SetValidation(cfg.Validation)
+	if err != nil {
+		fmt.Println("Invalid validation rules in config")
+		panic(err)
+	}
+	err = dep.CalculateSecuritiesAccountBalance(ctx)
+	if err != nil {
+		fmt.Println("Error loading depot")
+		panic(err)
+	}
+	return dep
+}
+
+// printAuditLog zeigt das Audit-Log, die neuesten Einträge zuerst. Filter: actor=, operation=, entity=,
+// id= (Id des geänderten Eintrags), from=01.01.2025, to=31.03.2025 (einschließlich), limit= (ohne Angabe 50)
+func printAuditLog(ctx context.Context, auditLog storage.AuditLog, options map[string]string) error {
+	filter := storage.AuditFilter{
+		Actor:     options["actor"],
+		Operation: options["operation"],
+		Entity:    options["entity"],
+		EntityId:  options["id"],
+		Limit:     50,
+	}
+	var err error
+	filter.From, filter.To, err = dateRangeFromOptions(options)
+	if err != nil {
+		return err
+	}
+	if value, exists := options["limit"]; exists {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for limit: %w", err)
+		}
+	}
+
+	entries, err := auditLog.ReadAuditLog(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Printf("%6d  %s  %-12s %-30s %-20s %s\n", entry.Id, entry.CreatedAt.Format("02.01.2006 15:04:05"),
+			entry.Actor, entry.Operation, entry.Entity, entry.EntityId)
+		if len(entry.Before) > 0 {
+			fmt.Printf("        before: %s\n", entry.Before)
+		}
+		if len(entry.After) > 0 {
+			fmt.Printf("        after:  %s\n", entry.After)
+		}
+	}
+	fmt.Printf("%d entries\n", len(entries))
+	return nil
+}
+
+// dateRangeFromOptions liest from= und to= im Format 02.01.2006. to gilt einschließlich.
+func dateRangeFromOptions(options map[string]string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if value, exists := options["from"]; exists {
+		from, err = time.Parse("02.01.2006", value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid value for from: %w", err)
+		}
+	}
+	if value, exists := options["to"]; exists {
+		to, err = time.Parse("02.01.2006", value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid value for to: %w", err)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// transactionQueryFromOptions liest die Filter ticker=, type=, assetType=, currency=, from= und to=,
+// die Sortierung sort= und desc sowie limit= und cursor= für das Blättern.
+func transactionQueryFromOptions(options map[string]string) (storage.TransactionQuery, error) {
+	query := storage.TransactionQuery{
+		TickerSymbol:    options["ticker"],
+		TransactionType: options["type"],
+		AssetType:       options["assetType"],
+		Currency:        options["currency"],
+		SortBy:          options["sort"],
+		Descending:      options["desc"] == "true",
+		Cursor:          options["cursor"],
+	}
+	var err error
+	query.From, query.To, err = dateRangeFromOptions(options)
+	if err != nil {
+		return query, err
+	}
+	if value, exists := options["limit"]; exists {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid value for limit: %w", err)
+		}
+	}
+	return query, nil
+}
+
+// printRealizedGains gibt die Abrechnungen mit denselben Filtern wie readTransactions aus (ohne type= und sort=).
+func printRealizedGains(ctx context.Context, store storage.Store, options map[string]string) error {
+	transactionQuery, err := transactionQueryFromOptions(options)
+	if err != nil {
+		return err
+	}
+	page, err := store.QueryRealizedGains(ctx, storage.RealizedGainQuery{
+		From:         transactionQuery.From,
+		To:           transactionQuery.To,
+		TickerSymbol: transactionQuery.TickerSymbol,
+		AssetType:    transactionQuery.AssetType,
+		Currency:     transactionQuery.Currency,
+		Descending:   transactionQuery.Descending,
+		Limit:        transactionQuery.Limit,
+		Cursor:       transactionQuery.Cursor,
+	})
+	if err != nil {
+		return err
+	}
+	total := 0.0
+	for _, gain := range page.RealizedGains {
+		fmt.Printf("%-20s %10.4f  %10.2f -> %10.2f  %12.2f %s\n", gain.Asset, gain.Quantity, gain.BuyPrice, gain.SellPrice, gain.Amount, gain.Currency)
+		total += gain.Amount
+	}
+	fmt.Printf("%d realized gains, total %.2f\n", len(page.RealizedGains), total)
+	if page.NextCursor != "" {
+		fmt.Printf("More realized gains with cursor=%s\n", page.NextCursor)
+	}
+	return nil
+}
+
+// writeBackup schreibt ein Backup des kompletten Stores in file=. Das Format ergibt sich aus der
+// Endung (.zip oder .json) oder wird mit format= angegeben.
+func writeBackup(ctx context.Context, cfg *config.Config, options map[string]string) error {
+	fileName := options["file"]
+	if fileName == "" {
+		fileName = fmt.Sprintf("stockportfolio-%s.zip", time.Now().Format("20060102-150405"))
+	}
+	format := options["format"]
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(fileName), ".")
+	}
+
+	store := openStore(cfg)
+	defer store.Close()
+	archive, err := portfolio.GetDepot(store).Backup(ctx, cfg.ArchiveMetadata())
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = storage.WriteArchive(&buffer, archive, format)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fileName, buffer.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup with %d transactions written to %s\n", len(archive.Transactions), fileName)
+	return nil
+}
+
+// validateImport prüft die Transaktionen eines Imports mit den Regeln aus der Konfiguration. Verkäufe und
+// Namen der Assets werden auch gegen die Transaktionen geprüft, die schon im Store sind.
+func validateImport(ctx context.Context, cfg *config.Config, store storage.Store, transactions []storage.Transaction) error {
+	validator, err := portfolio.NewValidator(cfg.Validation)
+	if err != nil {
+		return err
+	}
+	existing, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		return err
+	}
+	return validator.ValidateAll(transactions, portfolio.NewLedger(existing))
+}
+
+// verifyStore vergleicht die gespeicherten offenen Positionen und Realized Gains mit einer Neuberechnung
+// aller Transaktionen und zeigt die Abweichungen je Asset. Mit repair werden sie behoben.
+func verifyStore(ctx context.Context, cfg *config.Config, repair bool) (bool, error) {
+	store := openStore(cfg)
+	defer store.Close()
+	report, err := portfolio.GetDepot(store).Verify(ctx, repair)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("%d transactions, %d unclosed transactions and %d realized gains checked\n",
+		report.Transactions, report.UnclosedLots, report.RealizedGains)
+	for _, ticker := range report.Tickers {
+		fmt.Printf("%s:\n", ticker.TickerSymbol)
+		for _, lot := range ticker.MissingLots {
+			fmt.Printf("  missing unclosed transaction %s  %s  %10.4f\n", lot.Id, lot.Date.Format("02.01.2006"), lot.Quantity)
+		}
+		for _, lot := range ticker.UnexpectedLots {
+			fmt.Printf("  unexpected unclosed transaction %s  %s  %10.4f\n", lot.Id, lot.Date.Format("02.01.2006"), lot.Quantity)
+		}
+		for _, lot := range ticker.ChangedLots {
+			fmt.Printf("  changed unclosed transaction %s  %10.4f -> %10.4f\n", lot.Stored.Id, lot.Stored.Quantity, lot.Expected.Quantity)
+		}
+		for _, gain := range ticker.MissingGains {
+			fmt.Printf("  missing realized gain sell %s buy %s  %12.2f\n", gain.SellTransactionId, gain.BuyTransactionId, gain.Amount)
+		}
+		for _, gain := range ticker.UnexpectedGains {
+			fmt.Printf("  unexpected realized gain %s  %12.2f\n", gain.Id, gain.Amount)
+		}
+		for _, gain := range ticker.ChangedGains {
+			fmt.Printf("  changed realized gain %s  %12.2f -> %12.2f\n", gain.Stored.Id, gain.Stored.Amount, gain.Expected.Amount)
+		}
+	}
+	switch {
+	case report.Consistent():
+		fmt.Println("Store is consistent")
+	case report.Repaired:
+		fmt.Printf("%d discrepancies in %d assets repaired\n", report.Discrepancies(), len(report.Tickers))
+	default:
+		fmt.Printf("%d discrepancies in %d assets found, repair with verify repair\n", report.Discrepancies(), len(report.Tickers))
+	}
+	return report.Consistent() || report.Repaired, nil
+}
+
+// restoreBackup spielt das Backup aus file= in den leeren Store der Konfiguration zurück. Mit driver=
+// und dsn= kann ein anderer Store angegeben werden, z.B. um von SQLite nach PostgreSQL umzuziehen.
+func restoreBackup(ctx context.Context, cfg *config.Config, options map[string]string) error {
+	content, err := os.ReadFile(options["file"])
+	if err != nil {
+		return err
+	}
+	archive, err := storage.ReadArchive(content)
+	if err != nil {
+		return err
+	}
+
+	target := *cfg
+	if driver, exists := options["driver"]; exists {
+		target.DatabaseDriver = driver
+		target.DatabaseDSN = options["dsn"]
+	}
+	store := openStore(&target)
+	defer store.Close()
+	err = store.CreateDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	err = portfolio.GetDepot(store).Restore(ctx, archive)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup of %s with %d transactions restored\n", archive.CreatedAt.Format("02.01.2006 15:04:05"), len(archive.Transactions))
+	return nil
+}
+
+// encryptDatabase verschlüsselt die SQLite-Datei aus DatabaseFilePath nach file= (Standard: mit Endung .enc).
+// Der Schlüssel kommt aus STOCKPORTFOLIO_KEY_FILE oder STOCKPORTFOLIO_PASSPHRASE. Danach den Treiber
+// auf "sqlite-encrypted" umstellen und die unverschlüsselte Datei löschen.
+func encryptDatabase(cfg *config.Config, options map[string]string) error {
+	key, err := storage.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	target := options["file"]
+	if target == "" {
+		target = cfg.DatabaseFilePath + ".enc"
+	}
+	err = storage.EncryptDatabaseFile(cfg.DatabaseFilePath, target, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database %s encrypted to %s\n", cfg.DatabaseFilePath, target)
+	return nil
+}
+
+// rotateDatabaseKey verschlüsselt die konfigurierte Datenbank mit dem Schlüssel aus
+// STOCKPORTFOLIO_NEW_KEY_FILE oder STOCKPORTFOLIO_NEW_PASSPHRASE neu.
+func rotateDatabaseKey(cfg *config.Config) error {
+	driver, dsn := cfg.Database()
+	if driver != storage.DriverSQLiteEncrypted {
+		return fmt.Errorf("database driver %s is not encrypted", driver)
+	}
+	oldKey, err := storage.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	newKey, err := storage.NewKeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	err = storage.RotateKey(dsn, oldKey, newKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key of %s rotated\n", dsn)
+	return nil
+}
+
+// upgradeDatabase bringt eine vorhandene Datenbank auf die neueste Schemaversion
+func upgradeDatabase(ctx context.Context, cfg *config.Config) {
+	driver, dsn := cfg.Database()
+	if driver == storage.DriverSQLite || driver == storage.DriverSQLiteEncrypted {
+		if _, err := os.Stat(dsn); os.IsNotExist(err) {
+			return
+		}
+	}
+	store := openStore(cfg)
+	defer store.Close()
+	migrator, ok := store.(storage.Migrator)
+	if !ok {
+		return
+	}
+	err := migrator.MigrateUp(ctx)
+	if err != nil {
+		fmt.Println("Error upgrading database schema")
+		panic(err)
+	}
+}
+
+// runMigration führt "migrate status", "migrate up" oder "migrate down [version=n]" aus.
+// Ohne Version wird bei down die letzte angewendete Migration zurückgenommen.
+func runMigration(ctx context.Context, store storage.Migrator, options map[string]string) error {
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	current := 0
+	for _, state := range states {
+		if state.Applied {
+			current = state.Version
+		}
+	}
+
+	switch options["migrate"] {
+	case "", "status":
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied {
+				applied = "applied " + state.AppliedAt.Format("02.01.2006 15:04:05")
+			}
+			fmt.Printf("%3d  %-50s %s\n", state.Version, state.Description, applied)
+		}
+		fmt.Printf("Schema version %d of %d\n", current, storage.LatestSchemaVersion())
+		return nil
+	case "up":
+		err = store.MigrateUp(ctx)
+	case "down":
+		target := current - 1
+		if value, exists := options["version"]; exists {
+			target, err = strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for version: %w", err)
+			}
+		}
+		if target >= current {
+			return fmt.Errorf("schema version %d is not below the current version %d", target, current)
+		}
+		err = store.MigrateTo(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Database migrated")
+	return nil
+}
+
+// openStore öffnet die in der Konfiguration eingestellte Datenbank.
+// Die Verbindung bleibt bis zum Ende des Programms offen.
+// openTransactionFile liest die CSV-Transaktionsdatei aus der Konfiguration.
+func openTransactionFile(cfg *config.Config) *storage.CsvStorage {
+	store := storage.GetCsvStorage(cfg.TransactionFilePath)
+	err := store.SetOptions(cfg.Csv)
+	if err == nil {
+		err = store.Open()
+	}
+	if err != nil {
+		fmt.Println("Error loading transactions")
+		panic(err)
+	}
+	return store
+}
+
+func openStore(cfg *config.Config) storage.Store {
+	store, err := cfg.NewStore()
+	if err != nil {
+		fmt.Println("Error creating store")
+		panic(err)
+	}
+	err = store.Open()
+	if err != nil {
+		fmt.Println("Error opening database")
+		panic(err)
+	}
+	if auditLog, ok := store.(storage.AuditLog); ok {
+		auditLog.SetActor(cliActor())
+	}
+	return store
+}
+
+// cliActor ist der Benutzer, der die CLI aufgerufen hat, für das Audit-Log.
+func cliActor() string {
+	current, err := user.Current()
+	if err != nil {
+		return "cli"
+	}
+	return "cli:" + current.Username
 }
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminTokenEnv ist die Umgebungsvariable mit dem Token für die Admin-Routen.
+const AdminTokenEnv = "STOCKPORTFOLIO_ADMIN_TOKEN"
+
+// AdminAccess schützt die Admin-Routen (Backup, Restore, Prüfung und Reparatur). Ist token gesetzt, muss jede
+// Anfrage ihn als "Authorization: Bearer <token>" mitschicken. Ohne token sind die Routen nur von localhost
+// erreichbar. Geprüft wird dabei die Adresse der Verbindung und nicht X-Forwarded-For, das der Client setzen kann.
+func AdminAccess(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			given, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeError(c, problemUnauthorized, &ApiResponse{ErrorMessage: "Unauthorized"}, errors.New("missing or wrong admin token"))
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil || !ip.IsLoopback() {
+			writeError(c, problemForbidden, &ApiResponse{ErrorMessage: "Forbidden"},
+				fmt.Errorf("admin routes are only available from localhost without %s", AdminTokenEnv))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// BackupHandler liefert ein Backup des kompletten Stores als Download. Das Format wird mit dem
+// Query-Parameter format (zip oder json, Standard zip) gewählt.
+func BackupHandler(admin portfolio.Administration, metadata storage.ArchiveMetadata) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", storage.ArchiveFormatZip)
+
+		var buffer bytes.Buffer
+		archive, err := admin.Backup(c.Request.Context(), metadata)
+		if err == nil {
+			err = storage.WriteArchive(&buffer, archive, format)
+		}
+		if err != nil {
+			log.Printf("Error creating backup: %v\n", err)
+			respondError(c, &ApiResponse{ErrorMessage: "Could not create backup"}, err)
+			return
+		}
+
+		contentType := "application/json"
+		if format == storage.ArchiveFormatZip {
+			contentType = "application/zip"
+		}
+		fileName := fmt.Sprintf("stockportfolio-%s.%s", archive.CreatedAt.Format("20060102-150405"), format)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+		c.Data(http.StatusOK, contentType, buffer.Bytes())
+	}
+}
+
+// RestoreHandler spielt ein Backup (JSON oder Zip im Body) in den leeren Store zurück.
+// Größere Bodies als maxSize werden mit 413 abgelehnt, ohne sie komplett zu lesen.
+func RestoreHandler(admin portfolio.Administration, maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Backup restored",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+		content, err := c.GetRawData()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			response.Message = ""
+			response.ErrorMessage = "Backup too large"
+			writeError(c, problemTooLarge, response, err)
+			return
+		}
+		var archive *storage.Archive
+		if err == nil {
+			archive, err = storage.ReadArchive(content)
+		}
+		if err != nil {
+			response.Message = ""
+			response.ErrorMessage = "Invalid backup"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		err = admin.Restore(c.Request.Context(), archive)
+		if err != nil {
+			log.Printf("Error restoring backup: %v\n", err)
+			response.Message = ""
+			response.ErrorMessage = "Could not restore backup"
+			respondError(c, response, err)
+			return
+		}
+		response.Message = fmt.Sprintf("Backup of %s with %d transactions restored",
+			archive.CreatedAt.Format(time.DateTime), len(archive.Transactions))
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// VerifyHandler vergleicht die gespeicherten offenen Positionen und Realized Gains mit einer Neuberechnung
+// aller Transaktionen und liefert den Bericht. Mit repair werden gefundene Abweichungen behoben.
+func VerifyHandler(admin portfolio.Administration, repair bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Store is consistent",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		report, err := admin.Verify(c.Request.Context(), repair)
+		if err != nil {
+			log.Printf("Error verifying store: %v\n", err)
+			response.Message = ""
+			response.ErrorMessage = "Could not verify store"
+			respondError(c, response, err)
+			return
+		}
+		if report.Repaired {
+			response.Message = fmt.Sprintf("%d discrepancies in %d assets repaired", report.Discrepancies(), len(report.Tickers))
+		} else if !report.Consistent() {
+			response.Message = fmt.Sprintf("%d discrepancies in %d assets found", report.Discrepancies(), len(report.Tickers))
+		}
+		response.Data = report
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditLogHandler liefert das Audit-Log. Gefiltert wird über die Query-Parameter actor, operation,
+// entity, entityId, from und to (Format 2006-01-02 oder 02.01.2006, to einschließlich) und limit.
+func GetAuditLogHandler(trail portfolio.AuditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Audit log loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		filter, err := auditFilterFromQuery(c)
+		if err != nil {
+			response.Message = ""
+			response.ErrorMessage = "Invalid filter"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		data, err := trail.GetAuditLog(c.Request.Context(), filter)
+		if err != nil {
+			response.Message = ""
+			response.ErrorMessage = "Could not retrieve audit log"
+			respondError(c, response, err)
+			return
+		}
+		response.Data = data
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func auditFilterFromQuery(c *gin.Context) (storage.AuditFilter, error) {
+	filter := storage.AuditFilter{
+		Actor:     c.Query("actor"),
+		Operation: c.Query("operation"),
+		Entity:    c.Query("entity"),
+		EntityId:  c.Query("entityId"),
+	}
+	var err error
+	filter.From, filter.To, err = dateRangeFromQuery(c)
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit, err = limitFromQuery(c)
+	return filter, err
+}
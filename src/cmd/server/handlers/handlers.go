@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,6 +37,17 @@ func PingHandler(appConfig *config.Config) gin.HandlerFunc {
 	}
 }
 
+// RequestTimeout begrenzt die Dauer jeder Anfrage. Der Context der Anfrage wird nach timeout abgebrochen,
+// laufende Store-Zugriffe und Neuberechnungen brechen dann ab und werden zurückgerollt.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 func GetEntries(depot portfolio.Portfolio) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		response := &ApiResponse{
@@ -46,20 +61,49 @@ func GetEntries(depot portfolio.Portfolio) gin.HandlerFunc {
 	}
 }
 
+// GetEntriesAsOfHandler liefert den Depotbestand zum Ende des Tages aus dem Query-Parameter date
+// (Format 2006-01-02 oder 02.01.2006).
+func GetEntriesAsOfHandler(depot portfolio.Portfolio) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		date, err := parseDate(c.Query("date"))
+		if err != nil {
+			respondBadRequest(c, &ApiResponse{ErrorMessage: "Invalid date"}, err)
+			return
+		}
+
+		data, err := depot.GetEntriesAsOf(c.Request.Context(), date)
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not compute depot entries"}, err)
+			return
+		}
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      fmt.Sprintf("Depot entries as of %s loaded", date.Format(time.DateOnly)),
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         data,
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetRealizedGains liefert die Abrechnungen. Gefiltert wird über die Query-Parameter from und to
+// (Datum des Verkaufs), tickerSymbol, assetType und currency. Mit limit wird seitenweise geliefert,
+// der Cursor für die nächste Seite steht im Header X-Next-Cursor.
 func GetRealizedGains(depot portfolio.Portfolio) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		data, err := depot.GetAllRealizedGains()
+		query, err := realizedGainQueryFromQuery(c)
 		if err != nil {
-			response := &ApiResponse{
-				Status:       "error",
-				Message:      "",
-				ErrorMessage: "Could not retrieve realized gains",
-				ErrorDetails: err.Error(),
-				Data:         nil,
-			}
-			c.JSON(http.StatusOK, response)
+			respondBadRequest(c, &ApiResponse{ErrorMessage: "Invalid query"}, err)
+			return
+		}
+		page, err := depot.QueryRealizedGains(c.Request.Context(), query)
+		data := page.RealizedGains
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve realized gains"}, err)
 			return
 		}
+		setNextCursor(c, page.NextCursor)
 		response := &ApiResponse{
 			Status:       "success",
 			Message:      "Realized gains loaded",
@@ -73,16 +117,9 @@ func GetRealizedGains(depot portfolio.Portfolio) gin.HandlerFunc {
 
 func GetPerformanceHandler(depot portfolio.Portfolio) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		data, err := depot.GetPerformance()
+		data, err := depot.GetPerformance(c.Request.Context())
 		if err != nil {
-			response := &ApiResponse{
-				Status:       "error",
-				Message:      "",
-				ErrorMessage: "Could not retrieve performance data",
-				ErrorDetails: err.Error(),
-				Data:         nil,
-			}
-			c.JSON(http.StatusOK, response)
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve performance data"}, err)
 			return
 		}
 		response := &ApiResponse{
@@ -109,23 +146,19 @@ func AddTransactionHandler(depot portfolio.Portfolio) gin.HandlerFunc {
 
 		var transaction storage.Transaction
 		if err := c.ShouldBindJSON(&transaction); err != nil {
-			response.Status = "error"
 			response.Message = "Failed to add transaction"
 			response.ErrorMessage = "Invalid request body"
-			response.ErrorDetails = err.Error()
-			c.JSON(http.StatusOK, response)
+			respondBadRequest(c, response, err)
 			return
 		}
 
 		transaction.Id = uuid.New()
 		log.Printf("Received transaction: %+v\n", transaction)
-		err := depot.AddTransaction(transaction)
+		err := depot.AddTransaction(c.Request.Context(), transaction)
 		if err != nil {
 			log.Printf("Error adding transaction: %v\n", err)
-			response.Status = "error"
 			response.Message = "Failed to add transaction"
-			response.ErrorDetails = err.Error()
-			c.JSON(http.StatusOK, response)
+			respondError(c, response, err)
 			return
 		}
 
@@ -136,24 +169,111 @@ func AddTransactionHandler(depot portfolio.Portfolio) gin.HandlerFunc {
 	}
 }
 
-func GetAllTransactionsHandler(depot portfolio.Portfolio) gin.HandlerFunc {
+// UpdateTransactionHandler korrigiert eine bestehende Transaktion. Die Id kommt aus dem Pfad.
+func UpdateTransactionHandler(depot portfolio.Portfolio) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		data, err := depot.GetAllTransactions()
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Transaction updated successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
 		if err != nil {
-			response := &ApiResponse{
-				Status:       "error",
-				Message:      "",
-				ErrorMessage: "Could not retrieve transactions",
-				ErrorDetails: err.Error(),
-				Data:         nil,
-			}
-			c.JSON(http.StatusOK, response)
+			response.Message = "Failed to update transaction"
+			response.ErrorMessage = "Invalid transaction id"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		var transaction storage.Transaction
+		if err := c.ShouldBindJSON(&transaction); err != nil {
+			response.Message = "Failed to update transaction"
+			response.ErrorMessage = "Invalid request body"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		transaction.Id = id
+		err = depot.UpdateTransaction(c.Request.Context(), transaction)
+		if err != nil {
+			log.Printf("Error updating transaction: %v\n", err)
+			response.Message = "Failed to update transaction"
+			respondError(c, response, err)
+			return
+		}
+
+		log.Printf("Transaction updated successfully: %+v\n", transaction)
+		response.Data = transaction
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func RemoveTransactionHandler(depot portfolio.Portfolio) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Transaction removed successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			response.Message = "Failed to remove transaction"
+			response.ErrorMessage = "Invalid transaction id"
+			respondBadRequest(c, response, err)
 			return
 		}
 
+		err = depot.RemoveTransaction(c.Request.Context(), id)
+		if err != nil {
+			log.Printf("Error removing transaction: %v\n", err)
+			response.Message = "Failed to remove transaction"
+			respondError(c, response, err)
+			return
+		}
+
+		log.Printf("Transaction removed successfully: %s\n", id)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetAllTransactionsHandler liefert die Transaktionen als JSON oder als Text. Gefiltert wird über die
+// Query-Parameter from, to, tickerSymbol, transactionType, assetType und currency, sortiert mit sortBy
+// (date oder tickerSymbol) und desc=true. Mit limit wird seitenweise geliefert, der Cursor für die
+// nächste Seite steht im Header X-Next-Cursor und wird mit cursor übergeben.
+func GetAllTransactionsHandler(depot portfolio.Portfolio) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, err := transactionQueryFromQuery(c)
+		if err != nil {
+			respondBadRequest(c, &ApiResponse{ErrorMessage: "Invalid query"}, err)
+			return
+		}
 		accept := c.GetHeader("Accept")
 		// JSON by default or when Accept contains application/json
-		if accept == "" || strings.Contains(accept, "application/json") {
+		asJSON := accept == "" || strings.Contains(accept, "application/json")
+
+		//Der Text-Export ohne limit wird gestreamt und hält nie alle Transaktionen im Speicher
+		if !asJSON && query.Limit == 0 {
+			writeTransactionsAsText(c, depot.IterateTransactions(c.Request.Context(), query))
+			return
+		}
+
+		page, err := depot.QueryTransactions(c.Request.Context(), query)
+		data := page.Transactions
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve transactions"}, err)
+			return
+		}
+
+		setNextCursor(c, page.NextCursor)
+		if asJSON {
 			response := &ApiResponse{
 				Status:       "success",
 				Message:      "Transactions loaded",
@@ -171,22 +291,214 @@ func GetAllTransactionsHandler(depot portfolio.Portfolio) gin.HandlerFunc {
 			if i > 0 {
 				b.WriteString("\n")
 			}
-			// Format nach Bedarf anpassen: hier einige Standardfelder
-			//b.WriteString(fmt.Sprintf("Date: %s | Type: %s | AssetType: %s | Asset: %s | Ticker: %s | Qty: %v | Price: %v | Fees: %v | Currency: %s",
-			b.WriteString(fmt.Sprintf("%s;%s;%s;%s;%s;%v;%v;%v;%s",
-				// t.Date.Format(time.RFC3339),
-				t.Date.Format(time.DateOnly),
-				t.TransactionType,
-				t.AssetType,
-				t.Asset,
-				t.TickerSymbol,
-				t.Quantity,
-				t.Price,
-				t.Fees,
-				t.Currency))
+			b.WriteString(transactionAsText(t))
 		}
 		// Optional: Setze Content-Disposition Header für Dateidownload
 		//c.Header("Content-Disposition", "attachment; filename=\"datei.txt\"")
 		c.String(http.StatusOK, b.String())
 	}
 }
+
+// writeTransactionsAsText schreibt jede Transaktion sofort in die Antwort. Ein Fehler vor der ersten
+// Transaktion wird wie sonst als ApiResponse gemeldet. Danach ist der Status schon gesendet, dann wird
+// die Antwort abgebrochen und der Fehler nur protokolliert.
+func writeTransactionsAsText(c *gin.Context, transactions iter.Seq2[storage.Transaction, error]) {
+	written := false
+	for t, err := range transactions {
+		if err != nil {
+			if !written {
+				respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve transactions"}, err)
+				return
+			}
+			log.Printf("Transaction export aborted: %v\n", err)
+			c.Abort()
+			return
+		}
+		if written {
+			c.Writer.WriteString("\n")
+		} else {
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+			c.Status(http.StatusOK)
+			written = true
+		}
+		c.Writer.WriteString(transactionAsText(t))
+	}
+	if !written {
+		c.String(http.StatusOK, "")
+	}
+}
+
+// transactionAsText liefert eine Transaktion als Zeile für den Text-Export
+func transactionAsText(t storage.Transaction) string {
+	// Format nach Bedarf anpassen: hier einige Standardfelder
+	//b.WriteString(fmt.Sprintf("Date: %s | Type: %s | AssetType: %s | Asset: %s | Ticker: %s | Qty: %v | Price: %v | Fees: %v | Currency: %s",
+	return fmt.Sprintf("%s;%s;%s;%s;%s;%v;%v;%v;%s;%d;%s;%s;%s",
+		// t.Date.Format(time.RFC3339),
+		t.Date.Format(time.DateOnly),
+		t.TransactionType,
+		t.AssetType,
+		t.Asset,
+		t.TickerSymbol,
+		t.Quantity,
+		t.Price,
+		t.Fees,
+		t.Currency,
+		t.Sequence,
+		t.Broker,
+		t.OrderNumber,
+		t.ExecutionId)
+}
+
+// GetAllocationHandler liefert die Aufteilung des Depots nach assetType, currency oder category.
+// Die Kategorien, die Basiswährung und die Kurse kommen aus der Konfiguration.
+func GetAllocationHandler(depot portfolio.Portfolio, allocationConfig config.AllocationConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupBy := c.DefaultQuery("groupBy", portfolio.GroupByAssetType)
+		data, err := depot.GetAllocation(groupBy, allocationConfig.Categories, nil, allocationConfig.Rates())
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve allocation"}, err)
+			return
+		}
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Allocation loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         data,
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// RebalanceHandler berechnet die Aufträge für das Rebalancing. Fehlen im Request Kategorien,
+// Zielgewichtung oder Basiswährung, werden die Werte aus der Konfiguration verwendet.
+func RebalanceHandler(depot portfolio.Portfolio, allocationConfig config.AllocationConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Rebalancing orders computed",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		var request portfolio.RebalanceRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				response.Message = "Failed to compute rebalancing orders"
+				response.ErrorMessage = "Invalid request body"
+				respondBadRequest(c, response, err)
+				return
+			}
+		}
+		if len(request.Categories) == 0 {
+			request.Categories = allocationConfig.Categories
+		}
+		if len(request.TargetWeights) == 0 {
+			request.TargetWeights = allocationConfig.TargetWeights
+		}
+		if request.ExchangeRates.BaseCurrency == "" {
+			request.ExchangeRates = allocationConfig.Rates()
+		}
+
+		data, err := depot.Rebalance(request)
+		if err != nil {
+			response.Message = "Failed to compute rebalancing orders"
+			respondError(c, response, err)
+			return
+		}
+
+		response.Data = data
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// parseDate akzeptiert Datumsangaben im ISO-Format und im deutschen Format.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("date is missing")
+	}
+	for _, layout := range []string{time.DateOnly, "02.01.2006"} {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q has an unknown format, use 2006-01-02 or 02.01.2006", value)
+}
+
+// dateRangeFromQuery liest from und to. to gilt einschließlich, der ganze Tag gehört dazu.
+func dateRangeFromQuery(c *gin.Context) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		from, err = parseDate(value)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		to, err = parseDate(value)
+		if err != nil {
+			return from, to, err
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+func limitFromQuery(c *gin.Context) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("limit %q is not a positive number", value)
+	}
+	return limit, nil
+}
+
+func transactionQueryFromQuery(c *gin.Context) (storage.TransactionQuery, error) {
+	query := storage.TransactionQuery{
+		TickerSymbol:    c.Query("tickerSymbol"),
+		TransactionType: c.Query("transactionType"),
+		AssetType:       c.Query("assetType"),
+		Currency:        c.Query("currency"),
+		SortBy:          c.Query("sortBy"),
+		Descending:      c.Query("desc") == "true",
+		Cursor:          c.Query("cursor"),
+	}
+	var err error
+	query.From, query.To, err = dateRangeFromQuery(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit, err = limitFromQuery(c)
+	return query, err
+}
+
+func realizedGainQueryFromQuery(c *gin.Context) (storage.RealizedGainQuery, error) {
+	query := storage.RealizedGainQuery{
+		TickerSymbol: c.Query("tickerSymbol"),
+		AssetType:    c.Query("assetType"),
+		Currency:     c.Query("currency"),
+		Descending:   c.Query("desc") == "true",
+		Cursor:       c.Query("cursor"),
+	}
+	var err error
+	query.From, query.To, err = dateRangeFromQuery(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit, err = limitFromQuery(c)
+	return query, err
+}
+
+// setNextCursor setzt den Header X-Next-Cursor, wenn es eine weitere Seite gibt
+func setNextCursor(c *gin.Context, cursor string) {
+	if cursor != "" {
+		c.Header("X-Next-Cursor", cursor)
+	}
+}
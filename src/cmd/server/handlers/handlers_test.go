@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"iter"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,33 +22,98 @@ import (
 
 // mockDepot implements the AddTransaction method for testing
 type mockDepot struct {
-	addTransaction      func(storage.Transaction) error
+	addTransaction      func(context.Context, storage.Transaction) error
+	updateTransaction   func(storage.Transaction) error
+	removeTransaction   func(uuid.UUID) error
 	getEntries          func() map[string]portfolio.DepotEntry
+	getEntriesAsOf      func(time.Time) (map[string]portfolio.DepotEntry, error)
 	getAllRealizedGains func() ([]storage.RealizedGain, error)
 	getPerformance      func() (portfolio.Performance, error)
 	getAllTransactions  func() ([]storage.Transaction, error)
+	queryTransactions   func(storage.TransactionQuery) (storage.TransactionPage, error)
+	queryRealizedGains  func(storage.RealizedGainQuery) (storage.RealizedGainPage, error)
+	iterateTransactions func(storage.TransactionQuery) iter.Seq2[storage.Transaction, error]
+	getAllocation       func(string, map[string]string, map[string]float64, portfolio.ExchangeRates) (portfolio.Allocation, error)
+	rebalance           func(portfolio.RebalanceRequest) (portfolio.RebalancePlan, error)
 }
 
-func (m *mockDepot) AddTransaction(t storage.Transaction) error {
-	return m.addTransaction(t)
+func (m *mockDepot) AddTransaction(ctx context.Context, t storage.Transaction) error {
+	return m.addTransaction(ctx, t)
+}
+
+func (m *mockDepot) UpdateTransaction(ctx context.Context, t storage.Transaction) error {
+	return m.updateTransaction(t)
+}
+
+func (m *mockDepot) RemoveTransaction(ctx context.Context, id uuid.UUID) error {
+	return m.removeTransaction(id)
 }
 
 func (m *mockDepot) GetEntries() map[string]portfolio.DepotEntry {
 	return m.getEntries()
 }
 
-func (m *mockDepot) GetAllRealizedGains() ([]storage.RealizedGain, error) {
+func (m *mockDepot) GetEntriesAsOf(ctx context.Context, date time.Time) (map[string]portfolio.DepotEntry, error) {
+	return m.getEntriesAsOf(date)
+}
+
+func (m *mockDepot) GetAllRealizedGains(ctx context.Context) ([]storage.RealizedGain, error) {
 	return m.getAllRealizedGains()
 }
 
-func (m *mockDepot) GetPerformance() (portfolio.Performance, error) {
+func (m *mockDepot) GetPerformance(ctx context.Context) (portfolio.Performance, error) {
 	return m.getPerformance()
 }
 
-func (m *mockDepot) GetAllTransactions() ([]storage.Transaction, error) {
+func (m *mockDepot) GetAllTransactions(ctx context.Context) ([]storage.Transaction, error) {
 	return m.getAllTransactions()
 }
 
+// QueryTransactions liefert ohne eigene Funktion alle Transaktionen aus getAllTransactions
+func (m *mockDepot) QueryTransactions(ctx context.Context, query storage.TransactionQuery) (storage.TransactionPage, error) {
+	if m.queryTransactions != nil {
+		return m.queryTransactions(query)
+	}
+	transactions, err := m.getAllTransactions()
+	return storage.TransactionPage{Transactions: transactions}, err
+}
+
+// IterateTransactions liefert ohne eigene Funktion alle Transaktionen aus getAllTransactions
+func (m *mockDepot) IterateTransactions(ctx context.Context, query storage.TransactionQuery) iter.Seq2[storage.Transaction, error] {
+	if m.iterateTransactions != nil {
+		return m.iterateTransactions(query)
+	}
+	return func(yield func(storage.Transaction, error) bool) {
+		transactions, err := m.getAllTransactions()
+		if err != nil {
+			yield(storage.Transaction{}, err)
+			return
+		}
+		for _, transaction := range transactions {
+			if !yield(transaction, nil) {
+				return
+			}
+		}
+	}
+}
+
+// QueryRealizedGains liefert ohne eigene Funktion alle Abrechnungen aus getAllRealizedGains
+func (m *mockDepot) QueryRealizedGains(ctx context.Context, query storage.RealizedGainQuery) (storage.RealizedGainPage, error) {
+	if m.queryRealizedGains != nil {
+		return m.queryRealizedGains(query)
+	}
+	gains, err := m.getAllRealizedGains()
+	return storage.RealizedGainPage{RealizedGains: gains}, err
+}
+
+func (m *mockDepot) GetAllocation(groupBy string, categories map[string]string, prices map[string]float64, rates portfolio.ExchangeRates) (portfolio.Allocation, error) {
+	return m.getAllocation(groupBy, categories, prices, rates)
+}
+
+func (m *mockDepot) Rebalance(request portfolio.RebalanceRequest) (portfolio.RebalancePlan, error) {
+	return m.rebalance(request)
+}
+
 func TestPingHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -84,7 +153,7 @@ func TestAddTransactionHandler_Success(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mock := &mockDepot{
-		addTransaction: func(tr storage.Transaction) error {
+		addTransaction: func(ctx context.Context, tr storage.Transaction) error {
 			return nil
 		},
 	}
@@ -153,7 +222,7 @@ func TestAddTransactionHandler_BadRequest(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mock := &mockDepot{
-		addTransaction: func(tr storage.Transaction) error {
+		addTransaction: func(ctx context.Context, tr storage.Transaction) error {
 			return nil
 		},
 	}
@@ -168,8 +237,8 @@ func TestAddTransactionHandler_BadRequest(t *testing.T) {
 
 	router.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
 	}
 
 	var resp ApiResponse
@@ -195,7 +264,7 @@ func TestAddTransactionHandler_AddTransactionError(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mock := &mockDepot{
-		addTransaction: func(tr storage.Transaction) error {
+		addTransaction: func(ctx context.Context, tr storage.Transaction) error {
 			return errors.New("db error")
 		},
 	}
@@ -222,8 +291,8 @@ func TestAddTransactionHandler_AddTransactionError(t *testing.T) {
 
 	router.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, w.Code)
 	}
 
 	var resp ApiResponse
@@ -246,6 +315,47 @@ func TestAddTransactionHandler_AddTransactionError(t *testing.T) {
 
 }
 
+func TestAddTransactionHandler_RequestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var deadline time.Time
+	mock := &mockDepot{
+		addTransaction: func(ctx context.Context, tr storage.Transaction) error {
+			deadline, _ = ctx.Deadline()
+			<-ctx.Done()
+			//Wie das Depot, wenn der Store mit dem abgelaufenen Context abbricht
+			return &portfolio.StoreError{Action: "add transaction to store", Err: ctx.Err()}
+		},
+	}
+
+	router := gin.New()
+	router.Use(RequestTimeout(50 * time.Millisecond))
+	router.POST("/transaction", AddTransactionHandler(mock))
+
+	body, _ := json.Marshal(storage.Transaction{Date: time.Now(), TransactionType: "buy", Asset: "Apple Inc.", Currency: "USD",
+		TickerSymbol: "AAPL", Quantity: 10, Price: 150.0, Fees: 1.0, AssetType: "stock"})
+	req, _ := http.NewRequest(http.MethodPost, "/transaction", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	router.ServeHTTP(w, req)
+
+	if deadline.IsZero() || time.Since(start) > 5*time.Second {
+		t.Fatalf("Expected the depot to get the request context with deadline, got deadline %v", deadline)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	var resp ApiResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Status != "error" || !strings.Contains(resp.ErrorDetails, context.DeadlineExceeded.Error()) {
+		t.Errorf("Expected error with deadline exceeded, got %+v", resp)
+	}
+}
+
 func TestGetEntriesHandler_Success(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -598,6 +708,74 @@ func TestGetAllTransactionsAsTextHandler_Success(t *testing.T) {
 	}
 }
 
+func TestGetAllTransactionsAsTextHandler_Streaming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := &mockDepot{
+		iterateTransactions: func(query storage.TransactionQuery) iter.Seq2[storage.Transaction, error] {
+			return func(yield func(storage.Transaction, error) bool) {
+				for i := range 3 {
+					if !yield(storage.Transaction{Date: time.Date(2025, 1, i+1, 0, 0, 0, 0, time.UTC), TransactionType: "buy", TickerSymbol: query.TickerSymbol}, nil) {
+						return
+					}
+				}
+				yield(storage.Transaction{}, errors.New("connection lost"))
+			}
+		},
+	}
+	router := gin.New()
+	router.GET("/getalltransactions", GetAllTransactionsHandler(mock))
+
+	req, _ := http.NewRequest(http.MethodGet, "/getalltransactions?tickerSymbol=AAPL", nil)
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	//Die bis zum Fehler gelesenen Transaktionen sind schon gesendet
+	lines := bytes.Split(w.Body.Bytes(), []byte("\n"))
+	if w.Code != http.StatusOK || len(lines) != 3 || !bytes.HasPrefix(lines[2], []byte("2025-01-03;buy;;;AAPL;")) {
+		t.Errorf("Expected 3 streamed transactions, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetAllTransactionsHandler_Query(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var received storage.TransactionQuery
+	mock := &mockDepot{
+		queryTransactions: func(query storage.TransactionQuery) (storage.TransactionPage, error) {
+			received = query
+			return storage.TransactionPage{Transactions: []storage.Transaction{{Id: uuid.New(), TickerSymbol: "AAPL"}}, NextCursor: "next"}, nil
+		},
+	}
+	router := gin.New()
+	router.GET("/getalltransactions", GetAllTransactionsHandler(mock))
+
+	req, _ := http.NewRequest(http.MethodGet, "/getalltransactions?from=2025-01-01&to=31.03.2025&tickerSymbol=AAPL&currency=EUR&sortBy=tickerSymbol&desc=true&limit=1&cursor=abc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := storage.TransactionQuery{From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+		TickerSymbol: "AAPL", Currency: "EUR", SortBy: "tickerSymbol", Descending: true, Limit: 1, Cursor: "abc"}
+	if received != expected {
+		t.Errorf("Expected query %+v, got %+v", expected, received)
+	}
+	if w.Header().Get("X-Next-Cursor") != "next" {
+		t.Errorf("Expected header X-Next-Cursor 'next', got %q", w.Header().Get("X-Next-Cursor"))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/getalltransactions?limit=-1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp ApiResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Status != "error" || resp.ErrorMessage != "Invalid query" {
+		t.Errorf("Expected error 'Invalid query', got %+v", resp)
+	}
+}
+
 func TestGetAllTransactionsHandler_Error(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -638,3 +816,522 @@ func TestGetAllTransactionsHandler_Error(t *testing.T) {
 		t.Errorf("Expected error details 'db error', got %s", resp.ErrorDetails)
 	}
 }
+
+func TestRebalanceHandler_UsesConfigTargets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	allocationConfig := config.AllocationConfig{
+		Categories:    map[string]string{"AAPL": "equity"},
+		TargetWeights: map[string]float64{"equity": 80, "bonds": 20},
+		BaseCurrency:  "EUR",
+		ExchangeRates: map[string]float64{"USD": 0.92},
+	}
+
+	var received portfolio.RebalanceRequest
+	mock := &mockDepot{
+		rebalance: func(request portfolio.RebalanceRequest) (portfolio.RebalancePlan, error) {
+			received = request
+			return portfolio.RebalancePlan{}, nil
+		},
+	}
+
+	router := gin.New()
+	router.POST("/rebalance", RebalanceHandler(mock, allocationConfig))
+
+	req, _ := http.NewRequest(http.MethodPost, "/rebalance", bytes.NewBufferString(`{"cash": 500, "noSell": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	var resp ApiResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if resp.Status != "success" {
+		t.Errorf("Expected success status, got %s", resp.Status)
+	}
+
+	if received.Cash != 500 || !received.NoSell {
+		t.Errorf("Expected cash and noSell from request body, got %+v", received)
+	}
+
+	if received.TargetWeights["bonds"] != 20 || received.Categories["AAPL"] != "equity" {
+		t.Errorf("Expected targets and categories from config, got %+v", received)
+	}
+
+	if received.ExchangeRates.BaseCurrency != "EUR" || received.ExchangeRates.Rates["USD"] != 0.92 {
+		t.Errorf("Expected base currency and exchange rates from config, got %+v", received.ExchangeRates)
+	}
+}
+
+func TestGetAllocationHandler_UsesConfigRates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	allocationConfig := config.AllocationConfig{
+		Categories:    map[string]string{"AAPL": "equity"},
+		BaseCurrency:  "EUR",
+		ExchangeRates: map[string]float64{"USD": 0.92},
+	}
+
+	var receivedGroupBy string
+	var receivedCategories map[string]string
+	var receivedRates portfolio.ExchangeRates
+	mock := &mockDepot{
+		getAllocation: func(groupBy string, categories map[string]string, prices map[string]float64, rates portfolio.ExchangeRates) (portfolio.Allocation, error) {
+			receivedGroupBy = groupBy
+			receivedCategories = categories
+			receivedRates = rates
+			return portfolio.Allocation{}, nil
+		},
+	}
+
+	router := gin.New()
+	router.GET("/getallocation", GetAllocationHandler(mock, allocationConfig))
+
+	req, _ := http.NewRequest(http.MethodGet, "/getallocation?groupBy=category", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if receivedGroupBy != "category" || receivedCategories["AAPL"] != "equity" {
+		t.Errorf("Expected groupBy from query and categories from config, got %s %+v", receivedGroupBy, receivedCategories)
+	}
+
+	if receivedRates.BaseCurrency != "EUR" || receivedRates.Rates["USD"] != 0.92 {
+		t.Errorf("Expected base currency and exchange rates from config, got %+v", receivedRates)
+	}
+}
+
+func TestGetAllocationHandler_Error(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := &mockDepot{
+		getAllocation: func(groupBy string, categories map[string]string, prices map[string]float64, rates portfolio.ExchangeRates) (portfolio.Allocation, error) {
+			return portfolio.Allocation{}, errors.New("allocation group \"sector\" not supported")
+		},
+	}
+
+	router := gin.New()
+	router.GET("/getallocation", GetAllocationHandler(mock, config.AllocationConfig{}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/getallocation?groupBy=sector", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	var resp ApiResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if resp.Status != "error" {
+		t.Errorf("Expected error status, got %s", resp.Status)
+	}
+
+	if resp.ErrorMessage != "Could not retrieve allocation" {
+		t.Errorf("Expected error message 'Could not retrieve allocation', got %s", resp.ErrorMessage)
+	}
+}
+
+func TestUpdateTransactionHandler_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	id := uuid.New()
+	var received storage.Transaction
+	mock := &mockDepot{
+		updateTransaction: func(tr storage.Transaction) error {
+			received = tr
+			return nil
+		},
+	}
+
+	router := gin.New()
+	router.PUT("/transaction/:id", UpdateTransactionHandler(mock))
+
+	tx := storage.Transaction{
+		Date:            time.Now(),
+		TransactionType: "buy",
+		Asset:           "Apple Inc.",
+		Currency:        "USD",
+		Fees:            1.0,
+		TickerSymbol:    "AAPL",
+		Quantity:        12,
+		Price:           150.0,
+		AssetType:       "stock",
+	}
+	body, _ := json.Marshal(tx)
+
+	req, _ := http.NewRequest(http.MethodPut, "/transaction/"+id.String(), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	var resp ApiResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if resp.Status != "success" {
+		t.Errorf("Expected success status, got %s", resp.Status)
+	}
+
+	if received.Id != id || received.Quantity != 12 {
+		t.Errorf("Expected transaction with id from path, got %+v", received)
+	}
+}
+
+func TestRemoveTransactionHandler_Error(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := &mockDepot{
+		removeTransaction: func(id uuid.UUID) error {
+			return errors.New("transaction cannot be removed: not enough assets available for this sell transaction AAPL")
+		},
+	}
+
+	router := gin.New()
+	router.DELETE("/transaction/:id", RemoveTransactionHandler(mock))
+
+	req, _ := http.NewRequest(http.MethodDelete, "/transaction/"+uuid.New().String(), nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	var resp ApiResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if resp.Status != "error" {
+		t.Errorf("Expected error status, got %s", resp.Status)
+	}
+
+	if resp.Message != "Failed to remove transaction" {
+		t.Errorf("Expected error message 'Failed to remove transaction', got %s", resp.Message)
+	}
+}
+
+func TestGetEntriesAsOfHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var received time.Time
+	mock := &mockDepot{
+		getEntriesAsOf: func(date time.Time) (map[string]portfolio.DepotEntry, error) {
+			received = date
+			return map[string]portfolio.DepotEntry{}, nil
+		},
+	}
+
+	router := gin.New()
+	router.GET("/getentriesasof", GetEntriesAsOfHandler(mock))
+
+	for _, value := range []string{"2024-12-31", "31.12.2024"} {
+		req, _ := http.NewRequest(http.MethodGet, "/getentriesasof?date="+value, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		var resp ApiResponse
+		err := json.Unmarshal(w.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+
+		if resp.Status != "success" {
+			t.Errorf("Expected success status for %s, got %s", value, resp.Status)
+		}
+
+		if !received.Equal(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected date 2024-12-31, got %v", received)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/getentriesasof?date=yesterday", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	var resp ApiResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if resp.Status != "error" || resp.ErrorMessage != "Invalid date" {
+		t.Errorf("Expected error for invalid date, got %+v", resp)
+	}
+}
+
+func TestAddTransactionHandler_Problem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		err           error
+		expectedCode  int
+		expectedType  string
+		expectedField string
+	}{
+		{"duplicate", &portfolio.DuplicateError{Existing: storage.Transaction{Id: uuid.New()}}, http.StatusConflict, "/problems/duplicate-transaction", ""},
+		{"no open lots", fmt.Errorf("%w: not enough assets available for this sell transaction AAPL", portfolio.ErrNoOpenLots), http.StatusUnprocessableEntity, "/problems/no-open-lots", ""},
+		{"unsupported type", fmt.Errorf("%w: %q", portfolio.ErrUnsupportedType, "swap"), http.StatusUnprocessableEntity, "/problems/unsupported-type", ""},
+		{"validation", &portfolio.ValidationError{Field: "price", Message: "price must be greater than zero"}, http.StatusUnprocessableEntity, "/problems/validation-failed", "price"},
+		{"not found", &portfolio.StoreError{Action: "load transaction from store", Err: storage.ErrNotFound}, http.StatusNotFound, "/problems/not-found", ""},
+		{"store", &portfolio.StoreError{Action: "add transaction to store", Err: errors.New("database is locked")}, http.StatusInternalServerError, "/problems/store-unavailable", ""},
+		{"canceled", &portfolio.StoreError{Action: "add transaction to store", Err: context.Canceled}, http.StatusServiceUnavailable, "/problems/request-canceled", ""},
+		{"deadline exceeded", &portfolio.StoreError{Action: "add transaction to store", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, "/problems/request-timeout", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockDepot{
+				addTransaction: func(ctx context.Context, tr storage.Transaction) error {
+					return tt.err
+				},
+			}
+			router := gin.New()
+			router.POST("/transaction", AddTransactionHandler(mock))
+
+			tx := storage.Transaction{Date: time.Now(), TransactionType: "buy", AssetType: "stock", Asset: "Apple Inc.",
+				TickerSymbol: "AAPL", Quantity: 10, Price: 150.0, Fees: 1.0, Currency: "USD"}
+			body, _ := json.Marshal(tx)
+			req, _ := http.NewRequest(http.MethodPost, "/transaction", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/problem+json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status code %d, got %d", tt.expectedCode, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("Expected problem content type, got %s", contentType)
+			}
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("Failed to unmarshal problem: %v", err)
+			}
+			if problem.Type != tt.expectedType || problem.Status != tt.expectedCode || problem.Detail != tt.err.Error() || problem.Instance != "/transaction" {
+				t.Errorf("Unexpected problem %+v", problem)
+			}
+			if tt.expectedField != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.expectedField) {
+				t.Errorf("Expected validation error for %s, got %+v", tt.expectedField, problem.Errors)
+			}
+		})
+	}
+}
+
+func TestAddTransactionHandler_ZeroFees(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var received storage.Transaction
+	mock := &mockDepot{
+		addTransaction: func(ctx context.Context, tr storage.Transaction) error {
+			received = tr
+			return nil
+		},
+	}
+	router := gin.New()
+	router.POST("/transaction", AddTransactionHandler(mock))
+
+	body := `{"date":"2025-01-02T00:00:00Z","transactionType":"buy","assetType":"stock","asset":"Apple Inc.",` +
+		`"tickerSymbol":"AAPL","quantity":10,"price":150,"fees":0,"currency":"USD"}`
+	req, _ := http.NewRequest(http.MethodPost, "/transaction", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	//Die Prüfung der Werte übernimmt das Depot, nicht das Binden
+	if w.Code != http.StatusOK || received.TickerSymbol != "AAPL" || received.Fees != 0 {
+		t.Errorf("Expected transaction without fees to be passed to the depot, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// mockRegistry implementiert die Stammdaten der Instrumente für die Tests
+type mockRegistry struct {
+	addInstrument     func(storage.Instrument) (storage.Instrument, error)
+	updateInstrument  func(storage.Instrument) (storage.Instrument, error)
+	removeInstrument  func(string) error
+	getInstrument     func(string) (*storage.Instrument, error)
+	searchInstruments func(storage.InstrumentQuery) ([]storage.Instrument, error)
+}
+
+func (m *mockRegistry) AddInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error) {
+	return m.addInstrument(instrument)
+}
+
+func (m *mockRegistry) UpdateInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error) {
+	return m.updateInstrument(instrument)
+}
+
+func (m *mockRegistry) RemoveInstrument(ctx context.Context, isin string) error {
+	return m.removeInstrument(isin)
+}
+
+func (m *mockRegistry) GetInstrument(ctx context.Context, isin string) (*storage.Instrument, error) {
+	return m.getInstrument(isin)
+}
+
+func (m *mockRegistry) SearchInstruments(ctx context.Context, query storage.InstrumentQuery) ([]storage.Instrument, error) {
+	return m.searchInstruments(query)
+}
+
+func TestInstrumentHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	apple := storage.Instrument{Isin: "US0378331005", Wkn: "865985", Tickers: []string{"AAPL"}, Name: "Apple Inc.",
+		Type: "stock", Currency: "USD", Country: "US"}
+	var searched storage.InstrumentQuery
+	var updated storage.Instrument
+	mock := &mockRegistry{
+		addInstrument: func(instrument storage.Instrument) (storage.Instrument, error) {
+			return instrument, fmt.Errorf("%w: %s", portfolio.ErrDuplicateInstrument, instrument.Isin)
+		},
+		updateInstrument: func(instrument storage.Instrument) (storage.Instrument, error) {
+			updated = instrument
+			return instrument, nil
+		},
+		removeInstrument: func(isin string) error {
+			return &portfolio.ValidationError{Field: "isin", Message: "instrument " + isin + " is still used by transactions"}
+		},
+		getInstrument: func(isin string) (*storage.Instrument, error) {
+			return nil, fmt.Errorf("instrument %s %w", isin, storage.ErrNotFound)
+		},
+		searchInstruments: func(query storage.InstrumentQuery) ([]storage.Instrument, error) {
+			searched = query
+			return []storage.Instrument{apple}, nil
+		},
+	}
+	router := gin.New()
+	router.GET("/instruments", SearchInstrumentsHandler(mock))
+	router.GET("/instruments/:isin", GetInstrumentHandler(mock))
+	router.POST("/instruments", AddInstrumentHandler(mock))
+	router.PUT("/instruments/:isin", UpdateInstrumentHandler(mock))
+	router.DELETE("/instruments/:isin", RemoveInstrumentHandler(mock))
+
+	body, _ := json.Marshal(apple)
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         []byte
+		expectedCode int
+	}{
+		{"search", http.MethodGet, "/instruments?text=app&country=US&limit=5", nil, http.StatusOK},
+		{"invalid limit", http.MethodGet, "/instruments?limit=x", nil, http.StatusBadRequest},
+		{"not found", http.MethodGet, "/instruments/US5949181045", nil, http.StatusNotFound},
+		{"duplicate", http.MethodPost, "/instruments", body, http.StatusConflict},
+		{"invalid body", http.MethodPost, "/instruments", []byte("{"), http.StatusBadRequest},
+		{"update", http.MethodPut, "/instruments/US0378331005", []byte(`{"isin":"ignored","name":"Apple","type":"stock"}`), http.StatusOK},
+		{"still used", http.MethodDelete, "/instruments/US0378331005", nil, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status code %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if searched.Text != "app" || searched.Country != "US" || searched.Limit != 5 {
+		t.Errorf("Expected query parameters in search, got %+v", searched)
+	}
+	if updated.Isin != "US0378331005" || updated.Name != "Apple" {
+		t.Errorf("Expected ISIN from path in update, got %+v", updated)
+	}
+}
+
+// mockAdmin implementiert portfolio.Administration für die Admin-Routen
+type mockAdmin struct {
+	restored bool
+}
+
+func (m *mockAdmin) Backup(ctx context.Context, metadata storage.ArchiveMetadata) (*storage.Archive, error) {
+	return &storage.Archive{Version: storage.ArchiveVersion, CreatedAt: time.Now(), Metadata: metadata}, nil
+}
+
+func (m *mockAdmin) Restore(ctx context.Context, archive *storage.Archive) error {
+	m.restored = true
+	return nil
+}
+
+func (m *mockAdmin) Verify(ctx context.Context, repair bool) (portfolio.VerifyReport, error) {
+	return portfolio.VerifyReport{}, nil
+}
+
+func TestAdminAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		authorization string
+		expectedCode  int
+	}{
+		{"remote without token", "", "192.0.2.1:4711", "", http.StatusForbidden},
+		{"localhost without token", "", "127.0.0.1:4711", "", http.StatusOK},
+		{"localhost ipv6 without token", "", "[::1]:4711", "", http.StatusOK},
+		{"missing token", "secret", "127.0.0.1:4711", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "192.0.2.1:4711", "Bearer wrong", http.StatusUnauthorized},
+		{"token", "secret", "192.0.2.1:4711", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			admin := router.Group("/api/admin", AdminAccess(tt.token))
+			admin.GET("/verify", VerifyHandler(&mockAdmin{}, false))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/verify", nil)
+			req.RemoteAddr = tt.remoteAddr
+			//Zählt nicht, der Client kann den Header selbst setzen
+			req.Header.Set("X-Forwarded-For", "127.0.0.1")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status code %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRestoreHandler_TooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := &mockAdmin{}
+	router := gin.New()
+	router.POST("/restore", RestoreHandler(mock, 1024))
+
+	req, _ := http.NewRequest(http.MethodPost, "/restore", bytes.NewBuffer(bytes.Repeat([]byte(" "), 2048)))
+	req.Header.Set("Accept", problemContentType)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge || mock.restored {
+		t.Errorf("Expected status code %d without restore, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// SearchInstrumentsHandler sucht Instrumente. Gefiltert wird über die Query-Parameter text (ISIN, WKN,
+// Name oder Tickersymbol), type, country, sector und limit. Ohne Parameter werden alle geliefert.
+func SearchInstrumentsHandler(registry portfolio.InstrumentRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Instruments loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		limit, err := limitFromQuery(c)
+		if err != nil {
+			response.Message = ""
+			response.ErrorMessage = "Invalid filter"
+			respondBadRequest(c, response, err)
+			return
+		}
+		query := storage.InstrumentQuery{
+			Text:    c.Query("text"),
+			Type:    c.Query("type"),
+			Country: c.Query("country"),
+			Sector:  c.Query("sector"),
+			Limit:   limit,
+		}
+
+		data, err := registry.SearchInstruments(c.Request.Context(), query)
+		if err != nil {
+			response.Message = ""
+			response.ErrorMessage = "Could not retrieve instruments"
+			respondError(c, response, err)
+			return
+		}
+		response.Data = data
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func GetInstrumentHandler(registry portfolio.InstrumentRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instrument, err := registry.GetInstrument(c.Request.Context(), c.Param("isin"))
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve instrument"}, err)
+			return
+		}
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Instrument loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         instrument,
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func AddInstrumentHandler(registry portfolio.InstrumentRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Instrument added successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		var instrument storage.Instrument
+		if err := c.ShouldBindJSON(&instrument); err != nil {
+			response.Message = "Failed to add instrument"
+			response.ErrorMessage = "Invalid request body"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		instrument, err := registry.AddInstrument(c.Request.Context(), instrument)
+		if err != nil {
+			log.Printf("Error adding instrument: %v\n", err)
+			response.Message = "Failed to add instrument"
+			respondError(c, response, err)
+			return
+		}
+
+		response.Data = instrument
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// UpdateInstrumentHandler ersetzt die Stammdaten des Instruments. Die ISIN aus dem Pfad gilt,
+// eine ISIN im Body wird ignoriert.
+func UpdateInstrumentHandler(registry portfolio.InstrumentRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Instrument updated successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		var instrument storage.Instrument
+		if err := c.ShouldBindJSON(&instrument); err != nil {
+			response.Message = "Failed to update instrument"
+			response.ErrorMessage = "Invalid request body"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		instrument.Isin = c.Param("isin")
+		instrument, err := registry.UpdateInstrument(c.Request.Context(), instrument)
+		if err != nil {
+			log.Printf("Error updating instrument: %v\n", err)
+			response.Message = "Failed to update instrument"
+			respondError(c, response, err)
+			return
+		}
+
+		response.Data = instrument
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func RemoveInstrumentHandler(registry portfolio.InstrumentRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Instrument removed successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		err := registry.RemoveInstrument(c.Request.Context(), c.Param("isin"))
+		if err != nil {
+			response.Message = "Failed to remove instrument"
+			respondError(c, response, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem beschreibt einen Fehler nach RFC 7807. Errors enthält bei ungültigen Eingaben die einzelnen Fehler.
+type Problem struct {
+	Type     string                      `json:"type"`
+	Title    string                      `json:"title"`
+	Status   int                         `json:"status"`
+	Detail   string                      `json:"detail,omitempty"`
+	Instance string                      `json:"instance,omitempty"`
+	Errors   []portfolio.ValidationError `json:"errors,omitempty"`
+}
+
+type problemType struct {
+	status int
+	name   string
+	title  string
+}
+
+var (
+	problemBadRequest    = problemType{http.StatusBadRequest, "bad-request", "Invalid request"}
+	problemNotFound      = problemType{http.StatusNotFound, "not-found", "Not found"}
+	problemDuplicate     = problemType{http.StatusConflict, "duplicate-transaction", "Transaction already exists"}
+	problemDuplicateIsin = problemType{http.StatusConflict, "duplicate-instrument", "Instrument already exists"}
+	problemValidation    = problemType{http.StatusUnprocessableEntity, "validation-failed", "Validation failed"}
+	problemNoOpenLots    = problemType{http.StatusUnprocessableEntity, "no-open-lots", "No open lots"}
+	problemUnsupported   = problemType{http.StatusUnprocessableEntity, "unsupported-type", "Type not supported"}
+	problemUnauthorized  = problemType{http.StatusUnauthorized, "unauthorized", "Unauthorized"}
+	problemForbidden     = problemType{http.StatusForbidden, "forbidden", "Forbidden"}
+	problemTooLarge      = problemType{http.StatusRequestEntityTooLarge, "request-too-large", "Request too large"}
+	problemStore         = problemType{http.StatusInternalServerError, "store-unavailable", "Store unavailable"}
+	problemCanceled      = problemType{http.StatusServiceUnavailable, "request-canceled", "Request canceled"}
+	problemTimeout       = problemType{http.StatusGatewayTimeout, "request-timeout", "Request timed out"}
+	problemInternalError = problemType{http.StatusInternalServerError, "internal-error", "Internal error"}
+)
+
+// problemTypeOf ordnet einen Fehler des Depots oder Stores einer Problemart zu. Die Reihenfolge ist wichtig:
+// Ein StoreError mit storage.ErrNotFound ist ein fehlender Eintrag und kein Ausfall des Stores. Ebenso ist
+// ein StoreError mit einem abgebrochenen oder abgelaufenen Context der Anfrage kein Ausfall des Stores.
+func problemTypeOf(err error) problemType {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return problemTimeout
+	case errors.Is(err, context.Canceled):
+		return problemCanceled
+	case errors.Is(err, storage.ErrNotFound):
+		return problemNotFound
+	case errors.Is(err, portfolio.ErrDuplicateTransaction):
+		return problemDuplicate
+	case errors.Is(err, portfolio.ErrDuplicateInstrument):
+		return problemDuplicateIsin
+	case errors.Is(err, portfolio.ErrValidation):
+		return problemValidation
+	case errors.Is(err, portfolio.ErrNoOpenLots):
+		return problemNoOpenLots
+	case errors.Is(err, portfolio.ErrUnsupportedType):
+		return problemUnsupported
+	case errors.Is(err, portfolio.ErrStoreUnavailable):
+		return problemStore
+	}
+	return problemInternalError
+}
+
+// respondError antwortet mit dem zum Fehler passenden Statuscode. Clients, die application/problem+json
+// akzeptieren, bekommen ein Problem nach RFC 7807, alle anderen wie bisher eine ApiResponse.
+// In response sind Message und ErrorMessage schon gesetzt.
+func respondError(c *gin.Context, response *ApiResponse, err error) {
+	writeError(c, problemTypeOf(err), response, err)
+}
+
+// respondBadRequest antwortet auf eine ungültige Anfrage (Body, Pfad oder Query-Parameter) mit 400.
+func respondBadRequest(c *gin.Context, response *ApiResponse, err error) {
+	writeError(c, problemBadRequest, response, err)
+}
+
+func writeError(c *gin.Context, kind problemType, response *ApiResponse, err error) {
+	if !acceptsProblem(c) {
+		response.Status = "error"
+		response.ErrorDetails = err.Error()
+		response.Data = nil
+		c.JSON(kind.status, response)
+		return
+	}
+
+	problem := Problem{
+		Type:     "/problems/" + kind.name,
+		Title:    kind.title,
+		Status:   kind.status,
+		Detail:   err.Error(),
+		Instance: c.Request.URL.Path,
+		Errors:   portfolio.ValidationErrors(err),
+	}
+	body, marshalErr := json.Marshal(problem)
+	if marshalErr != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(kind.status, problemContentType, body)
+}
+
+func acceptsProblem(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), problemContentType)
+}
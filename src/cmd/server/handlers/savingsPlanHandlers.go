@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func GetSavingsPlansHandler(manager portfolio.SavingsPlanManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := manager.GetAllSavingsPlans(c.Request.Context())
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve savings plans"}, err)
+			return
+		}
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Savings plans loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         data,
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func AddSavingsPlanHandler(manager portfolio.SavingsPlanManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Savings plan added successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		var plan storage.SavingsPlan
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			response.Message = "Failed to add savings plan"
+			response.ErrorMessage = "Invalid request body"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		plan, err := manager.AddSavingsPlan(c.Request.Context(), plan)
+		if err != nil {
+			log.Printf("Error adding savings plan: %v\n", err)
+			response.Message = "Failed to add savings plan"
+			respondError(c, response, err)
+			return
+		}
+
+		response.Data = plan
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func RemoveSavingsPlanHandler(manager portfolio.SavingsPlanManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Savings plan removed successfully",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			response.Message = "Failed to remove savings plan"
+			response.ErrorMessage = "Invalid savings plan id"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		err = manager.RemoveSavingsPlan(c.Request.Context(), id)
+		if err != nil {
+			response.Message = "Failed to remove savings plan"
+			respondError(c, response, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func GetPendingTransactionsHandler(manager portfolio.SavingsPlanManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := manager.GetAllPendingTransactions(c.Request.Context())
+		if err != nil {
+			respondError(c, &ApiResponse{ErrorMessage: "Could not retrieve pending transactions"}, err)
+			return
+		}
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Pending transactions loaded",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         data,
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ConfirmPendingTransactionHandler übernimmt eine Sparplan-Transaktion mit Ausführungspreis
+// und Gebühren in das Depot.
+func ConfirmPendingTransactionHandler(manager portfolio.SavingsPlanManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		response := &ApiResponse{
+			Status:       "success",
+			Message:      "Pending transaction confirmed",
+			ErrorMessage: "",
+			ErrorDetails: "",
+			Data:         nil,
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			response.Message = "Failed to confirm pending transaction"
+			response.ErrorMessage = "Invalid pending transaction id"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		var execution portfolio.SavingsPlanExecution
+		if err := c.ShouldBindJSON(&execution); err != nil {
+			response.Message = "Failed to confirm pending transaction"
+			response.ErrorMessage = "Invalid request body"
+			respondBadRequest(c, response, err)
+			return
+		}
+
+		transaction, err := manager.ConfirmPendingTransaction(c.Request.Context(), id, execution)
+		if err != nil {
+			log.Printf("Error confirming pending transaction: %v\n", err)
+			response.Message = "Failed to confirm pending transaction"
+			respondError(c, response, err)
+			return
+		}
+
+		response.Data = transaction
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/fritzrepo/stockportfolio/cmd/server/handlers"
 	"github.com/fritzrepo/stockportfolio/internal/config"
@@ -20,14 +22,42 @@ var store storage.Store
 var depot *portfolio.Depot
 
 func main() {
+	defer store.Close()
 	router := gin.Default()
+	if appConfig.RequestTimeoutSeconds > 0 {
+		router.Use(handlers.RequestTimeout(time.Duration(appConfig.RequestTimeoutSeconds) * time.Second))
+	}
 
 	router.GET("/ping", handlers.PingHandler(appConfig))
 	router.GET("/api/depot/getentries", handlers.GetEntries(depot))
+	router.GET("/api/depot/getentriesasof", handlers.GetEntriesAsOfHandler(depot))
 	router.GET("/api/depot/getperformance", handlers.GetPerformanceHandler(depot))
 	router.GET("/api/depot/getrealizedgains", handlers.GetRealizedGains(depot))
 	router.POST("/api/depot/addTransaction", handlers.AddTransactionHandler(depot))
+	router.PUT("/api/depot/updateTransaction/:id", handlers.UpdateTransactionHandler(depot))
+	router.DELETE("/api/depot/removeTransaction/:id", handlers.RemoveTransactionHandler(depot))
 	router.GET("/api/depot/getalltransactions", handlers.GetAllTransactionsHandler(depot))
+	router.GET("/api/depot/getallocation", handlers.GetAllocationHandler(depot, appConfig.Allocation))
+	router.POST("/api/depot/rebalance", handlers.RebalanceHandler(depot, appConfig.Allocation))
+	router.GET("/api/savingsplans/getsavingsplans", handlers.GetSavingsPlansHandler(depot))
+	router.POST("/api/savingsplans/addSavingsPlan", handlers.AddSavingsPlanHandler(depot))
+	router.DELETE("/api/savingsplans/removeSavingsPlan/:id", handlers.RemoveSavingsPlanHandler(depot))
+	router.GET("/api/savingsplans/getpendingtransactions", handlers.GetPendingTransactionsHandler(depot))
+	router.POST("/api/savingsplans/confirmPendingTransaction/:id", handlers.ConfirmPendingTransactionHandler(depot))
+	router.GET("/api/instruments/getinstruments", handlers.SearchInstrumentsHandler(depot))
+	router.GET("/api/instruments/getinstrument/:isin", handlers.GetInstrumentHandler(depot))
+	router.POST("/api/instruments/addInstrument", handlers.AddInstrumentHandler(depot))
+	router.PUT("/api/instruments/updateInstrument/:isin", handlers.UpdateInstrumentHandler(depot))
+	router.DELETE("/api/instruments/removeInstrument/:isin", handlers.RemoveInstrumentHandler(depot))
+	router.GET("/api/audit/getauditlog", handlers.GetAuditLogHandler(depot))
+	//Admin-Routen nur mit Token aus STOCKPORTFOLIO_ADMIN_TOKEN oder ohne Token nur von localhost
+	admin := router.Group("/api/admin", handlers.AdminAccess(os.Getenv(handlers.AdminTokenEnv)))
+	admin.GET("/backup", handlers.BackupHandler(depot, appConfig.ArchiveMetadata()))
+	admin.POST("/restore", handlers.RestoreHandler(depot, appConfig.MaxRestoreSize()))
+	admin.GET("/verify", handlers.VerifyHandler(depot, false))
+	admin.POST("/repair", handlers.VerifyHandler(depot, true))
+
+	startSavingsPlanScheduler(depot, savingsPlanSchedulerInterval)
 
 	router.Run()
 }
@@ -57,28 +87,66 @@ func loadAppConfig() {
 func initializingStore() {
 	log.Println("Initializing store...")
 
-	_, err := os.Stat(appConfig.DatabaseFilePath)
-	dbNotExists := os.IsNotExist(err)
+	driver, dsn := appConfig.Database()
+	var err error
+	store, err = appConfig.NewStore()
+	if err != nil {
+		log.Fatalf("Failed to create the store: %v", err)
+	}
 
-	store = storage.GetFileDatabase(appConfig.DatabaseFilePath)
+	dbNotExists := false
+	switch driver {
+	case storage.DriverSQLite, storage.DriverSQLiteEncrypted, storage.DriverCSV, storage.DriverJSON, storage.DriverYAML:
+		_, err = os.Stat(dsn)
+		dbNotExists = os.IsNotExist(err)
+	}
+
+	//Die Verbindung bleibt offen, solange der Server läuft
+	err = store.Open()
+	if err != nil {
+		log.Fatalf("Failed to open the database: %v", err)
+	}
 
 	if dbNotExists {
 		log.Println("Database file does not exist, creating a new one...")
-		store.CreateDatabase()
+		store.CreateDatabase(context.Background())
+	} else if migrator, ok := store.(storage.Migrator); ok {
+		//Bestehende Datenbanken auf das aktuelle Schema bringen. Eine leere PostgreSQL-Datenbank wird dabei angelegt.
+		err = migrator.MigrateUp(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+	}
+
+	//Alle Änderungen über die API und den Scheduler werden dem Server zugeordnet
+	if auditLog, ok := store.(storage.AuditLog); ok {
+		auditLog.SetActor("server")
 	}
 
-	err = store.Ping()
+	err = store.Ping(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to connect to the database: %v", err)
 	} else {
-		log.Println("Store successful initialized and connected to the database.")
+		log.Printf("Store (%s) successful initialized and connected to the database.", driver)
 	}
 }
 
 func initializingDepot() error {
 	log.Println("Initializing depot...")
 	depot = portfolio.GetDepot(store)
-	err := depot.CalculateSecuritiesAccountBalance()
+	if len(appConfig.DuplicateFingerprint) > 0 {
+		err := depot.SetDuplicateFingerprint(appConfig.DuplicateFingerprint)
+		if err != nil {
+			log.Fatalf("Invalid duplicate fingerprint in config: %v", err)
+			return errors.New("failed to initialize depot")
+		}
+	}
+	err := depot.SetValidation(appConfig.Validation)
+	if err != nil {
+		log.Fatalf("Invalid validation rules in config: %v", err)
+		return errors.New("failed to initialize depot")
+	}
+	err = depot.CalculateSecuritiesAccountBalance(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to calculate securities account balance: %v", err)
 		return errors.New("failed to initialize depot")
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+)
+
+// Wie oft nach fälligen Sparplan-Ausführungen gesucht wird
+const savingsPlanSchedulerInterval = time.Hour
+
+// startSavingsPlanScheduler erzeugt beim Start und danach regelmäßig die fälligen
+// Sparplan-Transaktionen. Diese müssen anschließend mit Preis und Gebühren bestätigt werden.
+func startSavingsPlanScheduler(manager portfolio.SavingsPlanManager, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		createDueTransactions(manager)
+		for range ticker.C {
+			createDueTransactions(manager)
+		}
+	}()
+}
+
+func createDueTransactions(manager portfolio.SavingsPlanManager) {
+	created, err := manager.CreateDueTransactions(context.Background(), time.Now())
+	if err != nil {
+		log.Printf("Error creating due savings plan transactions: %v\n", err)
+	}
+	for _, pending := range created {
+		log.Printf("Created pending savings plan transaction: %s %s %.2f %s\n",
+			pending.DueDate.Format(time.DateOnly), pending.TickerSymbol, pending.Amount, pending.Currency)
+	}
+}
@@ -3,11 +3,105 @@ package config
 import (
 	"encoding/json"
 	"os"
+
+	"github.com/fritzrepo/stockportfolio/internal/portfolio"
+	"github.com/fritzrepo/stockportfolio/internal/storage"
 )
 
 type Config struct {
 	TransactionFilePath string `json:"transactionFilePath"`
 	DatabaseFilePath    string `json:"databaseFilePath"`
+	//Datenbanktreiber "sqlite" (Standard), "sqlite-encrypted", "postgres", "csv", "json" oder "yaml". Ohne DSN wird für SQLite
+	//DatabaseFilePath und für CSV TransactionFilePath verwendet. Für JSON und YAML ist der DSN ein Verzeichnis.
+	DatabaseDriver string `json:"databaseDriver"`
+	DatabaseDSN    string `json:"databaseDsn"`
+	//Format der CSV-Dateien. Ohne Angabe gilt storage.DefaultCsvOptions.
+	Csv storage.CsvOptions `json:"csv"`
+	//Transaktionen der JSON- und YAML-Dokumente in einer Datei ("single", Standard) oder pro Jahr ("yearly")
+	DocumentLayout string           `json:"documentLayout"`
+	Allocation     AllocationConfig `json:"allocation"`
+	//Felder für die Duplikaterkennung von Transaktionen ohne Auftragsnummer.
+	//Ohne Angabe wird portfolio.DefaultDuplicateFingerprint verwendet.
+	DuplicateFingerprint []string `json:"duplicateFingerprint"`
+	//Regeln für neue und geänderte Transaktionen. Ohne Angabe sind alle eingebauten Regeln aktiv.
+	Validation portfolio.ValidationConfig `json:"validation"`
+	//Zeitlimit für jede Anfrage an den Server in Sekunden. Danach werden Store-Zugriffe abgebrochen, 0 = ohne Limit.
+	RequestTimeoutSeconds int `json:"requestTimeoutSeconds"`
+	//Maximale Größe eines Backups beim Zurückspielen über den Server in MiB, 0 = 64 MiB.
+	MaxRestoreSizeMB int `json:"maxRestoreSizeMb"`
+}
+
+// AllocationConfig enthält die benutzerdefinierten Kategorien und deren Zielgewichtung
+// für die Aufteilung und das Rebalancing des Depots.
+type AllocationConfig struct {
+	Categories    map[string]string  `json:"categories"`    //TickerSymbol -> Kategorie
+	TargetWeights map[string]float64 `json:"targetWeights"` //Kategorie -> Zielgewichtung
+	//Basiswährung und Kurse (Währung -> Wert einer Einheit in der Basiswährung) für Depots mit mehreren Währungen
+	BaseCurrency  string             `json:"baseCurrency"`
+	ExchangeRates map[string]float64 `json:"exchangeRates"`
+}
+
+// Rates liefert Basiswährung und Kurse für die Aufteilung und das Rebalancing.
+func (c AllocationConfig) Rates() portfolio.ExchangeRates {
+	return portfolio.ExchangeRates{BaseCurrency: c.BaseCurrency, Rates: c.ExchangeRates}
+}
+
+// MaxRestoreSize liefert die maximale Größe eines Backups beim Zurückspielen über den Server in Byte.
+func (c *Config) MaxRestoreSize() int64 {
+	if c.MaxRestoreSizeMB <= 0 {
+		return 64 << 20
+	}
+	return int64(c.MaxRestoreSizeMB) << 20
+}
+
+// Database liefert Treiber und DSN der Datenbank.
+func (c *Config) Database() (string, string) {
+	driver := c.DatabaseDriver
+	if driver == "" {
+		driver = storage.DriverSQLite
+	}
+	dsn := c.DatabaseDSN
+	if dsn == "" && (driver == storage.DriverSQLite || driver == storage.DriverSQLiteEncrypted) {
+		dsn = c.DatabaseFilePath
+	}
+	if dsn == "" && driver == storage.DriverCSV {
+		dsn = c.TransactionFilePath
+	}
+	return driver, dsn
+}
+
+// NewStore erzeugt den Store für den konfigurierten Treiber und übernimmt das Format der Dateien.
+func (c *Config) NewStore() (storage.Store, error) {
+	store, err := storage.NewStore(c.Database())
+	if err != nil {
+		return nil, err
+	}
+	if csvStore, ok := store.(*storage.CsvStorage); ok {
+		err = csvStore.SetOptions(c.Csv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if documentStore, ok := store.(*storage.DocumentStorage); ok {
+		err = documentStore.SetLayout(c.DocumentLayout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// ArchiveMetadata beschreibt den Store für ein Backup. Übernommen werden nur die Einstellungen, die für die
+// Daten wichtig sind. Pfade und DSN (mit Zugangsdaten) gehören nicht ins Backup.
+func (c *Config) ArchiveMetadata() storage.ArchiveMetadata {
+	driver, _ := c.Database()
+	settings, _ := json.Marshal(struct {
+		Csv                  storage.CsvOptions `json:"csv"`
+		DocumentLayout       string             `json:"documentLayout"`
+		Allocation           AllocationConfig   `json:"allocation"`
+		DuplicateFingerprint []string           `json:"duplicateFingerprint"`
+	}{c.Csv, c.DocumentLayout, c.Allocation, c.DuplicateFingerprint})
+	return storage.ArchiveMetadata{Driver: driver, Settings: settings}
 }
 
 func LoadConfigFromJSON(filename string) (*Config, error) {
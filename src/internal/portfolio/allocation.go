@@ -0,0 +1,346 @@
+package portfolio
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Mögliche Gruppierungen für die Aufteilung des Depots
+const (
+	GroupByAssetType = "assetType"
+	GroupByCurrency  = "currency"
+	GroupByCategory  = "category"
+)
+
+// Kategorie für Assets, denen keine benutzerdefinierte Kategorie zugeordnet ist.
+const UnassignedCategory = "unassigned"
+
+type AllocationEntry struct {
+	Key           string   `json:"key"`    //Assettyp, Währung oder Kategorie
+	Value         float64  `json:"value"`  //Wert aller Assets dieser Gruppe
+	Weight        float64  `json:"weight"` //Anteil am Gesamtwert (0..1)
+	TickerSymbols []string `json:"tickerSymbols"`
+}
+
+type Allocation struct {
+	GroupBy    string            `json:"groupBy"`
+	Currency   string            `json:"currency"` //Währung aller Werte, die Basiswährung oder die einzige Währung im Depot
+	TotalValue float64           `json:"totalValue"`
+	Entries    []AllocationEntry `json:"entries"`
+}
+
+// ExchangeRates rechnet die Werte der Positionen in eine Basiswährung um, bevor sie addiert werden.
+// Ohne Basiswährung müssen alle Positionen dieselbe Währung haben.
+type ExchangeRates struct {
+	BaseCurrency string             `json:"baseCurrency"`
+	Rates        map[string]float64 `json:"rates"` //Währung -> Wert einer Einheit in der Basiswährung, z.B. USD -> 0.92 für EUR
+}
+
+type RebalanceRequest struct {
+	Categories    map[string]string  `json:"categories"`    //TickerSymbol -> Kategorie
+	TargetWeights map[string]float64 `json:"targetWeights"` //Kategorie -> Zielgewichtung (wird normiert)
+	Prices        map[string]float64 `json:"prices"`        //TickerSymbol -> aktueller Kurs. Fehlt er, wird der Einstandspreis verwendet.
+	Cash          float64            `json:"cash"`          //Neues Geld, das investiert werden soll, in der Währung der Aufteilung
+	NoSell        bool               `json:"noSell"`        //Nur mit neuem Geld umschichten, nie verkaufen
+	ExchangeRates ExchangeRates      `json:"exchangeRates"` //Nötig, wenn das Depot Positionen in mehreren Währungen hat
+}
+
+type RebalanceOrder struct {
+	Category        string  `json:"category"`
+	TransactionType string  `json:"transactionType"` // buy, sell
+	Asset           string  `json:"asset"`
+	TickerSymbol    string  `json:"tickerSymbol"` //Leer, wenn die Kategorie noch kein Asset enthält
+	Quantity        float64 `json:"quantity"`
+	Price           float64 `json:"price"`
+	Amount          float64 `json:"amount"`   //In der Währung des Assets
+	Currency        string  `json:"currency"` //Währung des Assets, ohne Asset die Währung der Aufteilung
+}
+
+type RebalancePlan struct {
+	TotalValue float64          `json:"totalValue"` //Depotwert inklusive neuem Geld
+	Cash       float64          `json:"cash"`
+	NoSell     bool             `json:"noSell"`
+	Current    Allocation       `json:"current"`
+	Orders     []RebalanceOrder `json:"orders"`
+}
+
+// GetAllocation berechnet die Aufteilung des aktuellen Depots nach Assettyp, Währung
+// oder benutzerdefinierter Kategorie. Ohne Kurse wird mit dem Einstandspreis bewertet.
+// Hat das Depot Positionen in mehreren Währungen, werden sie mit rates in die Basiswährung
+// umgerechnet. Fehlen Basiswährung oder ein Kurs, wird die Aufteilung abgelehnt.
+func (d *Depot) GetAllocation(groupBy string, categories map[string]string, prices map[string]float64, rates ExchangeRates) (Allocation, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return computeAllocation(d.depotEntries, groupBy, categories, prices, rates)
+}
+
+// Rebalance berechnet die Kauf- und Verkaufsaufträge, die nötig sind, um die
+// Zielgewichtung der Kategorien zu erreichen.
+func (d *Depot) Rebalance(request RebalanceRequest) (RebalancePlan, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return computeRebalancePlan(d.depotEntries, request)
+}
+
+func computeAllocation(entries map[string]DepotEntry, groupBy string, categories map[string]string, prices map[string]float64,
+	rates ExchangeRates) (Allocation, error) {
+	result := Allocation{GroupBy: groupBy, Entries: []AllocationEntry{}}
+
+	var err error
+	result.Currency, err = rates.currencyOf(entries)
+	if err != nil {
+		return result, err
+	}
+
+	groups := make(map[string]*AllocationEntry)
+	for _, entry := range entries {
+		key, err := allocationKey(entry, groupBy, categories)
+		if err != nil {
+			return result, err
+		}
+		group, exists := groups[key]
+		if !exists {
+			group = &AllocationEntry{Key: key}
+			groups[key] = group
+		}
+		value := entryValue(entry, prices) * rates.rate(entry.Currency)
+		group.Value += value
+		group.TickerSymbols = append(group.TickerSymbols, entry.TickerSymbol)
+		result.TotalValue += value
+	}
+
+	for _, group := range groups {
+		if result.TotalValue > 0 {
+			group.Weight = group.Value / result.TotalValue
+		}
+		sort.Strings(group.TickerSymbols)
+		result.Entries = append(result.Entries, *group)
+	}
+	sort.Slice(result.Entries, func(i, j int) bool {
+		return result.Entries[i].Key < result.Entries[j].Key
+	})
+	return result, nil
+}
+
+func computeRebalancePlan(entries map[string]DepotEntry, request RebalanceRequest) (RebalancePlan, error) {
+	plan := RebalancePlan{Cash: request.Cash, NoSell: request.NoSell, Orders: []RebalanceOrder{}}
+
+	if request.Cash < 0 {
+		return plan, validationError("cash", "cash must not be negative")
+	}
+
+	targets, err := normalizeWeights(request.TargetWeights)
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Current, err = computeAllocation(entries, GroupByCategory, request.Categories, request.Prices, request.ExchangeRates)
+	if err != nil {
+		return plan, err
+	}
+	plan.TotalValue = plan.Current.TotalValue + request.Cash
+	if plan.TotalValue <= 0 {
+		return plan, validationError("cash", "nothing to rebalance, depot and cash are empty")
+	}
+
+	current := make(map[string]float64)
+	for _, entry := range plan.Current.Entries {
+		current[entry.Key] = entry.Value
+	}
+
+	//Alle Kategorien, die entweder im Depot vorhanden sind oder eine Zielgewichtung haben.
+	//Kategorien ohne Zielgewichtung haben das Ziel 0 und werden (ohne NoSell) komplett verkauft.
+	categoryNames := make([]string, 0, len(targets)+len(current))
+	for category := range targets {
+		categoryNames = append(categoryNames, category)
+	}
+	for category := range current {
+		if _, exists := targets[category]; !exists {
+			categoryNames = append(categoryNames, category)
+		}
+	}
+	sort.Strings(categoryNames)
+
+	differences := make(map[string]float64)
+	for _, category := range categoryNames {
+		differences[category] = targets[category]*plan.TotalValue - current[category]
+	}
+
+	if request.NoSell {
+		//Das neue Geld wird anteilig auf die untergewichteten Kategorien verteilt.
+		//Die Summe der Fehlbeträge ist immer mindestens so groß wie das neue Geld.
+		totalDeficit := 0.0
+		for _, diff := range differences {
+			if diff > 0 {
+				totalDeficit += diff
+			}
+		}
+		for _, category := range categoryNames {
+			diff := differences[category]
+			if diff <= 0 || totalDeficit <= 0 {
+				differences[category] = 0
+				continue
+			}
+			differences[category] = request.Cash * diff / totalDeficit
+		}
+	}
+
+	for _, category := range categoryNames {
+		amount := roundToCent(differences[category])
+		if amount == 0 {
+			continue
+		}
+		plan.Orders = append(plan.Orders, splitCategoryOrder(entries, request, plan.Current.Currency, category, amount)...)
+	}
+	return plan, nil
+}
+
+// splitCategoryOrder verteilt den Betrag einer Kategorie (in der Währung der Aufteilung) anteilig auf die Assets
+// dieser Kategorie. Die Aufträge der Assets werden in deren Währung umgerechnet.
+func splitCategoryOrder(entries map[string]DepotEntry, request RebalanceRequest, currency string, category string, amount float64) []RebalanceOrder {
+	transactionType := "buy"
+	if amount < 0 {
+		transactionType = "sell"
+		amount = -amount
+	}
+
+	var holdings []DepotEntry
+	categoryValue := 0.0
+	for _, entry := range entries {
+		if categoryOf(entry.TickerSymbol, request.Categories) == category {
+			holdings = append(holdings, entry)
+			categoryValue += entryValue(entry, request.Prices) * request.ExchangeRates.rate(entry.Currency)
+		}
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		return holdings[i].TickerSymbol < holdings[j].TickerSymbol
+	})
+
+	//Die Kategorie hat noch keine Assets. Der Auftrag enthält dann nur den Betrag.
+	if len(holdings) == 0 || categoryValue <= 0 {
+		return []RebalanceOrder{{Category: category, TransactionType: transactionType, Amount: amount, Currency: currency}}
+	}
+
+	orders := make([]RebalanceOrder, 0, len(holdings))
+	for _, entry := range holdings {
+		price := entryPrice(entry, request.Prices)
+		//Anteil am Wert der Kategorie, umgerechnet in die Währung des Assets
+		rate := request.ExchangeRates.rate(entry.Currency)
+		valueInBase := entryValue(entry, request.Prices) * rate
+		share := roundToCent(amount * valueInBase / categoryValue / rate)
+		if share == 0 || price <= 0 {
+			continue
+		}
+		orders = append(orders, RebalanceOrder{
+			Category:        category,
+			TransactionType: transactionType,
+			Asset:           entry.Asset,
+			TickerSymbol:    entry.TickerSymbol,
+			Quantity:        share / price,
+			Price:           price,
+			Amount:          share,
+			Currency:        entry.Currency,
+		})
+	}
+	return orders
+}
+
+func allocationKey(entry DepotEntry, groupBy string, categories map[string]string) (string, error) {
+	switch groupBy {
+	case GroupByAssetType:
+		return entry.AssetType, nil
+	case GroupByCurrency:
+		return entry.Currency, nil
+	case GroupByCategory:
+		return categoryOf(entry.TickerSymbol, categories), nil
+	default:
+		return "", fmt.Errorf("allocation group %q not supported", groupBy)
+	}
+}
+
+// currencyOf liefert die Währung, in der die Werte der Positionen addiert werden. Ohne Basiswährung
+// müssen alle Positionen dieselbe Währung haben, sonst würden z.B. USD und EUR einfach addiert.
+func (r ExchangeRates) currencyOf(entries map[string]DepotEntry) (string, error) {
+	currencies := make(map[string]bool)
+	for _, entry := range entries {
+		currencies[entry.Currency] = true
+	}
+
+	if r.BaseCurrency == "" {
+		if len(currencies) > 1 {
+			return "", validationError("exchangeRates", fmt.Sprintf("holdings in %s need a base currency and exchange rates",
+				strings.Join(slices.Sorted(maps.Keys(currencies)), ", ")))
+		}
+		for currency := range currencies {
+			return currency, nil
+		}
+		return "", nil
+	}
+
+	for currency := range currencies {
+		rate, exists := r.Rates[currency]
+		if currency != r.BaseCurrency && (!exists || rate <= 0) {
+			return "", validationError("exchangeRates", fmt.Sprintf("no exchange rate from %s to %s", currency, r.BaseCurrency))
+		}
+	}
+	return r.BaseCurrency, nil
+}
+
+// rate liefert den Wert einer Einheit von currency in der Basiswährung. Die Kurse hat currencyOf
+// vorher geprüft, ohne Basiswährung gibt es nur eine Währung und damit nichts umzurechnen.
+func (r ExchangeRates) rate(currency string) float64 {
+	if r.BaseCurrency == "" || currency == r.BaseCurrency {
+		return 1
+	}
+	return r.Rates[currency]
+}
+
+func categoryOf(tickerSymbol string, categories map[string]string) string {
+	category, exists := categories[tickerSymbol]
+	if !exists || category == "" {
+		return UnassignedCategory
+	}
+	return category
+}
+
+func entryPrice(entry DepotEntry, prices map[string]float64) float64 {
+	if price, exists := prices[entry.TickerSymbol]; exists && price > 0 {
+		return price
+	}
+	return entry.Price
+}
+
+func entryValue(entry DepotEntry, prices map[string]float64) float64 {
+	return entry.Quantity * entryPrice(entry, prices)
+}
+
+// normalizeWeights skaliert die Zielgewichtungen auf die Summe 1.
+// So können sie auch in Prozent angegeben werden.
+func normalizeWeights(weights map[string]float64) (map[string]float64, error) {
+	if len(weights) == 0 {
+		return nil, validationError("targetWeights", "no target weights defined")
+	}
+	sum := 0.0
+	for category, weight := range weights {
+		if weight < 0 {
+			return nil, validationError("targetWeights", fmt.Sprintf("target weight of category %s must not be negative", category))
+		}
+		sum += weight
+	}
+	if sum <= 0 {
+		return nil, validationError("targetWeights", "sum of target weights must be greater than zero")
+	}
+	result := make(map[string]float64, len(weights))
+	for category, weight := range weights {
+		result[category] = weight / sum
+	}
+	return result, nil
+}
+
+func roundToCent(value float64) float64 {
+	return math.Round(value*100) / 100
+}
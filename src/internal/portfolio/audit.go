@@ -0,0 +1,47 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// GetAuditLog liefert die Einträge des Audit-Logs, die neuesten zuerst.
+func (d *Depot) GetAuditLog(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	auditLog, ok := d.store.(storage.AuditLog)
+	if !ok {
+		return nil, errors.New("store has no audit log")
+	}
+	entries, err := auditLog.ReadAuditLog(ctx, filter)
+	if err != nil {
+		return nil, storeError("read audit log from store", err)
+	}
+	return entries, nil
+}
+
+// recordAudit protokolliert einen Vorgang des Depots, der mehrere Store-Aufrufe auslöst (z.B. eine
+// Neuberechnung). Stores ohne Audit-Log werden übersprungen.
+func (d *Depot) recordAudit(ctx context.Context, operation string, entity string, details any) error {
+	auditLog, ok := d.store.(storage.AuditLog)
+	if !ok {
+		return nil
+	}
+	entry := storage.AuditEntry{Operation: operation, Entity: entity}
+	if details != nil {
+		content, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit entry: %w", err)
+		}
+		entry.After = content
+	}
+	err := auditLog.AddAuditEntry(ctx, &entry)
+	if err != nil {
+		return fmt.Errorf("failed to add audit entry: %w", err)
+	}
+	return nil
+}
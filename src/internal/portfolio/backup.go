@@ -0,0 +1,46 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// Backup liest den kompletten Store als Archiv, z.B. für eine Sicherung im laufenden Betrieb.
+func (d *Depot) Backup(ctx context.Context, metadata storage.ArchiveMetadata) (*storage.Archive, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	archive, err := storage.ExportArchive(ctx, d.store, metadata)
+	if err != nil {
+		return nil, storeError("export store", err)
+	}
+	return archive, nil
+}
+
+// Restore spielt ein Archiv in den leeren Store zurück und berechnet danach den Depotbestand. Passen die
+// offenen Positionen und Abrechnungen des Archivs nicht zu einer Neuberechnung seiner Transaktionen (siehe
+// Verify), wird das Archiv abgelehnt und nichts übernommen.
+func (d *Depot) Restore(ctx context.Context, archive *storage.Archive) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := storage.RestoreArchive(ctx, d.store, archive, func() error {
+		report, err := d.verify(ctx)
+		if err != nil {
+			return err
+		}
+		if !report.Consistent() {
+			return validationError("", fmt.Sprintf("unclosed transactions and realized gains of the archive do not match its transactions: %d discrepancies in %d assets",
+				report.Discrepancies(), len(report.Tickers)))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore archive: %w", err)
+	}
+	err = d.recordAudit(ctx, "Restore", "depot", map[string]any{"createdAt": archive.CreatedAt, "transactions": len(archive.Transactions)})
+	if err != nil {
+		return err
+	}
+	return d.calculateSecuritiesAccountBalance(ctx)
+}
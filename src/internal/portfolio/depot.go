@@ -1,8 +1,16 @@
 package portfolio
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/fritzrepo/stockportfolio/internal/storage"
 	"github.com/google/uuid"
@@ -29,10 +37,21 @@ func (d *DepotEntry) TotalPrice() float64 {
 	return d.Quantity * d.Price
 }
 
+// Toleranz beim Vergleich von Stückzahlen, um Rundungsfehler bei Bruchstücken abzufangen
+const quantityEpsilon = 1e-9
+
 type Depot struct {
 	depotEntries         map[string]DepotEntry
 	unclosedTransactions map[string][]storage.Transaction
 	store                storage.Store
+	duplicateFingerprint []string //Felder für die Duplikaterkennung ohne Auftragsnummer
+	snapshotInterval     int      //Anzahl der Ereignisse im Journal bis zum nächsten Snapshot
+	snapshotSequence     int64    //Sequenznummer des letzten Snapshots, gültig wenn snapshotLoaded gesetzt ist
+	snapshotLoaded       bool
+	validator            *Validator
+	//Serialisiert alle Zugriffe auf den Store und den Bestand im Speicher, z.B. von parallelen
+	//Requests und dem Sparplan-Scheduler. Der Store hat nur eine laufende Transaktion.
+	mu sync.Mutex
 }
 
 func GetDepot(dataStore storage.Store) *Depot {
@@ -40,37 +59,105 @@ func GetDepot(dataStore storage.Store) *Depot {
 		depotEntries:         make(map[string]DepotEntry),
 		unclosedTransactions: make(map[string][]storage.Transaction),
 		store:                dataStore,
+		duplicateFingerprint: DefaultDuplicateFingerprint,
+		snapshotInterval:     DefaultSnapshotInterval,
+		validator:            defaultValidator(),
 	}
 }
 
-func (d *Depot) CalculateSecuritiesAccountBalance() error {
-	err := d.loadUnclosedTransactions()
+// CalculateSecuritiesAccountBalance berechnet den Depotbestand. Hat der Store ein Journal, wird der
+// Bestand aus dem letzten Snapshot und den Ereignissen danach aufgebaut, sonst aus den gespeicherten
+// unclosed transactions.
+func (d *Depot) CalculateSecuritiesAccountBalance(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calculateSecuritiesAccountBalance(ctx)
+}
+
+func (d *Depot) calculateSecuritiesAccountBalance(ctx context.Context) error {
+	unclosedTransactions, err := d.loadFromJournal(ctx)
 	if err != nil {
 		return err
+	}
+	if unclosedTransactions != nil {
+		d.unclosedTransactions = unclosedTransactions
 	} else {
-		d.createDepotEntries()
+		err = d.loadUnclosedTransactions(ctx)
+		if err != nil {
+			return err
+		}
 	}
+	d.createDepotEntries()
 	return nil
 }
 
+// GetEntries liefert eine Kopie des aktuellen Depotbestands.
 func (d *Depot) GetEntries() map[string]DepotEntry {
-	return d.depotEntries
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return maps.Clone(d.depotEntries)
 }
 
-func (d *Depot) GetAllTransactions() ([]storage.Transaction, error) {
-	transactions, err := d.store.ReadAllTransactions()
+// GetEntriesAsOf berechnet den Depotbestand zum Ende des angegebenen Tages, z.B. für die
+// Steuererklärung oder den Abgleich mit dem Jahresdepotauszug. Dazu werden alle Transaktionen
+// bis zu diesem Tag seitenweise gelesen und nur im Speicher neu abgerechnet. Die gespeicherten
+// unclosed transactions und der aktuelle Depotbestand bleiben unverändert.
+func (d *Depot) GetEntriesAsOf(ctx context.Context, date time.Time) (map[string]DepotEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nextDay := time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, date.Location())
+
+	actions, err := d.corporateActions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actionsAsOf := slices.DeleteFunc(actions, func(action storage.CorporateAction) bool {
+		return !action.Date.Before(nextDay)
+	})
+
+	unclosedTransactions, err := replay(d.store.IterateTransactions(ctx, storage.TransactionQuery{To: nextDay}), actionsAsOf,
+		func(storage.RealizedGain) error { return nil })
 	if err != nil {
-		return nil, fmt.Errorf("failed to read transactions from store: %w", err)
+		return nil, fmt.Errorf("failed to compute depot as of %s: %w", date.Format(time.DateOnly), err)
+	}
+	return buildDepotEntries(unclosedTransactions), nil
+}
+
+func (d *Depot) GetAllTransactions(ctx context.Context) ([]storage.Transaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	transactions, err := d.store.ReadAllTransactions(ctx)
+	if err != nil {
+		return nil, storeError("read transactions from store", err)
 	}
 	return transactions, nil
 }
 
-func (d *Depot) GetPerformance() (Performance, error) {
+// IterateTransactions liefert die gefilterten Transaktionen nacheinander, z.B. für einen Export.
+// Gelesen wird seitenweise, nur während eine Seite gelesen wird, warten andere Zugriffe auf das Depot.
+func (d *Depot) IterateTransactions(ctx context.Context, query storage.TransactionQuery) iter.Seq2[storage.Transaction, error] {
+	return storage.PageTransactions(ctx, query, d.QueryTransactions)
+}
+
+// QueryTransactions liefert die gefilterten Transaktionen seitenweise, siehe storage.TransactionQuery.
+func (d *Depot) QueryTransactions(ctx context.Context, query storage.TransactionQuery) (storage.TransactionPage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	page, err := d.store.QueryTransactions(ctx, query)
+	if err != nil {
+		return page, storeError("query transactions from store", err)
+	}
+	return page, nil
+}
+
+func (d *Depot) GetPerformance(ctx context.Context) (Performance, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	result := Performance{}
 
-	realizedGains, err := d.GetAllRealizedGains()
+	realizedGains, err := d.store.ReadAllRealizedGains(ctx)
 	if err != nil {
-		return result, fmt.Errorf("failed to get all realized gains: %w", err)
+		return result, storeError("read realized gains from store", err)
 	}
 
 	result.CountOfRealizedGains = int16(len(realizedGains))
@@ -88,122 +175,467 @@ func (d *Depot) GetPerformance() (Performance, error) {
 	return result, nil
 }
 
-func (d *Depot) GetAllRealizedGains() ([]storage.RealizedGain, error) {
-	realizedGains, err := d.store.ReadAllRealizedGains()
+func (d *Depot) GetAllRealizedGains(ctx context.Context) ([]storage.RealizedGain, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	realizedGains, err := d.store.ReadAllRealizedGains(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read realized gains from store: %w", err)
+		return nil, storeError("read realized gains from store", err)
 	}
 	return realizedGains, nil
 }
 
+// QueryRealizedGains liefert die gefilterten Abrechnungen seitenweise, siehe storage.RealizedGainQuery.
+func (d *Depot) QueryRealizedGains(ctx context.Context, query storage.RealizedGainQuery) (storage.RealizedGainPage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	page, err := d.store.QueryRealizedGains(ctx, query)
+	if err != nil {
+		return page, storeError("query realized gains from store", err)
+	}
+	return page, nil
+}
+
 // Diese Funktion berechnet die "Realized Gains" und die "unclosed transactions" aller ihr
 // zugänglichen Transaktionen. Ist für den Import historischer Transaktionen oder für einen Fehlerfall gedacht,
 // bei dem man alles neu berechnen muss.
 // Sie ist auch für die Units Tests nützlich, da man damit den Algorithmus für "Realized Gains"
 // und "unclosed transactions" gut testen kann.
-func (d *Depot) ComputeAllTransactions() error {
+func (d *Depot) ComputeAllTransactions(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.recompute(ctx, nil)
+}
+
+// recompute führt die Änderung am Store (z.B. das Korrigieren einer Transaktion) und die
+// Neuberechnung in einer Store-Transaktion aus. Schlägt etwas fehl, bleiben Store und Depot unverändert.
+func (d *Depot) recompute(ctx context.Context, storeAction func() error) error {
+	previous := d.unclosedTransactions
+	err := d.inStoreTransaction(ctx, func() error {
+		if storeAction != nil {
+			err := storeAction()
+			if err != nil {
+				return err
+			}
+		}
+		return d.computeAllTransactions(ctx)
+	})
+	if err != nil {
+		d.unclosedTransactions = previous
+		return err
+	}
+
+	d.createDepotEntries()
+	return nil
+}
+
+// computeAllTransactions liest die Transaktionen mit IterateTransactions seitenweise aus dem Store, auch bei
+// sehr vielen Transaktionen bleibt der Speicherbedarf damit gleich. Die Realized Gains werden sofort gespeichert.
+// Ist eine Transaktion ungültig, rollt recompute die Store-Transaktion mit allen Änderungen zurück.
+// Der neue Stand wird als Snapshot ins Journal geschrieben. Die offenen Positionen und Abrechnungen werden
+// nicht einzeln ins Audit-Log geschrieben, sondern mit einem Eintrag "ComputeAllTransactions" und ihrer Anzahl.
+func (d *Depot) computeAllTransactions(ctx context.Context) error {
+	derived := storage.WithoutAudit(ctx)
+	actions, sequence, err := d.journalActions(derived)
+	if err != nil {
+		return err
+	}
+
+	err = d.store.RemoveAllRealizedGains(derived)
+	if err != nil {
+		return storeError("remove all realized gains from store", err)
+	}
 
-	err := d.store.RemoveAllRealizedGains()
+	realizedGains := 0
+	state, err := d.replayStore(d.store.IterateTransactions(derived, storage.TransactionQuery{}), actions,
+		func(realizedGain storage.RealizedGain) error {
+			err := d.store.AddRealizedGain(derived, realizedGain)
+			if err != nil {
+				return storeError("add realized gain to store", err)
+			}
+			realizedGains++
+			return nil
+		})
 	if err != nil {
-		return fmt.Errorf("failed to remove all realized gains from store: %w", err)
+		return err
 	}
 
-	transactions, err := d.store.ReadAllTransactions()
+	d.unclosedTransactions = state.UnclosedTransactions
+
+	err = d.saveAllUnclosedTransactions(derived)
 	if err != nil {
 		return err
 	}
+	err = d.saveSnapshot(derived, state, sequence)
+	if err != nil {
+		return err
+	}
+
+	unclosedTransactions := 0
+	for _, lots := range d.unclosedTransactions {
+		unclosedTransactions += len(lots)
+	}
+	return d.recordAudit(ctx, "ComputeAllTransactions", "depot",
+		map[string]int{"unclosedTransactions": unclosedTransactions, "realizedGains": realizedGains})
+}
+
+// saveAllUnclosedTransactions ersetzt alle gespeicherten unclosed transactions durch die im Speicher.
+func (d *Depot) saveAllUnclosedTransactions(ctx context.Context) error {
+	err := d.store.RemoveAllUnclosedTransactions(ctx)
+	if err != nil {
+		return storeError("remove all unclosed transaction from store", err)
+	}
+
+	//Schleife zum Speichern aller unclosed transactions
+	for _, asset := range d.unclosedTransactions {
+		for _, transaction := range asset {
+			err = d.store.AddUnclosedTransaction(ctx, transaction)
+			if err != nil {
+				return storeError("save unclosed transactions to store", err)
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateTransaction korrigiert eine gespeicherte Transaktion und berechnet danach
+// alle "Realized Gains" und "unclosed transactions" neu. Die Änderung wird abgelehnt,
+// wenn dadurch ein späterer Verkauf nicht mehr gedeckt ist.
+func (d *Depot) UpdateTransaction(ctx context.Context, changedTransaction storage.Transaction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.getTransaction(ctx, changedTransaction.Id)
+	if err != nil {
+		return err
+	}
+
+	instrument, err := d.linkInstrument(ctx, &changedTransaction)
+	if err != nil {
+		return err
+	}
+
+	//Geprüft wird gegen den Stand aller anderen Transaktionen des Assets
+	ledger := newEmptyLedger()
+	if instrument != nil {
+		ledger.Instruments[instrument.Isin] = *instrument
+	}
+	query := storage.TransactionQuery{TickerSymbol: changedTransaction.TickerSymbol}
+	for transaction, err := range d.store.IterateTransactions(ctx, query) {
+		if err != nil {
+			return storeError("read transactions from store", err)
+		}
+		if transaction.Id != changedTransaction.Id {
+			ledger.Add(transaction)
+		}
+	}
+	err = d.validator.Validate(changedTransaction, ledger)
+	if err != nil {
+		return err
+	}
+
+	//Ist ein späterer Verkauf nicht mehr gedeckt, schlägt die Neuberechnung fehl und alles wird zurückgerollt
+	err = d.recompute(ctx, func() error {
+		err := d.store.UpdateTransaction(ctx, &changedTransaction)
+		if err != nil {
+			return storeError("update transaction in store", err)
+		}
+		return d.recordEvent(ctx, transactionEvent(storage.EventTransactionCorrected, changedTransaction))
+	})
+	return rejectedChange("transaction cannot be changed", err)
+}
+
+// RemoveTransaction löscht eine gespeicherte Transaktion und berechnet danach
+// alle "Realized Gains" und "unclosed transactions" neu. Das Löschen wird abgelehnt,
+// wenn dadurch ein späterer Verkauf nicht mehr gedeckt ist.
+func (d *Depot) RemoveTransaction(ctx context.Context, id uuid.UUID) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.getTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = d.recompute(ctx, func() error {
+		err := d.store.RemoveTransaction(ctx, id)
+		if err != nil {
+			return storeError("remove transaction from store", err)
+		}
+		return d.recordEvent(ctx, deletedEvent(id))
+	})
+	return rejectedChange("transaction cannot be removed", err)
+}
+
+// rejectedChange ergänzt Fehler der Neuberechnung (z.B. ErrNoOpenLots) um den Grund der Ablehnung.
+// Fehler des Stores bleiben unverändert.
+func rejectedChange(reason string, err error) error {
+	var storeErr *StoreError
+	if err == nil || errors.As(err, &storeErr) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", reason, err)
+}
+
+func (d *Depot) GetTransaction(ctx context.Context, id uuid.UUID) (*storage.Transaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getTransaction(ctx, id)
+}
+
+func (d *Depot) getTransaction(ctx context.Context, id uuid.UUID) (*storage.Transaction, error) {
+	transaction, err := d.store.LoadTransactionById(ctx, id)
+	if err != nil {
+		return nil, storeError("load transaction from store", err)
+	}
+	if transaction == nil {
+		return nil, fmt.Errorf("transaction %s %w", id, storage.ErrNotFound)
+	}
+	return transaction, nil
+}
+
+// replay rechnet die Transaktionen in der Reihenfolge ab, in der sie kommen. Sie müssen also schon
+// chronologisch sortiert sein. Jeder neue Realized Gain wird sofort an addRealizedGain übergeben, so
+// hält replay nur die offenen Positionen im Speicher.
+func replay(transactions iter.Seq2[storage.Transaction, error], actions []storage.CorporateAction,
+	addRealizedGain func(realizedGain storage.RealizedGain) error) (map[string][]storage.Transaction, error) {
+	scratch := GetDepot(nil)
+	pendingActions := sortCorporateActions(actions)
+
+	for newTransaction, err := range transactions {
+		if err != nil {
+			return nil, storeError("read transactions from store", err)
+		}
+		for len(pendingActions) > 0 && !pendingActions[0].Date.After(newTransaction.Date) {
+			applyCorporateAction(scratch.unclosedTransactions, pendingActions[0])
+			pendingActions = pendingActions[1:]
+		}
 
-	for _, newTransaction := range transactions {
 		//Die neue Transaktion kann auch mehrere Realized Gains erzeugen (bei FiFo-Prinzip)
-		//Das ist der Fehler. Hier wird nur ein Realized Gain erzeugt, wenn die Transaktion verkauft wird.
-		areNewRealizedGains, newRealizedGains, err := d.processNewTransaction(newTransaction)
+		areNewRealizedGains, newRealizedGains, err := scratch.processNewTransaction(newTransaction)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if areNewRealizedGains {
 			for _, newRealizedGain := range newRealizedGains {
 				newRealizedGain.Id = uuid.New()
-				err = d.store.AddRealizedGain(newRealizedGain)
+				err = addRealizedGain(newRealizedGain)
 				if err != nil {
-					return fmt.Errorf("failed to add realized gain to store: %w", err)
+					return nil, err
 				}
 			}
 		}
 	}
+	for _, action := range pendingActions {
+		applyCorporateAction(scratch.unclosedTransactions, action)
+	}
+	return scratch.unclosedTransactions, nil
+}
+
+// AddTransaction fügt eine Transaktion hinzu. Die Transaktion, die realized gains und die
+// geänderten unclosed transactions werden in einer Store-Transaktion gespeichert. Schlägt
+// ein Schritt fehl, wird alles zurückgerollt, auch die unclosed transactions im Speicher.
+func (d *Depot) AddTransaction(ctx context.Context, newTransaction storage.Transaction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addTransaction(ctx, newTransaction, nil)
+}
 
-	err = d.store.RemoveAllUnclosedTransactions()
+// addTransaction führt afterAdd in derselben Store-Transaktion aus wie das Hinzufügen,
+// z.B. um eine bestätigte Sparplan-Transaktion zu entfernen.
+func (d *Depot) addTransaction(ctx context.Context, newTransaction storage.Transaction, afterAdd func() error) error {
+	err := d.validateNewTransaction(ctx, &newTransaction)
 	if err != nil {
-		return fmt.Errorf("failed to remove all unclosed transaction from store: %w", err)
+		return err
 	}
 
-	//Schleife zum Speichern aller unclosed transactions
-	for _, asset := range d.unclosedTransactions {
-		for _, transaction := range asset {
-			err = d.store.AddUnclosedTransaction(transaction)
+	//Eine nachgetragene Transaktion ändert die Abrechnung aller späteren Transaktionen des Assets
+	backdated, err := d.isBackdated(ctx, newTransaction)
+	if err != nil {
+		return err
+	}
+	if backdated {
+		return d.recompute(ctx, func() error {
+			err := d.storeNewTransaction(ctx, &newTransaction)
 			if err != nil {
-				return fmt.Errorf("failed to save unclosed transactions to store: %w", err)
+				return err
+			}
+			if afterAdd != nil {
+				return afterAdd()
 			}
+			return nil
+		})
+	}
+
+	tickerSymbol := newTransaction.TickerSymbol
+	//Nur die offenen Positionen des Assets der neuen Transaktion können sich ändern
+	lotsBefore := slices.Clone(d.unclosedTransactions[tickerSymbol])
+
+	err = d.inStoreTransaction(ctx, func() error {
+		err := d.saveNewTransaction(ctx, newTransaction, lotsBefore)
+		if err != nil {
+			return err
+		}
+		if afterAdd != nil {
+			return afterAdd()
+		}
+		return nil
+	})
+	if err != nil {
+		//Der Store wurde zurückgerollt, deshalb auch den Stand im Speicher zurücksetzen
+		if len(lotsBefore) == 0 {
+			delete(d.unclosedTransactions, tickerSymbol)
+		} else {
+			d.unclosedTransactions[tickerSymbol] = lotsBefore
 		}
+		return err
 	}
 
 	d.createDepotEntries()
-
 	return nil
 }
 
-func (d *Depot) AddTransaction(newTransaction storage.Transaction) error {
-
-	//Überprüfen, ob die Transaction schon existiert
-	transaction, err := d.store.LoadTransactionByParams(newTransaction.Date, newTransaction.TransactionType, newTransaction.TickerSymbol)
+// isBackdated prüft, ob die neue Transaktion vor der letzten gespeicherten Transaktion des Assets
+// liegt (Datum und Sequenznummer). Dann kann sie nicht hinten an die offenen Positionen angehängt werden.
+func (d *Depot) isBackdated(ctx context.Context, newTransaction storage.Transaction) (bool, error) {
+	page, err := d.store.QueryTransactions(ctx, storage.TransactionQuery{TickerSymbol: newTransaction.TickerSymbol,
+		Descending: true, Limit: 1})
 	if err != nil {
-		return err
+		return false, storeError("query transactions from store", err)
 	}
-	if transaction != nil {
-		return errors.New("transaction already exists")
+	if len(page.Transactions) == 0 {
+		return false, nil
 	}
+	last := page.Transactions[0]
+	return position{last.Date, last.Sequence}.after(newTransaction.Date, newTransaction.Sequence), nil
+}
 
-	newTransaction.Id = uuid.New()
-
-	areNewRealizedGains, newRealizedGains, err := d.processNewTransaction(newTransaction)
+func (d *Depot) saveNewTransaction(ctx context.Context, newTransaction storage.Transaction, lotsBefore []storage.Transaction) error {
+	err := d.storeNewTransaction(ctx, &newTransaction)
 	if err != nil {
 		return err
 	}
 
-	err = d.store.AddTransaction(&newTransaction)
+	areNewRealizedGains, newRealizedGains, err := d.processNewTransaction(newTransaction)
 	if err != nil {
-		return fmt.Errorf("failed to add transaction to store: %w", err)
+		return err
 	}
 
 	if areNewRealizedGains {
 		for _, newRealizedGain := range newRealizedGains {
 			newRealizedGain.Id = uuid.New()
-			err = d.store.AddRealizedGain(newRealizedGain)
+			err = d.store.AddRealizedGain(ctx, newRealizedGain)
 			if err != nil {
-				return fmt.Errorf("failed to add realized gain to store: %w", err)
+				return storeError("add realized gain to store", err)
 			}
 		}
 	}
 
-	err = d.store.RemoveAllUnclosedTransactions()
+	return d.saveUnclosedTransactionChanges(ctx, lotsBefore, d.unclosedTransactions[newTransaction.TickerSymbol])
+}
+
+// storeNewTransaction prüft auf ein Duplikat, vergibt die Id und speichert die Transaktion mit ihrem Ereignis im Journal.
+func (d *Depot) storeNewTransaction(ctx context.Context, newTransaction *storage.Transaction) error {
+	//Überprüfen, ob die Transaction schon existiert
+	transaction, err := d.findDuplicate(ctx, *newTransaction)
 	if err != nil {
-		return fmt.Errorf("failed to remove all unclosed transaction from store: %w", err)
+		return err
+	}
+	if transaction != nil {
+		return &DuplicateError{Existing: *transaction}
 	}
 
-	//Schleife zum Speichern aller unclosed transactions
-	for _, asset := range d.unclosedTransactions {
-		for _, transaction := range asset {
-			err = d.store.AddUnclosedTransaction(transaction)
+	newTransaction.Id = uuid.New()
+
+	err = d.store.AddTransaction(ctx, newTransaction)
+	if err != nil {
+		return storeError("add transaction to store", err)
+	}
+	return d.recordEvent(ctx, transactionEvent(storage.EventTransactionAdded, *newTransaction))
+}
+
+// inStoreTransaction führt action in einer Store-Transaktion aus. Gibt action einen Fehler
+// zurück, wird die Store-Transaktion zurückgerollt, sonst bestätigt.
+func (d *Depot) inStoreTransaction(ctx context.Context, action func() error) error {
+	err := d.store.Begin(ctx)
+	if err != nil {
+		return storeError("begin store transaction", err)
+	}
+
+	//Ein zurückgerollter Snapshot zählt nicht
+	snapshotSequence, snapshotLoaded := d.snapshotSequence, d.snapshotLoaded
+	err = action()
+	if err != nil {
+		d.snapshotSequence, d.snapshotLoaded = snapshotSequence, snapshotLoaded
+		rollbackErr := d.store.Rollback()
+		if rollbackErr != nil {
+			return errors.Join(err, storeError("rollback store transaction", rollbackErr))
+		}
+		return err
+	}
+
+	err = d.store.Commit()
+	if err != nil {
+		d.snapshotSequence, d.snapshotLoaded = snapshotSequence, snapshotLoaded
+		return storeError("commit store transaction", err)
+	}
+	return nil
+}
+
+// saveUnclosedTransactionChanges speichert nur die geänderten offenen Positionen eines Assets.
+// Ein Kauf fügt eine Position hinzu, ein Verkauf verkleinert die erste noch offene Position
+// oder schließt Positionen komplett.
+func (d *Depot) saveUnclosedTransactionChanges(ctx context.Context, before []storage.Transaction, after []storage.Transaction) error {
+	remaining := make(map[uuid.UUID]storage.Transaction, len(after))
+	for _, transaction := range after {
+		remaining[transaction.Id] = transaction
+	}
+
+	previous := make(map[uuid.UUID]storage.Transaction, len(before))
+	for _, transaction := range before {
+		previous[transaction.Id] = transaction
+		current, exists := remaining[transaction.Id]
+		if !exists {
+			err := d.store.RemoveUnclosedTransaction(ctx, transaction.Id)
+			if err != nil {
+				return storeError("remove unclosed transaction from store", err)
+			}
+			continue
+		}
+		if current != transaction {
+			err := d.store.UpdateUnclosedTransaction(ctx, current)
 			if err != nil {
-				return fmt.Errorf("failed to save unclosed transactions to store: %w", err)
+				return storeError("update unclosed transaction in store", err)
 			}
 		}
 	}
 
-	d.createDepotEntries()
+	for _, transaction := range after {
+		if _, exists := previous[transaction.Id]; !exists {
+			err := d.store.AddUnclosedTransaction(ctx, transaction)
+			if err != nil {
+				return storeError("save unclosed transactions to store", err)
+			}
+		}
+	}
 	return nil
 }
 
+// sortTransactions sortiert eine Kopie der Transaktionen nach Datum und bei gleichem Datum nach
+// der Sequenznummer. So wird z.B. ein Kauf vor dem Verkauf am selben Tag abgerechnet, egal in
+// welcher Reihenfolge der Store die Transaktionen liefert. Bei gleicher Sequenznummer bleibt
+// die Reihenfolge des Stores erhalten.
+func sortTransactions(transactions []storage.Transaction) []storage.Transaction {
+	sorted := make([]storage.Transaction, len(transactions))
+	copy(sorted, transactions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].Date.Before(sorted[j].Date)
+		}
+		return sorted[i].Sequence < sorted[j].Sequence
+	})
+	return sorted
+}
+
 func (d *Depot) processNewTransaction(newTransaction storage.Transaction) (bool, []storage.RealizedGain, error) {
 	isNewRealizedGain := false
 	var newRealizedGains []storage.RealizedGain
@@ -218,7 +650,7 @@ func (d *Depot) processNewTransaction(newTransaction storage.Transaction) (bool,
 			return false, nil, fmt.Errorf("failed to process sell transaction: %w", err)
 		}
 	default:
-		return false, nil, errors.New("transaction type not supported")
+		return false, nil, fmt.Errorf("%w: %q", ErrUnsupportedType, newTransaction.TransactionType)
 	}
 	return isNewRealizedGain, newRealizedGains, nil
 }
@@ -246,7 +678,7 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 
 	transactions, exists := d.unclosedTransactions[newTransaction.TickerSymbol]
 	if !exists {
-		return areNewRealizedGains, nil, fmt.Errorf("no buy transaction available for this sell transaction %s", newTransaction.TickerSymbol)
+		return areNewRealizedGains, nil, fmt.Errorf("%w: no buy transaction available for this sell transaction %s", ErrNoOpenLots, newTransaction.TickerSymbol)
 	}
 
 	//FiFo-Prinzip (First in, first out)
@@ -259,6 +691,9 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 
 	_ = copy(modifyTransactions, transactions)
 
+	//Wird true, sobald die komplette Anzahl der sell Transaktion abgerechnet ist.
+	settled := false
+
 	for _, availableBuyTrans := range transactions {
 
 		if availableBuyTrans.TransactionType != "buy" {
@@ -266,7 +701,7 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 		}
 
 		//Buy und sell Transaktionen sind gleich
-		if availableBuyTrans.Quantity == newTransaction.Quantity {
+		if math.Abs(availableBuyTrans.Quantity-newTransaction.Quantity) < quantityEpsilon {
 			//Entferne die Transaktion aus der modifyTransactions
 			filteredTransactions := []storage.Transaction{}
 			for _, transaction := range modifyTransactions {
@@ -280,6 +715,7 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 			areNewRealizedGains = true
 			newRealizedGain = calculateProfitLoss(newTransaction, availableBuyTrans)
 			newRealizedGains = append(newRealizedGains, newRealizedGain)
+			settled = true
 			break
 		}
 		//Buy Transaktion ist größer als die Sell Transaktion
@@ -297,6 +733,7 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 					break
 				}
 			}
+			settled = true
 			break
 		}
 		//Buy Transaktion ist kleiner als die Sell Transaktion
@@ -321,6 +758,11 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 		}
 	}
 
+	//Es wurden mehr Assets verkauft, als im Depot vorhanden sind.
+	if !settled {
+		return false, nil, fmt.Errorf("%w: not enough assets available for this sell transaction %s", ErrNoOpenLots, newTransaction.TickerSymbol)
+	}
+
 	//Wennn die tansactions leer sind, dann lösche den Eintrag
 	if len(modifyTransactions) == 0 {
 		delete(d.unclosedTransactions, newTransaction.TickerSymbol)
@@ -335,31 +777,40 @@ func (d *Depot) addSellTransaction(newTransaction storage.Transaction) (bool, []
 	return areNewRealizedGains, newRealizedGains, nil
 }
 
-func (d *Depot) loadUnclosedTransactions() error {
+func (d *Depot) loadUnclosedTransactions(ctx context.Context) error {
 	var err error
-	d.unclosedTransactions, err = d.store.ReadAllUnclosedTransactions()
+	d.unclosedTransactions, err = d.store.ReadAllUnclosedTransactions(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read unclosed transactions from store: %w", err)
+		return storeError("read unclosed transactions from store", err)
 	}
 	return nil
 }
 
 func (d *Depot) createDepotEntries() {
 	clear(d.depotEntries)
-	for _, transactions := range d.unclosedTransactions {
+	for tickerSymbol, entry := range buildDepotEntries(d.unclosedTransactions) {
+		d.depotEntries[tickerSymbol] = entry
+	}
+}
+
+// buildDepotEntries fasst die unclosed transactions je Asset zu einem Depoteintrag zusammen.
+func buildDepotEntries(unclosedTransactions map[string][]storage.Transaction) map[string]DepotEntry {
+	depotEntries := make(map[string]DepotEntry)
+	for _, transactions := range unclosedTransactions {
 		for _, transaction := range transactions {
 			//Wenn das Asset noch nicht im Depot ist, dann füge es hinzu
-			entry, exists := d.depotEntries[transaction.TickerSymbol]
+			entry, exists := depotEntries[transaction.TickerSymbol]
 			if !exists {
-				d.depotEntries[transaction.TickerSymbol] = DepotEntry{AssetType: transaction.AssetType, Asset: transaction.Asset,
+				depotEntries[transaction.TickerSymbol] = DepotEntry{AssetType: transaction.AssetType, Asset: transaction.Asset,
 					TickerSymbol: transaction.TickerSymbol, Quantity: transaction.Quantity, Price: transaction.Price,
 					Currency: transaction.Currency}
 			} else {
 				//Wenn das Asset schon im Depot ist, dann aktualisiere den (durchschnitts) Preis und die Anzahl
 				entry.Price = (entry.Price*entry.Quantity + transaction.Price*transaction.Quantity) / (entry.Quantity + transaction.Quantity)
 				entry.Quantity += transaction.Quantity
-				d.depotEntries[transaction.TickerSymbol] = entry
+				depotEntries[transaction.TickerSymbol] = entry
 			}
 		}
 	}
+	return depotEntries
 }
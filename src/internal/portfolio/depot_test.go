@@ -1,22 +1,31 @@
 package portfolio
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
 )
 
-func setupTestStore(t *testing.T) storage.Store {
+func setupTestStore(t testing.TB) storage.Store {
+	ctx := context.Background()
 	store := storage.GetMemoryDatabase()
 	store.Open()
-	err := store.CreateDatabase()
+	err := store.CreateDatabase(ctx)
 	if err != nil {
 		t.Fatalf("Failed to create database: %v", err)
 	}
@@ -27,8 +36,25 @@ func setupTestStore(t *testing.T) storage.Store {
 	return store
 }
 
+// setupFileStore legt eine SQLite-Datei im temporären Verzeichnis des Tests an
+func setupFileStore(t testing.TB) storage.Store {
+	ctx := context.Background()
+	store := storage.GetFileDatabase(filepath.Join(t.TempDir(), "portfolio.db"))
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
 // TestComputeTransactions
 func TestComputeTransactions(t *testing.T) {
+	ctx := context.Background()
 
 	type TestCases = []struct {
 		Name          string                 `json:"name"`
@@ -36,7 +62,7 @@ func TestComputeTransactions(t *testing.T) {
 		ExpectedDepot map[string]DepotEntry  `json:"expectedDepot"`
 	}
 
-	testcount := 5
+	testcount := 6
 	testCases := make(TestCases, testcount)
 	for i := range testcount {
 
@@ -74,10 +100,23 @@ func TestComputeTransactions(t *testing.T) {
 			filenameTrans := fmt.Sprintf("../../testdata/depot/RawTransactionsTest%d.csv", i)
 			i = i + 1
 
-			store := storage.GetCsvStorage(filenameTrans)
-			dep := GetDepot(&store)
+			//Der CsvStorage schreibt offene Positionen und Abrechnungen neben die Datei, deshalb eine Kopie
+			content, err := os.ReadFile(filenameTrans)
+			if err != nil {
+				t.Fatalf("Failed to open test data: %v", err)
+			}
+			filenameCopy := filepath.Join(t.TempDir(), filepath.Base(filenameTrans))
+			if err = os.WriteFile(filenameCopy, content, 0644); err != nil {
+				t.Fatalf("Failed to copy test data: %v", err)
+			}
+
+			store := storage.GetCsvStorage(filenameCopy)
+			if err := store.Open(); err != nil {
+				t.Fatalf("Error loading transactions: %v", err)
+			}
+			dep := GetDepot(store)
 
-			err := dep.ComputeAllTransactions()
+			err = dep.ComputeAllTransactions(ctx)
 			if err != nil {
 				t.Fatalf("Error computing transactions: %v", err)
 			}
@@ -98,7 +137,7 @@ func TestComputeTransactions(t *testing.T) {
 			}
 
 			//Check the realized gains
-			realizedGains, err := dep.GetAllRealizedGains()
+			realizedGains, err := dep.GetAllRealizedGains(ctx)
 			if err != nil {
 				t.Fatalf("Error getting realized gains: %v", err)
 			}
@@ -134,10 +173,11 @@ func TestComputeTransactions(t *testing.T) {
 
 // Test addTransaction
 func TestAddTransactions(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 	dep := GetDepot(store)
 
-	err := dep.AddTransaction(storage.Transaction{
+	err := dep.AddTransaction(ctx, storage.Transaction{
 		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
 		TransactionType: "buy",
 		AssetType:       "stock",
@@ -152,7 +192,7 @@ func TestAddTransactions(t *testing.T) {
 		t.Fatalf("Failed to add transaction: %v", err)
 	}
 
-	err = dep.AddTransaction(storage.Transaction{
+	err = dep.AddTransaction(ctx, storage.Transaction{
 		Date:            time.Date(2023, 11, 1, 14, 0, 0, 0, time.UTC),
 		TransactionType: "sell",
 		AssetType:       "stock",
@@ -167,7 +207,7 @@ func TestAddTransactions(t *testing.T) {
 		t.Fatalf("Failed to add transaction: %v", err)
 	}
 
-	realizedGains, _ := dep.GetAllRealizedGains()
+	realizedGains, _ := dep.GetAllRealizedGains(ctx)
 
 	if len(realizedGains) != 1 {
 		t.Errorf("Expected 1 realized gain, but got %d", len(realizedGains))
@@ -185,6 +225,7 @@ func TestAddTransactions(t *testing.T) {
 }
 
 func TestDoNotAddAnExistingTransaction(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 	dep := GetDepot(store)
 
@@ -199,14 +240,1572 @@ func TestDoNotAddAnExistingTransaction(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err := dep.AddTransaction(transaction)
+	err := dep.AddTransaction(ctx, transaction)
 	if err != nil {
 		t.Fatalf("Failed to add transaction: %v", err)
 	}
 
 	//Versuche die gleiche Transaktion erneut hinzuzufügen
-	err = dep.AddTransaction(transaction)
+	err = dep.AddTransaction(ctx, transaction)
+	if !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("Expected ErrDuplicateTransaction when adding an existing transaction, but got %v", err)
+	}
+	var duplicate *DuplicateError
+	if !errors.As(err, &duplicate) || duplicate.Existing.TickerSymbol != "AAPL" {
+		t.Errorf("Expected DuplicateError with the stored transaction, got %v", err)
+	}
+
+	transaction.TransactionType = "swap"
+	err = dep.AddTransaction(ctx, transaction)
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, but got %v", err)
+	}
+}
+
+func allocationTestEntries() map[string]DepotEntry {
+	return map[string]DepotEntry{
+		"AAPL": {AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"},
+		"BAS1": {AssetType: "stock", Asset: "BASF", TickerSymbol: "BAS1", Quantity: 20, Price: 50, Currency: "EUR"},
+		"BTC":  {AssetType: "crypto", Asset: "Bitcoin", TickerSymbol: "BTC", Quantity: 1, Price: 2000, Currency: "EUR"},
+	}
+}
+
+// allocationTestRates rechnet USD 1:1 in EUR um, damit die Beträge der Tests einfach bleiben
+var allocationTestRates = ExchangeRates{BaseCurrency: "EUR", Rates: map[string]float64{"USD": 1}}
+
+func TestGetAllocation(t *testing.T) {
+	dep := GetDepot(setupTestStore(t))
+	dep.depotEntries = allocationTestEntries()
+
+	allocation, err := dep.GetAllocation(GroupByAssetType, nil, nil, allocationTestRates)
+	if err != nil {
+		t.Fatalf("Failed to compute allocation: %v", err)
+	}
+	if allocation.TotalValue != 4000 || len(allocation.Entries) != 2 {
+		t.Fatalf("Unexpected allocation: %+v", allocation)
+	}
+	if allocation.Entries[0].Key != "crypto" || allocation.Entries[0].Weight != 0.5 {
+		t.Errorf("Expected crypto with weight 0.5, got %+v", allocation.Entries[0])
+	}
+
+	//Mit aktuellem Kurs bewerten
+	allocation, err = dep.GetAllocation(GroupByCurrency, nil, map[string]float64{"AAPL": 200}, allocationTestRates)
+	if err != nil {
+		t.Fatalf("Failed to compute allocation: %v", err)
+	}
+	if allocation.TotalValue != 5000 || allocation.Entries[1].Key != "USD" || allocation.Entries[1].Value != 2000 {
+		t.Errorf("Unexpected allocation by currency: %+v", allocation)
+	}
+
+	allocation, err = dep.GetAllocation(GroupByCategory, map[string]string{"AAPL": "equity", "BAS1": "equity"}, nil, allocationTestRates)
+	if err != nil {
+		t.Fatalf("Failed to compute allocation: %v", err)
+	}
+	if len(allocation.Entries) != 2 || allocation.Entries[1].Key != UnassignedCategory {
+		t.Errorf("Expected BTC to be unassigned, got %+v", allocation)
+	}
+
+	_, err = dep.GetAllocation("sector", nil, nil, allocationTestRates)
+	if err == nil {
+		t.Error("Expected error for unsupported grouping, but got none")
+	}
+
+	//USD und EUR werden nicht ohne Kurs addiert
+	_, err = dep.GetAllocation(GroupByAssetType, nil, nil, ExchangeRates{})
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "EUR, USD") {
+		t.Errorf("Expected validation error for mixed currencies, but got %v", err)
+	}
+	_, err = dep.GetAllocation(GroupByAssetType, nil, nil, ExchangeRates{BaseCurrency: "EUR"})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected validation error for missing exchange rate, but got %v", err)
+	}
+
+	//Mit Kurs: 10 AAPL zu 100 USD sind 900 EUR
+	allocation, err = dep.GetAllocation(GroupByCurrency, nil, nil, ExchangeRates{BaseCurrency: "EUR", Rates: map[string]float64{"USD": 0.9}})
+	if err != nil {
+		t.Fatalf("Failed to compute allocation: %v", err)
+	}
+	if allocation.Currency != "EUR" || allocation.TotalValue != 3900 || allocation.Entries[1].Value != 900 {
+		t.Errorf("Expected 3900 EUR with 900 EUR in USD, got %+v", allocation)
+	}
+
+	//Nur eine Währung braucht keine Kurse
+	dep.depotEntries = map[string]DepotEntry{"BAS1": allocationTestEntries()["BAS1"]}
+	allocation, err = dep.GetAllocation(GroupByAssetType, nil, nil, ExchangeRates{})
+	if err != nil || allocation.Currency != "EUR" || allocation.TotalValue != 1000 {
+		t.Errorf("Expected 1000 EUR, got %+v (%v)", allocation, err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	dep := GetDepot(setupTestStore(t))
+	dep.depotEntries = allocationTestEntries()
+
+	request := RebalanceRequest{
+		Categories:    map[string]string{"AAPL": "equity", "BAS1": "equity", "BTC": "crypto"},
+		TargetWeights: map[string]float64{"equity": 60, "crypto": 20, "bonds": 20},
+		ExchangeRates: allocationTestRates,
+	}
+
+	//Voll: equity 2000 -> 2400, crypto 2000 -> 800, bonds 0 -> 800
+	plan, err := dep.Rebalance(request)
+	if err != nil {
+		t.Fatalf("Failed to rebalance: %v", err)
+	}
+	expected := map[string]float64{"bonds": 800, "BTC": -1200, "AAPL": 200, "BAS1": 200}
+	if len(plan.Orders) != len(expected) {
+		t.Fatalf("Expected %d orders, got %+v", len(expected), plan.Orders)
+	}
+	for _, order := range plan.Orders {
+		key := order.TickerSymbol
+		if key == "" {
+			key = order.Category
+		}
+		amount := order.Amount
+		if order.TransactionType == "sell" {
+			amount = -amount
+		}
+		if math.Abs(expected[key]-amount) > 1e-6 {
+			t.Errorf("Order for %s: expected amount %v, got %+v", key, expected[key], order)
+		}
+	}
+
+	//Nur mit neuem Geld, ohne Verkäufe
+	request.Cash = 1000
+	request.NoSell = true
+	plan, err = dep.Rebalance(request)
+	if err != nil {
+		t.Fatalf("Failed to rebalance: %v", err)
+	}
+	total := 0.0
+	for _, order := range plan.Orders {
+		if order.TransactionType != "buy" {
+			t.Errorf("Expected only buy orders, got %+v", order)
+		}
+		total += order.Amount
+	}
+	if math.Abs(total-1000) > 0.01 {
+		t.Errorf("Expected to invest 1000, but got %v", total)
+	}
+
+	//Mit USD zu 0.5 EUR: equity 500 + 1000 EUR -> 2100 EUR, ein Drittel davon entfällt auf AAPL (200 EUR = 400 USD)
+	request.Cash = 0
+	request.NoSell = false
+	request.ExchangeRates = ExchangeRates{BaseCurrency: "EUR", Rates: map[string]float64{"USD": 0.5}}
+	request.TargetWeights = map[string]float64{"equity": 60, "crypto": 40}
+	plan, err = dep.Rebalance(request)
+	if err != nil {
+		t.Fatalf("Failed to rebalance: %v", err)
+	}
+	index := slices.IndexFunc(plan.Orders, func(order RebalanceOrder) bool { return order.TickerSymbol == "AAPL" })
+	if index < 0 || plan.Orders[index].Amount != 400 || plan.Orders[index].Currency != "USD" || plan.Orders[index].Quantity != 4 {
+		t.Errorf("Expected to buy 4 AAPL for 400 USD, got %+v", plan.Orders)
+	}
+}
+
+func TestSavingsPlanCreatesDueTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	plan, err := dep.AddSavingsPlan(ctx, storage.SavingsPlan{
+		AssetType:    "etf",
+		Asset:        "MSCI World",
+		TickerSymbol: "EUNL",
+		Amount:       100,
+		Currency:     "EUR",
+		Interval:     "monthly",
+		ExecutionDay: 31,
+		StartDate:    time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add savings plan: %v", err)
+	}
+
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	created, err := dep.CreateDueTransactions(ctx, now)
+	if err != nil {
+		t.Fatalf("Failed to create due transactions: %v", err)
+	}
+
+	//31.01., 28.02. (Monatsende) und 31.03.
+	expectedDates := []time.Time{
+		time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+	}
+	if len(created) != len(expectedDates) {
+		t.Fatalf("Expected %d pending transactions, got %d", len(expectedDates), len(created))
+	}
+	for i, pending := range created {
+		if !pending.DueDate.Equal(expectedDates[i]) || pending.SavingsPlanId != plan.Id {
+			t.Errorf("Unexpected pending transaction %+v", pending)
+		}
+	}
+
+	//Ein zweiter Lauf darf keine doppelten Transaktionen erzeugen
+	created, err = dep.CreateDueTransactions(ctx, now)
+	if err != nil {
+		t.Fatalf("Failed to create due transactions: %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("Expected no new pending transactions, got %d", len(created))
+	}
+}
+
+func TestCreateDueTransactionsIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Store: setupTestStore(t)}
+	dep := GetDepot(store)
+
+	_, err := dep.AddSavingsPlan(ctx, storage.SavingsPlan{AssetType: "etf", Asset: "MSCI World", TickerSymbol: "EUNL", Amount: 100,
+		Currency: "EUR", Interval: "monthly", ExecutionDay: 1, StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Failed to add savings plan: %v", err)
+	}
+
+	//Ohne gespeicherte Fälligkeit darf auch keine offene Transaktion bleiben, sonst entstehen beim nächsten Lauf Duplikate
+	store.failUpdateSavingsPlan = true
+	now := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	created, err := dep.CreateDueTransactions(ctx, now)
+	if err == nil || len(created) != 0 {
+		t.Fatalf("Expected error without created transactions, got %d (%v)", len(created), err)
+	}
+	pending, _ := store.ReadAllPendingTransactions(ctx)
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending transactions after rollback, got %+v", pending)
+	}
+
+	store.failUpdateSavingsPlan = false
+	created, err = dep.CreateDueTransactions(ctx, now)
+	if err != nil || len(created) != 3 {
+		t.Errorf("Expected 3 pending transactions, got %d (%v)", len(created), err)
+	}
+}
+
+func TestConfirmPendingTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	_, err := dep.AddSavingsPlan(ctx, storage.SavingsPlan{
+		AssetType:    "etf",
+		Asset:        "MSCI World",
+		TickerSymbol: "EUNL",
+		Amount:       101.5,
+		Currency:     "EUR",
+		Interval:     "quarterly",
+		ExecutionDay: 1,
+		StartDate:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add savings plan: %v", err)
+	}
+
+	created, err := dep.CreateDueTransactions(ctx, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(created) != 1 {
+		t.Fatalf("Expected one pending transaction, got %d (%v)", len(created), err)
+	}
+
+	transaction, err := dep.ConfirmPendingTransaction(ctx, created[0].Id, SavingsPlanExecution{Price: 50, Fees: 1.5})
+	if err != nil {
+		t.Fatalf("Failed to confirm pending transaction: %v", err)
+	}
+	if transaction.Quantity != 2 || transaction.TransactionType != "buy" {
+		t.Errorf("Unexpected transaction %+v", transaction)
+	}
+
+	entry, exists := dep.GetEntries()["EUNL"]
+	if !exists || entry.Quantity != 2 || entry.Price != 50 {
+		t.Errorf("Expected depot entry with 2 EUNL at 50, got %+v", entry)
+	}
+
+	pendingTransactions, _ := dep.GetAllPendingTransactions(ctx)
+	if len(pendingTransactions) != 0 {
+		t.Errorf("Expected confirmed transaction to be removed, got %d pending", len(pendingTransactions))
+	}
+}
+
+func TestUpdateAndRemoveTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+	sell := buy
+	sell.Date = time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 8
+	sell.Price = 200
+
+	for _, transaction := range []storage.Transaction{buy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	transactions, _ := dep.GetAllTransactions(ctx)
+	storedBuy := transactions[0]
+
+	//Tippfehler im Preis korrigieren
+	storedBuy.Price = 100
+	err := dep.UpdateTransaction(ctx, storedBuy)
+	if err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+
+	realizedGains, _ := dep.GetAllRealizedGains(ctx)
+	if len(realizedGains) != 1 || realizedGains[0].BuyPrice != 100 {
+		t.Errorf("Expected realized gain to be recomputed with buy price 100, got %+v", realizedGains)
+	}
+	if entry := dep.GetEntries()["AAPL"]; entry.Quantity != 2 || entry.Price != 100 {
+		t.Errorf("Expected 2 AAPL at 100 in depot, got %+v", entry)
+	}
+
+	//Der spätere Verkauf wäre nicht mehr gedeckt
+	storedBuy.Quantity = 5
+	err = dep.UpdateTransaction(ctx, storedBuy)
+	if !errors.Is(err, ErrNoOpenLots) {
+		t.Errorf("Expected ErrNoOpenLots when the update makes a later sell invalid, but got %v", err)
+	}
+
+	err = dep.RemoveTransaction(ctx, storedBuy.Id)
 	if err == nil {
-		t.Fatalf("Expected error when adding an existing transaction, but got none")
+		t.Error("Expected error when removing the buy of a later sell, but got none")
+	}
+
+	//Erst den Verkauf, dann den Kauf löschen
+	err = dep.RemoveTransaction(ctx, transactions[1].Id)
+	if err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	err = dep.RemoveTransaction(ctx, storedBuy.Id)
+	if err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+
+	realizedGains, _ = dep.GetAllRealizedGains(ctx)
+	transactions, _ = dep.GetAllTransactions(ctx)
+	if len(realizedGains) != 0 || len(transactions) != 0 || len(dep.GetEntries()) != 0 {
+		t.Errorf("Expected empty depot, got %d gains, %d transactions, %d entries", len(realizedGains), len(transactions), len(dep.GetEntries()))
+	}
+}
+
+func TestGetEntriesAsOf(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	transactions := []storage.Transaction{
+		{Date: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock", Asset: "Apple",
+			TickerSymbol: "AAPL", Quantity: 10, Price: 100, Fees: 1, Currency: "USD"},
+		{Date: time.Date(2024, 12, 31, 15, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock", Asset: "Apple",
+			TickerSymbol: "AAPL", Quantity: 10, Price: 200, Fees: 1, Currency: "USD"},
+		{Date: time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC), TransactionType: "sell", AssetType: "stock", Asset: "Apple",
+			TickerSymbol: "AAPL", Quantity: 15, Price: 220, Fees: 1, Currency: "USD"},
+	}
+	for _, transaction := range transactions {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	//Der Kauf am 31.12. um 15 Uhr gehört zum Bestand am Jahresende
+	entries, err := dep.GetEntriesAsOf(ctx, time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to compute depot as of date: %v", err)
+	}
+	if entry := entries["AAPL"]; entry.Quantity != 20 || entry.Price != 150 {
+		t.Errorf("Expected 20 AAPL at 150 as of 31.12.2024, got %+v", entry)
+	}
+
+	entries, err = dep.GetEntriesAsOf(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to compute depot as of date: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty depot as of 01.01.2024, got %+v", entries)
+	}
+
+	//Der aktuelle Bestand bleibt unverändert
+	if entry := dep.GetEntries()["AAPL"]; entry.Quantity != 5 || entry.Price != 200 {
+		t.Errorf("Expected current depot with 5 AAPL at 200, got %+v", entry)
+	}
+	unclosedTransactions, _ := store.ReadAllUnclosedTransactions(ctx)
+	if len(unclosedTransactions["AAPL"]) != 1 {
+		t.Errorf("Expected stored unclosed transactions to be unchanged, got %+v", unclosedTransactions)
+	}
+}
+
+func TestJournalRebuild(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+	if err := dep.SetSnapshotInterval(2); err != nil {
+		t.Fatalf("Failed to set snapshot interval: %v", err)
+	}
+
+	buy := storage.Transaction{Date: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock",
+		Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Fees: 1, Currency: "USD"}
+	secondBuy := buy
+	secondBuy.Date = time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	secondBuy.Price = 200
+	sell := buy
+	sell.Date = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 15
+	for _, transaction := range []storage.Transaction{buy, secondBuy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	transactions, _ := dep.GetAllTransactions(ctx)
+	if err := dep.RemoveTransaction(ctx, transactions[2].Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+
+	journal := store.(storage.Journal)
+	events, _ := journal.ReadEvents(ctx, 0)
+	if len(events) != 4 || events[2].Type != storage.EventTransactionAdded || events[3].Type != storage.EventTransactionDeleted {
+		t.Fatalf("Expected 3 added and 1 deleted event, but got %+v", events)
+	}
+	snapshot, _ := journal.LoadLatestSnapshot(ctx)
+	if snapshot == nil || snapshot.EventSequence != events[3].Sequence {
+		t.Fatalf("Expected snapshot after event %d, but got %+v", events[3].Sequence, snapshot)
+	}
+
+	//Die gespeicherten Positionen werden nicht gebraucht, der Bestand kommt aus dem Journal
+	if err := store.RemoveAllUnclosedTransactions(ctx); err != nil {
+		t.Fatalf("Failed to remove unclosed transactions: %v", err)
+	}
+	third := secondBuy
+	third.Date = time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	if err := dep.AddTransaction(ctx, third); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	rebuilt := GetDepot(store)
+	if err := rebuilt.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+	if entry := rebuilt.GetEntries()["AAPL"]; entry.Quantity != 30 || math.Abs(entry.Price-500.0/3) > 1e-9 {
+		t.Errorf("Expected 30 AAPL at 166.67, got %+v", entry)
+	}
+}
+
+func TestCorporateActionSplit(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{Date: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock",
+		Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 400, Fees: 1, Currency: "USD"}
+	if err := dep.AddTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	if err := dep.ApplyCorporateAction(ctx, storage.CorporateAction{Type: "merger", TickerSymbol: "AAPL", Date: buy.Date, Ratio: 4}); err == nil {
+		t.Error("Expected error for an unsupported corporate action, but got none")
+	}
+	split := storage.CorporateAction{Type: "split", TickerSymbol: "AAPL", Date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Ratio: 4}
+	if err := dep.ApplyCorporateAction(ctx, split); err != nil {
+		t.Fatalf("Failed to apply split: %v", err)
+	}
+	if entry := dep.GetEntries()["AAPL"]; entry.Quantity != 40 || entry.Price != 100 {
+		t.Errorf("Expected 40 AAPL at 100 after split, got %+v", entry)
+	}
+
+	//Nach dem Split können alle 40 Stück verkauft werden
+	sell := buy
+	sell.Date = time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 40
+	sell.Price = 120
+	if err := dep.AddTransaction(ctx, sell); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	realizedGains, _ := dep.GetAllRealizedGains(ctx)
+	if len(realizedGains) != 1 || realizedGains[0].BuyPrice != 100 || realizedGains[0].Quantity != 40 {
+		t.Errorf("Expected realized gain of 40 at buy price 100, got %+v", realizedGains)
+	}
+
+	//Vor dem Split gilt die alte Stückzahl
+	entries, _ := dep.GetEntriesAsOf(ctx, time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC))
+	if entry := entries["AAPL"]; entry.Quantity != 10 || entry.Price != 400 {
+		t.Errorf("Expected 10 AAPL at 400 before split, got %+v", entry)
+	}
+
+	rebuilt := GetDepot(store)
+	if err := rebuilt.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+	if len(rebuilt.GetEntries()) != 0 {
+		t.Errorf("Expected empty depot after selling all, got %+v", rebuilt.GetEntries())
+	}
+}
+
+// TestConcurrentAddTransactions fügt Transaktionen parallel hinzu, wie es parallele Requests und der
+// Sparplan-Scheduler tun. Mit go test -race findet der Test gemeinsam genutzten Zustand ohne Sperre.
+func TestConcurrentAddTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := setupFileStore(t)
+	dep := GetDepot(store)
+	if err := dep.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+
+	const workers = 8
+	const perWorker = 10
+	day := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tickerSymbol := fmt.Sprintf("T%02d", worker)
+			for i := range perWorker {
+				buy := storage.Transaction{Date: day.AddDate(0, 0, i), TransactionType: "buy", AssetType: "stock",
+					Asset: "Asset " + tickerSymbol, TickerSymbol: tickerSymbol, Quantity: 1, Price: 100, Currency: "EUR"}
+				if err := dep.AddTransaction(ctx, buy); err != nil {
+					errs <- err
+				}
+				//Gleichzeitig lesen, wie die Handler es tun
+				_ = dep.GetEntries()
+				if _, err := dep.QueryTransactions(ctx, storage.TransactionQuery{TickerSymbol: tickerSymbol, Limit: 5}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	transactions, _ := dep.GetAllTransactions(ctx)
+	if len(transactions) != workers*perWorker {
+		t.Errorf("Expected %d transactions, but got %d", workers*perWorker, len(transactions))
+	}
+	entries := dep.GetEntries()
+	for worker := range workers {
+		if entry := entries[fmt.Sprintf("T%02d", worker)]; entry.Quantity != perWorker {
+			t.Errorf("Expected %d of %s, got %+v", perWorker, fmt.Sprintf("T%02d", worker), entry)
+		}
+	}
+}
+
+func TestComputeAllTransactionsIsAudited(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"}
+	sell := buy
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	for _, transaction := range []storage.Transaction{buy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	before, err := dep.GetAuditLog(ctx, storage.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+
+	if err := dep.ComputeAllTransactions(ctx); err != nil {
+		t.Fatalf("Failed to compute transactions: %v", err)
+	}
+
+	//Die neu berechneten Positionen und Abrechnungen stehen nur als Anzahl im Eintrag der Neuberechnung
+	entries, err := dep.GetAuditLog(ctx, storage.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(entries) != len(before)+1 || entries[0].Operation != "ComputeAllTransactions" || entries[0].Entity != "depot" {
+		t.Fatalf("Expected only one recompute entry, but got %+v", entries[:len(entries)-len(before)])
+	}
+	var counts map[string]int
+	if err := json.Unmarshal(entries[0].After, &counts); err != nil || counts["unclosedTransactions"] != 1 || counts["realizedGains"] != 1 {
+		t.Errorf("Expected 1 unclosed transaction and 1 realized gain, got %s (%v)", entries[0].After, err)
+	}
+}
+
+func TestDuplicateDetection(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{
+		Date:            time.Date(2023, 10, 1, 9, 30, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+
+	if err := dep.AddTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	//Zweiter Kauf am selben Tag mit anderer Anzahl ist kein Duplikat
+	secondBuy := buy
+	secondBuy.Date = time.Date(2023, 10, 1, 15, 0, 0, 0, time.UTC)
+	secondBuy.Quantity = 5
+	if err := dep.AddTransaction(ctx, secondBuy); err != nil {
+		t.Fatalf("Expected second buy on the same day to be accepted: %v", err)
+	}
+
+	//Erneuter Import mit leicht abweichender Uhrzeit ist ein Duplikat
+	reimport := buy
+	reimport.Date = time.Date(2023, 10, 1, 9, 30, 5, 0, time.UTC)
+	if err := dep.AddTransaction(ctx, reimport); err == nil {
+		t.Error("Expected error when re-importing a transaction with a different time, but got none")
+	}
+
+	//Mit Auftragsnummer entscheidet nur die Auftragsnummer
+	ordered := buy
+	ordered.Broker = "comdirect"
+	ordered.OrderNumber = "4711"
+	if err := dep.AddTransaction(ctx, ordered); err != nil {
+		t.Fatalf("Expected transaction with order number to be accepted: %v", err)
+	}
+	ordered.Date = time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	if err := dep.AddTransaction(ctx, ordered); err == nil {
+		t.Error("Expected error when adding the same order number twice, but got none")
+	}
+
+	//Mit genauem Zeitpunkt im Fingerabdruck ist der erneute Import erlaubt
+	if err := dep.SetDuplicateFingerprint([]string{"dateTime", "transactionType"}); err != nil {
+		t.Fatalf("Failed to set duplicate fingerprint: %v", err)
+	}
+	if err := dep.AddTransaction(ctx, reimport); err != nil {
+		t.Errorf("Expected transaction to be accepted with fingerprint dateTime: %v", err)
+	}
+
+	if err := dep.SetDuplicateFingerprint([]string{"isin"}); err == nil {
+		t.Error("Expected error for unsupported fingerprint field, but got none")
+	}
+}
+
+func TestAddTransactionSavesOnlyChangedLots(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+	secondBuy := buy
+	secondBuy.Date = time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC)
+	secondBuy.Price = 160
+	basf := buy
+	basf.Asset = "BASF"
+	basf.TickerSymbol = "BAS1"
+	basf.Price = 45
+	partialSell := buy
+	partialSell.Date = time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	partialSell.TransactionType = "sell"
+	partialSell.Quantity = 12
+	partialSell.Price = 200
+	finalSell := partialSell
+	finalSell.Date = time.Date(2023, 11, 2, 12, 0, 0, 0, time.UTC)
+	finalSell.Quantity = 8
+
+	for i, transaction := range []storage.Transaction{buy, secondBuy, basf, partialSell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction %d: %v", i, err)
+		}
+	}
+
+	//Der gespeicherte Stand muss dem Stand im Speicher entsprechen
+	stored, err := store.ReadAllUnclosedTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read unclosed transactions: %v", err)
+	}
+	if !reflect.DeepEqual(stored, dep.unclosedTransactions) {
+		t.Errorf("Stored unclosed transactions differ from depot:\n%+v\n%+v", stored, dep.unclosedTransactions)
+	}
+	if lots := stored["AAPL"]; len(lots) != 1 || math.Abs(lots[0].Quantity-8) > quantityEpsilon || lots[0].Price != 160 {
+		t.Errorf("Expected one AAPL lot with 8 pieces at 160, got %+v", lots)
+	}
+
+	//Nach dem Verkauf der letzten Position ist das Asset nicht mehr offen
+	if err := dep.AddTransaction(ctx, finalSell); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read unclosed ticker symbols: %v", err)
+	}
+	if len(tickerSymbols) != 1 || tickerSymbols[0] != "BAS1" {
+		t.Errorf("Expected only BAS1 to be unclosed, got %v", tickerSymbols)
+	}
+}
+
+// Eine nachgetragene Transaktion wird nicht hinten angehängt, der Verkauf schließt die zeitlich erste Position.
+func TestAddBackdatedTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	laterBuy := storage.Transaction{Date: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 200, Currency: "USD"}
+	earlierBuy := laterBuy
+	earlierBuy.Date = time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	earlierBuy.Price = 100
+	sell := laterBuy
+	sell.Date = time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Price = 250
+	for i, transaction := range []storage.Transaction{laterBuy, earlierBuy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction %d: %v", i, err)
+		}
+	}
+
+	lots := dep.unclosedTransactions["AAPL"]
+	if len(lots) != 1 || lots[0].Price != 200 || !lots[0].Date.Equal(laterBuy.Date) {
+		t.Errorf("Expected the lot of 2024-01-10 to stay open, got %+v", lots)
+	}
+	gains, err := store.ReadAllRealizedGains(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read realized gains: %v", err)
+	}
+	if len(gains) != 1 || gains[0].BuyPrice != 100 {
+		t.Errorf("Expected one realized gain with buy price 100, got %+v", gains)
+	}
+	report, err := dep.Verify(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to verify store: %v", err)
+	}
+	if !report.Consistent() {
+		t.Errorf("Expected consistent store, got %+v", report.Tickers)
+	}
+}
+
+// journalFreeStore verbirgt das Journal des Stores, die Benchmarks messen es getrennt.
+type journalFreeStore struct {
+	storage.Store
+}
+
+// setupBenchmarkDepot erzeugt ein Depot mit vielen offenen Positionen in einer SQLite-Datei, damit der
+// Unterschied zwischen dem Speichern aller und dem Speichern der geänderten Positionen sichtbar wird.
+// Ohne withJournal schreibt das Depot keine Ereignisse und Snapshots.
+func setupBenchmarkDepot(b *testing.B, withJournal bool) *Depot {
+	ctx := context.Background()
+	store := setupFileStore(b)
+	if !withJournal {
+		store = journalFreeStore{store}
+	}
+	dep := GetDepot(store)
+	if err := store.Begin(ctx); err != nil {
+		b.Fatalf("Failed to begin transaction: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		transaction := storage.Transaction{
+			Id:              uuid.New(),
+			Date:            time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+			TransactionType: "buy",
+			AssetType:       "stock",
+			Asset:           fmt.Sprintf("Asset %d", i%50),
+			TickerSymbol:    fmt.Sprintf("T%02d", i%50),
+			Quantity:        10,
+			Price:           100,
+			Currency:        "EUR"}
+		if err := store.AddTransaction(ctx, &transaction); err != nil {
+			b.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	if err := store.Commit(); err != nil {
+		b.Fatalf("Failed to commit transaction: %v", err)
+	}
+	if err := dep.ComputeAllTransactions(ctx); err != nil {
+		b.Fatalf("Failed to compute transactions: %v", err)
+	}
+	return dep
+}
+
+// benchmarkTransactions liefert einen Kauf und einen Verkauf gleicher Anzahl. Die Anzahl
+// der offenen Positionen bleibt dadurch über alle Iterationen gleich.
+func benchmarkTransactions(i int) []storage.Transaction {
+	buy := storage.Transaction{
+		Date:            time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Asset 0",
+		TickerSymbol:    "T00",
+		Quantity:        10,
+		Price:           float64(100 + i),
+		Currency:        "EUR"}
+	sell := buy
+	sell.TransactionType = "sell"
+	return []storage.Transaction{buy, sell}
+}
+
+func BenchmarkAddTransactionIncremental(b *testing.B) {
+	benchmarkAddTransaction(b, setupBenchmarkDepot(b, false))
+}
+
+// BenchmarkAddTransactionJournal misst dasselbe mit Journal, also zusätzlich mit Ereignis und Snapshot.
+func BenchmarkAddTransactionJournal(b *testing.B) {
+	benchmarkAddTransaction(b, setupBenchmarkDepot(b, true))
+}
+
+func benchmarkAddTransaction(b *testing.B, dep *Depot) {
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, transaction := range benchmarkTransactions(i) {
+			if err := dep.AddTransaction(ctx, transaction); err != nil {
+				b.Fatalf("Failed to add transaction: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkAddTransactionFullRewrite entspricht dem früheren Vorgehen, bei dem nach jeder
+// Transaktion alle unclosed transactions gelöscht und neu gespeichert wurden.
+func BenchmarkAddTransactionFullRewrite(b *testing.B) {
+	ctx := context.Background()
+	dep := setupBenchmarkDepot(b, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, transaction := range benchmarkTransactions(i) {
+			transaction.Id = uuid.New()
+			err := dep.inStoreTransaction(ctx, func() error {
+				if _, _, err := dep.processNewTransaction(transaction); err != nil {
+					return err
+				}
+				if err := dep.store.AddTransaction(ctx, &transaction); err != nil {
+					return err
+				}
+				return dep.saveAllUnclosedTransactions(ctx)
+			})
+			if err != nil {
+				b.Fatalf("Failed to add transaction: %v", err)
+			}
+		}
+	}
+}
+
+// failingStore lässt einzelne Store-Aufrufe fehlschlagen, um das Zurückrollen zu testen.
+type failingStore struct {
+	storage.Store
+	failAddRealizedGain   bool
+	failCommit            bool
+	failUpdateSavingsPlan bool
+}
+
+func (s *failingStore) UpdateSavingsPlan(ctx context.Context, plan *storage.SavingsPlan) error {
+	if s.failUpdateSavingsPlan {
+		return errors.New("disk full")
+	}
+	return s.Store.UpdateSavingsPlan(ctx, plan)
+}
+
+func (s *failingStore) AddRealizedGain(ctx context.Context, realizedGain storage.RealizedGain) error {
+	if s.failAddRealizedGain {
+		return errors.New("disk full")
+	}
+	return s.Store.AddRealizedGain(ctx, realizedGain)
+}
+
+func (s *failingStore) Commit() error {
+	if s.failCommit {
+		//Wie bei einem fehlgeschlagenen Commit wird nichts gespeichert
+		s.Store.Rollback()
+		return errors.New("database is locked")
+	}
+	return s.Store.Commit()
+}
+
+func TestAddTransactionIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Store: setupTestStore(t)}
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+	sell := buy
+	sell.Date = time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	sell.Price = 200
+
+	if err := dep.AddTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	for _, failure := range []string{"realized gain", "commit"} {
+		store.failAddRealizedGain = failure == "realized gain"
+		store.failCommit = failure == "commit"
+
+		if err := dep.AddTransaction(ctx, sell); !errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("Expected ErrStoreUnavailable when %s fails, but got %v", failure, err)
+		}
+
+		transactions, _ := store.ReadAllTransactions(ctx)
+		if len(transactions) != 1 {
+			t.Errorf("%s: expected sell transaction to be rolled back, got %d transactions", failure, len(transactions))
+		}
+		storedLots, _ := store.ReadAllUnclosedTransactions(ctx)
+		if len(storedLots["AAPL"]) != 1 || storedLots["AAPL"][0].Quantity != 10 {
+			t.Errorf("%s: expected stored lot to be unchanged, got %+v", failure, storedLots["AAPL"])
+		}
+		if lots := dep.unclosedTransactions["AAPL"]; len(lots) != 1 || lots[0].Quantity != 10 {
+			t.Errorf("%s: expected lot in memory to be unchanged, got %+v", failure, lots)
+		}
+		if entry := dep.GetEntries()["AAPL"]; entry.Quantity != 10 {
+			t.Errorf("%s: expected 10 AAPL in depot, got %v", failure, entry.Quantity)
+		}
+	}
+
+	//Ohne Fehler wird der Verkauf gespeichert
+	store.failAddRealizedGain = false
+	store.failCommit = false
+	if err := dep.AddTransaction(ctx, sell); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	realizedGains, _ := store.ReadAllRealizedGains(ctx)
+	if len(realizedGains) != 1 || dep.GetEntries()["AAPL"].Quantity != 6 {
+		t.Errorf("Expected 1 realized gain and 6 AAPL, got %d gains and %+v", len(realizedGains), dep.GetEntries()["AAPL"])
+	}
+}
+
+// streamingStore lässt ReadAllTransactions fehlschlagen, die Neuberechnung muss also IterateTransactions verwenden.
+// Gezählt wird, wie viele Transaktionen gelesen werden und wie oft das Journal gelesen wird.
+// Das Journal des Stores bleibt erreichbar.
+type streamingStore struct {
+	storage.Store
+	storage.Journal
+	rows        *int
+	journalRead *int
+}
+
+func newStreamingStore(store storage.Store) streamingStore {
+	return streamingStore{Store: store, Journal: store.(storage.Journal), rows: new(int), journalRead: new(int)}
+}
+
+func (s streamingStore) ReadEvents(ctx context.Context, afterSequence int64) ([]storage.JournalEvent, error) {
+	*s.journalRead++
+	return s.Journal.ReadEvents(ctx, afterSequence)
+}
+
+func (s streamingStore) ReadAllTransactions(ctx context.Context) ([]storage.Transaction, error) {
+	return nil, errors.New("ReadAllTransactions must not be used")
+}
+
+func (s streamingStore) IterateTransactions(ctx context.Context, query storage.TransactionQuery) iter.Seq2[storage.Transaction, error] {
+	return func(yield func(storage.Transaction, error) bool) {
+		for transaction, err := range s.Store.IterateTransactions(ctx, query) {
+			*s.rows++
+			if !yield(transaction, err) {
+				return
+			}
+		}
+	}
+}
+
+func TestComputeAllTransactionsStreams(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.Begin(ctx)
+	//Mehr Transaktionen als eine Seite von IterateTransactions. Kauf und Verkauf haben denselben Zeitpunkt
+	//und dieselbe Sequenznummer, es zählt also die Reihenfolge des Einfügens.
+	for i := range 1500 {
+		buy := storage.Transaction{Id: uuid.New(), Date: day.AddDate(0, 0, i), TransactionType: "buy", AssetType: "crypto",
+			Asset: "Bitcoin", TickerSymbol: "BTC", Quantity: 2, Price: float64(100 + i), Currency: "EUR"}
+		sell := buy
+		sell.Id = uuid.New()
+		sell.TransactionType = "sell"
+		sell.Quantity = 2
+		for _, transaction := range []storage.Transaction{buy, sell} {
+			if err := store.AddTransaction(ctx, &transaction); err != nil {
+				t.Fatalf("Failed to add transaction: %v", err)
+			}
+		}
+	}
+	store.Commit()
+
+	streaming := newStreamingStore(store)
+	dep := GetDepot(streaming)
+	//Schreibt den ersten Snapshot, Ereignisse werden aus den Transaktionen nicht abgeleitet
+	if err := dep.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+	if events, _ := streaming.Journal.ReadEvents(ctx, 0); len(events) != 0 {
+		t.Errorf("Expected no journal events for stored transactions, but got %d", len(events))
+	}
+	*streaming.rows = 0
+	if err := dep.ComputeAllTransactions(ctx); err != nil {
+		t.Fatalf("Failed to compute transactions: %v", err)
+	}
+	//Jede Transaktion wird genau einmal gelesen
+	if *streaming.rows != 3000 {
+		t.Errorf("Expected 3000 rows to be read, but got %d", *streaming.rows)
+	}
+	realizedGains, _ := store.ReadAllRealizedGains(ctx)
+	if len(realizedGains) != 1500 || len(dep.GetEntries()) != 0 {
+		t.Errorf("Expected 1500 realized gains and an empty depot, got %d gains and %+v", len(realizedGains), dep.GetEntries())
+	}
+
+	//Der Snapshot enthält nur die offenen Positionen, nicht die Transaktionen
+	snapshot, err := streaming.LoadLatestSnapshot(ctx)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Expected snapshot, but got %v", err)
+	}
+	if len(snapshot.State) > 512 {
+		t.Errorf("Expected small snapshot without transactions, but got %d bytes", len(snapshot.State))
+	}
+
+	//Korrigieren, Löschen und der Bestand zu einem Stichtag lesen ebenfalls seitenweise
+	page, _ := store.QueryTransactions(ctx, storage.TransactionQuery{Descending: true, Limit: 2})
+	sell, buy := page.Transactions[0], page.Transactions[1]
+	buy.Price = 50
+	if err := dep.UpdateTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+	if err := dep.RemoveTransaction(ctx, sell.Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	entries, err := dep.GetEntriesAsOf(ctx, buy.Date)
+	if err != nil {
+		t.Fatalf("Failed to compute depot as of date: %v", err)
+	}
+	if entry := entries["BTC"]; entry.Quantity != 2 || entry.Price != 50 {
+		t.Errorf("Expected 2 BTC at 50, got %+v", entry)
+	}
+
+	rebuilt := GetDepot(newStreamingStore(store))
+	if err := rebuilt.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+	if entry := rebuilt.GetEntries()["BTC"]; entry.Quantity != 2 || entry.Price != 50 {
+		t.Errorf("Expected 2 BTC at 50 after rebuild from journal, got %+v", entry)
+	}
+}
+
+func TestSnapshotsAreIncremental(t *testing.T) {
+	ctx := context.Background()
+	streaming := newStreamingStore(setupTestStore(t))
+	dep := GetDepot(streaming)
+	if err := dep.SetSnapshotInterval(3); err != nil {
+		t.Fatalf("Failed to set snapshot interval: %v", err)
+	}
+	if err := dep.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+
+	buy := storage.Transaction{Date: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock",
+		Asset: "Apple", TickerSymbol: "AAPL", Quantity: 1, Price: 100, Currency: "USD"}
+	*streaming.journalRead, *streaming.rows = 0, 0
+	for i := range 7 {
+		transaction := buy
+		transaction.Date = buy.Date.AddDate(0, 0, i)
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	//Das Journal wird nur für die Snapshots nach Ereignis 3 und 6 gelesen, der Store gar nicht
+	if *streaming.journalRead != 2 || *streaming.rows != 0 {
+		t.Errorf("Expected 2 journal reads and no rows, but got %d reads and %d rows", *streaming.journalRead, *streaming.rows)
+	}
+	snapshot, _ := streaming.LoadLatestSnapshot(ctx)
+	if snapshot == nil || snapshot.EventSequence != 6 {
+		t.Fatalf("Expected snapshot after event 6, but got %+v", snapshot)
+	}
+
+	rebuilt := GetDepot(streaming)
+	if err := rebuilt.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate depot: %v", err)
+	}
+	if entry := rebuilt.GetEntries()["AAPL"]; entry.Quantity != 7 || entry.Price != 100 {
+		t.Errorf("Expected 7 AAPL at 100, got %+v", entry)
+	}
+}
+
+// cancellingStore bricht den Context ab, sobald während der Neuberechnung ein Realized Gain gespeichert wird
+type cancellingStore struct {
+	storage.Store
+	cancel context.CancelFunc
+}
+
+func (s cancellingStore) AddRealizedGain(ctx context.Context, realizedGain storage.RealizedGain) error {
+	s.cancel()
+	return s.Store.AddRealizedGain(ctx, realizedGain)
+}
+
+func TestComputeAllTransactionsCancelled(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+	buy := storage.Transaction{Id: uuid.New(), Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"}
+	sell := buy
+	sell.Id = uuid.New()
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	sell.Price = 120
+	for _, transaction := range []storage.Transaction{buy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cancelled := GetDepot(cancellingStore{Store: store, cancel: cancel})
+	if err := cancelled.CalculateSecuritiesAccountBalance(ctx); err != nil {
+		t.Fatalf("Failed to calculate balance: %v", err)
+	}
+	if err := cancelled.ComputeAllTransactions(cancelCtx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	//Die Neuberechnung wurde komplett zurückgerollt
+	realizedGains, _ := store.ReadAllRealizedGains(ctx)
+	unclosed, _ := store.ReadAllUnclosedTransactions(ctx)
+	if len(realizedGains) != 1 || len(unclosed["AAPL"]) != 1 || unclosed["AAPL"][0].Quantity != 6 {
+		t.Errorf("Expected store to be unchanged, got %d gains and %+v", len(realizedGains), unclosed)
+	}
+	if entry := cancelled.GetEntries()["AAPL"]; entry.Quantity != 6 {
+		t.Errorf("Expected 6 AAPL in depot, got %v", entry.Quantity)
+	}
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Fees: 1, Currency: "USD"}
+	basf := buy
+	basf.Asset = "BASF"
+	basf.TickerSymbol = "BAS1"
+	basf.Price = 45
+	sell := buy
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	sell.Price = 120
+	for _, transaction := range []storage.Transaction{buy, basf, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	report, err := dep.Verify(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to verify store: %v", err)
+	}
+	if !report.Consistent() || report.Transactions != 3 || report.UnclosedLots != 2 || report.RealizedGains != 1 {
+		t.Fatalf("Expected consistent store with 3 transactions, 2 lots and 1 gain, got %+v", report)
+	}
+
+	//Stand nach einem Absturz mitten in AddTransaction nachstellen. AddTransaction vergibt neue Ids.
+	stored, err := store.ReadAllUnclosedTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read unclosed transactions: %v", err)
+	}
+	gains, err := store.ReadAllRealizedGains(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read realized gains: %v", err)
+	}
+	lot := stored["AAPL"][0]
+	lot.Quantity = 9
+	if err := store.UpdateUnclosedTransaction(ctx, lot); err != nil {
+		t.Fatalf("Failed to update unclosed transaction: %v", err)
+	}
+	missing := stored["BAS1"][0]
+	if err := store.RemoveUnclosedTransaction(ctx, missing.Id); err != nil {
+		t.Fatalf("Failed to remove unclosed transaction: %v", err)
+	}
+	orphan := storage.RealizedGain{Id: uuid.New(), SellTransactionId: gains[0].SellTransactionId, BuyTransactionId: missing.Id,
+		Asset: "Apple", Quantity: 1, Amount: 20, Currency: "USD"}
+	if err := store.AddRealizedGain(ctx, orphan); err != nil {
+		t.Fatalf("Failed to add realized gain: %v", err)
+	}
+
+	report, err = dep.Verify(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to verify store: %v", err)
+	}
+	if len(report.Tickers) != 2 || report.Discrepancies() != 3 || report.Repaired {
+		t.Fatalf("Expected 3 discrepancies in 2 assets, got %+v", report)
+	}
+	aapl, bas1 := report.Tickers[0], report.Tickers[1]
+	if aapl.TickerSymbol != "AAPL" || len(aapl.ChangedLots) != 1 || aapl.ChangedLots[0].Expected.Quantity != 6 ||
+		len(aapl.UnexpectedGains) != 1 || aapl.UnexpectedGains[0].Id != orphan.Id {
+		t.Errorf("Expected changed lot and unexpected gain for AAPL, got %+v", aapl)
+	}
+	if bas1.TickerSymbol != "BAS1" || len(bas1.MissingLots) != 1 || bas1.MissingLots[0].Id != missing.Id {
+		t.Errorf("Expected missing lot for BAS1, got %+v", bas1)
+	}
+
+	report, err = dep.Verify(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to repair store: %v", err)
+	}
+	if !report.Repaired {
+		t.Errorf("Expected store to be repaired")
+	}
+	report, err = dep.Verify(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to verify store: %v", err)
+	}
+	if !report.Consistent() {
+		t.Errorf("Expected consistent store after repair, got %+v", report.Tickers)
+	}
+	if entry := dep.GetEntries()["BAS1"]; entry.Quantity != 10 {
+		t.Errorf("Expected 10 BAS1 in depot, got %v", entry.Quantity)
+	}
+	entries, err := dep.GetAuditLog(ctx, storage.AuditFilter{Operation: "Repair"})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected one repair in audit log, got %d (%v)", len(entries), err)
+	}
+}
+
+// Ein Backup, dessen offene Positionen nicht zu seinen Transaktionen passen, wird nicht zurückgespielt.
+func TestRestoreRejectsInconsistentArchive(t *testing.T) {
+	ctx := context.Background()
+	dep := GetDepot(setupTestStore(t))
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"}
+	sell := buy
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	for _, transaction := range []storage.Transaction{buy, sell} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	archive, err := dep.Backup(ctx, storage.ArchiveMetadata{})
+	if err != nil {
+		t.Fatalf("Failed to back up depot: %v", err)
+	}
+
+	tampered := *archive
+	tampered.UnclosedTransactions = slices.Clone(archive.UnclosedTransactions)
+	tampered.UnclosedTransactions[0].Quantity = 10
+	target := setupTestStore(t)
+	if err := GetDepot(target).Restore(ctx, &tampered); !errors.Is(err, ErrValidation) {
+		t.Fatalf("Expected ErrValidation for an inconsistent archive, got %v", err)
+	}
+	if transactions, _ := target.ReadAllTransactions(ctx); len(transactions) != 0 {
+		t.Errorf("Expected nothing to be restored, got %d transactions", len(transactions))
+	}
+
+	restored := GetDepot(target)
+	if err := restored.Restore(ctx, archive); err != nil {
+		t.Fatalf("Failed to restore archive: %v", err)
+	}
+	if entry := restored.GetEntries()["AAPL"]; entry.Quantity != 6 {
+		t.Errorf("Expected 6 AAPL after restore, got %+v", entry)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Fees: 0, Currency: "USD"}
+	//Ohne Gebühren ist eine Transaktion gültig
+	if err := dep.AddTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to add transaction without fees: %v", err)
+	}
+
+	sell := buy
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	sell.Price = 120
+
+	tests := []struct {
+		name           string
+		change         func(t *storage.Transaction)
+		expectedFields []string
+	}{
+		{"negative quantity", func(t *storage.Transaction) { t.Quantity = -1 }, []string{"quantity"}},
+		{"zero price and negative fees", func(t *storage.Transaction) { t.Price = 0; t.Fees = -1 }, []string{"price", "fees"}},
+		{"unknown currency", func(t *storage.Transaction) { t.Currency = "XYZ" }, []string{"currency"}},
+		{"future date", func(t *storage.Transaction) { t.Date = time.Now().AddDate(0, 0, 2) }, []string{"date"}},
+		{"other asset name", func(t *storage.Transaction) { t.Asset = "Apple Computer" }, []string{"asset"}},
+		{"sell more than held", func(t *storage.Transaction) { t.Quantity = 11 }, []string{"quantity"}},
+		{"missing fields", func(t *storage.Transaction) { t.AssetType = ""; t.Asset = "" }, []string{"assetType", "asset"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transaction := sell
+			tt.change(&transaction)
+			err := dep.AddTransaction(ctx, transaction)
+			if !errors.Is(err, ErrValidation) {
+				t.Fatalf("Expected ErrValidation, got %v", err)
+			}
+			fields := []string{}
+			for _, validationErr := range ValidationErrors(err) {
+				fields = append(fields, validationErr.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.expectedFields) {
+				t.Errorf("Expected errors for %v, got %v (%v)", tt.expectedFields, fields, err)
+			}
+		})
+	}
+
+	//Abgeschaltete Regeln werden nicht geprüft
+	if err := dep.SetValidation(ValidationConfig{Disabled: []string{RuleTickerName}, Currencies: []string{"USD", "EUR"}}); err != nil {
+		t.Fatalf("Failed to set validation: %v", err)
+	}
+	renamed := sell
+	renamed.Asset = "Apple Inc."
+	renamed.Quantity = 4
+	if err := dep.AddTransaction(ctx, renamed); err != nil {
+		t.Errorf("Failed to add transaction with disabled rule: %v", err)
+	}
+	renamed.Currency = "CHF"
+	if err := dep.AddTransaction(ctx, renamed); !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected ErrValidation for a currency not in the config, got %v", err)
+	}
+	if err := dep.SetValidation(ValidationConfig{Disabled: []string{"isin"}}); err == nil {
+		t.Error("Expected error for unknown rule, but got none")
+	}
+}
+
+// Ein nachgetragener Verkauf wird gegen den Bestand an seinem Datum geprüft, nicht gegen den heutigen.
+func TestValidateBackdatedSell(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	buy := storage.Transaction{Date: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"}
+	laterBuy := buy
+	laterBuy.Date = time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	laterBuy.Quantity = 5
+	for _, transaction := range []storage.Transaction{buy, laterBuy} {
+		if err := dep.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	split := storage.CorporateAction{Type: "split", TickerSymbol: "AAPL", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Ratio: 2}
+	if err := dep.ApplyCorporateAction(ctx, split); err != nil {
+		t.Fatalf("Failed to apply split: %v", err)
+	}
+
+	//Vor dem ersten Kauf ist nichts im Bestand, auch wenn heute 25 Stück offen sind
+	sell := buy
+	sell.Date = time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 5
+	sell.Price = 120
+	err := dep.AddTransaction(ctx, sell)
+	if fields := ValidationErrors(err); len(fields) != 1 || fields[0].Field != "quantity" {
+		t.Errorf("Expected validation error for quantity, got %v", err)
+	}
+
+	//Zwischen Split und zweitem Kauf sind 20 Stück im Bestand
+	sell.Date = time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	sell.Quantity = 21
+	if err := dep.AddTransaction(ctx, sell); !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected ErrValidation for selling 21 of 20, got %v", err)
+	}
+	sell.Quantity = 20
+	if err := dep.AddTransaction(ctx, sell); err != nil {
+		t.Fatalf("Failed to add back-dated sell: %v", err)
+	}
+	if entry := dep.GetEntries()["AAPL"]; entry.Quantity != 5 || entry.Price != 100 {
+		t.Errorf("Expected 5 AAPL at 100, got %+v", entry)
+	}
+}
+
+func TestValidateImport(t *testing.T) {
+	validator, err := NewValidator(ValidationConfig{EarliestDate: "2020-01-01"})
+	if err != nil {
+		t.Fatalf("Failed to create validator: %v", err)
+	}
+	existing := []storage.Transaction{{Date: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "BASF", TickerSymbol: "BAS1", Quantity: 5, Price: 45, Currency: "EUR"}}
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Currency: "USD"}
+	sell := buy
+	sell.Date = buy.Date.AddDate(0, 1, 0)
+	sell.TransactionType = "sell"
+	basfSell := existing[0]
+	basfSell.Date = sell.Date
+	basfSell.TransactionType = "sell"
+	basfSell.Quantity = 6
+	old := buy
+	old.Date = time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	//Der Verkauf steht vor dem Kauf, geprüft wird aber in zeitlicher Reihenfolge
+	err = validator.ValidateAll([]storage.Transaction{sell, buy}, NewLedger(existing))
+	if err != nil {
+		t.Errorf("Expected valid import, got %v", err)
+	}
+
+	err = validator.ValidateAll([]storage.Transaction{basfSell, old}, NewLedger(existing))
+	validationErrs := ValidationErrors(err)
+	if len(validationErrs) != 2 || validationErrs[0].Field != "date" || validationErrs[1].Field != "quantity" {
+		t.Errorf("Expected errors for date and quantity, got %v", err)
+	}
+}
+
+func TestValidateIsin(t *testing.T) {
+	tests := []struct {
+		isin  string
+		valid bool
+	}{
+		{"US0378331005", true},
+		{"DE000BASF111", true},
+		{"IE00B4L5Y983", true},
+		{"US0378331006", false}, //Falsche Prüfziffer
+		{"US037833100", false},
+		{"0S0378331005", false},
+		{"US03783310AB", false},
+		{"US-378331005", false},
+	}
+	for _, tt := range tests {
+		err := ValidateIsin(tt.isin)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateIsin(%s): expected valid %v, got %v", tt.isin, tt.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrValidation) {
+			t.Errorf("ValidateIsin(%s): expected ErrValidation, got %v", tt.isin, err)
+		}
+	}
+}
+
+func TestInstruments(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	dep := GetDepot(store)
+
+	apple, err := dep.AddInstrument(ctx, storage.Instrument{Isin: " us0378331005", Wkn: "865985", Tickers: []string{"AAPL", "APC"},
+		Name: "Apple Inc.", Type: "stock", Exchange: "NASDAQ", Currency: "usd", Sector: "Technology", Country: "us"})
+	if err != nil {
+		t.Fatalf("Failed to add instrument: %v", err)
+	}
+	if apple.Isin != "US0378331005" || apple.Currency != "USD" || apple.Country != "US" {
+		t.Errorf("Expected normalized instrument, got %+v", apple)
+	}
+	if _, err = dep.AddInstrument(ctx, apple); !errors.Is(err, ErrDuplicateInstrument) {
+		t.Errorf("Expected ErrDuplicateInstrument, got %v", err)
+	}
+	_, err = dep.AddInstrument(ctx, storage.Instrument{Isin: "US5949181046", Wkn: "87092", Tickers: []string{"AAPL"}, Currency: "XYZ"})
+	fields := []string{}
+	for _, validationErr := range ValidationErrors(err) {
+		fields = append(fields, validationErr.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"isin", "wkn", "name", "type", "currency"}) {
+		t.Errorf("Expected errors for isin, wkn, name, type and currency, got %v", err)
+	}
+	_, err = dep.AddInstrument(ctx, storage.Instrument{Isin: "US5949181045", Tickers: []string{"AAPL"}, Name: "Microsoft", Type: "stock"})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected ErrValidation for a ticker symbol of another instrument, got %v", err)
+	}
+
+	//Fehlende Angaben werden aus den Stammdaten ergänzt, die Transaktion verweist über die ISIN auf das Instrument
+	buy := storage.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy", Isin: "US0378331005",
+		Quantity: 10, Price: 100}
+	if err = dep.AddTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to add transaction by ISIN: %v", err)
+	}
+	byTicker := storage.Transaction{Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), TransactionType: "buy", AssetType: "stock",
+		Asset: "Apple Inc.", TickerSymbol: "APC", Quantity: 5, Price: 110, Currency: "USD"}
+	if err = dep.AddTransaction(ctx, byTicker); err != nil {
+		t.Fatalf("Failed to add transaction by ticker symbol: %v", err)
+	}
+	transactions, err := dep.GetAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if len(transactions) != 2 || transactions[0].TickerSymbol != "AAPL" || transactions[0].Asset != "Apple Inc." ||
+		transactions[0].AssetType != "stock" || transactions[0].Currency != "USD" || transactions[1].Isin != "US0378331005" {
+		t.Errorf("Expected transactions linked to the instrument, got %+v", transactions)
+	}
+
+	tests := []struct {
+		name          string
+		change        func(t *storage.Transaction)
+		expectedField string
+	}{
+		{"invalid isin", func(t *storage.Transaction) { t.Isin = "US0378331006" }, "isin"},
+		{"unknown isin", func(t *storage.Transaction) { t.Isin = "US5949181045" }, "isin"},
+		{"other ticker symbol", func(t *storage.Transaction) { t.TickerSymbol = "MSFT" }, "tickerSymbol"},
+		{"other currency", func(t *storage.Transaction) { t.Currency = "EUR" }, "currency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transaction := buy
+			transaction.Date = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+			tt.change(&transaction)
+			err := dep.AddTransaction(ctx, transaction)
+			validationErrs := ValidationErrors(err)
+			if len(validationErrs) != 1 || validationErrs[0].Field != tt.expectedField {
+				t.Errorf("Expected validation error for %s, got %v", tt.expectedField, err)
+			}
+		})
+	}
+
+	found, err := dep.SearchInstruments(ctx, storage.InstrumentQuery{Text: "apc"})
+	if err != nil || len(found) != 1 || found[0].Isin != apple.Isin {
+		t.Errorf("Expected to find Apple by ticker symbol, got %+v (%v)", found, err)
+	}
+	apple.Exchange = "XETRA"
+	if _, err = dep.UpdateInstrument(ctx, apple); err != nil {
+		t.Fatalf("Failed to update instrument: %v", err)
+	}
+	if loaded, err := dep.GetInstrument(ctx, "us0378331005"); err != nil || loaded.Exchange != "XETRA" {
+		t.Errorf("Expected exchange XETRA after update, got %+v (%v)", loaded, err)
+	}
+	if _, err = dep.GetInstrument(ctx, "US5949181045"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	//Solange Transaktionen darauf verweisen, bleibt das Instrument erhalten
+	if err = dep.RemoveInstrument(ctx, apple.Isin); !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected ErrValidation when removing a used instrument, got %v", err)
+	}
+	for _, transaction := range transactions {
+		if err = dep.RemoveTransaction(ctx, transaction.Id); err != nil {
+			t.Fatalf("Failed to remove transaction: %v", err)
+		}
+	}
+	if err = dep.RemoveInstrument(ctx, apple.Isin); err != nil {
+		t.Errorf("Failed to remove instrument: %v", err)
 	}
 }
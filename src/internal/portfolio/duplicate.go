@@ -0,0 +1,87 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// Felder, aus denen der Fingerabdruck einer Transaktion gebildet werden kann.
+// "date" vergleicht nur den Tag, "dateTime" den genauen Zeitpunkt.
+var fingerprintFields = map[string]func(transaction storage.Transaction) string{
+	"date":            func(t storage.Transaction) string { return t.Date.Format(time.DateOnly) },
+	"dateTime":        func(t storage.Transaction) string { return t.Date.UTC().Format(time.RFC3339Nano) },
+	"transactionType": func(t storage.Transaction) string { return t.TransactionType },
+	"assetType":       func(t storage.Transaction) string { return t.AssetType },
+	"tickerSymbol":    func(t storage.Transaction) string { return t.TickerSymbol },
+	"quantity":        func(t storage.Transaction) string { return strconv.FormatFloat(t.Quantity, 'f', -1, 64) },
+	"price":           func(t storage.Transaction) string { return strconv.FormatFloat(t.Price, 'f', -1, 64) },
+	"fees":            func(t storage.Transaction) string { return strconv.FormatFloat(t.Fees, 'f', -1, 64) },
+	"currency":        func(t storage.Transaction) string { return t.Currency },
+	"broker":          func(t storage.Transaction) string { return t.Broker },
+}
+
+// DefaultDuplicateFingerprint wird verwendet, wenn in der Konfiguration kein Fingerabdruck angegeben ist.
+// Zwei Käufe am selben Tag sind damit nur Duplikate, wenn auch Anzahl und Preis übereinstimmen.
+var DefaultDuplicateFingerprint = []string{"date", "transactionType", "tickerSymbol", "quantity", "price"}
+
+// SetDuplicateFingerprint legt fest, welche Felder eine Transaktion ohne Auftragsnummer
+// eindeutig machen. Das Tickersymbol ist immer Teil des Fingerabdrucks.
+func (d *Depot) SetDuplicateFingerprint(fields []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(fields) == 0 {
+		return errors.New("duplicate fingerprint must contain at least one field")
+	}
+	for _, field := range fields {
+		if _, exists := fingerprintFields[field]; !exists {
+			return fmt.Errorf("duplicate fingerprint field %q not supported", field)
+		}
+	}
+	d.duplicateFingerprint = append([]string{}, fields...)
+	return nil
+}
+
+// findDuplicate sucht eine bereits gespeicherte Transaktion, die der neuen entspricht.
+// Hat die neue Transaktion eine Auftragsnummer, entscheidet nur die Kombination aus Broker,
+// Auftragsnummer und Ausführungs-Id. Sonst wird der Fingerabdruck verglichen.
+func (d *Depot) findDuplicate(ctx context.Context, newTransaction storage.Transaction) (*storage.Transaction, error) {
+	if newTransaction.OrderNumber != "" {
+		transaction, err := d.store.LoadTransactionByOrderNumber(ctx, newTransaction.Broker, newTransaction.OrderNumber, newTransaction.ExecutionId)
+		if err != nil {
+			return nil, storeError("load transaction by order number", err)
+		}
+		return transaction, nil
+	}
+
+	candidates, err := d.store.ReadTransactionsByTickerSymbol(ctx, newTransaction.TickerSymbol)
+	if err != nil {
+		return nil, storeError("read transactions by ticker symbol", err)
+	}
+
+	fingerprint := d.fingerprint(newTransaction)
+	for _, candidate := range candidates {
+		if d.fingerprint(candidate) == fingerprint {
+			return &candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *Depot) fingerprint(transaction storage.Transaction) string {
+	fields := d.duplicateFingerprint
+	if len(fields) == 0 {
+		fields = DefaultDuplicateFingerprint
+	}
+	values := make([]string, 0, len(fields)+1)
+	values = append(values, transaction.TickerSymbol)
+	for _, field := range fields {
+		values = append(values, fingerprintFields[field](transaction))
+	}
+	return strings.Join(values, "|")
+}
@@ -0,0 +1,93 @@
+package portfolio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// Fehlerarten des Depots. Die zurückgegebenen Fehler enthalten weitere Details und werden mit
+// errors.Is gegen diese Werte geprüft, z.B. um den HTTP-Statuscode festzulegen.
+var (
+	ErrDuplicateTransaction = errors.New("transaction already exists")
+	ErrDuplicateInstrument  = errors.New("instrument already exists")
+	ErrNoOpenLots           = errors.New("no open lots")
+	ErrUnsupportedType      = errors.New("transaction type not supported")
+	ErrValidation           = errors.New("validation failed")
+	ErrStoreUnavailable     = errors.New("store unavailable")
+)
+
+// DuplicateError wird zurückgegeben, wenn eine Transaktion schon gespeichert ist.
+// Existing ist die gespeicherte Transaktion.
+type DuplicateError struct {
+	Existing storage.Transaction
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("transaction already exists (%s)", e.Existing.Id)
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicateTransaction
+}
+
+// ValidationError beschreibt eine ungültige Eingabe. Field ist der Name des Feldes wie im JSON,
+// leer, wenn sich der Fehler nicht auf ein Feld bezieht.
+type ValidationError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func validationError(field string, message string) error {
+	return &ValidationError{Field: field, Message: message}
+}
+
+// StoreError wird zurückgegeben, wenn ein Zugriff auf den Store fehlschlägt. Der ursprüngliche
+// Fehler bleibt mit errors.Is erreichbar, z.B. storage.ErrNotFound oder context.Canceled.
+type StoreError struct {
+	Action string
+	Err    error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Action, e.Err)
+}
+
+func (e *StoreError) Unwrap() []error {
+	return []error{ErrStoreUnavailable, e.Err}
+}
+
+func storeError(action string, err error) error {
+	return &StoreError{Action: action, Err: err}
+}
+
+// ValidationErrors sammelt alle ValidationError aus err, auch aus mit errors.Join verbundenen Fehlern.
+func ValidationErrors(err error) []ValidationError {
+	var result []ValidationError
+	var collect func(err error)
+	collect = func(err error) {
+		if validation, ok := err.(*ValidationError); ok {
+			result = append(result, *validation)
+			return
+		}
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() error }:
+			collect(wrapped.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range wrapped.Unwrap() {
+				collect(inner)
+			}
+		}
+	}
+	collect(err)
+	return result
+}
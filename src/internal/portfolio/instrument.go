@@ -0,0 +1,306 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// AddInstrument legt die Stammdaten eines Wertpapiers an. ISIN, WKN, Land und Währung werden
+// in Großbuchstaben gespeichert.
+func (d *Depot) AddInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registry, err := d.instrumentStore()
+	if err != nil {
+		return instrument, err
+	}
+	instrument = normalizeInstrument(instrument)
+	err = validateInstrument(instrument)
+	if err != nil {
+		return instrument, err
+	}
+
+	existing, err := registry.LoadInstrument(ctx, instrument.Isin)
+	if err != nil {
+		return instrument, storeError("load instrument from store", err)
+	}
+	if existing != nil {
+		return instrument, fmt.Errorf("%w: %s", ErrDuplicateInstrument, instrument.Isin)
+	}
+	err = checkInstrumentTickers(ctx, registry, instrument)
+	if err != nil {
+		return instrument, err
+	}
+	err = registry.AddInstrument(ctx, &instrument)
+	if err != nil {
+		return instrument, storeError("add instrument to store", err)
+	}
+	return instrument, nil
+}
+
+// UpdateInstrument ändert die Stammdaten eines Wertpapiers. Die Tickersymbole werden komplett ersetzt.
+// Gespeicherte Transaktionen bleiben unverändert.
+func (d *Depot) UpdateInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registry, err := d.instrumentStore()
+	if err != nil {
+		return instrument, err
+	}
+	instrument = normalizeInstrument(instrument)
+	err = validateInstrument(instrument)
+	if err != nil {
+		return instrument, err
+	}
+
+	existing, err := registry.LoadInstrument(ctx, instrument.Isin)
+	if err != nil {
+		return instrument, storeError("load instrument from store", err)
+	}
+	if existing == nil {
+		return instrument, fmt.Errorf("instrument %s %w", instrument.Isin, storage.ErrNotFound)
+	}
+	err = checkInstrumentTickers(ctx, registry, instrument)
+	if err != nil {
+		return instrument, err
+	}
+	err = registry.UpdateInstrument(ctx, &instrument)
+	if err != nil {
+		return instrument, storeError("update instrument in store", err)
+	}
+	return instrument, nil
+}
+
+// RemoveInstrument löscht die Stammdaten eines Wertpapiers. Das wird abgelehnt, solange
+// Transaktionen darauf verweisen.
+func (d *Depot) RemoveInstrument(ctx context.Context, isin string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registry, err := d.instrumentStore()
+	if err != nil {
+		return err
+	}
+	isin = strings.ToUpper(strings.TrimSpace(isin))
+
+	for transaction, err := range d.store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+		if err != nil {
+			return storeError("read transactions from store", err)
+		}
+		if transaction.Isin == isin {
+			return validationError("isin", fmt.Sprintf("instrument %s is still used by transactions", isin))
+		}
+	}
+	err = registry.RemoveInstrument(ctx, isin)
+	if err != nil {
+		return storeError("remove instrument from store", err)
+	}
+	return nil
+}
+
+func (d *Depot) GetInstrument(ctx context.Context, isin string) (*storage.Instrument, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registry, err := d.instrumentStore()
+	if err != nil {
+		return nil, err
+	}
+	isin = strings.ToUpper(strings.TrimSpace(isin))
+	instrument, err := registry.LoadInstrument(ctx, isin)
+	if err != nil {
+		return nil, storeError("load instrument from store", err)
+	}
+	if instrument == nil {
+		return nil, fmt.Errorf("instrument %s %w", isin, storage.ErrNotFound)
+	}
+	return instrument, nil
+}
+
+func (d *Depot) SearchInstruments(ctx context.Context, query storage.InstrumentQuery) ([]storage.Instrument, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registry, err := d.instrumentStore()
+	if err != nil {
+		return nil, err
+	}
+	instruments, err := registry.SearchInstruments(ctx, query)
+	if err != nil {
+		return nil, storeError("search instruments in store", err)
+	}
+	return instruments, nil
+}
+
+func (d *Depot) instrumentStore() (storage.InstrumentStore, error) {
+	registry, ok := d.store.(storage.InstrumentStore)
+	if !ok {
+		return nil, errors.New("store has no instrument registry")
+	}
+	return registry, nil
+}
+
+// linkInstrument verknüpft eine Transaktion über die ISIN oder, ohne ISIN, über das Tickersymbol mit
+// ihrem Instrument und ergänzt fehlende Angaben aus den Stammdaten. Ohne Instrument wird nil geliefert.
+// Bei Stores ohne Stammdaten wird nur die ISIN geprüft.
+func (d *Depot) linkInstrument(ctx context.Context, transaction *storage.Transaction) (*storage.Instrument, error) {
+	transaction.Isin = strings.ToUpper(strings.TrimSpace(transaction.Isin))
+	if transaction.Isin != "" {
+		err := ValidateIsin(transaction.Isin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	registry, ok := d.store.(storage.InstrumentStore)
+	if !ok {
+		return nil, nil
+	}
+
+	var instrument *storage.Instrument
+	var err error
+	switch {
+	case transaction.Isin != "":
+		instrument, err = registry.LoadInstrument(ctx, transaction.Isin)
+		if err != nil {
+			return nil, storeError("load instrument from store", err)
+		}
+		if instrument == nil {
+			return nil, validationError("isin", fmt.Sprintf("instrument %s is not registered", transaction.Isin))
+		}
+	case transaction.TickerSymbol != "":
+		instrument, err = registry.LoadInstrumentByTicker(ctx, transaction.TickerSymbol)
+		if err != nil {
+			return nil, storeError("load instrument from store", err)
+		}
+		if instrument == nil {
+			return nil, nil
+		}
+		transaction.Isin = instrument.Isin
+	default:
+		return nil, nil
+	}
+
+	if transaction.Asset == "" {
+		transaction.Asset = instrument.Name
+	}
+	if transaction.TickerSymbol == "" && len(instrument.Tickers) > 0 {
+		transaction.TickerSymbol = instrument.Tickers[0]
+	}
+	if transaction.AssetType == "" {
+		transaction.AssetType = instrument.Type
+	}
+	if transaction.Currency == "" {
+		transaction.Currency = instrument.Currency
+	}
+	return instrument, nil
+}
+
+// checkInstrumentTickers stellt sicher, dass kein Tickersymbol schon zu einem anderen Instrument gehört.
+func checkInstrumentTickers(ctx context.Context, registry storage.InstrumentStore, instrument storage.Instrument) error {
+	for _, tickerSymbol := range instrument.Tickers {
+		other, err := registry.LoadInstrumentByTicker(ctx, tickerSymbol)
+		if err != nil {
+			return storeError("load instrument from store", err)
+		}
+		if other != nil && other.Isin != instrument.Isin {
+			return validationError("tickers", fmt.Sprintf("ticker symbol %s belongs to instrument %s", tickerSymbol, other.Isin))
+		}
+	}
+	return nil
+}
+
+func normalizeInstrument(instrument storage.Instrument) storage.Instrument {
+	instrument.Isin = strings.ToUpper(strings.TrimSpace(instrument.Isin))
+	instrument.Wkn = strings.ToUpper(strings.TrimSpace(instrument.Wkn))
+	instrument.Name = strings.TrimSpace(instrument.Name)
+	instrument.Type = strings.TrimSpace(instrument.Type)
+	instrument.Exchange = strings.TrimSpace(instrument.Exchange)
+	instrument.Currency = strings.ToUpper(strings.TrimSpace(instrument.Currency))
+	instrument.Sector = strings.TrimSpace(instrument.Sector)
+	instrument.Country = strings.ToUpper(strings.TrimSpace(instrument.Country))
+	tickers := make([]string, 0, len(instrument.Tickers))
+	for _, tickerSymbol := range instrument.Tickers {
+		tickers = append(tickers, strings.TrimSpace(tickerSymbol))
+	}
+	instrument.Tickers = tickers
+	return instrument
+}
+
+func validateInstrument(instrument storage.Instrument) error {
+	var errs []error
+	if err := ValidateIsin(instrument.Isin); err != nil {
+		errs = append(errs, err)
+	}
+	if instrument.Wkn != "" && (len(instrument.Wkn) != 6 || strings.IndexFunc(instrument.Wkn, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z')
+	}) >= 0) {
+		errs = append(errs, validationError("wkn", fmt.Sprintf("WKN %q must have 6 letters or digits", instrument.Wkn)))
+	}
+	if instrument.Name == "" {
+		errs = append(errs, validationError("name", "name is required"))
+	}
+	if instrument.Type == "" {
+		errs = append(errs, validationError("type", "type is required"))
+	}
+	if instrument.Currency != "" && !isoCurrencies[instrument.Currency] {
+		errs = append(errs, validationError("currency", fmt.Sprintf("currency %q is not supported, use an ISO 4217 code like EUR", instrument.Currency)))
+	}
+	if instrument.Country != "" && (len(instrument.Country) != 2 || strings.IndexFunc(instrument.Country, func(r rune) bool {
+		return r < 'A' || r > 'Z'
+	}) >= 0) {
+		errs = append(errs, validationError("country", fmt.Sprintf("country %q must be an ISO 3166 code like DE", instrument.Country)))
+	}
+	for i, tickerSymbol := range instrument.Tickers {
+		switch {
+		case tickerSymbol == "":
+			errs = append(errs, validationError("tickers", "ticker symbol must not be empty"))
+		case slices.Contains(instrument.Tickers[:i], tickerSymbol):
+			errs = append(errs, validationError("tickers", fmt.Sprintf("ticker symbol %s is listed more than once", tickerSymbol)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateIsin prüft Aufbau und Prüfziffer einer ISIN: zwei Buchstaben für das Land, neun Buchstaben
+// oder Ziffern und eine Prüfziffer. Für die Prüfziffer werden Buchstaben in Zahlen umgewandelt
+// (A = 10 bis Z = 35) und die Ziffernfolge nach dem Luhn-Verfahren geprüft.
+func ValidateIsin(isin string) error {
+	if len(isin) != 12 {
+		return validationError("isin", fmt.Sprintf("ISIN %q must have 12 characters", isin))
+	}
+	digits := make([]int, 0, 24)
+	for i, r := range isin {
+		switch {
+		case r >= 'A' && r <= 'Z' && i < 11:
+			value := int(r-'A') + 10
+			digits = append(digits, value/10, value%10)
+		case r >= '0' && r <= '9' && i >= 2:
+			digits = append(digits, int(r-'0'))
+		case i < 2:
+			return validationError("isin", fmt.Sprintf("ISIN %q must start with a country code", isin))
+		case i == 11:
+			return validationError("isin", fmt.Sprintf("ISIN %q must end with a check digit", isin))
+		default:
+			return validationError("isin", fmt.Sprintf("ISIN %q must only contain letters and digits", isin))
+		}
+	}
+
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		//Von rechts wird jede zweite Ziffer verdoppelt, die Prüfziffer selbst nicht
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return validationError("isin", fmt.Sprintf("ISIN %q has an invalid check digit", isin))
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
+)
+
+// DefaultSnapshotInterval ist die Anzahl der Ereignisse, nach der ein neuer Snapshot geschrieben wird.
+const DefaultSnapshotInterval = 100
+
+// journalState ist der Stand, der sich aus den Ereignissen im Journal ergibt. Er wird als Snapshot gespeichert.
+// Die Transaktionen selbst stehen nur im Store. Der Stand enthält die offenen Positionen und die Stelle
+// der Abrechnung, bis zu der sie gelten. Damit bleibt ein Snapshot klein, egal wie viele Transaktionen es gibt.
+type journalState struct {
+	Version              int                              `json:"version"`
+	CorporateActions     []storage.CorporateAction        `json:"corporateActions"`
+	UnclosedTransactions map[string][]storage.Transaction `json:"unclosedTransactions"`
+	SettledDate          time.Time                        `json:"settledDate"`     //Datum der letzten abgerechneten Transaktion oder Kapitalmaßnahme
+	SettledSequence      int                              `json:"settledSequence"` //Sequenznummer dazu, -1 bei einer Kapitalmaßnahme
+}
+
+// journalStateVersion ist die Version von journalState. Ältere Snapshots enthielten alle Transaktionen, aus ihnen
+// werden nur die Kapitalmaßnahmen übernommen und die offenen Positionen aus dem Store neu abgerechnet.
+const journalStateVersion = 2
+
+func (s *journalState) settled() position {
+	return position{s.SettledDate, s.SettledSequence}
+}
+
+// SetSnapshotInterval legt fest, nach wie vielen Ereignissen ein Snapshot geschrieben wird.
+func (d *Depot) SetSnapshotInterval(interval int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if interval < 1 {
+		return errors.New("snapshot interval must be at least 1")
+	}
+	d.snapshotInterval = interval
+	return nil
+}
+
+// ApplyCorporateAction speichert eine Kapitalmaßnahme (bisher nur Splits) im Journal und
+// berechnet danach alle "Realized Gains" und "unclosed transactions" neu.
+func (d *Depot) ApplyCorporateAction(ctx context.Context, action storage.CorporateAction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.store.(storage.Journal); !ok {
+		return errors.New("store has no journal for corporate actions")
+	}
+	if action.Type != "split" {
+		return validationError("type", fmt.Sprintf("corporate action %q not supported", action.Type))
+	}
+	if action.TickerSymbol == "" || action.Date.IsZero() {
+		return validationError("", "ticker symbol and date of corporate action are required")
+	}
+	if action.Ratio <= 0 {
+		return validationError("ratio", "ratio of split must be greater than zero")
+	}
+
+	return d.recompute(ctx, func() error {
+		return d.recordEvent(ctx, storage.JournalEvent{Type: storage.EventCorporateAction, CorporateAction: &action})
+	})
+}
+
+// noSnapshot ist die Sequenznummer des Snapshots, solange das Journal keinen hat.
+const noSnapshot int64 = -1
+
+// recordEvent hängt ein Ereignis an das Journal an, wenn der Store eines hat. Es läuft in derselben
+// Store-Transaktion wie die Änderung. Sind seit dem letzten Snapshot genug Ereignisse zusammengekommen,
+// wird auch der Snapshot in dieser Transaktion geschrieben. Gezählt wird über die Sequenznummer des
+// letzten Snapshots, das Journal wird also nur dann gelesen.
+func (d *Depot) recordEvent(ctx context.Context, event storage.JournalEvent) error {
+	journal, ok := d.store.(storage.Journal)
+	if !ok {
+		return nil
+	}
+	event.CreatedAt = time.Now()
+	err := journal.AppendEvent(ctx, &event)
+	if err != nil {
+		return fmt.Errorf("failed to append event to journal: %w", err)
+	}
+
+	//Nach Korrekturen, Löschungen und Kapitalmaßnahmen schreibt die Neuberechnung ohnehin einen Snapshot
+	if event.Type != storage.EventTransactionAdded {
+		return nil
+	}
+	if !d.snapshotLoaded {
+		snapshot, err := journal.LoadLatestSnapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load depot snapshot: %w", err)
+		}
+		d.snapshotSequence, d.snapshotLoaded = noSnapshot, true
+		if snapshot != nil {
+			d.snapshotSequence = snapshot.EventSequence
+		}
+	}
+	if event.Sequence-max(d.snapshotSequence, 0) < int64(d.snapshotInterval) {
+		return nil
+	}
+
+	//Der neue Snapshot baut auf dem letzten auf, abgerechnet werden nur die Ereignisse danach
+	state, sequence, _, err := d.rebuildFromJournal(ctx, journal)
+	if err != nil {
+		return err
+	}
+	return d.writeSnapshot(ctx, journal, state, sequence)
+}
+
+// writeSnapshot speichert den Stand nach dem Ereignis sequence. Ältere Snapshots löscht der Store.
+func (d *Depot) writeSnapshot(ctx context.Context, journal storage.Journal, state *journalState, sequence int64) error {
+	state.Version = journalStateVersion
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode depot snapshot: %w", err)
+	}
+	err = journal.AddSnapshot(ctx, &storage.DepotSnapshot{EventSequence: sequence, CreatedAt: time.Now(), State: content})
+	if err != nil {
+		return fmt.Errorf("failed to save depot snapshot: %w", err)
+	}
+	d.snapshotSequence, d.snapshotLoaded = sequence, true
+	return nil
+}
+
+// corporateActions liefert die Kapitalmaßnahmen aus dem Journal. Stores ohne Journal haben keine.
+func (d *Depot) corporateActions(ctx context.Context) ([]storage.CorporateAction, error) {
+	actions, _, err := d.journalActions(ctx)
+	return actions, err
+}
+
+// journalActions liefert die Kapitalmaßnahmen aus dem Journal und die Sequenznummer des letzten Ereignisses.
+// Dafür werden nur der letzte Snapshot und die Ereignisse danach gelesen, abgerechnet wird nichts.
+func (d *Depot) journalActions(ctx context.Context) ([]storage.CorporateAction, int64, error) {
+	journal, ok := d.store.(storage.Journal)
+	if !ok {
+		return nil, 0, nil
+	}
+	state, snapshotSequence, events, err := readJournal(ctx, journal)
+	if err != nil {
+		return nil, 0, err
+	}
+	sequence := max(snapshotSequence, 0)
+	for _, event := range events {
+		sequence = event.Sequence
+		if event.Type == storage.EventCorporateAction && event.CorporateAction != nil {
+			state.CorporateActions = append(state.CorporateActions, *event.CorporateAction)
+		}
+	}
+	return state.CorporateActions, sequence, nil
+}
+
+// saveSnapshot schreibt nach einer kompletten Neuberechnung den Stand als Snapshot zum letzten Ereignis.
+// So sind auch Transaktionen enthalten, die am Depot vorbei gespeichert wurden (z.B. fillDb).
+// Ist das Journal noch leer, gilt der Snapshot ab dem ersten Ereignis (Sequenznummer 0).
+func (d *Depot) saveSnapshot(ctx context.Context, state *journalState, sequence int64) error {
+	journal, ok := d.store.(storage.Journal)
+	if !ok {
+		return nil
+	}
+	return d.writeSnapshot(ctx, journal, state, sequence)
+}
+
+// loadFromJournal baut die unclosed transactions aus dem letzten Snapshot und den Ereignissen danach auf.
+// Gibt es noch keinen Snapshot, werden die gespeicherten Transaktionen einmalig abgerechnet und der Stand
+// als erster Snapshot gespeichert. Ereignisse werden daraus nicht erzeugt, im Journal stehen nur die
+// Änderungen über das Depot. Ohne Journal im Store wird nil geliefert.
+func (d *Depot) loadFromJournal(ctx context.Context) (map[string][]storage.Transaction, error) {
+	journal, ok := d.store.(storage.Journal)
+	if !ok {
+		return nil, nil
+	}
+	state, sequence, snapshotSequence, err := d.rebuildFromJournal(ctx, journal)
+	if err != nil {
+		return nil, err
+	}
+	d.snapshotSequence, d.snapshotLoaded = snapshotSequence, true
+	if snapshotSequence == noSnapshot {
+		err = d.writeSnapshot(ctx, journal, state, sequence)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state.UnclosedTransactions, nil
+}
+
+// replayStore rechnet die Transaktionen wie replay ab und liefert den Stand mit der Stelle der Abrechnung.
+func (d *Depot) replayStore(transactions iter.Seq2[storage.Transaction, error], actions []storage.CorporateAction,
+	addRealizedGain func(realizedGain storage.RealizedGain) error) (*journalState, error) {
+	if addRealizedGain == nil {
+		addRealizedGain = func(storage.RealizedGain) error { return nil }
+	}
+	last := position{sequence: -1}
+	tracked := func(yield func(storage.Transaction, error) bool) {
+		for transaction, err := range transactions {
+			if err == nil {
+				last = position{transaction.Date, transaction.Sequence}
+			}
+			if !yield(transaction, err) {
+				return
+			}
+		}
+	}
+	unclosedTransactions, err := replay(tracked, actions, addRealizedGain)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		if action.Date.After(last.date) {
+			last = position{action.Date, -1}
+		}
+	}
+	return &journalState{CorporateActions: actions, UnclosedTransactions: unclosedTransactions,
+		SettledDate: last.date, SettledSequence: last.sequence}, nil
+}
+
+// readJournal lädt den letzten Snapshot und die Ereignisse danach. Ohne Snapshot beginnt der Stand leer.
+// Zurück kommen der Stand des Snapshots, seine Sequenznummer (noSnapshot ohne Snapshot) und die Ereignisse.
+func readJournal(ctx context.Context, journal storage.Journal) (*journalState, int64, []storage.JournalEvent, error) {
+	state := &journalState{Version: journalStateVersion, SettledSequence: -1}
+	sequence := noSnapshot
+
+	snapshot, err := journal.LoadLatestSnapshot(ctx)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to load depot snapshot: %w", err)
+	}
+	if snapshot != nil {
+		state = &journalState{}
+		err = json.Unmarshal(snapshot.State, state)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to decode depot snapshot %d: %w", snapshot.EventSequence, err)
+		}
+		sequence = snapshot.EventSequence
+	}
+	if state.UnclosedTransactions == nil {
+		state.UnclosedTransactions = make(map[string][]storage.Transaction)
+	}
+
+	events, err := journal.ReadEvents(ctx, max(sequence, 0))
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read journal events: %w", err)
+	}
+	return state, sequence, events, nil
+}
+
+// rebuildFromJournal lädt den letzten Snapshot und wendet die Ereignisse danach an. Liegen alle neuen
+// Transaktionen und Kapitalmaßnahmen zeitlich hinter dem Snapshot, werden nur sie abgerechnet.
+// Sonst (Korrekturen, Löschungen, nachgetragene Transaktionen) und ohne Snapshot werden die Transaktionen
+// seitenweise aus dem Store neu abgerechnet. Zurück kommen der Stand, die Sequenznummer des letzten
+// Ereignisses und die des Snapshots.
+func (d *Depot) rebuildFromJournal(ctx context.Context, journal storage.Journal) (*journalState, int64, int64, error) {
+	state, snapshotSequence, events, err := readJournal(ctx, journal)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	sequence := max(snapshotSequence, 0)
+
+	//Die Abrechnung im Snapshot wird fortgesetzt, solange alles zeitlich dahinter liegt. Ohne Snapshot
+	//können im Store Transaktionen von vor dem Journal stehen (z.B. aus fillDb).
+	incremental := snapshotSequence != noSnapshot && state.Version == journalStateVersion
+	scratch := GetDepot(nil)
+	scratch.unclosedTransactions = state.UnclosedTransactions
+	last := state.settled()
+
+	for _, event := range events {
+		sequence = event.Sequence
+		switch event.Type {
+		case storage.EventTransactionAdded:
+			if event.Transaction == nil {
+				return nil, 0, 0, fmt.Errorf("journal event %d has no transaction", event.Sequence)
+			}
+			transaction := *event.Transaction
+			if incremental && !last.after(transaction.Date, transaction.Sequence) {
+				_, _, err = scratch.processNewTransaction(transaction)
+				if err != nil {
+					return nil, 0, 0, fmt.Errorf("journal event %d: %w", event.Sequence, err)
+				}
+				last = position{transaction.Date, transaction.Sequence}
+			} else {
+				incremental = false
+			}
+		case storage.EventTransactionCorrected:
+			if event.Transaction == nil {
+				return nil, 0, 0, fmt.Errorf("journal event %d has no transaction", event.Sequence)
+			}
+			incremental = false
+		case storage.EventTransactionDeleted:
+			incremental = false
+		case storage.EventCorporateAction:
+			if event.CorporateAction == nil {
+				return nil, 0, 0, fmt.Errorf("journal event %d has no corporate action", event.Sequence)
+			}
+			action := *event.CorporateAction
+			state.CorporateActions = append(state.CorporateActions, action)
+			//Transaktionen am Tag der Kapitalmaßnahme werden erst danach abgerechnet
+			if incremental && action.Date.After(last.date) {
+				applyCorporateAction(scratch.unclosedTransactions, action)
+				last = position{action.Date, -1}
+			} else {
+				incremental = false
+			}
+		default:
+			return nil, 0, 0, fmt.Errorf("journal event %d has unknown type %q", event.Sequence, event.Type)
+		}
+	}
+
+	if !incremental {
+		replayed, err := d.replayStore(d.store.IterateTransactions(ctx, storage.TransactionQuery{}), state.CorporateActions, nil)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to replay journal: %w", err)
+		}
+		return replayed, sequence, snapshotSequence, nil
+	}
+	state.UnclosedTransactions = scratch.unclosedTransactions
+	state.SettledDate, state.SettledSequence = last.date, last.sequence
+	return state, sequence, snapshotSequence, nil
+}
+
+// position ist die Stelle in der chronologischen Abrechnung (Datum und Sequenznummer).
+type position struct {
+	date     time.Time
+	sequence int
+}
+
+// after prüft, ob die Position hinter einer Transaktion mit diesem Datum und dieser Sequenznummer liegt.
+func (p position) after(date time.Time, sequence int) bool {
+	if !p.date.Equal(date) {
+		return p.date.After(date)
+	}
+	return p.sequence > sequence
+}
+
+// sortCorporateActions sortiert eine Kopie der Kapitalmaßnahmen nach Datum.
+func sortCorporateActions(actions []storage.CorporateAction) []storage.CorporateAction {
+	sorted := slices.Clone(actions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	return sorted
+}
+
+// applyCorporateAction passt die offenen Positionen an einen Split an. Aus einem Stück werden
+// Ratio Stücke zum entsprechend geteilten Kaufpreis.
+func applyCorporateAction(unclosedTransactions map[string][]storage.Transaction, action storage.CorporateAction) {
+	lots := unclosedTransactions[action.TickerSymbol]
+	for i := range lots {
+		lots[i].Quantity *= action.Ratio
+		lots[i].Price /= action.Ratio
+	}
+}
+
+// transactionEvent erzeugt das Ereignis für eine hinzugefügte oder korrigierte Transaktion.
+func transactionEvent(eventType string, transaction storage.Transaction) storage.JournalEvent {
+	return storage.JournalEvent{Type: eventType, Transaction: &transaction, TransactionId: transaction.Id}
+}
+
+// deletedEvent erzeugt das Ereignis für eine gelöschte Transaktion.
+func deletedEvent(id uuid.UUID) storage.JournalEvent {
+	return storage.JournalEvent{Type: storage.EventTransactionDeleted, TransactionId: id}
+}
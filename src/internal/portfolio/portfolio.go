@@ -1,11 +1,53 @@
 package portfolio
 
-import "github.com/fritzrepo/stockportfolio/internal/storage"
+import (
+	"context"
+	"iter"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
+)
 
 type Portfolio interface {
 	GetEntries() map[string]DepotEntry
-	AddTransaction(transaction storage.Transaction) error
-	GetAllTransactions() ([]storage.Transaction, error)
-	GetPerformance() (Performance, error)
-	GetAllRealizedGains() ([]storage.RealizedGain, error)
+	GetEntriesAsOf(ctx context.Context, date time.Time) (map[string]DepotEntry, error)
+	AddTransaction(ctx context.Context, transaction storage.Transaction) error
+	UpdateTransaction(ctx context.Context, transaction storage.Transaction) error
+	RemoveTransaction(ctx context.Context, id uuid.UUID) error
+	GetAllTransactions(ctx context.Context) ([]storage.Transaction, error)
+	QueryTransactions(ctx context.Context, query storage.TransactionQuery) (storage.TransactionPage, error)
+	IterateTransactions(ctx context.Context, query storage.TransactionQuery) iter.Seq2[storage.Transaction, error]
+	GetPerformance(ctx context.Context) (Performance, error)
+	GetAllRealizedGains(ctx context.Context) ([]storage.RealizedGain, error)
+	QueryRealizedGains(ctx context.Context, query storage.RealizedGainQuery) (storage.RealizedGainPage, error)
+	GetAllocation(groupBy string, categories map[string]string, prices map[string]float64, rates ExchangeRates) (Allocation, error)
+	Rebalance(request RebalanceRequest) (RebalancePlan, error)
+}
+
+type SavingsPlanManager interface {
+	AddSavingsPlan(ctx context.Context, plan storage.SavingsPlan) (storage.SavingsPlan, error)
+	GetAllSavingsPlans(ctx context.Context) ([]storage.SavingsPlan, error)
+	RemoveSavingsPlan(ctx context.Context, id uuid.UUID) error
+	GetAllPendingTransactions(ctx context.Context) ([]storage.PendingTransaction, error)
+	CreateDueTransactions(ctx context.Context, now time.Time) ([]storage.PendingTransaction, error)
+	ConfirmPendingTransaction(ctx context.Context, id uuid.UUID, execution SavingsPlanExecution) (storage.Transaction, error)
+}
+
+type InstrumentRegistry interface {
+	AddInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error)
+	UpdateInstrument(ctx context.Context, instrument storage.Instrument) (storage.Instrument, error)
+	RemoveInstrument(ctx context.Context, isin string) error
+	GetInstrument(ctx context.Context, isin string) (*storage.Instrument, error)
+	SearchInstruments(ctx context.Context, query storage.InstrumentQuery) ([]storage.Instrument, error)
+}
+
+type AuditTrail interface {
+	GetAuditLog(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error)
+}
+
+type Administration interface {
+	Backup(ctx context.Context, metadata storage.ArchiveMetadata) (*storage.Archive, error)
+	Restore(ctx context.Context, archive *storage.Archive) error
+	Verify(ctx context.Context, repair bool) (VerifyReport, error)
 }
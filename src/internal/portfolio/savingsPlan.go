@@ -0,0 +1,250 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Unterstützte Intervalle eines Sparplans und deren Abstand in Monaten
+var savingsPlanIntervals = map[string]int{
+	"monthly":      1,
+	"quarterly":    3,
+	"semiannually": 6,
+	"yearly":       12,
+}
+
+// SavingsPlanExecution enthält die tatsächlichen Ausführungsdaten einer Sparplan-Transaktion.
+type SavingsPlanExecution struct {
+	Price    float64    `json:"price" binding:"required"`
+	Quantity float64    `json:"quantity"` //Ist die Anzahl 0, wird sie aus Sparrate und Preis berechnet
+	Fees     float64    `json:"fees"`
+	Date     *time.Time `json:"date"` //Ohne Datum wird das Fälligkeitsdatum verwendet
+}
+
+func (d *Depot) AddSavingsPlan(ctx context.Context, plan storage.SavingsPlan) (storage.SavingsPlan, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := validateSavingsPlan(plan)
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Id = uuid.New()
+	plan.LastDueDate = nil
+	err = d.store.AddSavingsPlan(ctx, &plan)
+	if err != nil {
+		return plan, storeError("add savings plan to store", err)
+	}
+	return plan, nil
+}
+
+func (d *Depot) GetAllSavingsPlans(ctx context.Context) ([]storage.SavingsPlan, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	plans, err := d.store.ReadAllSavingsPlans(ctx)
+	if err != nil {
+		return nil, storeError("read savings plans from store", err)
+	}
+	return plans, nil
+}
+
+// RemoveSavingsPlan löscht den Sparplan und seine noch nicht bestätigten Transaktionen.
+// Bereits bestätigte Transaktionen bleiben erhalten.
+func (d *Depot) RemoveSavingsPlan(ctx context.Context, id uuid.UUID) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.store.RemoveSavingsPlan(ctx, id)
+	if err != nil {
+		return storeError("remove savings plan from store", err)
+	}
+	return nil
+}
+
+func (d *Depot) GetAllPendingTransactions(ctx context.Context) ([]storage.PendingTransaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pendingTransactions, err := d.store.ReadAllPendingTransactions(ctx)
+	if err != nil {
+		return nil, storeError("read pending transactions from store", err)
+	}
+	return pendingTransactions, nil
+}
+
+// CreateDueTransactions erzeugt für alle Sparpläne die offenen Kauf-Transaktionen,
+// die bis zum angegebenen Zeitpunkt fällig sind. Für jede Fälligkeit wird nur einmal
+// eine Transaktion erzeugt, auch wenn die Funktion mehrfach aufgerufen wird. Die Transaktionen
+// eines Sparplans und seine letzte Fälligkeit werden in einer Store-Transaktion gespeichert.
+func (d *Depot) CreateDueTransactions(ctx context.Context, now time.Time) ([]storage.PendingTransaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	plans, err := d.store.ReadAllSavingsPlans(ctx)
+	if err != nil {
+		return nil, storeError("read savings plans from store", err)
+	}
+
+	created := []storage.PendingTransaction{}
+	for _, plan := range plans {
+		dueDates, err := dueDatesOfSavingsPlan(plan, now)
+		if err != nil {
+			return created, err
+		}
+		if len(dueDates) == 0 {
+			continue
+		}
+
+		var createdOfPlan []storage.PendingTransaction
+		err = d.inStoreTransaction(ctx, func() error {
+			for _, dueDate := range dueDates {
+				pending := storage.PendingTransaction{
+					Id:            uuid.New(),
+					SavingsPlanId: plan.Id,
+					DueDate:       dueDate,
+					AssetType:     plan.AssetType,
+					Asset:         plan.Asset,
+					TickerSymbol:  plan.TickerSymbol,
+					Amount:        plan.Amount,
+					Currency:      plan.Currency,
+				}
+				err := d.store.AddPendingTransaction(ctx, &pending)
+				if err != nil {
+					return storeError("add pending transaction to store", err)
+				}
+				createdOfPlan = append(createdOfPlan, pending)
+			}
+
+			plan.LastDueDate = &dueDates[len(dueDates)-1]
+			err := d.store.UpdateSavingsPlan(ctx, &plan)
+			if err != nil {
+				return storeError("update savings plan in store", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return created, err
+		}
+		created = append(created, createdOfPlan...)
+	}
+	return created, nil
+}
+
+// ConfirmPendingTransaction übernimmt die offene Sparplan-Transaktion mit dem tatsächlichen
+// Ausführungspreis und den Gebühren als Kauf in das Depot.
+func (d *Depot) ConfirmPendingTransaction(ctx context.Context, id uuid.UUID, execution SavingsPlanExecution) (storage.Transaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	transaction := storage.Transaction{}
+
+	if execution.Price <= 0 {
+		return transaction, validationError("price", "execution price must be greater than zero")
+	}
+	if execution.Quantity < 0 || execution.Fees < 0 {
+		return transaction, validationError("fees", "quantity and fees must not be negative")
+	}
+
+	pending, err := d.store.LoadPendingTransaction(ctx, id)
+	if err != nil {
+		return transaction, storeError("load pending transaction from store", err)
+	}
+	if pending == nil {
+		return transaction, fmt.Errorf("pending transaction %s %w", id, storage.ErrNotFound)
+	}
+
+	transaction = storage.Transaction{
+		Date:            pending.DueDate,
+		TransactionType: "buy",
+		AssetType:       pending.AssetType,
+		Asset:           pending.Asset,
+		TickerSymbol:    pending.TickerSymbol,
+		Quantity:        execution.Quantity,
+		Price:           execution.Price,
+		Fees:            execution.Fees,
+		Currency:        pending.Currency,
+	}
+	if execution.Date != nil {
+		transaction.Date = *execution.Date
+	}
+	if transaction.Quantity == 0 {
+		//Sparpläne kaufen Bruchstücke. Die Sparrate enthält die Gebühren.
+		transaction.Quantity = (pending.Amount - execution.Fees) / execution.Price
+		if transaction.Quantity <= 0 {
+			return transaction, validationError("fees", "fees exceed the amount of the savings plan")
+		}
+	}
+
+	//Kauf und Entfernen der offenen Transaktion gehören zusammen
+	err = d.addTransaction(ctx, transaction, func() error {
+		err := d.store.RemovePendingTransaction(ctx, id)
+		if err != nil {
+			return storeError("remove pending transaction from store", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return transaction, err
+	}
+	return transaction, nil
+}
+
+func validateSavingsPlan(plan storage.SavingsPlan) error {
+	if _, exists := savingsPlanIntervals[plan.Interval]; !exists {
+		return validationError("interval", fmt.Sprintf("savings plan interval %q not supported", plan.Interval))
+	}
+	if plan.ExecutionDay < 1 || plan.ExecutionDay > 31 {
+		return validationError("executionDay", "execution day must be between 1 and 31")
+	}
+	if plan.Amount <= 0 {
+		return validationError("amount", "amount of savings plan must be greater than zero")
+	}
+	if plan.StartDate.IsZero() {
+		return validationError("startDate", "start date of savings plan is missing")
+	}
+	if plan.EndDate != nil && plan.EndDate.Before(plan.StartDate) {
+		return validationError("endDate", "end date of savings plan is before start date")
+	}
+	return nil
+}
+
+// dueDatesOfSavingsPlan berechnet alle Fälligkeiten nach der letzten erzeugten Fälligkeit
+// bis einschließlich now. Fällt der Ausführungstag nicht in den Monat (z.B. 31.),
+// wird der letzte Tag des Monats verwendet.
+func dueDatesOfSavingsPlan(plan storage.SavingsPlan, now time.Time) ([]time.Time, error) {
+	months, exists := savingsPlanIntervals[plan.Interval]
+	if !exists {
+		return nil, fmt.Errorf("savings plan interval %q not supported", plan.Interval)
+	}
+
+	start := plan.StartDate
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+
+	var dueDates []time.Time
+	for i := 0; ; i++ {
+		dueDate := executionDateInMonth(start.Year(), start.Month()+time.Month(i*months), plan.ExecutionDay, start.Location())
+		if dueDate.Before(startDay) {
+			continue
+		}
+		if dueDate.After(now) {
+			break
+		}
+		if plan.EndDate != nil && dueDate.After(*plan.EndDate) {
+			break
+		}
+		if plan.LastDueDate != nil && !dueDate.After(*plan.LastDueDate) {
+			continue
+		}
+		dueDates = append(dueDates, dueDate)
+	}
+	return dueDates, nil
+}
+
+func executionDateInMonth(year int, month time.Month, day int, loc *time.Location) time.Time {
+	//Der Tag 0 des Folgemonats ist der letzte Tag des Monats. time.Date normalisiert den Monat.
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
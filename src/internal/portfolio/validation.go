@@ -0,0 +1,395 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+)
+
+// Namen der eingebauten Regeln, mit diesen Namen werden sie in der Konfiguration abgeschaltet.
+const (
+	RuleRequiredFields  = "requiredFields"
+	RuleCurrency        = "currency"
+	RulePositiveAmounts = "positiveAmounts"
+	RuleDateWindow      = "dateWindow"
+	RuleTickerName      = "tickerName"
+	RuleSellCoverage    = "sellCoverage"
+	RuleInstrument      = "instrument"
+)
+
+// ValidationConfig legt fest, welche Regeln gelten. Ohne Angaben sind alle eingebauten Regeln aktiv.
+type ValidationConfig struct {
+	Disabled        []string `json:"disabled"`        //Namen der abgeschalteten Regeln
+	Currencies      []string `json:"currencies"`      //Erlaubte Währungen, ohne Angabe alle Codes nach ISO 4217
+	EarliestDate    string   `json:"earliestDate"`    //Frühestes Datum einer Transaktion (2006-01-02), ohne Angabe unbegrenzt
+	MaxDaysInFuture int      `json:"maxDaysInFuture"` //Tage, die eine Transaktion in der Zukunft liegen darf, 0 = bis heute
+}
+
+// Ledger ist der bisherige Stand, gegen den eine neue Transaktion geprüft wird.
+type Ledger struct {
+	Assets      map[string]string             //TickerSymbol -> Name des Assets aus früheren Transaktionen
+	Holdings    map[string]float64            //TickerSymbol -> Anzahl im Bestand
+	Instruments map[string]storage.Instrument //ISIN -> Stammdaten, gegen die die Transaktion geprüft wird
+}
+
+// NewLedger baut den Stand aus bereits gespeicherten Transaktionen auf.
+func NewLedger(transactions []storage.Transaction) *Ledger {
+	ledger := newEmptyLedger()
+	for _, transaction := range sortTransactions(transactions) {
+		ledger.Add(transaction)
+	}
+	return ledger
+}
+
+func newEmptyLedger() *Ledger {
+	return &Ledger{Assets: make(map[string]string), Holdings: make(map[string]float64), Instruments: make(map[string]storage.Instrument)}
+}
+
+// Add übernimmt eine geprüfte Transaktion in den Stand.
+func (l *Ledger) Add(transaction storage.Transaction) {
+	if _, exists := l.Assets[transaction.TickerSymbol]; !exists {
+		l.Assets[transaction.TickerSymbol] = transaction.Asset
+	}
+	switch transaction.TransactionType {
+	case "buy":
+		l.Holdings[transaction.TickerSymbol] += transaction.Quantity
+	case "sell":
+		l.Holdings[transaction.TickerSymbol] -= transaction.Quantity
+	}
+}
+
+// ValidationRule prüft eine Transaktion. Verstöße werden als *ValidationError zurückgegeben,
+// mehrere mit errors.Join.
+type ValidationRule interface {
+	Name() string
+	Check(transaction storage.Transaction, ledger *Ledger) error
+}
+
+// Validator prüft Transaktionen mit den aktiven Regeln. API, CLI und Importe verwenden ihn gleichermaßen.
+type Validator struct {
+	rules []ValidationRule
+}
+
+// ruleFunc macht aus einer Funktion eine Regel
+type ruleFunc struct {
+	name  string
+	check func(transaction storage.Transaction, ledger *Ledger) error
+}
+
+func (r ruleFunc) Name() string {
+	return r.name
+}
+
+func (r ruleFunc) Check(transaction storage.Transaction, ledger *Ledger) error {
+	return r.check(transaction, ledger)
+}
+
+// NewValidator erzeugt einen Validator mit den eingebauten Regeln aus config.
+func NewValidator(config ValidationConfig) (*Validator, error) {
+	currencies := isoCurrencies
+	if len(config.Currencies) > 0 {
+		currencies = make(map[string]bool, len(config.Currencies))
+		for _, currency := range config.Currencies {
+			if !isoCurrencies[currency] {
+				return nil, fmt.Errorf("currency %q is not an ISO 4217 code", currency)
+			}
+			currencies[currency] = true
+		}
+	}
+	var earliest time.Time
+	if config.EarliestDate != "" {
+		var err error
+		earliest, err = time.Parse(time.DateOnly, config.EarliestDate)
+		if err != nil {
+			return nil, fmt.Errorf("earliest date %q has an unknown format, use 2006-01-02", config.EarliestDate)
+		}
+	}
+	if config.MaxDaysInFuture < 0 {
+		return nil, errors.New("max days in future must not be negative")
+	}
+
+	builtIn := []ValidationRule{
+		ruleFunc{RuleRequiredFields, checkRequiredFields},
+		ruleFunc{RuleCurrency, func(transaction storage.Transaction, ledger *Ledger) error {
+			return checkCurrency(transaction, currencies)
+		}},
+		ruleFunc{RulePositiveAmounts, checkPositiveAmounts},
+		ruleFunc{RuleDateWindow, func(transaction storage.Transaction, ledger *Ledger) error {
+			return checkDateWindow(transaction, earliest, config.MaxDaysInFuture, time.Now())
+		}},
+		ruleFunc{RuleTickerName, checkTickerName},
+		ruleFunc{RuleSellCoverage, checkSellCoverage},
+		ruleFunc{RuleInstrument, checkInstrument},
+	}
+
+	validator := &Validator{}
+	for _, name := range config.Disabled {
+		if !slices.ContainsFunc(builtIn, func(rule ValidationRule) bool { return rule.Name() == name }) {
+			return nil, fmt.Errorf("validation rule %q not supported", name)
+		}
+	}
+	for _, rule := range builtIn {
+		if !slices.Contains(config.Disabled, rule.Name()) {
+			validator.rules = append(validator.rules, rule)
+		}
+	}
+	return validator, nil
+}
+
+// AddRule ergänzt eine eigene Regel.
+func (v *Validator) AddRule(rule ValidationRule) {
+	v.rules = append(v.rules, rule)
+}
+
+// Rules liefert die Namen der aktiven Regeln.
+func (v *Validator) Rules() []string {
+	names := make([]string, 0, len(v.rules))
+	for _, rule := range v.rules {
+		names = append(names, rule.Name())
+	}
+	return names
+}
+
+// Validate prüft eine Transaktion mit allen aktiven Regeln und liefert alle Verstöße zusammen.
+func (v *Validator) Validate(transaction storage.Transaction, ledger *Ledger) error {
+	var errs []error
+	for _, rule := range v.rules {
+		if err := rule.Check(transaction, ledger); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateAll prüft die Transaktionen eines Imports in zeitlicher Reihenfolge. Jede gültige Transaktion
+// wird in ledger übernommen, damit z.B. ein Verkauf durch einen Kauf im selben Import gedeckt ist.
+func (v *Validator) ValidateAll(transactions []storage.Transaction, ledger *Ledger) error {
+	var errs []error
+	for _, transaction := range sortTransactions(transactions) {
+		err := v.Validate(transaction, ledger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s %s: %w", transaction.Date.Format(time.DateOnly),
+				transaction.TransactionType, transaction.TickerSymbol, err))
+			continue
+		}
+		ledger.Add(transaction)
+	}
+	return errors.Join(errs...)
+}
+
+func checkRequiredFields(transaction storage.Transaction, ledger *Ledger) error {
+	var errs []error
+	required := []struct {
+		field string
+		value string
+	}{
+		{"transactionType", transaction.TransactionType},
+		{"assetType", transaction.AssetType},
+		{"asset", transaction.Asset},
+		{"tickerSymbol", transaction.TickerSymbol},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			errs = append(errs, validationError(r.field, r.field+" is required"))
+		}
+	}
+	if transaction.Date.IsZero() {
+		errs = append(errs, validationError("date", "date is required"))
+	}
+	return errors.Join(errs...)
+}
+
+func checkCurrency(transaction storage.Transaction, currencies map[string]bool) error {
+	if !currencies[transaction.Currency] {
+		return validationError("currency", fmt.Sprintf("currency %q is not supported, use an ISO 4217 code like EUR", transaction.Currency))
+	}
+	return nil
+}
+
+func checkPositiveAmounts(transaction storage.Transaction, ledger *Ledger) error {
+	var errs []error
+	if transaction.Quantity <= 0 {
+		errs = append(errs, validationError("quantity", "quantity must be greater than zero"))
+	}
+	if transaction.Price <= 0 {
+		errs = append(errs, validationError("price", "price must be greater than zero"))
+	}
+	if transaction.Fees < 0 {
+		errs = append(errs, validationError("fees", "fees must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// checkDateWindow lässt Transaktionen bis zum Ende des Tages zu, der maxDaysInFuture nach now liegt.
+func checkDateWindow(transaction storage.Transaction, earliest time.Time, maxDaysInFuture int, now time.Time) error {
+	if transaction.Date.IsZero() {
+		return nil
+	}
+	if !earliest.IsZero() && transaction.Date.Before(earliest) {
+		return validationError("date", fmt.Sprintf("date must not be before %s", earliest.Format(time.DateOnly)))
+	}
+	year, month, day := now.Date()
+	latest := time.Date(year, month, day+1+maxDaysInFuture, 0, 0, 0, 0, now.Location())
+	if !transaction.Date.Before(latest) {
+		return validationError("date", fmt.Sprintf("date must not be after %s", latest.AddDate(0, 0, -1).Format(time.DateOnly)))
+	}
+	return nil
+}
+
+func checkTickerName(transaction storage.Transaction, ledger *Ledger) error {
+	//Ein fehlender Name wird von requiredFields gemeldet
+	asset, exists := ledger.Assets[transaction.TickerSymbol]
+	if exists && transaction.Asset != "" && !strings.EqualFold(strings.TrimSpace(asset), strings.TrimSpace(transaction.Asset)) {
+		return validationError("asset", fmt.Sprintf("ticker symbol %s belongs to asset %q, not %q", transaction.TickerSymbol, asset, transaction.Asset))
+	}
+	return nil
+}
+
+func checkSellCoverage(transaction storage.Transaction, ledger *Ledger) error {
+	if transaction.TransactionType != "sell" {
+		return nil
+	}
+	held := ledger.Holdings[transaction.TickerSymbol]
+	if transaction.Quantity-held > quantityEpsilon {
+		return validationError("quantity", fmt.Sprintf("cannot sell %v %s, only %v held", transaction.Quantity, transaction.TickerSymbol, math.Max(held, 0)))
+	}
+	return nil
+}
+
+// checkInstrument vergleicht die Transaktion mit den Stammdaten ihres Instruments.
+func checkInstrument(transaction storage.Transaction, ledger *Ledger) error {
+	instrument, exists := ledger.Instruments[transaction.Isin]
+	if transaction.Isin == "" || !exists {
+		return nil
+	}
+	var errs []error
+	if len(instrument.Tickers) > 0 && !slices.Contains(instrument.Tickers, transaction.TickerSymbol) {
+		errs = append(errs, validationError("tickerSymbol", fmt.Sprintf("ticker symbol %s is not listed for instrument %s", transaction.TickerSymbol, instrument.Isin)))
+	}
+	if !strings.EqualFold(strings.TrimSpace(transaction.Asset), instrument.Name) {
+		errs = append(errs, validationError("asset", fmt.Sprintf("instrument %s is named %q, not %q", instrument.Isin, instrument.Name, transaction.Asset)))
+	}
+	if transaction.AssetType != instrument.Type {
+		errs = append(errs, validationError("assetType", fmt.Sprintf("instrument %s is of type %s, not %s", instrument.Isin, instrument.Type, transaction.AssetType)))
+	}
+	if instrument.Currency != "" && transaction.Currency != instrument.Currency {
+		errs = append(errs, validationError("currency", fmt.Sprintf("instrument %s is traded in %s, not %s", instrument.Isin, instrument.Currency, transaction.Currency)))
+	}
+	return errors.Join(errs...)
+}
+
+// isoCurrencies enthält die aktiven Währungscodes nach ISO 4217
+var isoCurrencies = func() map[string]bool {
+	codes := strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD
+		CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD
+		GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT
+		LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR
+		NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP
+		STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XOF
+		XPF YER ZAR ZMW ZWL`)
+	result := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		result[code] = true
+	}
+	return result
+}()
+
+// defaultValidator enthält alle eingebauten Regeln mit den Standardwerten
+func defaultValidator() *Validator {
+	validator, _ := NewValidator(ValidationConfig{})
+	return validator
+}
+
+// SetValidation legt die Regeln fest, mit denen neue und geänderte Transaktionen geprüft werden.
+func (d *Depot) SetValidation(config ValidationConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	validator, err := NewValidator(config)
+	if err != nil {
+		return err
+	}
+	d.validator = validator
+	return nil
+}
+
+// Validator liefert den Validator des Depots, z.B. um einen Import vorab zu prüfen.
+func (d *Depot) Validator() *Validator {
+	return d.validator
+}
+
+// validateNewTransaction verknüpft eine neue Transaktion mit ihrem Instrument und prüft sie gegen die
+// gespeicherten Transaktionen des Assets. Der Bestand gilt zum Datum und zur Sequenznummer der Transaktion.
+func (d *Depot) validateNewTransaction(ctx context.Context, transaction *storage.Transaction) error {
+	instrument, err := d.linkInstrument(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	ledger := newEmptyLedger()
+	if instrument != nil {
+		ledger.Instruments[instrument.Isin] = *instrument
+	}
+	if transaction.TickerSymbol != "" {
+		earlier, err := d.store.ReadTransactionsByTickerSymbol(ctx, transaction.TickerSymbol)
+		if err != nil {
+			return storeError("read transactions by ticker symbol", err)
+		}
+		earlier = sortTransactions(earlier)
+		backdated := false
+		if len(earlier) > 0 {
+			ledger.Assets[transaction.TickerSymbol] = earlier[0].Asset
+			last := earlier[len(earlier)-1]
+			backdated = position{last.Date, last.Sequence}.after(transaction.Date, transaction.Sequence)
+		}
+		//Liegt die Transaktion hinter allen gespeicherten, ist der Bestand die Summe der offenen Positionen
+		if backdated {
+			holdings, err := d.holdingsAt(ctx, *transaction, earlier)
+			if err != nil {
+				return err
+			}
+			ledger.Holdings[transaction.TickerSymbol] = holdings
+		} else {
+			for _, lot := range d.unclosedTransactions[transaction.TickerSymbol] {
+				ledger.Holdings[transaction.TickerSymbol] += lot.Quantity
+			}
+		}
+	}
+	return d.validator.Validate(*transaction, ledger)
+}
+
+// holdingsAt rechnet die sortierten Transaktionen des Assets bis einschließlich Datum und Sequenznummer
+// einer nachgetragenen Transaktion ab, mit den Kapitalmaßnahmen bis zu diesem Tag, und liefert den Bestand.
+func (d *Depot) holdingsAt(ctx context.Context, transaction storage.Transaction, sorted []storage.Transaction) (float64, error) {
+	actions, err := d.corporateActions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	actions = slices.DeleteFunc(actions, func(action storage.CorporateAction) bool {
+		return action.TickerSymbol != transaction.TickerSymbol || action.Date.After(transaction.Date)
+	})
+	upTo := func(yield func(storage.Transaction, error) bool) {
+		for _, earlier := range sorted {
+			if (position{earlier.Date, earlier.Sequence}).after(transaction.Date, transaction.Sequence) {
+				return
+			}
+			if !yield(earlier, nil) {
+				return
+			}
+		}
+	}
+	lots, err := replay(upTo, actions, func(storage.RealizedGain) error { return nil })
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute holdings as of %s: %w", transaction.Date.Format(time.DateOnly), err)
+	}
+	holdings := 0.0
+	for _, lot := range lots[transaction.TickerSymbol] {
+		holdings += lot.Quantity
+	}
+	return holdings, nil
+}
@@ -0,0 +1,239 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
+)
+
+// VerifyReport ist das Ergebnis der Konsistenzprüfung. Tickers enthält nur die Assets mit Abweichungen.
+type VerifyReport struct {
+	CheckedAt     time.Time           `json:"checkedAt"`
+	Transactions  int                 `json:"transactions"`  //Anzahl der neu abgerechneten Transaktionen
+	UnclosedLots  int                 `json:"unclosedLots"`  //Anzahl der gespeicherten offenen Positionen
+	RealizedGains int                 `json:"realizedGains"` //Anzahl der gespeicherten Realized Gains
+	Tickers       []TickerDiscrepancy `json:"tickers"`
+	Repaired      bool                `json:"repaired"`
+}
+
+// TickerDiscrepancy enthält die Abweichungen eines Assets. Missing fehlt im Store, Unexpected ist im Store,
+// ergibt sich aber nicht aus den Transaktionen. Offene Positionen werden über die Id der Kauftransaktion
+// zugeordnet, Realized Gains über Verkauf und Kauf.
+type TickerDiscrepancy struct {
+	TickerSymbol     string                 `json:"tickerSymbol"`
+	MissingLots      []storage.Transaction  `json:"missingLots,omitempty"`
+	UnexpectedLots   []storage.Transaction  `json:"unexpectedLots,omitempty"`
+	ChangedLots      []LotDifference        `json:"changedLots,omitempty"`
+	MissingGains     []storage.RealizedGain `json:"missingGains,omitempty"`
+	UnexpectedGains  []storage.RealizedGain `json:"unexpectedGains,omitempty"`
+	ChangedGains     []GainDifference       `json:"changedGains,omitempty"`
+	discrepancyCount int
+}
+
+type LotDifference struct {
+	Expected storage.Transaction `json:"expected"`
+	Stored   storage.Transaction `json:"stored"`
+}
+
+type GainDifference struct {
+	Expected storage.RealizedGain `json:"expected"`
+	Stored   storage.RealizedGain `json:"stored"`
+}
+
+// Consistent ist true, wenn der Store zu den Transaktionen passt.
+func (r VerifyReport) Consistent() bool {
+	return len(r.Tickers) == 0
+}
+
+// Discrepancies ist die Anzahl aller Abweichungen.
+func (r VerifyReport) Discrepancies() int {
+	count := 0
+	for _, ticker := range r.Tickers {
+		count += ticker.discrepancyCount
+	}
+	return count
+}
+
+// gainKey ordnet einen Realized Gain zu. Die Id wird bei jeder Abrechnung neu vergeben.
+type gainKey struct {
+	sell uuid.UUID
+	buy  uuid.UUID
+}
+
+// Verify rechnet alle Transaktionen nur im Speicher neu ab und vergleicht das Ergebnis mit den gespeicherten
+// offenen Positionen und Realized Gains. Mit repair werden Abweichungen durch eine Neuberechnung behoben,
+// die wie ComputeAllTransactions in einer Store-Transaktion läuft. Der Bericht beschreibt dann den Stand davor.
+func (d *Depot) Verify(ctx context.Context, repair bool) (VerifyReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report, err := d.verify(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	if repair && !report.Consistent() {
+		err = d.recompute(ctx, func() error {
+			return d.recordAudit(ctx, "Repair", "depot", map[string]any{"discrepancies": report.Discrepancies(), "tickers": len(report.Tickers)})
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to repair store: %w", err)
+		}
+		report.Repaired = true
+	}
+	return report, nil
+}
+
+// verify vergleicht den Store mit einer Neuberechnung, ohne etwas zu ändern. Es läuft auch innerhalb
+// einer Store-Transaktion, z.B. beim Zurückspielen eines Backups.
+func (d *Depot) verify(ctx context.Context) (VerifyReport, error) {
+	report := VerifyReport{CheckedAt: time.Now(), Tickers: []TickerDiscrepancy{}}
+
+	actions, err := d.corporateActions(ctx)
+	if err != nil {
+		return report, err
+	}
+	//Die Verkäufe werden gemerkt, um die Realized Gains einem Asset zuzuordnen
+	tickerOfSell := make(map[uuid.UUID]string)
+	transactions := func(yield func(storage.Transaction, error) bool) {
+		for transaction, err := range d.store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+			if err == nil {
+				report.Transactions++
+				if transaction.TransactionType == "sell" {
+					tickerOfSell[transaction.Id] = transaction.TickerSymbol
+				}
+			}
+			if !yield(transaction, err) {
+				return
+			}
+		}
+	}
+	expectedGains := make(map[gainKey]storage.RealizedGain)
+	expectedLots, err := replay(transactions, actions, func(realizedGain storage.RealizedGain) error {
+		expectedGains[gainKey{realizedGain.SellTransactionId, realizedGain.BuyTransactionId}] = realizedGain
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to replay transactions: %w", err)
+	}
+
+	storedLots, err := d.store.ReadAllUnclosedTransactions(ctx)
+	if err != nil {
+		return report, storeError("read unclosed transactions from store", err)
+	}
+	storedGains, err := d.store.ReadAllRealizedGains(ctx)
+	if err != nil {
+		return report, storeError("read realized gains from store", err)
+	}
+	report.RealizedGains = len(storedGains)
+
+	discrepancies := make(map[string]*TickerDiscrepancy)
+	tickerReport := func(tickerSymbol string) *TickerDiscrepancy {
+		entry, exists := discrepancies[tickerSymbol]
+		if !exists {
+			entry = &TickerDiscrepancy{TickerSymbol: tickerSymbol}
+			discrepancies[tickerSymbol] = entry
+		}
+		entry.discrepancyCount++
+		return entry
+	}
+
+	//Offene Positionen über alle Assets vergleichen, eine Position kann auch unter dem falschen Asset gespeichert sein
+	expectedById := make(map[uuid.UUID]storage.Transaction)
+	for _, lots := range expectedLots {
+		for _, lot := range lots {
+			expectedById[lot.Id] = lot
+		}
+	}
+	storedById := make(map[uuid.UUID]storage.Transaction)
+	for _, lots := range storedLots {
+		for _, lot := range lots {
+			report.UnclosedLots++
+			storedById[lot.Id] = lot
+			expected, exists := expectedById[lot.Id]
+			switch {
+			case !exists:
+				entry := tickerReport(lot.TickerSymbol)
+				entry.UnexpectedLots = append(entry.UnexpectedLots, lot)
+			case !sameTransaction(expected, lot):
+				entry := tickerReport(expected.TickerSymbol)
+				entry.ChangedLots = append(entry.ChangedLots, LotDifference{Expected: expected, Stored: lot})
+			}
+		}
+	}
+	for id, lot := range expectedById {
+		if _, exists := storedById[id]; !exists {
+			entry := tickerReport(lot.TickerSymbol)
+			entry.MissingLots = append(entry.MissingLots, lot)
+		}
+	}
+
+	storedByKey := make(map[gainKey]storage.RealizedGain)
+	for _, gain := range storedGains {
+		key := gainKey{gain.SellTransactionId, gain.BuyTransactionId}
+		expected, exists := expectedGains[key]
+		_, duplicate := storedByKey[key]
+		storedByKey[key] = gain
+		switch {
+		case !exists || duplicate:
+			entry := tickerReport(tickerOfSell[gain.SellTransactionId])
+			entry.UnexpectedGains = append(entry.UnexpectedGains, gain)
+		case !sameRealizedGain(expected, gain):
+			entry := tickerReport(tickerOfSell[gain.SellTransactionId])
+			entry.ChangedGains = append(entry.ChangedGains, GainDifference{Expected: expected, Stored: gain})
+		}
+	}
+	for key, gain := range expectedGains {
+		if _, exists := storedByKey[key]; !exists {
+			entry := tickerReport(tickerOfSell[gain.SellTransactionId])
+			entry.MissingGains = append(entry.MissingGains, gain)
+		}
+	}
+
+	for _, entry := range discrepancies {
+		entry.sortEntries()
+		report.Tickers = append(report.Tickers, *entry)
+	}
+	sort.Slice(report.Tickers, func(i, j int) bool {
+		return report.Tickers[i].TickerSymbol < report.Tickers[j].TickerSymbol
+	})
+	return report, nil
+}
+
+// sameRealizedGain vergleicht zwei Realized Gains ohne die Id.
+func sameRealizedGain(a storage.RealizedGain, b storage.RealizedGain) bool {
+	a.Id = uuid.Nil
+	b.Id = uuid.Nil
+	return a == b
+}
+
+// sortEntries sortiert die Abweichungen nach Datum bzw. Verkauf, damit der Bericht bei gleichem Stand gleich aussieht.
+func (t *TickerDiscrepancy) sortEntries() {
+	for _, lots := range [][]storage.Transaction{t.MissingLots, t.UnexpectedLots} {
+		sort.SliceStable(lots, func(i, j int) bool { return lots[i].Date.Before(lots[j].Date) })
+	}
+	sort.SliceStable(t.ChangedLots, func(i, j int) bool { return t.ChangedLots[i].Expected.Date.Before(t.ChangedLots[j].Expected.Date) })
+	for _, gains := range [][]storage.RealizedGain{t.MissingGains, t.UnexpectedGains} {
+		sort.SliceStable(gains, func(i, j int) bool {
+			return gains[i].SellTransactionId.String()+gains[i].BuyTransactionId.String() <
+				gains[j].SellTransactionId.String()+gains[j].BuyTransactionId.String()
+		})
+	}
+	sort.SliceStable(t.ChangedGains, func(i, j int) bool {
+		return t.ChangedGains[i].Expected.SellTransactionId.String() < t.ChangedGains[j].Expected.SellTransactionId.String()
+	})
+}
+
+// sameTransaction vergleicht zwei Transaktionen. Das Datum wird unabhängig von der Zeitzone verglichen,
+// da es nach dem Lesen aus dem Store eine andere Location haben kann.
+func sameTransaction(a storage.Transaction, b storage.Transaction) bool {
+	if !a.Date.Equal(b.Date) {
+		return false
+	}
+	a.Date = time.Time{}
+	b.Date = time.Time{}
+	return a == b
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditLog wird von Stores implementiert, die jede Änderung protokollieren. Jeder ändernde Aufruf
+// schreibt in derselben Store-Transaktion einen Eintrag mit dem Stand vorher und nachher.
+// Wird die Store-Transaktion zurückgerollt, verschwindet auch der Eintrag.
+type AuditLog interface {
+	//SetActor legt fest, wer die folgenden Änderungen macht, z.B. "cli:fritz" oder "server"
+	SetActor(actor string)
+	//AddAuditEntry protokolliert Vorgänge oberhalb des Stores, z.B. eine Neuberechnung oder einen Import
+	AddAuditEntry(ctx context.Context, entry *AuditEntry) error
+	ReadAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// AuditEntry ist ein Eintrag im Audit-Log. Before und After sind der Stand als JSON,
+// bei neuen Einträgen fehlt Before, bei gelöschten After.
+type AuditEntry struct {
+	Id        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Operation string          `json:"operation"` //Name des Store-Aufrufs, z.B. UpdateTransaction
+	Entity    string          `json:"entity"`    //transaction, unclosedTransaction, realizedGain, savingsPlan, pendingTransaction, instrument
+	EntityId  string          `json:"entityId"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditFilter schränkt die Einträge ein. Leere Felder werden nicht gefiltert.
+// Die neuesten Einträge kommen zuerst.
+type AuditFilter struct {
+	Actor     string
+	Operation string
+	Entity    string
+	EntityId  string
+	From      time.Time //Einschließlich
+	To        time.Time //Ausschließlich
+	Limit     int
+}
+
+// AuditSummary steht beim Löschen aller Einträge einer Tabelle statt der Einträge selbst im Audit-Log.
+type AuditSummary struct {
+	Count int `json:"count"`
+}
+
+func (s *DatabaseStorage) summarize(ctx context.Context, db dbExecutor, table string) (AuditSummary, error) {
+	count, err := s.countRows(ctx, db, table)
+	return AuditSummary{Count: count}, err
+}
+
+// auditKey markiert einen Context, in dem Änderungen nicht einzeln protokolliert werden
+type auditKey struct{}
+
+// WithoutAudit liefert einen Context, in dem die SQL-Stores Änderungen nicht einzeln ins Audit-Log
+// schreiben. Gedacht für abgeleitete Daten wie die unclosed transactions und realized gains einer
+// Neuberechnung, die der Aufrufer stattdessen mit einem Eintrag über AddAuditEntry protokolliert.
+func WithoutAudit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditKey{}, true)
+}
+
+func withoutAudit(ctx context.Context) bool {
+	suppressed, _ := ctx.Value(auditKey{}).(bool)
+	return suppressed
+}
+
+// auditValue wandelt einen Stand in JSON um. nil (auch ein nil-Zeiger) wird als NULL gespeichert.
+func auditValue(value any) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	if string(content) == "null" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: string(content), Valid: true}, nil
+}
+
+func (s *DatabaseStorage) insertAuditEntry(ctx context.Context, db dbExecutor, entry *AuditEntry) error {
+	before := sql.NullString{String: string(entry.Before), Valid: len(entry.Before) > 0}
+	after := sql.NullString{String: string(entry.After), Valid: len(entry.After) > 0}
+	err := db.QueryRowContext(ctx, "INSERT INTO audit_log (actor, createdAt, operation, entity, entityId, valueBefore, valueAfter) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id;",
+		entry.Actor, entry.CreatedAt, entry.Operation, entry.Entity, entry.EntityId, before, after).Scan(&entry.Id)
+	if err != nil {
+		return fmt.Errorf("error at insert audit entry. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) loadAuditEntries(ctx context.Context, db dbExecutor, filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []any
+	for column, value := range map[string]string{"actor": filter.Actor, "operation": filter.Operation,
+		"entity": filter.Entity, "entityId": filter.EntityId} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "createdAt >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "createdAt < ?")
+		args = append(args, filter.To)
+	}
+
+	sqlStmt := "SELECT id, actor, createdAt, operation, entity, entityId, valueBefore, valueAfter FROM audit_log"
+	if len(conditions) > 0 {
+		sqlStmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlStmt += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		sqlStmt += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error at read audit log. %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after sql.NullString
+		err = rows.Scan(&entry.Id, &entry.Actor, &entry.CreatedAt, &entry.Operation, &entry.Entity, &entry.EntityId, &before, &after)
+		if err != nil {
+			return nil, fmt.Errorf("error at scan audit entry. %w", err)
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveVersion ist die Version des Formats von Backups. Ältere Versionen können weiter eingelesen werden,
+// neuere werden abgelehnt. Ab Version 2 haben die Transaktionen ihre Position in der Abrechnung.
+const ArchiveVersion = 2
+
+// Formate eines Backups
+const (
+	ArchiveFormatJSON = "json" //Ein JSON-Dokument
+	ArchiveFormatZip  = "zip"  //Ein JSON-Dokument je Teil, komprimiert
+)
+
+// Archive ist ein vollständiges Backup eines Stores. Es kann in einen leeren Store mit beliebigem
+// Treiber zurückgespielt werden. Instrumente, Journal und Audit-Log sind nur enthalten, wenn der Store sie hat.
+type Archive struct {
+	Version              int                   `json:"version"`
+	CreatedAt            time.Time             `json:"createdAt"`
+	Metadata             ArchiveMetadata       `json:"metadata"`
+	Transactions         []ArchivedTransaction `json:"transactions"`
+	UnclosedTransactions []Transaction         `json:"unclosedTransactions"` //Je Asset in der Reihenfolge der Abrechnung (FIFO)
+	RealizedGains        []RealizedGain        `json:"realizedGains"`
+	SavingsPlans         []SavingsPlan         `json:"savingsPlans"`
+	PendingTransactions  []PendingTransaction  `json:"pendingTransactions"`
+	Instruments          []Instrument          `json:"instruments,omitempty"`
+	JournalEvents        []JournalEvent        `json:"journalEvents,omitempty"`
+	AuditLog             []AuditEntry          `json:"auditLog,omitempty"` //Älteste Einträge zuerst
+}
+
+// ArchivedTransaction ist eine Transaktion im Backup mit ihrer Position in der Abrechnung (ab 1). Bei gleichem
+// Datum und gleicher Sequenznummer ist das die Einfügereihenfolge, die sonst beim Zurückspielen verloren ginge.
+// Backups der Version 1 haben keine Position, dort gilt die Reihenfolge der Liste.
+type ArchivedTransaction struct {
+	Transaction
+	Position int `json:"position,omitempty"`
+}
+
+// ArchiveMetadata beschreibt, woher ein Backup stammt. Settings enthält die Einstellungen der
+// Konfiguration, die für die Daten wichtig sind (z.B. die Duplikaterkennung), aber keine Zugangsdaten.
+type ArchiveMetadata struct {
+	Driver        string          `json:"driver"`
+	SchemaVersion int             `json:"schemaVersion,omitempty"` //Nur bei Stores mit Migrationen
+	Settings      json.RawMessage `json:"settings,omitempty"`
+}
+
+// ExportArchive liest den kompletten Store in einer Store-Transaktion. So ist das Backup auch dann
+// in sich stimmig, wenn gleichzeitig geschrieben wird. Die Store-Transaktion wird danach zurückgerollt.
+func ExportArchive(ctx context.Context, store Store, metadata ArchiveMetadata) (*Archive, error) {
+	archive := &Archive{Version: ArchiveVersion, CreatedAt: time.Now(), Metadata: metadata}
+
+	err := store.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error at begin export. %w", err)
+	}
+	err = archive.read(ctx, store)
+	rollbackErr := store.Rollback()
+	if err != nil {
+		return nil, err
+	}
+	if rollbackErr != nil {
+		return nil, fmt.Errorf("error at finish export. %w", rollbackErr)
+	}
+	return archive, nil
+}
+
+func (a *Archive) read(ctx context.Context, store Store) error {
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("error at export transactions. %w", err)
+	}
+	//ReadAllTransactions liefert die Transaktionen in der Reihenfolge der Abrechnung
+	a.Transactions = make([]ArchivedTransaction, 0, len(transactions))
+	for i, transaction := range transactions {
+		a.Transactions = append(a.Transactions, ArchivedTransaction{Transaction: transaction, Position: i + 1})
+	}
+	unclosed, err := store.ReadAllUnclosedTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("error at export unclosed transactions. %w", err)
+	}
+	//Sortiert nach Asset, damit das Backup bei gleichem Inhalt gleich aussieht
+	tickerSymbols := make([]string, 0, len(unclosed))
+	for tickerSymbol := range unclosed {
+		tickerSymbols = append(tickerSymbols, tickerSymbol)
+	}
+	slices.Sort(tickerSymbols)
+	a.UnclosedTransactions = []Transaction{}
+	for _, tickerSymbol := range tickerSymbols {
+		a.UnclosedTransactions = append(a.UnclosedTransactions, unclosed[tickerSymbol]...)
+	}
+	a.RealizedGains, err = store.ReadAllRealizedGains(ctx)
+	if err != nil {
+		return fmt.Errorf("error at export realized gains. %w", err)
+	}
+	a.SavingsPlans, err = store.ReadAllSavingsPlans(ctx)
+	if err != nil {
+		return fmt.Errorf("error at export savings plans. %w", err)
+	}
+	a.PendingTransactions, err = store.ReadAllPendingTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("error at export pending transactions. %w", err)
+	}
+
+	if migrator, ok := store.(Migrator); ok {
+		states, err := migrator.MigrationStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("error at export schema version. %w", err)
+		}
+		for _, state := range states {
+			if state.Applied {
+				a.Metadata.SchemaVersion = state.Version
+			}
+		}
+	}
+	if instruments, ok := store.(InstrumentStore); ok {
+		a.Instruments, err = instruments.SearchInstruments(ctx, InstrumentQuery{})
+		if err != nil {
+			return fmt.Errorf("error at export instruments. %w", err)
+		}
+	}
+	if journal, ok := store.(Journal); ok {
+		a.JournalEvents, err = journal.ReadEvents(ctx, 0)
+		if err != nil {
+			return fmt.Errorf("error at export journal. %w", err)
+		}
+	}
+	if auditLog, ok := store.(AuditLog); ok {
+		a.AuditLog, err = auditLog.ReadAuditLog(ctx, AuditFilter{})
+		if err != nil {
+			return fmt.Errorf("error at export audit log. %w", err)
+		}
+		slices.Reverse(a.AuditLog)
+	}
+	return nil
+}
+
+// sections sind die Teile des Archivs als Dokumente im Zip-Format.
+func (a *Archive) sections() []struct {
+	name  string
+	value any
+} {
+	return []struct {
+		name  string
+		value any
+	}{
+		{"transactions.json", &a.Transactions},
+		{"lots.json", &a.UnclosedTransactions},
+		{"gains.json", &a.RealizedGains},
+		{"savingsplans.json", &a.SavingsPlans},
+		{"pending.json", &a.PendingTransactions},
+		{"instruments.json", &a.Instruments},
+		{"journal.json", &a.JournalEvents},
+		{"audit.json", &a.AuditLog},
+	}
+}
+
+// archiveManifest ist das erste Dokument im Zip-Format.
+type archiveManifest struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Metadata  ArchiveMetadata `json:"metadata"`
+}
+
+const archiveManifestName = "manifest.json"
+
+// WriteArchive schreibt das Backup als JSON-Dokument oder als Zip-Datei.
+func WriteArchive(w io.Writer, archive *Archive, format string) error {
+	switch format {
+	case ArchiveFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(archive)
+	case ArchiveFormatZip:
+		zipWriter := zip.NewWriter(w)
+		parts := append([]struct {
+			name  string
+			value any
+		}{{archiveManifestName, archiveManifest{archive.Version, archive.CreatedAt, archive.Metadata}}}, archive.sections()...)
+		for _, part := range parts {
+			file, err := zipWriter.Create(part.name)
+			if err != nil {
+				return fmt.Errorf("error at write %s. %w", part.name, err)
+			}
+			err = json.NewEncoder(file).Encode(part.value)
+			if err != nil {
+				return fmt.Errorf("error at write %s. %w", part.name, err)
+			}
+		}
+		return zipWriter.Close()
+	default:
+		return fmt.Errorf("archive format %q not supported", format)
+	}
+}
+
+// ReadArchive liest ein Backup. Das Format (JSON oder Zip) wird am Inhalt erkannt.
+func ReadArchive(content []byte) (*Archive, error) {
+	archive := &Archive{}
+	if !bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+		err := json.Unmarshal(content, archive)
+		if err != nil {
+			return nil, fmt.Errorf("error at read archive. %w", err)
+		}
+		return archive, archive.checkVersion()
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error at read archive. %w", err)
+	}
+	var manifest archiveManifest
+	parts := map[string]any{archiveManifestName: &manifest}
+	for _, section := range archive.sections() {
+		parts[section.name] = section.value
+	}
+	found := false
+	for _, file := range zipReader.File {
+		target, known := parts[file.Name]
+		if !known {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error at read %s. %w", file.Name, err)
+		}
+		err = json.NewDecoder(reader).Decode(target)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error at read %s. %w", file.Name, err)
+		}
+		found = found || file.Name == archiveManifestName
+	}
+	if !found {
+		return nil, fmt.Errorf("archive has no %s", archiveManifestName)
+	}
+	archive.Version = manifest.Version
+	archive.CreatedAt = manifest.CreatedAt
+	archive.Metadata = manifest.Metadata
+	return archive, archive.checkVersion()
+}
+
+func (a *Archive) checkVersion() error {
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		return fmt.Errorf("archive version %d not supported, latest version is %d", a.Version, ArchiveVersion)
+	}
+	return nil
+}
+
+// Check prüft, ob das Backup in sich stimmig ist: eindeutige Ids, offene Positionen und Abrechnungen
+// gehören zu vorhandenen Transaktionen, offene Sparplan-Transaktionen zu vorhandenen Sparplänen und
+// Transaktionen mit ISIN zu vorhandenen Instrumenten.
+// Alle gefundenen Fehler werden zusammen zurückgegeben.
+func (a *Archive) Check() error {
+	var problems []error
+	err := a.checkVersion()
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	transactions := make(map[uuid.UUID]Transaction, len(a.Transactions))
+	positions := make(map[int]bool, len(a.Transactions))
+	for _, transaction := range a.Transactions {
+		if _, exists := transactions[transaction.Id]; exists {
+			problems = append(problems, fmt.Errorf("transaction %s exists more than once", transaction.Id))
+		}
+		transactions[transaction.Id] = transaction.Transaction
+		if transaction.Position != 0 && positions[transaction.Position] {
+			problems = append(problems, fmt.Errorf("position %d of transaction %s exists more than once", transaction.Position, transaction.Id))
+		}
+		positions[transaction.Position] = true
+	}
+
+	lots := make(map[uuid.UUID]bool, len(a.UnclosedTransactions))
+	for _, lot := range a.UnclosedTransactions {
+		if lots[lot.Id] {
+			problems = append(problems, fmt.Errorf("unclosed transaction %s exists more than once", lot.Id))
+		}
+		lots[lot.Id] = true
+		buy, exists := transactions[lot.Id]
+		switch {
+		case !exists:
+			problems = append(problems, fmt.Errorf("unclosed transaction %s has no transaction", lot.Id))
+		case buy.TransactionType != "buy" || buy.TickerSymbol != lot.TickerSymbol:
+			problems = append(problems, fmt.Errorf("unclosed transaction %s does not match buy transaction", lot.Id))
+		}
+	}
+
+	gains := make(map[uuid.UUID]bool, len(a.RealizedGains))
+	for _, gain := range a.RealizedGains {
+		if gains[gain.Id] {
+			problems = append(problems, fmt.Errorf("realized gain %s exists more than once", gain.Id))
+		}
+		gains[gain.Id] = true
+		if _, exists := transactions[gain.SellTransactionId]; !exists {
+			problems = append(problems, fmt.Errorf("realized gain %s has no sell transaction %s", gain.Id, gain.SellTransactionId))
+		}
+		if _, exists := transactions[gain.BuyTransactionId]; !exists {
+			problems = append(problems, fmt.Errorf("realized gain %s has no buy transaction %s", gain.Id, gain.BuyTransactionId))
+		}
+	}
+
+	//Verweise auf Instrumente nur prüfen, wenn das Backup Instrumente enthält
+	instruments := make(map[string]bool, len(a.Instruments))
+	for _, instrument := range a.Instruments {
+		if instruments[instrument.Isin] {
+			problems = append(problems, fmt.Errorf("instrument %s exists more than once", instrument.Isin))
+		}
+		instruments[instrument.Isin] = true
+	}
+	if len(instruments) > 0 {
+		for _, transaction := range a.Transactions {
+			if transaction.Isin != "" && !instruments[transaction.Isin] {
+				problems = append(problems, fmt.Errorf("transaction %s has no instrument %s", transaction.Id, transaction.Isin))
+			}
+		}
+	}
+
+	plans := make(map[uuid.UUID]bool, len(a.SavingsPlans))
+	for _, plan := range a.SavingsPlans {
+		if plans[plan.Id] {
+			problems = append(problems, fmt.Errorf("savings plan %s exists more than once", plan.Id))
+		}
+		plans[plan.Id] = true
+	}
+	for _, pending := range a.PendingTransactions {
+		if !plans[pending.SavingsPlanId] {
+			problems = append(problems, fmt.Errorf("pending transaction %s has no savings plan %s", pending.Id, pending.SavingsPlanId))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// RestoreArchive spielt ein Backup in einen leeren Store zurück. Vorher wird das Backup mit Check geprüft.
+// Alles wird in einer Store-Transaktion gespeichert. Instrumente, Journal und Audit-Log werden nur übernommen,
+// wenn der Store sie hat. Die Snapshots des Journals werden danach neu erzeugt. verify wird nach dem Schreiben
+// in derselben Store-Transaktion aufgerufen, z.B. um offene Positionen und Abrechnungen gegen eine
+// Neuberechnung zu prüfen. Gibt verify einen Fehler zurück, wird nichts übernommen.
+func RestoreArchive(ctx context.Context, store Store, archive *Archive, verify func() error) error {
+	err := archive.Check()
+	if err != nil {
+		return fmt.Errorf("archive is inconsistent. %w", err)
+	}
+	empty, err := isEmpty(ctx, store)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.New("store is not empty, restore only into an empty store")
+	}
+
+	return withTransaction(ctx, store, func() error {
+		//Zuerst das alte Audit-Log, damit die Einträge der Wiederherstellung danach kommen
+		if auditLog, ok := store.(AuditLog); ok {
+			for _, entry := range archive.AuditLog {
+				err := auditLog.AddAuditEntry(ctx, &entry)
+				if err != nil {
+					return fmt.Errorf("error at restore audit log. %w", err)
+				}
+			}
+		}
+		if instruments, ok := store.(InstrumentStore); ok {
+			for _, instrument := range archive.Instruments {
+				err := instruments.AddInstrument(ctx, &instrument)
+				if err != nil {
+					return fmt.Errorf("error at restore instrument %s. %w", instrument.Isin, err)
+				}
+			}
+		}
+		//In der Reihenfolge der Abrechnung, damit die Stores die Einfügereihenfolge wieder so vergeben
+		ordered := slices.Clone(archive.Transactions)
+		slices.SortStableFunc(ordered, func(a, b ArchivedTransaction) int {
+			return a.Position - b.Position
+		})
+		for _, archived := range ordered {
+			transaction := archived.Transaction
+			err := store.AddTransaction(ctx, &transaction)
+			if err != nil {
+				return fmt.Errorf("error at restore transaction %s. %w", transaction.Id, err)
+			}
+		}
+		for _, lot := range archive.UnclosedTransactions {
+			err := store.AddUnclosedTransaction(ctx, lot)
+			if err != nil {
+				return fmt.Errorf("error at restore unclosed transaction %s. %w", lot.Id, err)
+			}
+		}
+		for _, gain := range archive.RealizedGains {
+			err := store.AddRealizedGain(ctx, gain)
+			if err != nil {
+				return fmt.Errorf("error at restore realized gain %s. %w", gain.Id, err)
+			}
+		}
+		for _, plan := range archive.SavingsPlans {
+			err := store.AddSavingsPlan(ctx, &plan)
+			if err != nil {
+				return fmt.Errorf("error at restore savings plan %s. %w", plan.Id, err)
+			}
+		}
+		for _, pending := range archive.PendingTransactions {
+			err := store.AddPendingTransaction(ctx, &pending)
+			if err != nil {
+				return fmt.Errorf("error at restore pending transaction %s. %w", pending.Id, err)
+			}
+		}
+		if journal, ok := store.(Journal); ok {
+			for _, event := range archive.JournalEvents {
+				err := journal.AppendEvent(ctx, &event)
+				if err != nil {
+					return fmt.Errorf("error at restore journal event %d. %w", event.Sequence, err)
+				}
+			}
+		}
+		if verify != nil {
+			return verify()
+		}
+		return nil
+	})
+}
+
+// isEmpty prüft, ob der Store weder Transaktionen noch Sparpläne oder Instrumente enthält.
+// Gelesen wird jeweils höchstens ein Eintrag.
+func isEmpty(ctx context.Context, store Store) (bool, error) {
+	transactions, err := store.QueryTransactions(ctx, TransactionQuery{Limit: 1})
+	if err != nil || len(transactions.Transactions) > 0 {
+		return false, err
+	}
+	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols(ctx)
+	if err != nil || len(tickerSymbols) > 0 {
+		return false, err
+	}
+	gains, err := store.QueryRealizedGains(ctx, RealizedGainQuery{Limit: 1})
+	if err != nil || len(gains.RealizedGains) > 0 {
+		return false, err
+	}
+	plans, err := store.ReadAllSavingsPlans(ctx)
+	if err != nil || len(plans) > 0 {
+		return false, err
+	}
+	if instruments, ok := store.(InstrumentStore); ok {
+		found, err := instruments.SearchInstruments(ctx, InstrumentQuery{Limit: 1})
+		if err != nil || len(found) > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func fillBackupTestStore(t *testing.T, store Store) (Transaction, Transaction) {
+	ctx := context.Background()
+	buy := Transaction{Id: uuid.New(), Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100, Fees: 1, Currency: "EUR"}
+	sell := buy
+	sell.Id = uuid.New()
+	sell.Date = time.Date(2025, 2, 3, 0, 0, 0, 0, time.UTC)
+	sell.TransactionType = "sell"
+	sell.Quantity = 4
+	sell.Price = 120
+	lot := buy
+	lot.Quantity = 6
+	plan := SavingsPlan{Id: uuid.New(), AssetType: "etf", Asset: "World", TickerSymbol: "IWDA", Amount: 100, Currency: "EUR",
+		Interval: "monthly", ExecutionDay: 1, StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pending := PendingTransaction{Id: uuid.New(), SavingsPlanId: plan.Id, DueDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		AssetType: "etf", Asset: "World", TickerSymbol: "IWDA", Amount: 100, Currency: "EUR"}
+
+	if instruments, ok := store.(InstrumentStore); ok {
+		apple := Instrument{Isin: "US0378331005", Tickers: []string{"AAPL"}, Name: "Apple", Type: "stock", Currency: "EUR"}
+		if err := instruments.AddInstrument(ctx, &apple); err != nil {
+			t.Fatalf("Failed to insert instrument: %v", err)
+		}
+		buy.Isin = apple.Isin
+	}
+	for _, transaction := range []Transaction{buy, sell} {
+		if err := store.AddTransaction(ctx, &transaction); err != nil {
+			t.Fatalf("Failed to insert transaction: %v", err)
+		}
+	}
+	if err := store.AddUnclosedTransaction(ctx, lot); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+	gain := RealizedGain{Id: uuid.New(), SellTransactionId: sell.Id, BuyTransactionId: buy.Id, Asset: "Apple", Amount: 80,
+		IsProfit: true, Quantity: 4, BuyPrice: 100, SellPrice: 120, Currency: "EUR"}
+	if err := store.AddRealizedGain(ctx, gain); err != nil {
+		t.Fatalf("Failed to insert realized gain: %v", err)
+	}
+	if err := store.AddSavingsPlan(ctx, &plan); err != nil {
+		t.Fatalf("Failed to insert savings plan: %v", err)
+	}
+	if err := store.AddPendingTransaction(ctx, &pending); err != nil {
+		t.Fatalf("Failed to insert pending transaction: %v", err)
+	}
+	return buy, sell
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	for _, format := range []string{ArchiveFormatJSON, ArchiveFormatZip} {
+		t.Run(format, func(t *testing.T) {
+			source := setupTestStore(t)
+			buy, sell := fillBackupTestStore(t, source)
+
+			archive, err := ExportArchive(ctx, source, ArchiveMetadata{Driver: DriverMemory})
+			if err != nil {
+				t.Fatalf("Failed to export store: %v", err)
+			}
+			if archive.Metadata.SchemaVersion != LatestSchemaVersion() || len(archive.AuditLog) == 0 {
+				t.Errorf("Expected schema version %d and audit log, but got %+v", LatestSchemaVersion(), archive.Metadata)
+			}
+			if len(archive.Instruments) != 1 || archive.Instruments[0].Isin != buy.Isin {
+				t.Errorf("Expected instrument %s, but got %+v", buy.Isin, archive.Instruments)
+			}
+			var buffer bytes.Buffer
+			if err = WriteArchive(&buffer, archive, format); err != nil {
+				t.Fatalf("Failed to write archive: %v", err)
+			}
+			restored, err := ReadArchive(buffer.Bytes())
+			if err != nil {
+				t.Fatalf("Failed to read archive: %v", err)
+			}
+
+			//Ein Backup aus SQL lässt sich in einen Store mit anderem Treiber zurückspielen
+			target, _ := GetDocumentStorage(t.TempDir(), DocumentFormatYAML)
+			if err = target.Open(); err != nil {
+				t.Fatalf("Failed to open document storage: %v", err)
+			}
+			if err = RestoreArchive(ctx, target, restored, nil); err != nil {
+				t.Fatalf("Failed to restore archive: %v", err)
+			}
+			transactions, _ := target.ReadAllTransactions(ctx)
+			if len(transactions) != 2 || transactions[0] != buy || transactions[1] != sell {
+				t.Errorf("Expected %+v and %+v, but got %+v", buy, sell, transactions)
+			}
+			unclosed, _ := target.ReadAllUnclosedTransactions(ctx)
+			gains, _ := target.ReadAllRealizedGains(ctx)
+			pending, _ := target.ReadAllPendingTransactions(ctx)
+			if len(unclosed["AAPL"]) != 1 || unclosed["AAPL"][0].Quantity != 6 || len(gains) != 1 || len(pending) != 1 {
+				t.Errorf("Unexpected restored data: lots %+v, gains %+v, pending %+v", unclosed, gains, pending)
+			}
+
+			//Nur in einen leeren Store
+			if err = RestoreArchive(ctx, target, restored, nil); err == nil || !strings.Contains(err.Error(), "not empty") {
+				t.Errorf("Expected error for a store that is not empty, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveCheck(t *testing.T) {
+	ctx := context.Background()
+	buy := Transaction{Id: uuid.New(), TransactionType: "buy", TickerSymbol: "AAPL"}
+	lot := buy
+	lot.Id = uuid.New()
+	archive := &Archive{
+		Version:              ArchiveVersion,
+		Transactions:         []ArchivedTransaction{{Transaction: buy, Position: 1}, {Transaction: buy, Position: 1}},
+		UnclosedTransactions: []Transaction{lot},
+		RealizedGains:        []RealizedGain{{Id: uuid.New(), SellTransactionId: uuid.New(), BuyTransactionId: buy.Id}},
+		PendingTransactions:  []PendingTransaction{{Id: uuid.New(), SavingsPlanId: uuid.New()}},
+		Instruments:          []Instrument{{Isin: "DE000BASF111"}},
+	}
+	archive.Transactions[0].Isin = "US0378331005"
+
+	err := archive.Check()
+	if err == nil {
+		t.Fatal("Expected errors for an inconsistent archive, but got none")
+	}
+	for _, expected := range []string{"transaction " + buy.Id.String() + " exists more than once", "unclosed transaction " + lot.Id.String() + " has no transaction",
+		"has no sell transaction", "has no savings plan", "has no instrument US0378331005", "position 1 of transaction"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, but got %v", expected, err)
+		}
+	}
+
+	store := setupTestStore(t)
+	if err = RestoreArchive(ctx, store, archive, nil); err == nil {
+		t.Error("Expected restore of an inconsistent archive to fail, but got none")
+	}
+	if _, err = ReadArchive([]byte(`{"version": 99}`)); err == nil {
+		t.Error("Expected error for an unknown archive version, but got none")
+	}
+}
+
+func TestRestoreKeepsSameDayOrder(t *testing.T) {
+	ctx := context.Background()
+	source := setupTestStore(t)
+	//Gleicher Zeitpunkt und gleiche Sequenznummer, die Ids sind absteigend sortiert
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	var inserted []uuid.UUID
+	for i := range 5 {
+		transaction := Transaction{Id: uuid.MustParse(fmt.Sprintf("%08d-0000-0000-0000-000000000000", 9-i)), Date: day,
+			TransactionType: "buy", AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 1, Price: 100, Currency: "EUR"}
+		if err := source.AddTransaction(ctx, &transaction); err != nil {
+			t.Fatalf("Failed to insert transaction: %v", err)
+		}
+		inserted = append(inserted, transaction.Id)
+	}
+
+	archive, err := ExportArchive(ctx, source, ArchiveMetadata{Driver: DriverMemory})
+	if err != nil {
+		t.Fatalf("Failed to export store: %v", err)
+	}
+	//Die Position gilt auch, wenn die Liste z.B. nach Id sortiert wurde
+	slices.Reverse(archive.Transactions)
+
+	target := setupTestStore(t)
+	if err = RestoreArchive(ctx, target, archive, nil); err != nil {
+		t.Fatalf("Failed to restore archive: %v", err)
+	}
+	transactions, _ := target.ReadAllTransactions(ctx)
+	for i, transaction := range transactions {
+		if transaction.Id != inserted[i] {
+			t.Fatalf("Expected transaction %s at position %d, but got %s", inserted[i], i+1, transaction.Id)
+		}
+	}
+}
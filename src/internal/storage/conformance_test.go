@@ -0,0 +1,94 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/fritzrepo/stockportfolio/internal/storage/storetest"
+)
+
+// openTestStore öffnet den Store, legt das Schema an und schließt ihn nach dem Test.
+func openTestStore(t *testing.T, store storage.Store) storage.Store {
+	ctx := context.Background()
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	// Close vor dem Aufräumen der Testverzeichnisse und Schemas
+	t.Cleanup(func() {
+		store.Close()
+	})
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	return store
+}
+
+func TestMemoryDatabaseConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return openTestStore(t, storage.GetMemoryDatabase())
+	})
+}
+
+func TestFileDatabaseConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return openTestStore(t, storage.GetFileDatabase(filepath.Join(t.TempDir(), "portfolio.db")))
+	})
+}
+
+func TestEncryptedDatabaseConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		directory := t.TempDir()
+		keyFile := filepath.Join(directory, "portfolio.key")
+		if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		key, err := storage.KeyFromFile(keyFile)
+		if err != nil {
+			t.Fatalf("Failed to read key file: %v", err)
+		}
+		return openTestStore(t, storage.GetEncryptedDatabase(filepath.Join(directory, "portfolio.db.enc"), key))
+	})
+}
+
+func TestPostgresDatabaseConformance(t *testing.T) {
+	dsn := storetest.PostgresDSN(t)
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return openTestStore(t, storage.GetPostgresDatabase(storetest.PostgresSchemaDSN(t, dsn)))
+	})
+}
+
+func TestCsvStorageConformance(t *testing.T) {
+	ctx := context.Background()
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		filePath := filepath.Join(t.TempDir(), "transactions.csv")
+		// CreateDatabase legt die Datei erst an, Open liest sie
+		store := storage.GetCsvStorage(filePath)
+		if err := store.CreateDatabase(ctx); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		return openTestStore(t, store)
+	})
+}
+
+func TestDocumentStorageConformance(t *testing.T) {
+	for _, format := range []string{storage.DocumentFormatJSON, storage.DocumentFormatYAML} {
+		for _, layout := range []string{storage.DocumentLayoutSingle, storage.DocumentLayoutYearly} {
+			t.Run(format+"_"+layout, func(t *testing.T) {
+				storetest.Run(t, func(t *testing.T) storage.Store {
+					store, err := storage.GetDocumentStorage(t.TempDir(), format)
+					if err != nil {
+						t.Fatalf("Failed to create document storage: %v", err)
+					}
+					if err = store.SetLayout(layout); err != nil {
+						t.Fatalf("Failed to set layout: %v", err)
+					}
+					return openTestStore(t, store)
+				})
+			})
+		}
+	}
+}
@@ -1,144 +1,581 @@
 package storage
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-// CsvStorage implements the storage.Store interface for CSV file storage.
-// Only for tests. Not for production use.
+// CsvOptions beschreibt das Format der CSV-Dateien.
+type CsvOptions struct {
+	Separator    string   `json:"separator"`    //Trennzeichen der Spalten, Standard ";"
+	DecimalComma bool     `json:"decimalComma"` //Zahlen mit Dezimalkomma schreiben. Gelesen werden beide Schreibweisen.
+	DateFormats  []string `json:"dateFormats"`  //Datumsformate im Go-Layout. Das erste Format wird beim Schreiben verwendet.
+}
+
+// DefaultCsvOptions entspricht dem bisherigen Format der Transaktionsdatei.
+var DefaultCsvOptions = CsvOptions{
+	Separator:   ";",
+	DateFormats: []string{"02.01.2006", "2006-01-02"},
+}
+
+// CsvStorage speichert das Depot in CSV-Dateien. Die Transaktionen stehen in der angegebenen Datei,
+// offene Positionen, Abrechnungen, Sparpläne und offene Sparplan-Transaktionen in Begleitdateien
+// daneben (z.B. transactions.lots.csv). Beim Öffnen werden alle Dateien gelesen, nach jeder Änderung
+// (bzw. bei Commit) werden sie neu geschrieben.
 type CsvStorage struct {
-	filePath      string
-	realizedGains []RealizedGain
+	memoryState
+	filePath string
+	options  CsvOptions
+}
+
+// Begleitdateien neben der Transaktionsdatei
+const (
+	csvLotsSuffix         = ".lots.csv"
+	csvGainsSuffix        = ".gains.csv"
+	csvSavingsPlansSuffix = ".savingsplans.csv"
+	csvPendingSuffix      = ".pending.csv"
+	csvReplaceLogSuffix   = ".replace.json" //Protokolldatei von replaceFiles, nur während des Schreibens vorhanden
+)
+
+// Spalten der Transaktionsdatei. Dateien ohne Kopfzeile haben die Spalten in dieser Reihenfolge,
+// die ersten neun sind Pflicht.
+var csvTransactionColumns = []string{"date", "transactionType", "assetType", "asset", "tickerSymbol",
+	"quantity", "price", "fees", "currency", "sequence", "broker", "orderNumber", "executionId", "id", "isin"}
+
+const csvRequiredTransactionColumns = 9
+
+var csvGainColumns = []string{"id", "sellTransactionId", "buyTransactionId", "asset", "amount", "isProfit",
+	"taxRate", "quantity", "buyPrice", "sellPrice", "currency"}
+
+var csvSavingsPlanColumns = []string{"id", "assetType", "asset", "tickerSymbol", "amount", "currency",
+	"interval", "executionDay", "startDate", "endDate", "lastDueDate"}
+
+var csvPendingColumns = []string{"id", "savingsPlanId", "dueDate", "assetType", "asset", "tickerSymbol", "amount", "currency"}
+
+// SetOptions legt das Format der Dateien fest. Es muss vor Open gesetzt werden.
+func (s *CsvStorage) SetOptions(options CsvOptions) error {
+	if options.Separator == "" {
+		options.Separator = DefaultCsvOptions.Separator
+	}
+	separator, size := utf8.DecodeRuneInString(options.Separator)
+	if size != len(options.Separator) || separator == '"' || separator == '\r' || separator == '\n' {
+		return fmt.Errorf("csv separator %q not supported", options.Separator)
+	}
+	if len(options.DateFormats) == 0 {
+		options.DateFormats = DefaultCsvOptions.DateFormats
+	}
+	s.options = options
+	return nil
+}
+
+func (s *CsvStorage) Open() error {
+	//Wurde das Schreiben der Dateien unterbrochen, wird es zuerst fertiggestellt
+	err := completeReplace(s.companionPath(csvReplaceLogSuffix))
+	if err != nil {
+		return err
+	}
+	state := memoryState{}
+	state.transactions, err = s.readTransactions(s.filePath)
+	if err != nil {
+		return err
+	}
+	state.unclosed, err = s.readTransactions(s.companionPath(csvLotsSuffix))
+	if err != nil {
+		return err
+	}
+	state.realizedGains, err = s.readGainFile()
+	if err != nil {
+		return err
+	}
+	state.savingsPlans, err = s.readSavingsPlanFile()
+	if err != nil {
+		return err
+	}
+	state.pending, err = s.readPendingFile()
+	if err != nil {
+		return err
+	}
+	state.changed = s.save
+	s.memoryState = state
+	return nil
 }
 
-func (s *CsvStorage) CreateDatabase() error {
-	// Not implemented for CSV storage
-	return errors.New("CreateDatabase not implemented for CSV storage")
+func (s *CsvStorage) Close() error {
+	return nil
 }
 
-func (s *CsvStorage) Ping() error {
-	_, err := os.Open(s.filePath)
+// CreateDatabase legt eine leere Transaktionsdatei an, falls es sie noch nicht gibt.
+func (s *CsvStorage) CreateDatabase(ctx context.Context) error {
+	if _, err := os.Stat(s.filePath); err == nil {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *CsvStorage) Ping(ctx context.Context) error {
+	_, err := os.Stat(s.filePath)
 	return err
 }
 
-func (s *CsvStorage) AddTransaction(transaction *Transaction) error {
-	//Not implemented for CSV storage
-	return errors.New("AddTransaction not implemented for CSV storage")
+func (s *CsvStorage) companionPath(suffix string) string {
+	return strings.TrimSuffix(s.filePath, filepath.Ext(s.filePath)) + suffix
 }
 
-func (s *CsvStorage) ReadAllTransactions() ([]Transaction, error) {
-	lines, err := loadFile(s.filePath)
+// save schreibt alle Dateien über replaceFiles, die Dateien werden also zusammen ersetzt.
+func (s *CsvStorage) save() error {
+	files := []struct {
+		path    string
+		columns []string
+		records [][]string
+	}{
+		{s.filePath, csvTransactionColumns, s.transactionRecords(s.transactions)},
+		{s.companionPath(csvLotsSuffix), csvTransactionColumns, s.transactionRecords(s.unclosed)},
+		{s.companionPath(csvGainsSuffix), csvGainColumns, s.gainRecords()},
+		{s.companionPath(csvSavingsPlansSuffix), csvSavingsPlanColumns, s.savingsPlanRecords()},
+		{s.companionPath(csvPendingSuffix), csvPendingColumns, s.pendingRecords()},
+	}
+
+	var contents []fileContent
+	for i, file := range files {
+		exists := fileExists(file.path)
+		//Die Transaktionsdatei wird nur bei Änderungen neu geschrieben. Leere Begleitdateien
+		//werden erst angelegt, wenn sie gebraucht werden.
+		if i == 0 && exists && !s.transactionsChanged {
+			continue
+		}
+		if i > 0 && len(file.records) == 0 && !exists {
+			continue
+		}
+		var buffer bytes.Buffer
+		writer := csv.NewWriter(&buffer)
+		writer.Comma = s.separator()
+		err := writer.WriteAll(append([][]string{file.columns}, file.records...))
+		if err != nil {
+			return fmt.Errorf("error at write %s. %w", file.path, err)
+		}
+		contents = append(contents, fileContent{path: file.path, content: buffer.Bytes()})
+	}
+	err := replaceFiles(s.companionPath(csvReplaceLogSuffix), contents, nil)
+	if err != nil {
+		return err
+	}
+	s.transactionsChanged = false
+	return nil
+}
+
+func (s *CsvStorage) separator() rune {
+	separator, _ := utf8.DecodeRuneInString(s.options.Separator)
+	return separator
+}
+
+// csvRecord ist eine gelesene Zeile mit ihrer Zeilennummer für Fehlermeldungen.
+type csvRecord struct {
+	line   int
+	values []string
+	column map[string]int //Spaltenname (klein geschrieben) -> Index
+}
+
+func (r csvRecord) value(name string) string {
+	index, exists := r.column[strings.ToLower(name)]
+	if !exists || index >= len(r.values) {
+		return ""
+	}
+	return strings.TrimSpace(r.values[index])
+}
+
+// readCsvFile liest alle Zeilen einer Datei. Beginnt die Datei mit einer Kopfzeile, werden die Spalten
+// über ihren Namen zugeordnet, sonst über die Reihenfolge in columns. Fehlt die Datei, ist sie leer.
+func (s *CsvStorage) readCsvFile(path string, columns []string, required int) ([]csvRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	transactions := make([]Transaction, 0, len(lines))
-	for _, line := range lines {
-		// Parse line
-		values := strings.Split(line, ";")
-		transaction := Transaction{}
-		parsedDate, err := time.Parse("02.01.2006", values[0]) // Adjust the format as per your date format
-		if err != nil {
-			return nil, err
-		}
-		transaction.Date = parsedDate
-		transaction.TransactionType = values[1]
-		transaction.AssetType = values[2]
-		transaction.Asset = values[3]
-		transaction.TickerSymbol = values[4]
-		quantity, err := strconv.ParseFloat(values[5], 32)
-		if err != nil {
-			return nil, err
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = s.separator()
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	column := make(map[string]int, len(columns))
+	for i, name := range columns {
+		column[strings.ToLower(name)] = i
+	}
+
+	var records []csvRecord
+	first := true
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
 		}
-		transaction.Quantity = float64(quantity)
-		price, err := strconv.ParseFloat(values[6], 32)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %w", path, err)
 		}
-		transaction.Price = float64(price)
-		fees, err := strconv.ParseFloat(values[7], 32)
-		if err != nil {
-			return nil, err
-		}
-		transaction.Fees = float64(fees)
-		// currency, err := currency.ParseISO(values[8])
-		// if err != nil {
-		// 	return nil, err
-		// }
-		transaction.Currency = values[8]
-		transaction.Id = uuid.New()
-		transactions = append(transactions, transaction)
+		line, _ := reader.FieldPos(0)
+		if first {
+			first = false
+			if header, isHeader := csvHeader(values, columns); isHeader {
+				for _, name := range columns[:required] {
+					if _, exists := header[strings.ToLower(name)]; !exists {
+						return nil, fmt.Errorf("%s line %d: column %q missing", path, line, name)
+					}
+				}
+				column = header
+				continue
+			}
+		}
+		if len(values) == 1 && strings.TrimSpace(values[0]) == "" {
+			continue
+		}
+		if len(values) < required {
+			return nil, fmt.Errorf("%s line %d: expected at least %d columns, got %d", path, line, required, len(values))
+		}
+		records = append(records, csvRecord{line: line, values: values, column: column})
 	}
-	return transactions, nil
+	return records, nil
 }
 
-func (s *CsvStorage) AddUnclosedTransaction(asset Transaction) error {
-	// Not implemented for CSV storage. Do nothing.
-	return nil
+// csvHeader prüft, ob die Zeile eine Kopfzeile ist. Das ist sie, wenn sie mindestens einen bekannten Spaltennamen enthält.
+func csvHeader(values []string, columns []string) (map[string]int, bool) {
+	header := make(map[string]int, len(values))
+	known := false
+	for i, value := range values {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(value, "\ufeff")))
+		header[name] = i
+		if slices.ContainsFunc(columns, func(column string) bool { return strings.EqualFold(column, name) }) {
+			known = true
+		}
+	}
+	return header, known
 }
 
-func (s *CsvStorage) LoadTransactionByParams(date time.Time, transType string, tickSymbol string) (*Transaction, error) {
-	// Not implemented for CSV storage
-	return nil, errors.New("LoadTransactionByParams not implemented for CSV storage")
+// csvParser sammelt den ersten Fehler beim Umwandeln der Felder einer Zeile.
+type csvParser struct {
+	storage *CsvStorage
+	path    string
+	record  csvRecord
+	err     error
 }
 
-func (s *CsvStorage) RemoveAllUnclosedTransactions() error {
-	// Not implemented for CSV storage. Do nothing.
-	return nil
+func (p *csvParser) fail(name string, description string, value string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s line %d, column %s: %s %q", p.path, p.record.line, name, description, value)
+	}
+}
+
+func (p *csvParser) text(name string) string {
+	return p.record.value(name)
 }
 
-func (s *CsvStorage) ReadAllUnclosedTickerSymbols() ([]string, error) {
-	// Not implemented for CSV storage
-	return nil, errors.New("ReadAllUnclosedTickerSymbols not implemented for CSV storage")
+func (p *csvParser) number(name string) float64 {
+	value := p.record.value(name)
+	if value == "" {
+		return 0
+	}
+	number, err := parseDecimal(value)
+	if err != nil {
+		p.fail(name, "invalid number", value)
+	}
+	return number
 }
 
-func (s *CsvStorage) ReadAllUnclosedTransactions() (map[string][]Transaction, error) {
-	// Not implemented for CSV storage
-	return nil, errors.New("ReadAllUnclosedTransactions not implemented for CSV storage")
+func (p *csvParser) integer(name string) int {
+	value := p.record.value(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		p.fail(name, "invalid integer", value)
+	}
+	return number
 }
 
-func (s *CsvStorage) AddRealizedGain(realizedGain RealizedGain) error {
-	s.realizedGains = append(s.realizedGains, realizedGain)
-	return nil
+func (p *csvParser) boolean(name string) bool {
+	value := p.record.value(name)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(name, "invalid boolean", value)
+	}
+	return b
 }
 
-func (s *CsvStorage) ReadAllRealizedGains() ([]RealizedGain, error) {
-	return s.realizedGains, nil
+func (p *csvParser) date(name string) time.Time {
+	value := p.record.value(name)
+	date, err := p.storage.parseDate(value)
+	if err != nil {
+		p.fail(name, "invalid date", value)
+	}
+	return date
 }
 
-func (s *CsvStorage) RemoveAllRealizedGains() error {
-	s.realizedGains = nil
-	return nil
+func (p *csvParser) optionalDate(name string) *time.Time {
+	if p.record.value(name) == "" {
+		return nil
+	}
+	date := p.date(name)
+	return &date
 }
 
-func loadFile(filename string) ([]string, error) {
-	file, err := os.Open(filename)
+func (p *csvParser) id(name string) uuid.UUID {
+	value := p.record.value(name)
+	id, err := uuid.Parse(value)
+	if err != nil {
+		p.fail(name, "invalid id", value)
+	}
+	return id
+}
+
+// transactionId liest die Id der Transaktion. Zeilen ohne Id (von Hand gepflegte Dateien) bekommen eine
+// aus dem Inhalt abgeleitete Id, die bei jedem Lesen gleich ist, auch wenn davor Zeilen eingefügt werden.
+// Gleiche Zeilen werden über occurrences unterschieden (die wievielte gleiche Zeile der Datei).
+func (p *csvParser) transactionId(occurrences map[string]int) uuid.UUID {
+	if p.record.value("id") == "" {
+		values := make([]string, len(p.record.values))
+		for i, value := range p.record.values {
+			values[i] = strings.TrimSpace(value)
+		}
+		content := strings.Join(values, "\x00")
+		occurrences[content]++
+		return uuid.NewSHA1(uuid.NameSpaceOID, []byte(strconv.Itoa(occurrences[content])+":"+content))
+	}
+	return p.id("id")
+}
+
+// parseDecimal liest Zahlen mit Dezimalpunkt (1234.5) und Dezimalkomma (1234,5 oder 1.234,5).
+func parseDecimal(value string) (float64, error) {
+	comma := strings.LastIndex(value, ",")
+	point := strings.LastIndex(value, ".")
+	switch {
+	case comma >= 0 && comma > point:
+		//Dezimalkomma, Punkte sind Tausendertrennzeichen
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	case comma >= 0:
+		//Dezimalpunkt, Kommas sind Tausendertrennzeichen
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func (s *CsvStorage) formatDecimal(value float64) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if s.options.DecimalComma {
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	return text
+}
+
+// parseDate versucht alle konfigurierten Formate und zuletzt RFC 3339.
+func (s *CsvStorage) parseDate(value string) (time.Time, error) {
+	for _, format := range s.options.DateFormats {
+		date, err := time.Parse(format, value)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// formatDate schreibt das Datum im ersten konfigurierten Format. Gingen dabei Uhrzeit
+// oder Zeitzone verloren, wird RFC 3339 verwendet.
+func (s *CsvStorage) formatDate(date time.Time) string {
+	text := date.Format(s.options.DateFormats[0])
+	if parsed, err := time.Parse(s.options.DateFormats[0], text); err == nil && parsed.Equal(date) {
+		return text
+	}
+	return date.Format(time.RFC3339Nano)
+}
+
+func (s *CsvStorage) formatOptionalDate(date *time.Time) string {
+	if date == nil {
+		return ""
+	}
+	return s.formatDate(*date)
+}
+
+func (s *CsvStorage) readTransactions(path string) ([]Transaction, error) {
+	records, err := s.readCsvFile(path, csvTransactionColumns, csvRequiredTransactionColumns)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	transactions := make([]Transaction, 0, len(records))
+	occurrences := make(map[string]int)
+	for _, record := range records {
+		p := csvParser{storage: s, path: path, record: record}
+		transaction := Transaction{
+			Date:            p.date("date"),
+			TransactionType: p.text("transactionType"),
+			AssetType:       p.text("assetType"),
+			Asset:           p.text("asset"),
+			TickerSymbol:    p.text("tickerSymbol"),
+			Quantity:        p.number("quantity"),
+			Price:           p.number("price"),
+			Fees:            p.number("fees"),
+			Currency:        p.text("currency"),
+			Sequence:        p.integer("sequence"),
+			Broker:          p.text("broker"),
+			OrderNumber:     p.text("orderNumber"),
+			ExecutionId:     p.text("executionId"),
+			Id:              p.transactionId(occurrences),
+			Isin:            p.text("isin"),
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+func (s *CsvStorage) transactionRecords(transactions []Transaction) [][]string {
+	records := make([][]string, 0, len(transactions))
+	for _, t := range transactions {
+		records = append(records, []string{s.formatDate(t.Date), t.TransactionType, t.AssetType, t.Asset, t.TickerSymbol,
+			s.formatDecimal(t.Quantity), s.formatDecimal(t.Price), s.formatDecimal(t.Fees), t.Currency,
+			strconv.Itoa(t.Sequence), t.Broker, t.OrderNumber, t.ExecutionId, t.Id.String(), t.Isin})
 	}
+	return records
+}
 
-	if err := scanner.Err(); err != nil {
+func (s *CsvStorage) readGainFile() ([]RealizedGain, error) {
+	path := s.companionPath(csvGainsSuffix)
+	records, err := s.readCsvFile(path, csvGainColumns, len(csvGainColumns))
+	if err != nil {
 		return nil, err
 	}
+	gains := make([]RealizedGain, 0, len(records))
+	for _, record := range records {
+		p := csvParser{storage: s, path: path, record: record}
+		gain := RealizedGain{
+			Id:                p.id("id"),
+			SellTransactionId: p.id("sellTransactionId"),
+			BuyTransactionId:  p.id("buyTransactionId"),
+			Asset:             p.text("asset"),
+			Amount:            p.number("amount"),
+			IsProfit:          p.boolean("isProfit"),
+			TaxRate:           p.number("taxRate"),
+			Quantity:          p.number("quantity"),
+			BuyPrice:          p.number("buyPrice"),
+			SellPrice:         p.number("sellPrice"),
+			Currency:          p.text("currency"),
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		gains = append(gains, gain)
+	}
+	return gains, nil
+}
 
-	return lines, nil
+func (s *CsvStorage) gainRecords() [][]string {
+	records := make([][]string, 0, len(s.realizedGains))
+	for _, g := range s.realizedGains {
+		records = append(records, []string{g.Id.String(), g.SellTransactionId.String(), g.BuyTransactionId.String(), g.Asset,
+			s.formatDecimal(g.Amount), strconv.FormatBool(g.IsProfit), s.formatDecimal(g.TaxRate), s.formatDecimal(g.Quantity),
+			s.formatDecimal(g.BuyPrice), s.formatDecimal(g.SellPrice), g.Currency})
+	}
+	return records
+}
+
+func (s *CsvStorage) readSavingsPlanFile() ([]SavingsPlan, error) {
+	path := s.companionPath(csvSavingsPlansSuffix)
+	records, err := s.readCsvFile(path, csvSavingsPlanColumns, len(csvSavingsPlanColumns))
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]SavingsPlan, 0, len(records))
+	for _, record := range records {
+		p := csvParser{storage: s, path: path, record: record}
+		plan := SavingsPlan{
+			Id:           p.id("id"),
+			AssetType:    p.text("assetType"),
+			Asset:        p.text("asset"),
+			TickerSymbol: p.text("tickerSymbol"),
+			Amount:       p.number("amount"),
+			Currency:     p.text("currency"),
+			Interval:     p.text("interval"),
+			ExecutionDay: p.integer("executionDay"),
+			StartDate:    p.date("startDate"),
+			EndDate:      p.optionalDate("endDate"),
+			LastDueDate:  p.optionalDate("lastDueDate"),
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (s *CsvStorage) savingsPlanRecords() [][]string {
+	records := make([][]string, 0, len(s.savingsPlans))
+	for _, p := range s.savingsPlans {
+		records = append(records, []string{p.Id.String(), p.AssetType, p.Asset, p.TickerSymbol, s.formatDecimal(p.Amount), p.Currency,
+			p.Interval, strconv.Itoa(p.ExecutionDay), s.formatDate(p.StartDate), s.formatOptionalDate(p.EndDate), s.formatOptionalDate(p.LastDueDate)})
+	}
+	return records
+}
+
+func (s *CsvStorage) readPendingFile() ([]PendingTransaction, error) {
+	path := s.companionPath(csvPendingSuffix)
+	records, err := s.readCsvFile(path, csvPendingColumns, len(csvPendingColumns))
+	if err != nil {
+		return nil, err
+	}
+	pendingTransactions := make([]PendingTransaction, 0, len(records))
+	for _, record := range records {
+		p := csvParser{storage: s, path: path, record: record}
+		pending := PendingTransaction{
+			Id:            p.id("id"),
+			SavingsPlanId: p.id("savingsPlanId"),
+			DueDate:       p.date("dueDate"),
+			AssetType:     p.text("assetType"),
+			Asset:         p.text("asset"),
+			TickerSymbol:  p.text("tickerSymbol"),
+			Amount:        p.number("amount"),
+			Currency:      p.text("currency"),
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		pendingTransactions = append(pendingTransactions, pending)
+	}
+	return pendingTransactions, nil
+}
+
+func (s *CsvStorage) pendingRecords() [][]string {
+	records := make([][]string, 0, len(s.pending))
+	for _, p := range s.pending {
+		records = append(records, []string{p.Id.String(), p.SavingsPlanId.String(), s.formatDate(p.DueDate), p.AssetType, p.Asset,
+			p.TickerSymbol, s.formatDecimal(p.Amount), p.Currency})
+	}
+	return records
 }
 
-func GetCsvStorage(pathToFile string) CsvStorage {
-	return CsvStorage{
+func GetCsvStorage(pathToFile string) *CsvStorage {
+	return &CsvStorage{
 		filePath: pathToFile,
+		options:  DefaultCsvOptions,
 	}
 }
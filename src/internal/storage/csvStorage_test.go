@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func writeCsvTestFile(t *testing.T, content string) string {
+	filePath := filepath.Join(t.TempDir(), "transactions.csv")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return filePath
+}
+
+func TestCsvParsing(t *testing.T) {
+	ctx := context.Background()
+	filePath := writeCsvTestFile(t, "Ticker;Date;Type;assetType;Asset;Quantity;Price;Fees;Currency;TransactionType;TickerSymbol\n"+
+		"x;01.10.2025;;stock;\"Apple; Inc.\";10;\"1.234,5\";4,95;EUR;buy;AAPL\n"+
+		"\n"+
+		"x;2025-10-07;;stock;Apple;10;1250.25;4;EUR;sell;AAPL\n")
+
+	store := GetCsvStorage(filePath)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open csv file: %v", err)
+	}
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, but got %d", len(transactions))
+	}
+	buy := transactions[0]
+	if buy.Asset != "Apple; Inc." || buy.Price != 1234.5 || buy.Fees != 4.95 || buy.TransactionType != "buy" ||
+		!buy.Date.Equal(time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected transaction %+v", buy)
+	}
+	if transactions[1].Price != 1250.25 || !transactions[1].Date.Equal(time.Date(2025, 10, 7, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected transaction %+v", transactions[1])
+	}
+
+	//Ohne Id in der Datei bleibt die Id beim erneuten Lesen gleich
+	again := GetCsvStorage(filePath)
+	if err = again.Open(); err != nil {
+		t.Fatalf("Failed to open csv file: %v", err)
+	}
+	if loaded, _ := again.LoadTransactionById(ctx, buy.Id); loaded == nil {
+		t.Errorf("Expected transaction %s to have the same id after reopen", buy.Id)
+	}
+}
+
+func TestCsvIdsWithoutIdColumn(t *testing.T) {
+	ctx := context.Background()
+	row := "01.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n"
+	sell := "07.10.2025;sell;stock;Apple;AAPL;5;110;4;EUR\n"
+	readIds := func(content string) []uuid.UUID {
+		store := GetCsvStorage(writeCsvTestFile(t, content))
+		if err := store.Open(); err != nil {
+			t.Fatalf("Failed to open csv file: %v", err)
+		}
+		transactions, _ := store.ReadAllTransactions(ctx)
+		ids := make([]uuid.UUID, 0, len(transactions))
+		for _, transaction := range transactions {
+			ids = append(ids, transaction.Id)
+		}
+		return ids
+	}
+
+	ids := readIds(row + row + sell)
+	if ids[0] == ids[1] {
+		t.Errorf("Expected different ids for equal rows, but got %s twice", ids[0])
+	}
+	//Eine von Hand eingefügte Zeile ändert die Ids der anderen Zeilen nicht
+	inserted := readIds("02.09.2025;buy;stock;BASF;BAS1;5;45;1;EUR\n" + row + row + sell)
+	if inserted[1] != ids[0] || inserted[2] != ids[1] || inserted[3] != ids[2] {
+		t.Errorf("Expected ids %v after inserting a line, but got %v", ids, inserted[1:])
+	}
+}
+
+func TestCsvParsingErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"ShortLine", "01.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n01.10.2025;buy;stock\n", "line 2: expected at least 9 columns, got 3"},
+		{"InvalidNumber", "01.10.2025;buy;stock;Apple;AAPL;ten;100.5;4;EUR\n", "line 1, column quantity: invalid number \"ten\""},
+		{"InvalidDate", "01.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n\n32.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n", "line 3, column date"},
+		{"MissingColumn", "date;transactionType;tickerSymbol\n01.10.2025;buy;AAPL\n", "line 1: column \"assetType\" missing"},
+		{"Quotes", "01.10.2025;buy;stock;\"Apple;AAPL;10;100.5;4;EUR\n", "extraneous or missing \" in quoted-field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := GetCsvStorage(writeCsvTestFile(t, tt.content))
+			err := store.Open()
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, but got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestCsvOptions(t *testing.T) {
+	ctx := context.Background()
+	filePath := writeCsvTestFile(t, "10/01/2025,buy,stock,Apple,AAPL,10,\"100,5\",4,EUR\n")
+
+	store := GetCsvStorage(filePath)
+	if err := store.SetOptions(CsvOptions{Separator: "ab"}); err == nil {
+		t.Error("Expected error for a separator with more than one character, but got none")
+	}
+	err := store.SetOptions(CsvOptions{Separator: ",", DecimalComma: true, DateFormats: []string{"01/02/2006"}})
+	if err != nil {
+		t.Fatalf("Failed to set options: %v", err)
+	}
+	if err = store.Open(); err != nil {
+		t.Fatalf("Failed to open csv file: %v", err)
+	}
+	transactions, _ := store.ReadAllTransactions(ctx)
+	if len(transactions) != 1 || transactions[0].Price != 100.5 ||
+		!transactions[0].Date.Equal(time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected transactions %+v", transactions)
+	}
+
+	//Geschrieben wird im ersten Datumsformat und mit Dezimalkomma
+	transactions[0].Id = uuid.New()
+	transactions[0].Date = time.Date(2025, 10, 2, 0, 0, 0, 0, time.UTC)
+	if err = store.AddTransaction(ctx, &transactions[0]); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	content, _ := os.ReadFile(filePath)
+	if !strings.Contains(string(content), "10/02/2025,buy,stock,Apple,AAPL,10,\"100,5\",4,EUR,0,,,,"+transactions[0].Id.String()) {
+		t.Errorf("Unexpected file content:\n%s", content)
+	}
+}
+
+func TestCsvWriteAndReopen(t *testing.T) {
+	ctx := context.Background()
+	filePath := writeCsvTestFile(t, "01.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n")
+
+	store := GetCsvStorage(filePath)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open csv file: %v", err)
+	}
+	transactions, _ := store.ReadAllTransactions(ctx)
+	buy := transactions[0]
+	sell := Transaction{Id: uuid.New(), Date: time.Date(2025, 10, 7, 14, 30, 0, 0, time.UTC), TransactionType: "sell",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 4, Price: 110, Fees: 4, Currency: "EUR"}
+	lot := buy
+	lot.Quantity = 6
+	gain := RealizedGain{Id: uuid.New(), SellTransactionId: sell.Id, BuyTransactionId: buy.Id, Asset: "Apple",
+		Amount: 30, IsProfit: true, Quantity: 4, BuyPrice: 100.5, SellPrice: 110, Currency: "EUR"}
+
+	//Alle Dateien werden erst bei Commit geschrieben
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.AddTransaction(ctx, &sell); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	if err := store.AddUnclosedTransaction(ctx, lot); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+	if err := store.AddRealizedGain(ctx, gain); err != nil {
+		t.Fatalf("Failed to insert realized gain: %v", err)
+	}
+	if _, err := os.Stat(store.companionPath(csvLotsSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected no lot file before commit, but got %v", err)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	reopened := GetCsvStorage(filePath)
+	if err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to reopen csv file: %v", err)
+	}
+	transactions, _ = reopened.ReadAllTransactions(ctx)
+	if len(transactions) != 2 || transactions[0] != buy || transactions[1].Id != sell.Id || !transactions[1].Date.Equal(sell.Date) {
+		t.Errorf("Expected %+v and %+v, but got %+v", buy, sell, transactions)
+	}
+	unclosed, _ := reopened.ReadAllUnclosedTransactions(ctx)
+	if len(unclosed["AAPL"]) != 1 || unclosed["AAPL"][0] != lot {
+		t.Errorf("Expected lot %+v, but got %+v", lot, unclosed)
+	}
+	gains, _ := reopened.ReadAllRealizedGains(ctx)
+	if len(gains) != 1 || gains[0] != gain {
+		t.Errorf("Expected gain %+v, but got %+v", gain, gains)
+	}
+
+	//Es bleiben keine temporären Dateien zurück
+	entries, _ := os.ReadDir(filepath.Dir(filePath))
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("Unexpected temporary file %s", entry.Name())
+		}
+	}
+}
+
+// Ein Absturz nach dem ersten Rename wird beim nächsten Öffnen fertiggestellt, Transaktionen und
+// Begleitdateien passen danach zusammen.
+func TestCsvCompletesInterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	filePath := writeCsvTestFile(t, "01.10.2025;buy;stock;Apple;AAPL;10;100.5;4;EUR\n")
+	store := GetCsvStorage(filePath)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open csv file: %v", err)
+	}
+	transactions, _ := store.ReadAllTransactions(ctx)
+	buy := transactions[0]
+
+	//Stand eines Schreibvorgangs nachstellen, der nach dem Umbenennen der Transaktionsdatei abgebrochen ist
+	sell := Transaction{Id: uuid.New(), Date: time.Date(2025, 10, 7, 0, 0, 0, 0, time.UTC), TransactionType: "sell",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 4, Price: 110, Fees: 4, Currency: "EUR"}
+	lot := buy
+	lot.Quantity = 6
+	files := []fileContent{
+		{filePath, csvContent(t, store, csvTransactionColumns, store.transactionRecords([]Transaction{buy, sell}))},
+		{store.companionPath(csvLotsSuffix), csvContent(t, store, csvTransactionColumns, store.transactionRecords([]Transaction{lot}))},
+	}
+	var log replaceLog
+	for _, file := range files {
+		tempPath, err := writeTempFile(file)
+		if err != nil {
+			t.Fatalf("Failed to write temporary file: %v", err)
+		}
+		log.Files = append(log.Files, replaceLogFile{Temp: tempPath, Path: file.path})
+	}
+	logPath := store.companionPath(csvReplaceLogSuffix)
+	if err := writeReplaceLog(logPath, log); err != nil {
+		t.Fatalf("Failed to write replace log: %v", err)
+	}
+	if err := os.Rename(log.Files[0].Temp, filePath); err != nil {
+		t.Fatalf("Failed to rename transaction file: %v", err)
+	}
+
+	reopened := GetCsvStorage(filePath)
+	if err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to reopen csv file: %v", err)
+	}
+	transactions, _ = reopened.ReadAllTransactions(ctx)
+	unclosed, _ := reopened.ReadAllUnclosedTransactions(ctx)
+	if len(transactions) != 2 || len(unclosed["AAPL"]) != 1 || unclosed["AAPL"][0] != lot {
+		t.Errorf("Expected 2 transactions and lot %+v, but got %+v and %+v", lot, transactions, unclosed)
+	}
+	if fileExists(logPath) || fileExists(log.Files[1].Temp) {
+		t.Error("Expected replace log and temporary files to be removed")
+	}
+}
+
+func csvContent(t *testing.T, store *CsvStorage, columns []string, records [][]string) []byte {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Comma = store.separator()
+	if err := writer.WriteAll(append([][]string{columns}, records...)); err != nil {
+		t.Fatalf("Failed to write csv content: %v", err)
+	}
+	return buffer.Bytes()
+}
@@ -1,140 +1,175 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type DatabaseStorage struct {
+	dialect sqlDialect //nil für SQLite
+}
+
+// dbExecutor wird von *sql.DB und *sql.Tx erfüllt. So können alle SQL-Funktionen
+// direkt auf der Datenbank oder innerhalb einer Transaktion ausgeführt werden.
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-func (s *DatabaseStorage) createDatabase(db *sql.DB) error {
+func (s *DatabaseStorage) createDatabase(ctx context.Context, db dbExecutor) error {
 
-	// Fremdschlüssel-Unterstützung aktivieren
-	sqlStmt := "PRAGMA foreign_keys = ON;"
-	_, err := db.Exec(sqlStmt)
-	if err != nil {
-		return fmt.Errorf("error at enable foreign key support. %w", err)
+	// Fremdschlüssel-Unterstützung aktivieren (nur SQLite)
+	if s.dialect == nil {
+		sqlStmt := "PRAGMA foreign_keys = ON;"
+		_, err := db.ExecContext(ctx, sqlStmt)
+		if err != nil {
+			return fmt.Errorf("error at enable foreign key support. %w", err)
+		}
 	}
 
-	// Create the transactions table
-	sqlStmt = "CREATE TABLE transactions (id TEXT(36) not null primary key, date DATETIME, transactionType TEXT, " +
-		"assetType TEXT, asset TEXT, tickerSymbol TEXT, quantity REAL, price REAL, fees REAL, currency TEXT);"
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return fmt.Errorf("error at create table transactions. %w", err)
+	// Die Tabellen werden von den Migrationen angelegt
+	return s.migrateTo(ctx, db, LatestSchemaVersion())
+}
+
+// Spalten einer Transaktion in der Reihenfolge von scanTransaction
+const transactionColumns = "date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency, sequence, " +
+	"broker, orderNumber, executionId, isin"
+
+// rowScanner wird von *sql.Row und *sql.Rows erfüllt
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTransaction liest die Id und die Spalten aus transactionColumns
+func scanTransaction(row rowScanner) (Transaction, error) {
+	var transaction Transaction
+	err := row.Scan(
+		&transaction.Id,
+		&transaction.Date,
+		&transaction.TransactionType,
+		&transaction.AssetType,
+		&transaction.Asset,
+		&transaction.TickerSymbol,
+		&transaction.Quantity,
+		&transaction.Price,
+		&transaction.Fees,
+		&transaction.Currency,
+		&transaction.Sequence,
+		&transaction.Broker,
+		&transaction.OrderNumber,
+		&transaction.ExecutionId,
+		&transaction.Isin)
+	return transaction, err
+}
+
+// transactionValues liefert die Werte in der Reihenfolge von transactionColumns
+func transactionValues(transaction *Transaction) []any {
+	return []any{
+		transaction.Date,
+		transaction.TransactionType,
+		transaction.AssetType,
+		transaction.Asset,
+		transaction.TickerSymbol,
+		transaction.Quantity,
+		transaction.Price,
+		transaction.Fees,
+		transaction.Currency,
+		transaction.Sequence,
+		transaction.Broker,
+		transaction.OrderNumber,
+		transaction.ExecutionId,
+		transaction.Isin,
 	}
+}
 
-	sqlStmt = "CREATE UNIQUE INDEX idx_transactions_id ON transactions(id);"
-	_, err = db.Exec(sqlStmt)
+func (s *DatabaseStorage) insertTransaction(ctx context.Context, db dbExecutor, transaction *Transaction) error {
+	sqlStmt := "INSERT INTO transactions (id, " + transactionColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err := db.ExecContext(ctx, sqlStmt, append([]any{transaction.Id}, transactionValues(transaction)...)...)
 	if err != nil {
-		return fmt.Errorf("error at create index on table transactions. %w", err)
+		return err
 	}
+	return nil
+}
 
-	// Unclosed_transactions
-	// 1:n asset -> unclosed_transactions
-
-	// Create the unclosed_assets table
-	sqlStmt = "CREATE TABLE unclosed_assets (asset_id INTEGER PRIMARY KEY AUTOINCREMENT, ticker_symbol TEXT UNIQUE NOT NULL);"
-	_, err = db.Exec(sqlStmt)
+func (s *DatabaseStorage) updateTransaction(ctx context.Context, db dbExecutor, transaction *Transaction) error {
+	sqlStmt := "UPDATE transactions SET date = ?, transactionType = ?, assetType = ?, asset = ?, tickerSymbol = ?, " +
+		"quantity = ?, price = ?, fees = ?, currency = ?, sequence = ?, broker = ?, orderNumber = ?, executionId = ?, isin = ? WHERE id = ?;"
+	result, err := db.ExecContext(ctx, sqlStmt, append(transactionValues(transaction), transaction.Id)...)
 	if err != nil {
-		return fmt.Errorf("error at create table unclosed_assets. %w", err)
+		return fmt.Errorf("error at update transaction. %w", err)
 	}
+	return checkFound(result, "transaction", transaction.Id)
+}
 
-	// Create the unclosed_transactions table
-	sqlStmt = "CREATE TABLE unclosed_trans (unclosed_id INTEGER PRIMARY KEY AUTOINCREMENT, " +
-		"asset_id INTEGER NOT NULL, " +
-		"transaction_id TEXT, date DATETIME, transactionType TEXT, " +
-		"assetType TEXT, asset TEXT, tickerSymbol TEXT, quantity REAL, price REAL, fees REAL, currency TEXT, " +
-		"FOREIGN KEY (asset_id) REFERENCES unclosed_assets(asset_id) ON DELETE CASCADE);"
-	_, err = db.Exec(sqlStmt)
+func (s *DatabaseStorage) deleteTransaction(ctx context.Context, db dbExecutor, id uuid.UUID) error {
+	// Abhängige Abrechnungen und offene Positionen zuerst löschen. Sie müssen danach neu berechnet werden.
+	_, err := db.ExecContext(ctx, "DELETE FROM realized_gains WHERE sellTransactionId = ? OR buyTransactionId = ?;", id, id)
 	if err != nil {
-		return fmt.Errorf("error at create table unclosed_trans. %w", err)
+		return fmt.Errorf("error at delete realized gains of transaction. %w", err)
 	}
-
-	sqlStmt = "CREATE INDEX IF NOT EXISTS idx_nclosed_id ON unclosed_trans(unclosed_id)"
-	_, err = db.Exec(sqlStmt)
+	_, err = db.ExecContext(ctx, "DELETE FROM unclosed_trans WHERE transaction_id = ?;", id)
 	if err != nil {
-		return fmt.Errorf("error at create index on table unclosed. %w", err)
+		return fmt.Errorf("error at delete unclosed transaction. %w", err)
 	}
 
-	// Create the RealizedGains table
-	sqlStmt = "CREATE TABLE realized_gains (id TEXT(36) not null primary key, sellTransactionId TEXT(36), buyTransactionId TEXT(36), " +
-		"asset TEXT, amount REAL, isProfit INTEGER, taxRate REAL, quantity REAL, buyPrice REAL, sellPrice REAL, currency TEXT, " +
-		"FOREIGN KEY (sellTransactionId) REFERENCES transactions(id) ON DELETE CASCADE, " +
-		"FOREIGN KEY (buyTransactionId) REFERENCES transactions(id) ON DELETE CASCADE);"
-	_, err = db.Exec(sqlStmt)
+	result, err := db.ExecContext(ctx, "DELETE FROM transactions WHERE id = ?;", id)
 	if err != nil {
-		return fmt.Errorf("error at create table realized_gains. %w", err)
+		return fmt.Errorf("error at delete transaction. %w", err)
 	}
-
-	return nil
+	return checkFound(result, "transaction", id)
 }
 
-func (s *DatabaseStorage) insertTransaction(db *sql.DB, transaction *Transaction) error {
-	sqlStmt := "INSERT INTO transactions (id, date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
-	_, err := db.Exec(sqlStmt,
-		transaction.Id,
-		transaction.Date,
-		transaction.TransactionType,
-		transaction.AssetType,
-		transaction.Asset,
-		transaction.TickerSymbol,
-		transaction.Quantity,
-		transaction.Price,
-		transaction.Fees,
-		transaction.Currency)
+func (s *DatabaseStorage) loadTransactionById(ctx context.Context, db dbExecutor, id uuid.UUID) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, "SELECT id, "+transactionColumns+" FROM transactions WHERE id = ?", id)
+	transaction, err := scanTransaction(row)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return nil, nil // Transaction not found
+		}
+		return nil, err
 	}
-	return nil
+	return &transaction, nil
 }
 
-func (s *DatabaseStorage) loadAllTransactions(db *sql.DB) ([]Transaction, error) {
+func (s *DatabaseStorage) loadAllTransactions(ctx context.Context, db dbExecutor) ([]Transaction, error) {
 	transactions := make([]Transaction, 0)
-	rows, err := db.Query("SELECT id, date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency FROM transactions")
+	// Chronologisch, bei gleichem Datum nach der Sequenznummer
+	rows, err := db.QueryContext(ctx, "SELECT id, "+transactionColumns+" FROM transactions ORDER BY date, sequence, position")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var transaction Transaction
-		err = rows.Scan(
-			&transaction.Id,
-			&transaction.Date,
-			&transaction.TransactionType,
-			&transaction.AssetType,
-			&transaction.Asset,
-			&transaction.TickerSymbol,
-			&transaction.Quantity,
-			&transaction.Price,
-			&transaction.Fees,
-			&transaction.Currency)
+		transaction, err := scanTransaction(rows)
 		if err != nil {
 			return nil, err
 		}
 		transactions = append(transactions, transaction)
 	}
-	return transactions, nil
+	return transactions, rows.Err()
 }
 
-func (s *DatabaseStorage) loadTransactionByParams(db *sql.DB, date time.Time, transType string, tickSymbol string) (*Transaction, error) {
-	var transaction Transaction
-	row := db.QueryRow("SELECT id, date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency FROM transactions WHERE date = ? AND transactionType = ? AND tickerSymbol = ?", date, transType, tickSymbol)
-	err := row.Scan(
-		&transaction.Id,
-		&transaction.Date,
-		&transaction.TransactionType,
-		&transaction.AssetType,
-		&transaction.Asset,
-		&transaction.TickerSymbol,
-		&transaction.Quantity,
-		&transaction.Price,
-		&transaction.Fees,
-		&transaction.Currency)
+func (s *DatabaseStorage) loadTransactionByParams(ctx context.Context, db dbExecutor, date time.Time, transType string, tickSymbol string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, "SELECT id, "+transactionColumns+" FROM transactions WHERE date = ? AND transactionType = ? AND tickerSymbol = ?", date, transType, tickSymbol)
+	transaction, err := scanTransaction(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Transaction not found
+		}
+		return nil, err
+	}
+	return &transaction, nil
+}
 
+func (s *DatabaseStorage) loadTransactionByOrderNumber(ctx context.Context, db dbExecutor, broker string, orderNumber string, executionId string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, "SELECT id, "+transactionColumns+" FROM transactions WHERE broker = ? AND orderNumber = ? AND executionId = ?", broker, orderNumber, executionId)
+	transaction, err := scanTransaction(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Transaction not found
@@ -144,15 +179,30 @@ func (s *DatabaseStorage) loadTransactionByParams(db *sql.DB, date time.Time, tr
 	return &transaction, nil
 }
 
-func (s *DatabaseStorage) insertUnclosedTransaction(db *sql.DB, trans Transaction) error {
+func (s *DatabaseStorage) loadTransactionsByTickerSymbol(ctx context.Context, db dbExecutor, tickSymbol string) ([]Transaction, error) {
+	transactions := make([]Transaction, 0)
+	rows, err := db.QueryContext(ctx, "SELECT id, "+transactionColumns+" FROM transactions WHERE tickerSymbol = ? ORDER BY date, sequence, position", tickSymbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
+func (s *DatabaseStorage) insertUnclosedTransaction(ctx context.Context, db dbExecutor, trans Transaction) error {
 
 	// Save Asset-Name in unclosed_assets table
-	// SQLite spezifisch
-	sqlStmt := "INSERT OR IGNORE INTO unclosed_assets (ticker_symbol) VALUES (?);"
-	// Modern
-	// sqlStmt := "INSERT INTO unclosed_assets (ticker_symbol) VALUES (?) ON CONFLICT(ticker_symbol) DO NOTHING;"
-	// Beides wird von SQLite unterstützt
-	_, err := db.Exec(sqlStmt, trans.TickerSymbol)
+	// Wird von SQLite und PostgreSQL unterstützt (im Gegensatz zu INSERT OR IGNORE)
+	sqlStmt := "INSERT INTO unclosed_assets (ticker_symbol) VALUES (?) ON CONFLICT(ticker_symbol) DO NOTHING;"
+	_, err := db.ExecContext(ctx, sqlStmt, trans.TickerSymbol)
 
 	if err != nil {
 		return err
@@ -161,26 +211,15 @@ func (s *DatabaseStorage) insertUnclosedTransaction(db *sql.DB, trans Transactio
 	// Get the asset_id from the unclosed_assets table
 	var assetId int
 	sqlStmt = "SELECT asset_id FROM unclosed_assets WHERE ticker_symbol = ?;"
-	err = db.QueryRow(sqlStmt, trans.TickerSymbol).Scan(&assetId)
+	err = db.QueryRowContext(ctx, sqlStmt, trans.TickerSymbol).Scan(&assetId)
 
 	if err != nil {
 		return err
 	}
 
 	// Insert the transaction into unclosed
-	sqlStmt = "INSERT INTO unclosed_trans (asset_id, transaction_id, date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
-	_, err = db.Exec(sqlStmt,
-		assetId,
-		trans.Id,
-		trans.Date,
-		trans.TransactionType,
-		trans.AssetType,
-		trans.Asset,
-		trans.TickerSymbol,
-		trans.Quantity,
-		trans.Price,
-		trans.Fees,
-		trans.Currency)
+	sqlStmt = "INSERT INTO unclosed_trans (asset_id, transaction_id, " + transactionColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = db.ExecContext(ctx, sqlStmt, append([]any{assetId, trans.Id}, transactionValues(&trans)...)...)
 
 	if err != nil {
 		return err
@@ -189,15 +228,61 @@ func (s *DatabaseStorage) insertUnclosedTransaction(db *sql.DB, trans Transactio
 	return nil
 }
 
-func (s *DatabaseStorage) deleteAllUnclosedTransaction(db *sql.DB) error {
+func (s *DatabaseStorage) updateUnclosedTransaction(ctx context.Context, db dbExecutor, trans Transaction) error {
+	sqlStmt := "UPDATE unclosed_trans SET date = ?, transactionType = ?, assetType = ?, asset = ?, tickerSymbol = ?, " +
+		"quantity = ?, price = ?, fees = ?, currency = ?, sequence = ?, broker = ?, orderNumber = ?, executionId = ?, isin = ? WHERE transaction_id = ?;"
+	result, err := db.ExecContext(ctx, sqlStmt, append(transactionValues(&trans), trans.Id)...)
+	if err != nil {
+		return fmt.Errorf("error at update unclosed transaction. %w", err)
+	}
+	return checkFound(result, "unclosed transaction", trans.Id)
+}
+
+func (s *DatabaseStorage) loadUnclosedTransaction(ctx context.Context, db dbExecutor, id uuid.UUID) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, "SELECT transaction_id, "+transactionColumns+" FROM unclosed_trans WHERE transaction_id = ?", id)
+	transaction, err := scanTransaction(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error at read unclosed transaction. %w", err)
+	}
+	return &transaction, nil
+}
+
+func (s *DatabaseStorage) deleteUnclosedTransaction(ctx context.Context, db dbExecutor, id uuid.UUID) error {
+	var assetId int
+	err := db.QueryRowContext(ctx, "SELECT asset_id FROM unclosed_trans WHERE transaction_id = ?;", id).Scan(&assetId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("unclosed transaction %s %w", id, ErrNotFound)
+		}
+		return fmt.Errorf("error at delete unclosed transaction. %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM unclosed_trans WHERE transaction_id = ?;", id)
+	if err != nil {
+		return fmt.Errorf("error at delete unclosed transaction. %w", err)
+	}
+
+	// Das Asset wird entfernt, sobald es keine offenen Positionen mehr hat
+	sqlStmt := "DELETE FROM unclosed_assets WHERE asset_id = ? AND NOT EXISTS (SELECT 1 FROM unclosed_trans WHERE asset_id = ?);"
+	_, err = db.ExecContext(ctx, sqlStmt, assetId, assetId)
+	if err != nil {
+		return fmt.Errorf("error at delete unclosed asset. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) deleteAllUnclosedTransaction(ctx context.Context, db dbExecutor) error {
 	sqlStmt := "DELETE FROM unclosed_trans;"
-	_, err := db.Exec(sqlStmt)
+	_, err := db.ExecContext(ctx, sqlStmt)
 	if err != nil {
 		return fmt.Errorf("error at delete all unclosed transactions. %w", err)
 	}
 
 	sqlStmt = "DELETE FROM unclosed_assets;"
-	_, err = db.Exec(sqlStmt)
+	_, err = db.ExecContext(ctx, sqlStmt)
 	if err != nil {
 		return fmt.Errorf("error at delete all unclosed assets. %w", err)
 	}
@@ -205,11 +290,11 @@ func (s *DatabaseStorage) deleteAllUnclosedTransaction(db *sql.DB) error {
 	return nil
 }
 
-func (s *DatabaseStorage) loadUnclosedTickerSymbols(db *sql.DB) ([]string, error) {
+func (s *DatabaseStorage) loadUnclosedTickerSymbols(ctx context.Context, db dbExecutor) ([]string, error) {
 	tickerSymbols := make([]string, 0)
 
 	sqlStmt := "SELECT ticker_symbol FROM unclosed_assets;"
-	rows, err := db.Query(sqlStmt)
+	rows, err := db.QueryContext(ctx, sqlStmt)
 	if err != nil {
 		return nil, fmt.Errorf("error at read unclosed ticker symbol. %w", err)
 	}
@@ -227,51 +312,30 @@ func (s *DatabaseStorage) loadUnclosedTickerSymbols(db *sql.DB) ([]string, error
 	return tickerSymbols, nil
 }
 
-func (s *DatabaseStorage) loadUnclosedTransactions(db *sql.DB) (map[string][]Transaction, error) {
+func (s *DatabaseStorage) loadUnclosedTransactions(ctx context.Context, db dbExecutor) (map[string][]Transaction, error) {
 	unclosedTransactions := make(map[string][]Transaction)
-	var tickerSymbols []string
 
-	tickerSymbols, err := s.loadUnclosedTickerSymbols(db)
+	// Die Reihenfolge des Einfügens entspricht der FiFo-Reihenfolge
+	sqlStmt := "SELECT transaction_id, " + transactionColumns + " FROM unclosed_trans ORDER BY unclosed_id;"
+	rows, err := db.QueryContext(ctx, sqlStmt)
 	if err != nil {
 		return nil, fmt.Errorf("error at read unclosed transactions. %w", err)
 	}
+	defer rows.Close()
 
-	for _, tickerSymbol := range tickerSymbols {
-		sqlStmt := `SELECT transaction_id, date, transactionType, assetType, asset, tickerSymbol, 
-		quantity, price, fees, currency FROM unclosed_trans 
-		WHERE asset_id = (SELECT asset_id FROM unclosed_assets WHERE ticker_symbol = ?);`
-
-		rows, err := db.Query(sqlStmt, tickerSymbol)
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error at read unclosed transactions. %w", err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var transaction Transaction
-			err = rows.Scan(
-				&transaction.Id,
-				&transaction.Date,
-				&transaction.TransactionType,
-				&transaction.AssetType,
-				&transaction.Asset,
-				&transaction.TickerSymbol,
-				&transaction.Quantity,
-				&transaction.Price,
-				&transaction.Fees,
-				&transaction.Currency)
-			if err != nil {
-				return nil, err
-			}
-			unclosedTransactions[tickerSymbol] = append(unclosedTransactions[tickerSymbol], transaction)
+			return nil, err
 		}
+		unclosedTransactions[transaction.TickerSymbol] = append(unclosedTransactions[transaction.TickerSymbol], transaction)
 	}
-	return unclosedTransactions, nil
+	return unclosedTransactions, rows.Err()
 }
 
-func (s *DatabaseStorage) insertRealizedGain(db *sql.DB, realizedGain *RealizedGain) error {
+func (s *DatabaseStorage) insertRealizedGain(ctx context.Context, db dbExecutor, realizedGain *RealizedGain) error {
 	sqlStmt := "INSERT INTO realized_gains (id, sellTransactionId, buyTransactionId, asset, amount, isProfit, taxRate, quantity, buyPrice, sellPrice, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
-	_, err := db.Exec(sqlStmt,
+	_, err := db.ExecContext(ctx, sqlStmt,
 		realizedGain.Id,
 		realizedGain.SellTransactionId,
 		realizedGain.BuyTransactionId,
@@ -289,10 +353,10 @@ func (s *DatabaseStorage) insertRealizedGain(db *sql.DB, realizedGain *RealizedG
 	return nil
 }
 
-func (s *DatabaseStorage) loadAllRealizedGains(db *sql.DB) ([]RealizedGain, error) {
+func (s *DatabaseStorage) loadAllRealizedGains(ctx context.Context, db dbExecutor) ([]RealizedGain, error) {
 	realizedGains := make([]RealizedGain, 0)
 
-	rows, err := db.Query("SELECT id, sellTransactionId, buyTransactionId, asset, amount, isProfit, taxRate, quantity, buyPrice, sellPrice, currency FROM realized_gains")
+	rows, err := db.QueryContext(ctx, "SELECT id, sellTransactionId, buyTransactionId, asset, amount, isProfit, taxRate, quantity, buyPrice, sellPrice, currency FROM realized_gains")
 	if err != nil {
 		return nil, err
 	}
@@ -320,17 +384,219 @@ func (s *DatabaseStorage) loadAllRealizedGains(db *sql.DB) ([]RealizedGain, erro
 	return realizedGains, nil
 }
 
-func (s *DatabaseStorage) removeRealizedGains(db *sql.DB) error {
+func (s *DatabaseStorage) removeRealizedGains(ctx context.Context, db dbExecutor) error {
 	sqlStmt := "DELETE FROM realized_gains;"
-	_, err := db.Exec(sqlStmt)
+	_, err := db.ExecContext(ctx, sqlStmt)
 	if err != nil {
 		return fmt.Errorf("error at delete all realized gains. %w", err)
 	}
 	return nil
 }
 
-func (s *DatabaseStorage) ping(db *sql.DB) error {
-	err := db.Ping()
+// countRows liefert die Anzahl der Zeilen einer Tabelle, z.B. für das Audit-Log beim Löschen aller Einträge.
+func (s *DatabaseStorage) countRows(ctx context.Context, db dbExecutor, table string) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+";").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error at count rows of %s. %w", table, err)
+	}
+	return count, nil
+}
+
+func (s *DatabaseStorage) insertSavingsPlan(ctx context.Context, db dbExecutor, plan *SavingsPlan) error {
+	sqlStmt := "INSERT INTO savings_plans (id, assetType, asset, tickerSymbol, amount, currency, planInterval, executionDay, startDate, endDate, lastDueDate) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err := db.ExecContext(ctx, sqlStmt,
+		plan.Id,
+		plan.AssetType,
+		plan.Asset,
+		plan.TickerSymbol,
+		plan.Amount,
+		plan.Currency,
+		plan.Interval,
+		plan.ExecutionDay,
+		plan.StartDate,
+		plan.EndDate,
+		plan.LastDueDate)
+	if err != nil {
+		return fmt.Errorf("error at insert savings plan. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) updateSavingsPlan(ctx context.Context, db dbExecutor, plan *SavingsPlan) error {
+	sqlStmt := "UPDATE savings_plans SET assetType = ?, asset = ?, tickerSymbol = ?, amount = ?, currency = ?, planInterval = ?, " +
+		"executionDay = ?, startDate = ?, endDate = ?, lastDueDate = ? WHERE id = ?;"
+	result, err := db.ExecContext(ctx, sqlStmt,
+		plan.AssetType,
+		plan.Asset,
+		plan.TickerSymbol,
+		plan.Amount,
+		plan.Currency,
+		plan.Interval,
+		plan.ExecutionDay,
+		plan.StartDate,
+		plan.EndDate,
+		plan.LastDueDate,
+		plan.Id)
+	if err != nil {
+		return fmt.Errorf("error at update savings plan. %w", err)
+	}
+	return checkFound(result, "savings plan", plan.Id)
+}
+
+func (s *DatabaseStorage) deleteSavingsPlan(ctx context.Context, db dbExecutor, id uuid.UUID) error {
+	// Die offenen Transaktionen des Sparplans werden mit gelöscht.
+	_, err := db.ExecContext(ctx, "DELETE FROM pending_transactions WHERE savingsPlanId = ?;", id)
+	if err != nil {
+		return fmt.Errorf("error at delete pending transactions of savings plan. %w", err)
+	}
+	result, err := db.ExecContext(ctx, "DELETE FROM savings_plans WHERE id = ?;", id)
+	if err != nil {
+		return fmt.Errorf("error at delete savings plan. %w", err)
+	}
+	return checkFound(result, "savings plan", id)
+}
+
+func (s *DatabaseStorage) loadSavingsPlan(ctx context.Context, db dbExecutor, id uuid.UUID) (*SavingsPlan, error) {
+	plans, err := s.loadAllSavingsPlans(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		if plan.Id == id {
+			return &plan, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *DatabaseStorage) loadAllSavingsPlans(ctx context.Context, db dbExecutor) ([]SavingsPlan, error) {
+	plans := make([]SavingsPlan, 0)
+
+	rows, err := db.QueryContext(ctx, "SELECT id, assetType, asset, tickerSymbol, amount, currency, planInterval, executionDay, startDate, endDate, lastDueDate FROM savings_plans")
+	if err != nil {
+		return nil, fmt.Errorf("error at read savings plans. %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var plan SavingsPlan
+		var endDate, lastDueDate sql.NullTime
+		err = rows.Scan(
+			&plan.Id,
+			&plan.AssetType,
+			&plan.Asset,
+			&plan.TickerSymbol,
+			&plan.Amount,
+			&plan.Currency,
+			&plan.Interval,
+			&plan.ExecutionDay,
+			&plan.StartDate,
+			&endDate,
+			&lastDueDate)
+		if err != nil {
+			return nil, err
+		}
+		if endDate.Valid {
+			plan.EndDate = &endDate.Time
+		}
+		if lastDueDate.Valid {
+			plan.LastDueDate = &lastDueDate.Time
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (s *DatabaseStorage) insertPendingTransaction(ctx context.Context, db dbExecutor, pending *PendingTransaction) error {
+	sqlStmt := "INSERT INTO pending_transactions (id, savingsPlanId, dueDate, assetType, asset, tickerSymbol, amount, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err := db.ExecContext(ctx, sqlStmt,
+		pending.Id,
+		pending.SavingsPlanId,
+		pending.DueDate,
+		pending.AssetType,
+		pending.Asset,
+		pending.TickerSymbol,
+		pending.Amount,
+		pending.Currency)
+	if err != nil {
+		return fmt.Errorf("error at insert pending transaction. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) loadPendingTransaction(ctx context.Context, db dbExecutor, id uuid.UUID) (*PendingTransaction, error) {
+	var pending PendingTransaction
+	row := db.QueryRowContext(ctx, "SELECT id, savingsPlanId, dueDate, assetType, asset, tickerSymbol, amount, currency FROM pending_transactions WHERE id = ?", id)
+	err := row.Scan(
+		&pending.Id,
+		&pending.SavingsPlanId,
+		&pending.DueDate,
+		&pending.AssetType,
+		&pending.Asset,
+		&pending.TickerSymbol,
+		&pending.Amount,
+		&pending.Currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Pending transaction not found
+		}
+		return nil, err
+	}
+	return &pending, nil
+}
+
+func (s *DatabaseStorage) loadAllPendingTransactions(ctx context.Context, db dbExecutor) ([]PendingTransaction, error) {
+	pendingTransactions := make([]PendingTransaction, 0)
+
+	rows, err := db.QueryContext(ctx, "SELECT id, savingsPlanId, dueDate, assetType, asset, tickerSymbol, amount, currency FROM pending_transactions ORDER BY dueDate")
+	if err != nil {
+		return nil, fmt.Errorf("error at read pending transactions. %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pending PendingTransaction
+		err = rows.Scan(
+			&pending.Id,
+			&pending.SavingsPlanId,
+			&pending.DueDate,
+			&pending.AssetType,
+			&pending.Asset,
+			&pending.TickerSymbol,
+			&pending.Amount,
+			&pending.Currency)
+		if err != nil {
+			return nil, err
+		}
+		pendingTransactions = append(pendingTransactions, pending)
+	}
+	return pendingTransactions, nil
+}
+
+func (s *DatabaseStorage) deletePendingTransaction(ctx context.Context, db dbExecutor, id uuid.UUID) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM pending_transactions WHERE id = ?;", id)
+	if err != nil {
+		return fmt.Errorf("error at delete pending transaction. %w", err)
+	}
+	return checkFound(result, "pending transaction", id)
+}
+
+// checkFound gibt ErrNotFound zurück, wenn die Anweisung keine Zeile verändert hat.
+func checkFound(result sql.Result, entity string, id any) error {
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error at read affected rows. %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%s %s %w", entity, id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) ping(ctx context.Context, db *sql.DB) error {
+	err := db.PingContext(ctx)
 	if err != nil {
 		return fmt.Errorf("error at ping database. %w", err)
 	}
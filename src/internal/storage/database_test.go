@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -9,9 +12,10 @@ import (
 )
 
 func setupTestStore(t *testing.T) Store {
+	ctx := context.Background()
 	store := GetMemoryDatabase()
 	store.Open()
-	err := store.CreateDatabase()
+	err := store.CreateDatabase(ctx)
 	if err != nil {
 		t.Fatalf("Failed to create database: %v", err)
 	}
@@ -23,6 +27,7 @@ func setupTestStore(t *testing.T) Store {
 }
 
 func TestInsertTransaction(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 
 	transaction := &Transaction{
@@ -37,12 +42,12 @@ func TestInsertTransaction(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err := store.AddTransaction(transaction)
+	err := store.AddTransaction(ctx, transaction)
 	if err != nil {
 		t.Errorf("Failed to insert transaction: %v", err)
 	}
 
-	transactions, err := store.ReadAllTransactions()
+	transactions, err := store.ReadAllTransactions(ctx)
 	if err != nil {
 		t.Errorf("Failed to load transactions: %v", err)
 	}
@@ -61,6 +66,7 @@ func TestInsertTransaction(t *testing.T) {
 }
 
 func TestInsertUclosedTransaction(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 
 	transaction := &Transaction{
@@ -75,18 +81,18 @@ func TestInsertUclosedTransaction(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err := store.AddUnclosedTransaction(*transaction)
+	err := store.AddUnclosedTransaction(ctx, *transaction)
 	if err != nil {
 		t.Errorf("Failed to insert unclosed asset name: %v", err)
 	}
 	//Füge es nochmal ein, um zu testen, das es bei der Tabelle "unclosed_assets" zu keinem Insert-Fehler kommt.
 	//Bzw. dass der Insert dann nicht durchgeführt wird.
-	err = store.AddUnclosedTransaction(*transaction)
+	err = store.AddUnclosedTransaction(ctx, *transaction)
 	if err != nil {
 		t.Errorf("Failed to insert unclosed asset name: %v", err)
 	}
 
-	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols()
+	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols(ctx)
 	if err != nil {
 		t.Errorf("Failed to load unclosed asset names: %v", err)
 	}
@@ -95,7 +101,7 @@ func TestInsertUclosedTransaction(t *testing.T) {
 		t.Errorf("Expected unclosed asset name 'Apple', but got %v", tickerSymbols)
 	}
 
-	unclosedTransactions, err := store.ReadAllUnclosedTransactions()
+	unclosedTransactions, err := store.ReadAllUnclosedTransactions(ctx)
 	if err != nil {
 		t.Errorf("Failed to load unclosed transactions: %v", err)
 	}
@@ -117,12 +123,12 @@ func TestInsertUclosedTransaction(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err = store.AddUnclosedTransaction(*transaction)
+	err = store.AddUnclosedTransaction(ctx, *transaction)
 	if err != nil {
 		t.Errorf("Failed to insert unclosed asset name: %v", err)
 	}
 
-	unclosedTransactions, err = store.ReadAllUnclosedTransactions()
+	unclosedTransactions, err = store.ReadAllUnclosedTransactions(ctx)
 	if err != nil {
 		t.Errorf("Failed to load unclosed transactions: %v", err)
 	}
@@ -133,6 +139,7 @@ func TestInsertUclosedTransaction(t *testing.T) {
 }
 
 func TestInsertRealizedGains(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 
 	Id1 := uuid.New() //Wird für die Foreign Key-Referenz benötigt.
@@ -151,7 +158,7 @@ func TestInsertRealizedGains(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err := store.AddTransaction(transaction)
+	err := store.AddTransaction(ctx, transaction)
 	if err != nil {
 		t.Errorf("Failed to insert transaction: %v", err)
 	}
@@ -168,7 +175,7 @@ func TestInsertRealizedGains(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err = store.AddTransaction(transaction)
+	err = store.AddTransaction(ctx, transaction)
 	if err != nil {
 		t.Errorf("Failed to insert transaction: %v", err)
 	}
@@ -187,12 +194,12 @@ func TestInsertRealizedGains(t *testing.T) {
 		Currency:          "USD",
 	}
 
-	err = store.AddRealizedGain(*gain)
+	err = store.AddRealizedGain(ctx, *gain)
 	if err != nil {
 		t.Errorf("Failed to insert realized gain: %v", err)
 	}
 
-	realizedGains, err := store.ReadAllRealizedGains()
+	realizedGains, err := store.ReadAllRealizedGains(ctx)
 	if err != nil {
 		t.Errorf("Failed to load realized gains: %v", err)
 	}
@@ -212,12 +219,12 @@ func TestInsertRealizedGains(t *testing.T) {
 		t.Errorf("Expected %+v, but got %+v", gain, realizedGains[0])
 	}
 
-	err = store.RemoveAllRealizedGains()
+	err = store.RemoveAllRealizedGains(ctx)
 	if err != nil {
 		t.Errorf("Failed to remove all realized gains: %v", err)
 	}
 
-	realizedGains, err = store.ReadAllRealizedGains()
+	realizedGains, err = store.ReadAllRealizedGains(ctx)
 	if err != nil {
 		t.Errorf("Failed to load realized gains: %v", err)
 	}
@@ -228,6 +235,7 @@ func TestInsertRealizedGains(t *testing.T) {
 }
 
 func TestLoadTransactionByParams(t *testing.T) {
+	ctx := context.Background()
 	store := setupTestStore(t)
 
 	transaction := &Transaction{
@@ -242,12 +250,12 @@ func TestLoadTransactionByParams(t *testing.T) {
 		Fees:            1.5,
 		Currency:        "USD"}
 
-	err := store.AddTransaction(transaction)
+	err := store.AddTransaction(ctx, transaction)
 	if err != nil {
 		t.Errorf("Failed to insert transaction: %v", err)
 	}
 
-	loadedTransaction, err := store.LoadTransactionByParams(transaction.Date, transaction.TransactionType, transaction.TickerSymbol)
+	loadedTransaction, err := store.LoadTransactionByParams(ctx, transaction.Date, transaction.TransactionType, transaction.TickerSymbol)
 	if err != nil {
 		t.Errorf("Failed to load transaction by params: %v", err)
 	}
@@ -260,7 +268,7 @@ func TestLoadTransactionByParams(t *testing.T) {
 	}
 
 	//Teste das Laden einer nicht existierenden Transaktion
-	loadedTransaction, err = store.LoadTransactionByParams(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), "sell", "MSFT")
+	loadedTransaction, err = store.LoadTransactionByParams(ctx, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), "sell", "MSFT")
 	if err != nil {
 		t.Errorf("Failed to load transaction by params: %v", err)
 	}
@@ -268,3 +276,592 @@ func TestLoadTransactionByParams(t *testing.T) {
 		t.Errorf("Expected nil for non-existing transaction, but got: %+v", loadedTransaction)
 	}
 }
+
+func TestSavingsPlans(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+
+	endDate := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	plan := &SavingsPlan{
+		Id:           uuid.New(),
+		AssetType:    "etf",
+		Asset:        "MSCI World",
+		TickerSymbol: "EUNL",
+		Amount:       100,
+		Currency:     "EUR",
+		Interval:     "monthly",
+		ExecutionDay: 1,
+		StartDate:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:      &endDate,
+	}
+
+	err := store.AddSavingsPlan(ctx, plan)
+	if err != nil {
+		t.Fatalf("Failed to insert savings plan: %v", err)
+	}
+
+	lastDueDate := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	plan.LastDueDate = &lastDueDate
+	err = store.UpdateSavingsPlan(ctx, plan)
+	if err != nil {
+		t.Fatalf("Failed to update savings plan: %v", err)
+	}
+
+	plans, err := store.ReadAllSavingsPlans(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load savings plans: %v", err)
+	}
+	if len(plans) != 1 || plans[0].Interval != "monthly" || plans[0].EndDate == nil || !plans[0].EndDate.Equal(endDate) ||
+		plans[0].LastDueDate == nil || !plans[0].LastDueDate.Equal(lastDueDate) {
+		t.Fatalf("Expected %+v, but got %+v", plan, plans)
+	}
+
+	pending := &PendingTransaction{
+		Id:            uuid.New(),
+		SavingsPlanId: plan.Id,
+		DueDate:       lastDueDate,
+		AssetType:     plan.AssetType,
+		Asset:         plan.Asset,
+		TickerSymbol:  plan.TickerSymbol,
+		Amount:        plan.Amount,
+		Currency:      plan.Currency,
+	}
+	err = store.AddPendingTransaction(ctx, pending)
+	if err != nil {
+		t.Fatalf("Failed to insert pending transaction: %v", err)
+	}
+
+	loaded, err := store.LoadPendingTransaction(ctx, pending.Id)
+	if err != nil || loaded == nil || *loaded != *pending {
+		t.Errorf("Expected %+v, but got %+v (%v)", pending, loaded, err)
+	}
+
+	//Mit dem Sparplan werden auch die offenen Transaktionen gelöscht
+	err = store.RemoveSavingsPlan(ctx, plan.Id)
+	if err != nil {
+		t.Fatalf("Failed to remove savings plan: %v", err)
+	}
+	pendingTransactions, err := store.ReadAllPendingTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load pending transactions: %v", err)
+	}
+	if len(pendingTransactions) != 0 {
+		t.Errorf("Expected 0 pending transactions, but got %d", len(pendingTransactions))
+	}
+}
+
+func TestUpdateAndRemoveTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+
+	transaction := &Transaction{
+		Id:              uuid.New(),
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+
+	err := store.AddTransaction(ctx, transaction)
+	if err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+
+	transaction.Price = 105
+	err = store.UpdateTransaction(ctx, transaction)
+	if err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+
+	loadedTransaction, err := store.LoadTransactionById(ctx, transaction.Id)
+	if err != nil || loadedTransaction == nil {
+		t.Fatalf("Failed to load transaction by id: %v", err)
+	}
+	if *loadedTransaction != *transaction {
+		t.Errorf("Loaded transaction does not match updated: %+v != %+v", loadedTransaction, transaction)
+	}
+
+	err = store.RemoveTransaction(ctx, transaction.Id)
+	if err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+
+	loadedTransaction, err = store.LoadTransactionById(ctx, transaction.Id)
+	if err != nil || loadedTransaction != nil {
+		t.Errorf("Expected removed transaction to be gone, got %+v (%v)", loadedTransaction, err)
+	}
+
+	//Nicht vorhandene Transaktionen können weder geändert noch gelöscht werden
+	if err = store.UpdateTransaction(ctx, transaction); err == nil {
+		t.Error("Expected error when updating a missing transaction, but got none")
+	}
+	if err = store.RemoveTransaction(ctx, transaction.Id); err == nil {
+		t.Error("Expected error when removing a missing transaction, but got none")
+	}
+}
+
+func TestOrderNumberIsUnique(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+
+	transaction := &Transaction{
+		Id:              uuid.New(),
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD",
+		Broker:          "comdirect",
+		OrderNumber:     "4711",
+		ExecutionId:     "1"}
+
+	err := store.AddTransaction(ctx, transaction)
+	if err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+
+	loadedTransaction, err := store.LoadTransactionByOrderNumber(ctx, "comdirect", "4711", "1")
+	if err != nil {
+		t.Fatalf("Failed to load transaction by order number: %v", err)
+	}
+	if loadedTransaction == nil || *loadedTransaction != *transaction {
+		t.Errorf("Loaded transaction does not match original: %+v != %+v", loadedTransaction, transaction)
+	}
+
+	//Zweite Teilausführung desselben Auftrags ist erlaubt
+	partial := *transaction
+	partial.Id = uuid.New()
+	partial.ExecutionId = "2"
+	err = store.AddTransaction(ctx, &partial)
+	if err != nil {
+		t.Fatalf("Failed to insert second execution of the order: %v", err)
+	}
+
+	//Dieselbe Ausführung ein zweites Mal wird vom Index abgelehnt
+	duplicate := *transaction
+	duplicate.Id = uuid.New()
+	err = store.AddTransaction(ctx, &duplicate)
+	if err == nil {
+		t.Error("Expected error when inserting the same execution twice, but got none")
+	}
+
+	//Ohne Auftragsnummer greift der Index nicht
+	withoutOrder := *transaction
+	withoutOrder.Broker, withoutOrder.OrderNumber, withoutOrder.ExecutionId = "", "", ""
+	for i := 0; i < 2; i++ {
+		withoutOrder.Id = uuid.New()
+		err = store.AddTransaction(ctx, &withoutOrder)
+		if err != nil {
+			t.Fatalf("Failed to insert transaction without order number: %v", err)
+		}
+	}
+
+	transactions, err := store.ReadTransactionsByTickerSymbol(ctx, "AAPL")
+	if err != nil {
+		t.Fatalf("Failed to read transactions by ticker symbol: %v", err)
+	}
+	if len(transactions) != 4 {
+		t.Errorf("Expected 4 AAPL transactions, got %d", len(transactions))
+	}
+}
+
+func TestUpdateAndRemoveUnclosedTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+
+	first := Transaction{
+		Id:              uuid.New(),
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+	second := first
+	second.Id = uuid.New()
+	second.Price = 160
+
+	for _, transaction := range []Transaction{first, second} {
+		if err := store.AddUnclosedTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to insert unclosed transaction: %v", err)
+		}
+	}
+
+	first.Quantity = 4
+	if err := store.UpdateUnclosedTransaction(ctx, first); err != nil {
+		t.Fatalf("Failed to update unclosed transaction: %v", err)
+	}
+	unclosedTransactions, _ := store.ReadAllUnclosedTransactions(ctx)
+	lots := unclosedTransactions["AAPL"]
+	if len(lots) != 2 || lots[0] != first || lots[1] != second {
+		t.Errorf("Expected updated first lot and unchanged second lot, got %+v", lots)
+	}
+
+	if err := store.RemoveUnclosedTransaction(ctx, first.Id); err != nil {
+		t.Fatalf("Failed to remove unclosed transaction: %v", err)
+	}
+	tickerSymbols, _ := store.ReadAllUnclosedTickerSymbols(ctx)
+	if len(tickerSymbols) != 1 {
+		t.Errorf("Expected asset to stay unclosed while a lot is open, got %v", tickerSymbols)
+	}
+
+	if err := store.RemoveUnclosedTransaction(ctx, second.Id); err != nil {
+		t.Fatalf("Failed to remove unclosed transaction: %v", err)
+	}
+	tickerSymbols, _ = store.ReadAllUnclosedTickerSymbols(ctx)
+	if len(tickerSymbols) != 0 {
+		t.Errorf("Expected no unclosed assets, got %v", tickerSymbols)
+	}
+
+	if err := store.UpdateUnclosedTransaction(ctx, first); err == nil {
+		t.Error("Expected error when updating a removed unclosed transaction, but got none")
+	}
+	if err := store.RemoveUnclosedTransaction(ctx, first.Id); err == nil {
+		t.Error("Expected error when removing a removed unclosed transaction, but got none")
+	}
+}
+
+func TestTransactionCommitAndRollback(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+
+	transaction := &Transaction{
+		Id:              uuid.New(),
+		Date:            time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		TransactionType: "buy",
+		AssetType:       "stock",
+		Asset:           "Apple",
+		TickerSymbol:    "AAPL",
+		Quantity:        10,
+		Price:           150,
+		Fees:            1.5,
+		Currency:        "USD"}
+
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.Begin(ctx); err == nil {
+		t.Error("Expected error when beginning a nested transaction, but got none")
+	}
+	if err := store.AddTransaction(ctx, transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	if err := store.AddUnclosedTransaction(ctx, *transaction); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+	if err := store.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback transaction: %v", err)
+	}
+
+	transactions, _ := store.ReadAllTransactions(ctx)
+	unclosedTransactions, _ := store.ReadAllUnclosedTransactions(ctx)
+	if len(transactions) != 0 || len(unclosedTransactions) != 0 {
+		t.Errorf("Expected nothing to be stored after rollback, got %d transactions and %d unclosed assets", len(transactions), len(unclosedTransactions))
+	}
+
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.AddTransaction(ctx, transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	transactions, _ = store.ReadAllTransactions(ctx)
+	if len(transactions) != 1 {
+		t.Errorf("Expected 1 transaction after commit, got %d", len(transactions))
+	}
+
+	if err := store.Commit(); err == nil {
+		t.Error("Expected error when committing without transaction, but got none")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t)
+	auditLog := store.(AuditLog)
+	auditLog.SetActor("cli:fritz")
+
+	transaction := Transaction{Id: uuid.New(), Date: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 150, Fees: 1.5, Currency: "USD"}
+	if err := store.AddTransaction(ctx, &transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	corrected := transaction
+	corrected.Price = 105
+	if err := store.UpdateTransaction(ctx, &corrected); err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+
+	//Zurückgerollte Änderungen stehen nicht im Audit-Log
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.RemoveTransaction(ctx, transaction.Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	if err := store.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback transaction: %v", err)
+	}
+
+	entries, err := auditLog.ReadAuditLog(ctx, AuditFilter{EntityId: transaction.Id.String()})
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Operation != "UpdateTransaction" || entries[1].Operation != "AddTransaction" {
+		t.Fatalf("Expected update and add entries, but got %+v", entries)
+	}
+	var before, after Transaction
+	if err = json.Unmarshal(entries[0].Before, &before); err != nil || before.Price != 150 {
+		t.Errorf("Expected price 150 before the update, but got %s (%v)", entries[0].Before, err)
+	}
+	if err = json.Unmarshal(entries[0].After, &after); err != nil || after.Price != 105 {
+		t.Errorf("Expected price 105 after the update, but got %s (%v)", entries[0].After, err)
+	}
+	if entries[0].Actor != "cli:fritz" || entries[1].Before != nil {
+		t.Errorf("Unexpected add entry %+v", entries[1])
+	}
+
+	limited, _ := auditLog.ReadAuditLog(ctx, AuditFilter{Operation: "AddTransaction", From: time.Now().Add(-time.Hour), Limit: 1})
+	if len(limited) != 1 || limited[0].Id != entries[1].Id {
+		t.Errorf("Expected only the add entry, but got %+v", limited)
+	}
+	future, _ := auditLog.ReadAuditLog(ctx, AuditFilter{From: time.Now().Add(time.Hour)})
+	if len(future) != 0 {
+		t.Errorf("Expected no entries in the future, but got %+v", future)
+	}
+
+	//Beim Löschen aller Einträge steht nur die Anzahl im Audit-Log
+	for range 2 {
+		if err := store.AddUnclosedTransaction(ctx, Transaction{Id: uuid.New(), Date: transaction.Date, TransactionType: "buy",
+			AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 5, Price: 150, Currency: "USD"}); err != nil {
+			t.Fatalf("Failed to insert unclosed transaction: %v", err)
+		}
+	}
+	if err := store.RemoveAllUnclosedTransactions(ctx); err != nil {
+		t.Fatalf("Failed to remove unclosed transactions: %v", err)
+	}
+	removed, _ := auditLog.ReadAuditLog(ctx, AuditFilter{Operation: "RemoveAllUnclosedTransactions"})
+	var summary AuditSummary
+	if len(removed) != 1 || json.Unmarshal(removed[0].Before, &summary) != nil || summary.Count != 2 {
+		t.Errorf("Expected a count of 2 removed unclosed transactions, but got %+v", removed)
+	}
+
+	//Ohne Audit wird die Änderung gespeichert, aber nicht protokolliert
+	if err := store.AddRealizedGain(WithoutAudit(ctx), RealizedGain{Id: uuid.New(), SellTransactionId: transaction.Id,
+		BuyTransactionId: transaction.Id, Asset: "Apple", Currency: "USD"}); err != nil {
+		t.Fatalf("Failed to insert realized gain: %v", err)
+	}
+	gains, _ := store.ReadAllRealizedGains(ctx)
+	unaudited, _ := auditLog.ReadAuditLog(ctx, AuditFilter{Entity: "realizedGain"})
+	if len(gains) != 1 || len(unaudited) != 0 {
+		t.Errorf("Expected one realized gain without audit entry, but got %d gains and %+v", len(gains), unaudited)
+	}
+}
+
+func TestOldSnapshotsAreRemoved(t *testing.T) {
+	ctx := context.Background()
+	store := GetMemoryDatabase()
+	store.Open()
+	t.Cleanup(func() {
+		store.Close()
+	})
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	for sequence := int64(1); sequence <= 3; sequence++ {
+		snapshot := DepotSnapshot{EventSequence: sequence * 100, CreatedAt: time.Now(), State: []byte("{}")}
+		if err := store.AddSnapshot(ctx, &snapshot); err != nil {
+			t.Fatalf("Failed to add snapshot: %v", err)
+		}
+	}
+	var count int
+	var sequence int64
+	if err := store.db.QueryRow("SELECT COUNT(*), MAX(eventSequence) FROM depot_snapshots;").Scan(&count, &sequence); err != nil {
+		t.Fatalf("Failed to count snapshots: %v", err)
+	}
+	if count != 1 || sequence != 300 {
+		t.Errorf("Expected only the snapshot after event 300, but got %d snapshots up to %d", count, sequence)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestStore(t).(Migrator)
+
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read migration status: %v", err)
+	}
+	if len(states) != LatestSchemaVersion() {
+		t.Fatalf("Expected %d migrations, got %d", LatestSchemaVersion(), len(states))
+	}
+	for _, state := range states {
+		if !state.Applied || state.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied on a new database", state.Version)
+		}
+	}
+
+	//Zurück auf das ursprüngliche Schema und wieder hoch
+	if err := store.MigrateTo(ctx, 1); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	states, _ = store.MigrationStatus(ctx)
+	if !states[0].Applied || states[1].Applied {
+		t.Errorf("Expected only migration 1 to be applied, got %+v", states)
+	}
+	if _, err := store.(Store).ReadAllSavingsPlans(ctx); err == nil {
+		t.Error("Expected error when reading savings plans without table, but got none")
+	}
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if _, err := store.(Store).ReadAllSavingsPlans(ctx); err != nil {
+		t.Errorf("Failed to read savings plans after migrate up: %v", err)
+	}
+
+	if err := store.MigrateTo(ctx, LatestSchemaVersion()+1); err == nil {
+		t.Error("Expected error when migrating to an unknown version, but got none")
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	store := GetMemoryDatabase()
+	store.Open()
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	//Schema einer Datenbank aus der Zeit vor den Migrationen
+	for _, sqlStmt := range migrations[0].up {
+		if _, err := store.db.Exec(sqlStmt); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+	_, err := store.db.Exec("INSERT INTO transactions (id, date, transactionType, assetType, asset, tickerSymbol, quantity, price, fees, currency) "+
+		"VALUES (?, ?, 'buy', 'stock', 'Apple', 'AAPL', 10, 150, 1.5, 'USD');", uuid.New(), time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to insert legacy transaction: %v", err)
+	}
+
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read migration status: %v", err)
+	}
+	if !states[0].Applied || states[1].Applied {
+		t.Errorf("Expected legacy database to be at version 1, got %+v", states)
+	}
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions after migration: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].Sequence != 0 || transactions[0].OrderNumber != "" {
+		t.Errorf("Expected legacy transaction with default values, got %+v", transactions)
+	}
+}
+
+func TestFileDatabase(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "depot.sqlite")
+	store := GetFileDatabase(filePath)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	//Die PRAGMAs gelten für jede Verbindung des Pools, nicht nur für die erste
+	var foreignKeys int
+	var journalMode string
+	if err := store.db.QueryRow("PRAGMA foreign_keys;").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("Expected foreign keys to be enabled, got %d (%v)", foreignKeys, err)
+	}
+	if err := store.db.QueryRow("PRAGMA journal_mode;").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("Expected WAL journal mode, got %q (%v)", journalMode, err)
+	}
+
+	plan := &SavingsPlan{
+		Id:           uuid.New(),
+		AssetType:    "etf",
+		Asset:        "MSCI World",
+		TickerSymbol: "EUNL",
+		Amount:       100,
+		Currency:     "EUR",
+		Interval:     "monthly",
+		ExecutionDay: 1,
+		StartDate:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := store.AddSavingsPlan(ctx, plan); err != nil {
+		t.Fatalf("Failed to insert savings plan: %v", err)
+	}
+	pending := &PendingTransaction{Id: uuid.New(), SavingsPlanId: plan.Id, DueDate: plan.StartDate, Amount: 100}
+	if err := store.AddPendingTransaction(ctx, pending); err != nil {
+		t.Fatalf("Failed to insert pending transaction: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	//Nach erneutem Öffnen muss ON DELETE CASCADE greifen
+	store = GetFileDatabase(filePath)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	if _, err := store.db.Exec("DELETE FROM savings_plans WHERE id = ?;", plan.Id); err != nil {
+		t.Fatalf("Failed to delete savings plan: %v", err)
+	}
+	pendingTransactions, err := store.ReadAllPendingTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read pending transactions: %v", err)
+	}
+	if len(pendingTransactions) != 0 {
+		t.Errorf("Expected pending transactions to be removed by cascade, got %d", len(pendingTransactions))
+	}
+
+	//Eine offene Transaktion ohne Sparplan verletzt den Fremdschlüssel
+	if err := store.AddPendingTransaction(ctx, pending); err == nil {
+		t.Error("Expected foreign key error for pending transaction without savings plan, but got none")
+	}
+}
+
+func TestPostgresDialect(t *testing.T) {
+	dialect := postgresDialect{}
+
+	query := dialect.translate("SELECT id FROM transactions WHERE orderNumber <> '' AND broker = ? AND date = ?;")
+	expected := "SELECT id FROM transactions WHERE orderNumber <> '' AND broker = $1 AND date = $2;"
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+
+	query = dialect.translate("CREATE TABLE x (id TEXT(36) not null primary key, n INTEGER PRIMARY KEY AUTOINCREMENT, " +
+		"date DATETIME, price REAL, isProfit INTEGER, note TEXT DEFAULT '?');")
+	expected = "CREATE TABLE x (id TEXT not null primary key, n SERIAL PRIMARY KEY, " +
+		"date TIMESTAMPTZ, price DOUBLE PRECISION, isProfit BOOLEAN, note TEXT DEFAULT '?');"
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sqlDialect passt die für SQLite geschriebenen Anweisungen in DatabaseStorage und in den
+// Migrationen an eine andere Datenbank an. Ohne Dialekt (nil) werden sie unverändert verwendet.
+type sqlDialect interface {
+	translate(query string) string
+	tableExistsQuery() string
+}
+
+type postgresDialect struct{}
+
+// Ersetzungen der SQLite-Datentypen durch die passenden PostgreSQL-Datentypen
+var postgresTypes = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\bINTEGER PRIMARY KEY AUTOINCREMENT\b`), "SERIAL PRIMARY KEY"},
+	{regexp.MustCompile(`\bTEXT\(\d+\)`), "TEXT"},
+	{regexp.MustCompile(`\bDATETIME\b`), "TIMESTAMPTZ"},
+	{regexp.MustCompile(`\bREAL\b`), "DOUBLE PRECISION"},
+	{regexp.MustCompile(`\bisProfit INTEGER\b`), "isProfit BOOLEAN"},
+}
+
+func (d postgresDialect) translate(query string) string {
+	for _, t := range postgresTypes {
+		query = t.pattern.ReplaceAllString(query, t.replacement)
+	}
+	return rebindPlaceholders(query)
+}
+
+func (d postgresDialect) tableExistsQuery() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?;"
+}
+
+// rebindPlaceholders ersetzt die Platzhalter ? durch $1, $2, ... Fragezeichen in
+// Zeichenketten ('...') bleiben erhalten.
+func rebindPlaceholders(query string) string {
+	var b strings.Builder
+	inString := false
+	count := 0
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+			b.WriteRune(r)
+		case r == '?' && !inString:
+			count++
+			b.WriteString("$" + strconv.Itoa(count))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// dialectExecutor übersetzt jede Anweisung, bevor sie an die Datenbank geht.
+type dialectExecutor struct {
+	db      dbExecutor
+	dialect sqlDialect
+}
+
+func (e dialectExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return e.db.ExecContext(ctx, e.dialect.translate(query), args...)
+}
+
+func (e dialectExecutor) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return e.db.QueryContext(ctx, e.dialect.translate(query), args...)
+}
+
+func (e dialectExecutor) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return e.db.QueryRowContext(ctx, e.dialect.translate(query), args...)
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formate und Aufteilungen des DocumentStorage
+const (
+	DocumentFormatJSON = "json"
+	DocumentFormatYAML = "yaml"
+
+	DocumentLayoutSingle = "single" //Alle Transaktionen in transactions.json
+	DocumentLayoutYearly = "yearly" //Ein Dokument pro Jahr, z.B. transactions-2025.json
+)
+
+// DocumentStorage speichert das Depot als lesbare JSON- oder YAML-Dokumente in einem Verzeichnis,
+// z.B. für Depots in einem Git-Repository. Sortierung und Formatierung sind stabil, damit eine
+// Änderung nur die betroffenen Zeilen ändert. Offene Positionen, Abrechnungen, Sparpläne und offene
+// Sparplan-Transaktionen liegen als eigene Dokumente daneben (lots, gains, savingsplans, pending).
+type DocumentStorage struct {
+	memoryState
+	directory string
+	format    string
+	layout    string
+}
+
+// Die Dokumente haben jeweils eine Version und eine Liste. So können später weitere Felder hinzukommen.
+const documentVersion = 1
+
+type transactionDocument struct {
+	Version      int           `json:"version" yaml:"version"`
+	Transactions []Transaction `json:"transactions" yaml:"transactions"`
+}
+
+type lotDocument struct {
+	Version int           `json:"version" yaml:"version"`
+	Lots    []Transaction `json:"lots" yaml:"lots"` //In der Reihenfolge der Abrechnung (FIFO)
+}
+
+type gainDocument struct {
+	Version       int            `json:"version" yaml:"version"`
+	RealizedGains []RealizedGain `json:"realizedGains" yaml:"realizedGains"`
+}
+
+type savingsPlanDocument struct {
+	Version      int           `json:"version" yaml:"version"`
+	SavingsPlans []SavingsPlan `json:"savingsPlans" yaml:"savingsPlans"`
+}
+
+type pendingDocument struct {
+	Version             int                  `json:"version" yaml:"version"`
+	PendingTransactions []PendingTransaction `json:"pendingTransactions" yaml:"pendingTransactions"`
+}
+
+// SetLayout legt fest, ob die Transaktionen in einem Dokument oder in einem Dokument pro Jahr gespeichert werden.
+// Es muss vor Open gesetzt werden.
+func (s *DocumentStorage) SetLayout(layout string) error {
+	if layout == "" {
+		layout = DocumentLayoutSingle
+	}
+	if layout != DocumentLayoutSingle && layout != DocumentLayoutYearly {
+		return fmt.Errorf("document layout %q not supported", layout)
+	}
+	s.layout = layout
+	return nil
+}
+
+func (s *DocumentStorage) Open() error {
+	//Wurde das Schreiben der Dokumente unterbrochen, wird es zuerst fertiggestellt
+	err := completeReplace(s.replaceLogPath())
+	if err != nil {
+		return err
+	}
+	state := memoryState{}
+	transactionFiles, err := s.transactionFiles()
+	if err != nil {
+		return err
+	}
+	for _, path := range transactionFiles {
+		var document transactionDocument
+		err = s.readDocument(path, &document)
+		if err != nil {
+			return err
+		}
+		state.transactions = append(state.transactions, document.Transactions...)
+	}
+
+	var lots lotDocument
+	var gains gainDocument
+	var plans savingsPlanDocument
+	var pending pendingDocument
+	for name, document := range map[string]any{"lots": &lots, "gains": &gains, "savingsplans": &plans, "pending": &pending} {
+		path := s.documentPath(name)
+		if !fileExists(path) {
+			continue
+		}
+		err = s.readDocument(path, document)
+		if err != nil {
+			return err
+		}
+	}
+	state.unclosed = lots.Lots
+	state.realizedGains = gains.RealizedGains
+	state.savingsPlans = plans.SavingsPlans
+	state.pending = pending.PendingTransactions
+	state.changed = s.save
+	s.memoryState = state
+	return nil
+}
+
+func (s *DocumentStorage) Close() error {
+	return nil
+}
+
+// CreateDatabase legt das Verzeichnis und ein leeres Transaktionsdokument an, falls es sie noch nicht gibt.
+func (s *DocumentStorage) CreateDatabase(ctx context.Context) error {
+	err := os.MkdirAll(s.directory, 0755)
+	if err != nil {
+		return err
+	}
+	files, err := s.transactionFiles()
+	if err != nil || len(files) > 0 {
+		return err
+	}
+	s.transactionsChanged = true
+	return s.save()
+}
+
+func (s *DocumentStorage) Ping(ctx context.Context) error {
+	info, err := os.Stat(s.directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.directory)
+	}
+	return nil
+}
+
+func (s *DocumentStorage) documentPath(name string) string {
+	return filepath.Join(s.directory, name+"."+s.format)
+}
+
+// transactionFiles liefert die vorhandenen Transaktionsdokumente, bei Aufteilung nach Jahren aufsteigend sortiert.
+func (s *DocumentStorage) transactionFiles() ([]string, error) {
+	if s.layout == DocumentLayoutSingle {
+		path := s.documentPath("transactions")
+		if !fileExists(path) {
+			return nil, nil
+		}
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(s.directory, "transactions-*."+s.format))
+	if err != nil {
+		return nil, err
+	}
+	//Nur Dateien, deren Name ein Jahr ist. Die Namen haben gleich viele Stellen und sind damit sortiert.
+	files = slices.DeleteFunc(files, func(path string) bool {
+		_, err := strconv.Atoi(s.yearOfFile(path))
+		return err != nil
+	})
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *DocumentStorage) yearOfFile(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), "."+s.format)
+	return strings.TrimPrefix(name, "transactions-")
+}
+
+func (s *DocumentStorage) readDocument(path string, document any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if s.format == DocumentFormatYAML {
+		err = yaml.Unmarshal(content, document)
+	} else {
+		err = json.Unmarshal(content, document)
+	}
+	if err != nil {
+		return fmt.Errorf("error at read %s. %w", path, err)
+	}
+	return nil
+}
+
+// encode formatiert das Dokument immer gleich: zwei Leerzeichen Einrückung und ein Zeilenumbruch am Ende.
+func (s *DocumentStorage) encode(document any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if s.format == DocumentFormatYAML {
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(document)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		return buffer.Bytes(), err
+	}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(document)
+	return buffer.Bytes(), err
+}
+
+// save schreibt alle Dokumente über replaceFiles. Die Transaktionen werden nur bei Änderungen
+// neu geschrieben, chronologisch sortiert wie bei ReadAllTransactions.
+func (s *DocumentStorage) save() error {
+	var files []fileContent
+	var remove []string
+	add := func(path string, document any) error {
+		content, err := s.encode(document)
+		if err != nil {
+			return fmt.Errorf("error at write %s. %w", path, err)
+		}
+		files = append(files, fileContent{path: path, content: content})
+		return nil
+	}
+
+	if s.transactionsChanged {
+		transactions := s.sortedTransactions()
+		if s.layout == DocumentLayoutSingle {
+			err := add(s.documentPath("transactions"), transactionDocument{documentVersion, transactions})
+			if err != nil {
+				return err
+			}
+		} else {
+			byYear := make(map[string][]Transaction)
+			for _, transaction := range transactions {
+				year := fmt.Sprintf("%04d", transaction.Date.Year())
+				byYear[year] = append(byYear[year], transaction)
+			}
+			existing, err := s.transactionFiles()
+			if err != nil {
+				return err
+			}
+			//Jahre ohne Transaktionen werden gelöscht
+			for _, path := range existing {
+				if _, exists := byYear[s.yearOfFile(path)]; !exists {
+					remove = append(remove, path)
+				}
+			}
+			for year, yearTransactions := range byYear {
+				err = add(s.documentPath("transactions-"+year), transactionDocument{documentVersion, yearTransactions})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	//Leere Dokumente werden erst angelegt, wenn sie gebraucht werden
+	documents := []struct {
+		name     string
+		count    int
+		document any
+	}{
+		{"lots", len(s.unclosed), lotDocument{documentVersion, emptyIfNil(s.unclosed)}},
+		{"gains", len(s.realizedGains), gainDocument{documentVersion, emptyIfNil(s.realizedGains)}},
+		{"savingsplans", len(s.savingsPlans), savingsPlanDocument{documentVersion, emptyIfNil(s.savingsPlans)}},
+		{"pending", len(s.pending), pendingDocument{documentVersion, s.sortedPending()}},
+	}
+	for _, d := range documents {
+		path := s.documentPath(d.name)
+		if d.count == 0 && !fileExists(path) {
+			continue
+		}
+		err := add(path, d.document)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(s.directory, 0755)
+	if err != nil {
+		return err
+	}
+	err = replaceFiles(s.replaceLogPath(), files, remove)
+	if err != nil {
+		return err
+	}
+	s.transactionsChanged = false
+	return nil
+}
+
+// replaceLogPath ist die Protokolldatei von replaceFiles, sie ist nur während des Schreibens vorhanden.
+func (s *DocumentStorage) replaceLogPath() string {
+	return filepath.Join(s.directory, "replace.json")
+}
+
+func (s *DocumentStorage) sortedPending() []PendingTransaction {
+	pending, _ := s.ReadAllPendingTransactions(context.Background())
+	return pending
+}
+
+// emptyIfNil schreibt leere Listen als [] statt null.
+func emptyIfNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// GetDocumentStorage liefert einen DocumentStorage im Verzeichnis directory mit dem Format json oder yaml.
+func GetDocumentStorage(directory string, format string) (*DocumentStorage, error) {
+	if format != DocumentFormatJSON && format != DocumentFormatYAML {
+		return nil, fmt.Errorf("document format %q not supported", format)
+	}
+	return &DocumentStorage{
+		directory: directory,
+		format:    format,
+		layout:    DocumentLayoutSingle,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDocumentStorageYearlyLayout(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	store, _ := GetDocumentStorage(directory, DocumentFormatYAML)
+	if err := store.SetLayout(DocumentLayoutYearly); err != nil {
+		t.Fatalf("Failed to set layout: %v", err)
+	}
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open document storage: %v", err)
+	}
+
+	older := Transaction{Id: uuid.New(), Date: time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100.5, Fees: 4, Currency: "EUR"}
+	newer := older
+	newer.Id = uuid.New()
+	newer.Date = time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, transaction := range []Transaction{newer, older} {
+		if err := store.AddTransaction(ctx, &transaction); err != nil {
+			t.Fatalf("Failed to insert transaction: %v", err)
+		}
+	}
+	if err := store.AddUnclosedTransaction(ctx, older); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+
+	for _, name := range []string{"transactions-2024.yaml", "transactions-2025.yaml", "lots.yaml"} {
+		if !fileExists(filepath.Join(directory, name)) {
+			t.Errorf("Expected document %s", name)
+		}
+	}
+	content, _ := os.ReadFile(filepath.Join(directory, "transactions-2025.yaml"))
+	if !strings.Contains(string(content), "id: "+newer.Id.String()) || strings.Contains(string(content), older.Id.String()) {
+		t.Errorf("Expected only the transaction of 2025, but got:\n%s", content)
+	}
+
+	reopened, _ := GetDocumentStorage(directory, DocumentFormatYAML)
+	reopened.SetLayout(DocumentLayoutYearly)
+	if err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to reopen document storage: %v", err)
+	}
+	transactions, _ := reopened.ReadAllTransactions(ctx)
+	if len(transactions) != 2 || transactions[0] != older || transactions[1] != newer {
+		t.Errorf("Expected %+v and %+v, but got %+v", older, newer, transactions)
+	}
+	unclosed, _ := reopened.ReadAllUnclosedTransactions(ctx)
+	if len(unclosed["AAPL"]) != 1 || unclosed["AAPL"][0] != older {
+		t.Errorf("Expected lot %+v, but got %+v", older, unclosed)
+	}
+
+	//Ein Jahr ohne Transaktionen verschwindet
+	if err := reopened.RemoveTransaction(ctx, newer.Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	if fileExists(filepath.Join(directory, "transactions-2025.yaml")) {
+		t.Error("Expected document of 2025 to be removed")
+	}
+}
+
+func TestDocumentStorageStableFormat(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	store, _ := GetDocumentStorage(directory, DocumentFormatJSON)
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create document storage: %v", err)
+	}
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open document storage: %v", err)
+	}
+	path := filepath.Join(directory, "transactions.json")
+	empty, _ := os.ReadFile(path)
+	if string(empty) != "{\n  \"version\": 1,\n  \"transactions\": []\n}\n" {
+		t.Errorf("Unexpected empty document:\n%s", empty)
+	}
+
+	transaction := Transaction{Id: uuid.New(), Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100.5, Fees: 4, Currency: "EUR"}
+	if err := store.AddTransaction(ctx, &transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	first, _ := os.ReadFile(path)
+
+	//Erneutes Schreiben ohne inhaltliche Änderung ergibt dieselbe Datei
+	if err := store.UpdateTransaction(ctx, &transaction); err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+	second, _ := os.ReadFile(path)
+	if string(first) != string(second) {
+		t.Errorf("Expected identical documents, but got:\n%s\nand\n%s", first, second)
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// EncryptedDatabase ist eine SQLite-Datenbank, die nur verschlüsselt auf der Platte liegt. Zwischen Open und
+// Close liegt sie entschlüsselt im Speicher. Nach jedem Commit und beim Close wird die ganze Datenbank
+// verschlüsselt in die Datei geschrieben. Da jede Änderung über eine Store-Transaktion läuft (siehe audited),
+// gehen nur Änderungen außerhalb des Stores (z.B. Journal ohne Transaktion) erst beim Close in die Datei.
+type EncryptedDatabase struct {
+	sqlStore
+	filePath string
+	key      EncryptionKey
+}
+
+func GetEncryptedDatabase(pathToFile string, key EncryptionKey) *EncryptedDatabase {
+	return &EncryptedDatabase{
+		filePath: pathToFile,
+		key:      key,
+	}
+}
+
+func (s *EncryptedDatabase) Open() error {
+	var err error
+	s.db, err = sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	if err != nil {
+		return err
+	}
+	//Jede Verbindung zu ":memory:" hätte ihre eigene Datenbank. Die Verbindung darf nie geschlossen werden.
+	s.db.SetMaxOpenConns(1)
+	s.db.SetMaxIdleConns(1)
+	s.afterCommit = s.save
+
+	content, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptContent(s.key, content)
+	if err != nil {
+		return fmt.Errorf("error at decrypt %s. %w", s.filePath, err)
+	}
+	return loadSerializedDatabase(s.db, plaintext)
+}
+
+func (s *EncryptedDatabase) CreateDatabase(ctx context.Context) error {
+	err := s.sqlStore.CreateDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *EncryptedDatabase) Ping(ctx context.Context) error {
+	err := s.sqlStore.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(s.filePath)
+	return err
+}
+
+func (s *EncryptedDatabase) Close() error {
+	if s.db == nil {
+		return errors.New("database instance is nil, cannot close")
+	}
+	err := s.save()
+	return errors.Join(err, s.db.Close())
+}
+
+// save verschlüsselt die Datenbank und ersetzt die Datei. Eine leere Datenbank ohne Tabellen wird nicht geschrieben.
+func (s *EncryptedDatabase) save() error {
+	plaintext, err := serializeDatabase(s.db)
+	if err != nil || len(plaintext) == 0 {
+		return err
+	}
+	return writeEncryptedFile(s.filePath, s.key, plaintext)
+}
+
+func writeEncryptedFile(path string, key EncryptionKey, plaintext []byte) error {
+	content, err := encryptContent(key, plaintext)
+	if err != nil {
+		return fmt.Errorf("error at encrypt %s. %w", path, err)
+	}
+	return replaceFiles("", []fileContent{{path: path, content: content}}, nil)
+}
+
+// rawConnection führt action mit der SQLite-Verbindung des Pools aus.
+func rawConnection(db *sql.DB, action func(conn *sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("connection is not a sqlite connection")
+		}
+		return action(sqliteConn)
+	})
+}
+
+func serializeDatabase(db *sql.DB) ([]byte, error) {
+	var content []byte
+	err := rawConnection(db, func(conn *sqlite3.SQLiteConn) error {
+		var err error
+		content, err = conn.Serialize("main")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error at serialize database. %w", err)
+	}
+	return content, nil
+}
+
+// loadSerializedDatabase kopiert eine serialisierte Datenbank mit der Backup-API in db. Eine direkt
+// deserialisierte Datenbank könnte nicht mehr wachsen.
+func loadSerializedDatabase(db *sql.DB, content []byte) error {
+	source, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	source.SetMaxOpenConns(1)
+	//Eine Datei im WAL-Modus lässt sich im Speicher nicht öffnen, daher auf das normale Journal umstellen
+	if len(content) > 19 && content[18] == 2 && content[19] == 2 {
+		content = bytes.Clone(content)
+		content[18], content[19] = 1, 1
+	}
+
+	return rawConnection(source, func(sourceConn *sqlite3.SQLiteConn) error {
+		err := sourceConn.Deserialize(content, "main")
+		if err != nil {
+			return fmt.Errorf("error at load database. %w", err)
+		}
+		return rawConnection(db, func(targetConn *sqlite3.SQLiteConn) error {
+			backup, err := targetConn.Backup("main", sourceConn, "main")
+			if err != nil {
+				return fmt.Errorf("error at load database. %w", err)
+			}
+			_, err = backup.Step(-1)
+			return errors.Join(err, backup.Finish())
+		})
+	})
+}
+
+// EncryptDatabaseFile verschlüsselt eine vorhandene SQLite-Datei in targetPath. Die Quelldatei bleibt
+// unverändert und sollte danach sicher gelöscht werden.
+func EncryptDatabaseFile(sourcePath string, targetPath string, key EncryptionKey) error {
+	if !fileExists(sourcePath) {
+		return fmt.Errorf("database %s not found", sourcePath)
+	}
+	if fileExists(targetPath) {
+		return fmt.Errorf("%s already exists", targetPath)
+	}
+	db, err := sql.Open("sqlite3", fileDatabaseDSN(sourcePath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	//Serialize liefert auch die Änderungen, die noch im Write-Ahead-Log stehen
+	plaintext, err := serializeDatabase(db)
+	if err != nil {
+		return err
+	}
+	return writeEncryptedFile(targetPath, key, plaintext)
+}
+
+// RotateKey verschlüsselt eine Datei mit einem neuen Schlüssel. Die Datei wird erst ersetzt,
+// wenn sie mit dem alten Schlüssel entschlüsselt und mit dem neuen verschlüsselt ist.
+func RotateKey(path string, oldKey EncryptionKey, newKey EncryptionKey) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptContent(oldKey, content)
+	if err != nil {
+		return fmt.Errorf("error at decrypt %s. %w", path, err)
+	}
+	return writeEncryptedFile(path, newKey, plaintext)
+}
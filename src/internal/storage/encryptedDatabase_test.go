@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEncryptedDatabase(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	path := filepath.Join(directory, "depot.sqlite.enc")
+	key, _ := KeyFromPassphrase("correct horse battery staple")
+
+	store := GetEncryptedDatabase(path, key)
+	if err := store.Open(); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := store.CreateDatabase(ctx); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	transaction := Transaction{Id: uuid.New(), Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100.5, Fees: 4, Currency: "EUR"}
+	if err := store.AddTransaction(ctx, &transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+
+	//Schon nach dem Commit steht die Änderung in der Datei, aber nicht im Klartext
+	content, _ := os.ReadFile(path)
+	if !isEncrypted(content) || bytes.Contains(content, []byte("AAPL")) || bytes.Contains(content, []byte("SQLite format")) {
+		t.Error("Expected encrypted file without plain text")
+	}
+	reopened := GetEncryptedDatabase(path, key)
+	if err := reopened.Open(); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	transactions, _ := reopened.ReadAllTransactions(ctx)
+	if len(transactions) != 1 || transactions[0].Id != transaction.Id {
+		t.Errorf("Expected %+v, but got %+v", transaction, transactions)
+	}
+	store.Close()
+
+	//Die geladene Datenbank kann weiter wachsen
+	for i := 0; i < 200; i++ {
+		more := transaction
+		more.Id = uuid.New()
+		more.Sequence = i
+		if err := reopened.AddTransaction(ctx, &more); err != nil {
+			t.Fatalf("Failed to insert transaction %d: %v", i, err)
+		}
+	}
+	reopened.Close()
+
+	wrongKey, _ := KeyFromPassphrase("wrong passphrase")
+	if err := GetEncryptedDatabase(path, wrongKey).Open(); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, but got %v", err)
+	}
+
+	//Schlüsselwechsel auf eine Schlüsseldatei
+	keyFile := filepath.Join(directory, "depot.key")
+	os.WriteFile(keyFile, bytes.Repeat([]byte{7}, 32), 0600)
+	newKey, err := KeyFromFile(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+	if err = RotateKey(path, key, newKey); err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	if err = GetEncryptedDatabase(path, key).Open(); err == nil {
+		t.Error("Expected the old key to fail after rotation, but got none")
+	}
+	rotated := GetEncryptedDatabase(path, newKey)
+	if err = rotated.Open(); err != nil {
+		t.Fatalf("Failed to open database with new key: %v", err)
+	}
+	defer rotated.Close()
+	transactions, _ = rotated.ReadAllTransactions(ctx)
+	if len(transactions) != 201 {
+		t.Errorf("Expected 201 transactions after rotation, but got %d", len(transactions))
+	}
+}
+
+func TestEncryptDatabaseFile(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	plainPath := filepath.Join(directory, "depot.sqlite")
+	plain := GetFileDatabase(plainPath)
+	if err := plain.Open(); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	plain.CreateDatabase(ctx)
+	transaction := Transaction{Id: uuid.New(), Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), TransactionType: "buy",
+		AssetType: "stock", Asset: "Apple", TickerSymbol: "AAPL", Quantity: 10, Price: 100.5, Fees: 4, Currency: "EUR"}
+	if err := plain.AddTransaction(ctx, &transaction); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	//Noch offen, die Änderung steht im Write-Ahead-Log
+	defer plain.Close()
+
+	keyFile := filepath.Join(directory, "depot.key")
+	os.WriteFile(keyFile, []byte(" "+string(bytes.Repeat([]byte("0f"), 32))+"\n"), 0600)
+	key, err := KeyFromFile(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+	encryptedPath := plainPath + ".enc"
+	if err = EncryptDatabaseFile(plainPath, encryptedPath, key); err != nil {
+		t.Fatalf("Failed to encrypt database: %v", err)
+	}
+	if err = EncryptDatabaseFile(plainPath, encryptedPath, key); err == nil {
+		t.Error("Expected error when the encrypted file already exists, but got none")
+	}
+
+	encrypted := GetEncryptedDatabase(encryptedPath, key)
+	if err = encrypted.Open(); err != nil {
+		t.Fatalf("Failed to open encrypted database: %v", err)
+	}
+	defer encrypted.Close()
+	loaded, _ := encrypted.LoadTransactionById(ctx, transaction.Id)
+	if loaded == nil {
+		t.Error("Expected transaction in encrypted database")
+	}
+	if _, err = KeyFromPassphrase("short"); err == nil {
+		t.Error("Expected error for a short passphrase, but got none")
+	}
+}
+
+func TestDamagedEncryptionHeader(t *testing.T) {
+	key, _ := KeyFromPassphrase("correct horse battery")
+	content, err := encryptContent(key, []byte("depot"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt content: %v", err)
+	}
+	headerEnd := len(encryptionMagic) + bytes.IndexByte(content[len(encryptionMagic):], '\n')
+	header := string(content[len(encryptionMagic):headerEnd])
+
+	//Der Header ist noch nicht authentifiziert, bevor der Schlüssel abgeleitet wird
+	for name, damaged := range map[string]string{
+		"no threads":  strings.Replace(header, `"threads":4`, `"threads":0`, 1),
+		"no time":     strings.Replace(header, `"time":3`, `"time":0`, 1),
+		"huge memory": strings.Replace(header, `"memory":65536`, `"memory":4294967295`, 1),
+		"short salt":  `{"version":1,"kdf":"argon2id","salt":"AAAA","time":3,"memory":65536,"threads":4}`,
+	} {
+		if damaged == header {
+			t.Fatalf("%s: header %s was not changed", name, header)
+		}
+		file := append([]byte(encryptionMagic+damaged), content[headerEnd:]...)
+		if _, err := decryptContent(key, file); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("%s: expected ErrWrongKey, but got %v", name, err)
+		}
+	}
+	if plaintext, err := decryptContent(key, content); err != nil || string(plaintext) != "depot" {
+		t.Errorf("Expected the undamaged file to decrypt, but got %q, %v", plaintext, err)
+	}
+}
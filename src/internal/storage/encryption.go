@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Umgebungsvariablen für den Schlüssel verschlüsselter Datenbanken. Die Variablen mit NEW
+// enthalten beim Schlüsselwechsel den neuen Schlüssel.
+const (
+	PassphraseEnv    = "STOCKPORTFOLIO_PASSPHRASE"
+	KeyFileEnv       = "STOCKPORTFOLIO_KEY_FILE"
+	NewPassphraseEnv = "STOCKPORTFOLIO_NEW_PASSPHRASE"
+	NewKeyFileEnv    = "STOCKPORTFOLIO_NEW_KEY_FILE"
+)
+
+// ErrWrongKey wird zurückgegeben, wenn eine Datei mit dem Schlüssel nicht entschlüsselt werden kann.
+// Das ist auch der Fall, wenn die Datei verändert wurde.
+var ErrWrongKey = errors.New("wrong key or damaged file")
+
+// Die Datei beginnt mit einer Kennung und einer Zeile mit dem Header als JSON. Danach folgen Nonce und
+// der mit AES-256-GCM verschlüsselte Inhalt. Der Header wird mit authentifiziert.
+const encryptionMagic = "STOCKPORTFOLIO-ENCRYPTED\n"
+
+const (
+	kdfArgon2id = "argon2id" //Schlüssel aus einer Passphrase
+	kdfKeyFile  = "keyfile"  //Schlüssel direkt aus einer Schlüsseldatei
+)
+
+type encryptionHeader struct {
+	Version int    `json:"version"`
+	Kdf     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"` //In KiB
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+// EncryptionKey ist entweder eine Passphrase, aus der mit Argon2id und einem zufälligen Salt der
+// Schlüssel abgeleitet wird, oder ein Schlüssel mit 32 Byte aus einer Schlüsseldatei.
+type EncryptionKey struct {
+	passphrase []byte
+	key        []byte
+	derived    *derivedKey
+}
+
+// derivedKey merkt sich Salt und abgeleiteten Schlüssel, damit Argon2id beim Speichern nach
+// jedem Commit nicht erneut rechnen muss. Die Nonce ist trotzdem bei jedem Schreiben neu.
+type derivedKey struct {
+	salt   []byte
+	secret []byte
+}
+
+// KeyFromPassphrase liefert einen Schlüssel aus einer Passphrase.
+func KeyFromPassphrase(passphrase string) (EncryptionKey, error) {
+	if len(passphrase) < 8 {
+		return EncryptionKey{}, errors.New("passphrase must have at least 8 characters")
+	}
+	return EncryptionKey{passphrase: []byte(passphrase), derived: &derivedKey{}}, nil
+}
+
+// KeyFromFile liest einen Schlüssel mit 32 Byte aus einer Datei, roh, als Hex oder als Base64,
+// z.B. erzeugt mit "openssl rand -hex 32".
+func KeyFromFile(path string) (EncryptionKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return EncryptionKey{}, fmt.Errorf("error at read key file. %w", err)
+	}
+	if len(content) == 32 {
+		return EncryptionKey{key: content}, nil
+	}
+	text := strings.TrimSpace(string(content))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return EncryptionKey{key: key}, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return EncryptionKey{key: key}, nil
+	}
+	return EncryptionKey{}, fmt.Errorf("key file %s must contain 32 bytes (raw, hex or base64)", path)
+}
+
+// KeyFromEnvironment liefert den Schlüssel aus STOCKPORTFOLIO_KEY_FILE oder STOCKPORTFOLIO_PASSPHRASE.
+func KeyFromEnvironment() (EncryptionKey, error) {
+	return keyFromEnvironment(KeyFileEnv, PassphraseEnv)
+}
+
+// NewKeyFromEnvironment liefert den neuen Schlüssel für einen Schlüsselwechsel.
+func NewKeyFromEnvironment() (EncryptionKey, error) {
+	return keyFromEnvironment(NewKeyFileEnv, NewPassphraseEnv)
+}
+
+func keyFromEnvironment(keyFileEnv string, passphraseEnv string) (EncryptionKey, error) {
+	if path := os.Getenv(keyFileEnv); path != "" {
+		return KeyFromFile(path)
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return KeyFromPassphrase(passphrase)
+	}
+	return EncryptionKey{}, fmt.Errorf("no key for encryption, set %s or %s", keyFileEnv, passphraseEnv)
+}
+
+// Parameter für Argon2id nach RFC 9106 (zweite Empfehlung, 64 MiB)
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+)
+
+// Grenzen für die Parameter aus dem Header. Der Header wird erst mit dem Inhalt authentifiziert,
+// vorher könnte eine veränderte Datei sonst beliebig viel Speicher und Rechenzeit anfordern.
+const (
+	argon2SaltLength = 16
+	argon2MaxTime    = 16
+	argon2MaxMemory  = 1024 * 1024 //1 GiB
+)
+
+// checkArgon2Header prüft die Parameter für Argon2id, bevor damit ein Schlüssel abgeleitet wird.
+func checkArgon2Header(header encryptionHeader) error {
+	if len(header.Salt) != argon2SaltLength || header.Time < 1 || header.Time > argon2MaxTime ||
+		header.Threads < 1 || header.Memory > argon2MaxMemory {
+		return fmt.Errorf("invalid argon2id parameters in encryption header. %w", ErrWrongKey)
+	}
+	return nil
+}
+
+// encryptContent verschlüsselt den Inhalt einer Datei. Für eine Passphrase wird beim ersten Aufruf ein Salt erzeugt.
+func encryptContent(key EncryptionKey, plaintext []byte) ([]byte, error) {
+	header := encryptionHeader{Version: 1, Kdf: kdfKeyFile}
+	secret := key.key
+	if key.passphrase != nil {
+		if key.derived == nil {
+			key.derived = &derivedKey{}
+		}
+		if key.derived.secret == nil {
+			salt := make([]byte, argon2SaltLength)
+			_, err := rand.Read(salt)
+			if err != nil {
+				return nil, err
+			}
+			key.derived.salt = salt
+			key.derived.secret = argon2.IDKey(key.passphrase, salt, argon2Time, argon2Memory, argon2Threads, 32)
+		}
+		header = encryptionHeader{Version: 1, Kdf: kdfArgon2id, Salt: key.derived.salt, Time: argon2Time,
+			Memory: argon2Memory, Threads: argon2Threads}
+		secret = key.derived.secret
+	}
+	if len(secret) != 32 {
+		return nil, errors.New("no key for encryption")
+	}
+
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append([]byte(encryptionMagic), append(headerLine, '\n')...)
+	aead, err := newAead(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	content := append(prefix, nonce...)
+	return aead.Seal(content, nonce, plaintext, prefix), nil
+}
+
+// decryptContent entschlüsselt den Inhalt einer mit encryptContent geschriebenen Datei.
+func decryptContent(key EncryptionKey, content []byte) ([]byte, error) {
+	if !isEncrypted(content) {
+		return nil, errors.New("file is not encrypted")
+	}
+	headerEnd := bytes.IndexByte(content[len(encryptionMagic):], '\n')
+	if headerEnd < 0 {
+		return nil, ErrWrongKey
+	}
+	prefix := content[:len(encryptionMagic)+headerEnd+1]
+	var header encryptionHeader
+	err := json.Unmarshal(prefix[len(encryptionMagic):], &header)
+	if err != nil || header.Version != 1 {
+		return nil, fmt.Errorf("unknown encryption header. %w", ErrWrongKey)
+	}
+
+	var secret []byte
+	switch header.Kdf {
+	case kdfArgon2id:
+		if key.passphrase == nil {
+			return nil, errors.New("file is encrypted with a passphrase, but a key file was given")
+		}
+		err = checkArgon2Header(header)
+		if err != nil {
+			return nil, err
+		}
+		if key.derived != nil && key.derived.secret != nil && bytes.Equal(key.derived.salt, header.Salt) &&
+			header.Time == argon2Time && header.Memory == argon2Memory && header.Threads == argon2Threads {
+			secret = key.derived.secret
+		} else {
+			secret = argon2.IDKey(key.passphrase, header.Salt, header.Time, header.Memory, header.Threads, 32)
+		}
+	case kdfKeyFile:
+		if key.key == nil {
+			return nil, errors.New("file is encrypted with a key file, but a passphrase was given")
+		}
+		secret = key.key
+	default:
+		return nil, fmt.Errorf("key derivation %q not supported", header.Kdf)
+	}
+
+	aead, err := newAead(secret)
+	if err != nil {
+		return nil, err
+	}
+	rest := content[len(prefix):]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], prefix)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+func isEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(encryptionMagic))
+}
+
+func newAead(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "fmt"
+
+// Unterstützte Datenbanktreiber für NewStore
+const (
+	DriverSQLite = "sqlite"
+	//Verschlüsselte SQLite-Datei, der Schlüssel kommt aus der Umgebung (KeyFromEnvironment)
+	DriverSQLiteEncrypted = "sqlite-encrypted"
+	DriverPostgres        = "postgres"
+	DriverMemory          = "memory"
+	DriverCSV             = "csv"
+	DriverJSON            = DocumentFormatJSON
+	DriverYAML            = DocumentFormatYAML
+)
+
+// NewStore erzeugt den Store für den angegebenen Treiber. Für SQLite ist der DSN der Pfad
+// zur Datenbankdatei, für CSV der Pfad zur Transaktionsdatei und für JSON und YAML das Verzeichnis der Dokumente. Der Store muss danach noch mit Open geöffnet werden.
+func NewStore(driver string, dsn string) (Store, error) {
+	switch driver {
+	case DriverSQLite:
+		return GetFileDatabase(dsn), nil
+	case DriverSQLiteEncrypted:
+		key, err := KeyFromEnvironment()
+		if err != nil {
+			return nil, err
+		}
+		return GetEncryptedDatabase(dsn, key), nil
+	case DriverPostgres:
+		return GetPostgresDatabase(dsn), nil
+	case DriverMemory:
+		return GetMemoryDatabase(), nil
+	case DriverCSV:
+		return GetCsvStorage(dsn), nil
+	case DriverJSON, DriverYAML:
+		if dsn == "" {
+			return nil, fmt.Errorf("directory of database driver %q missing", driver)
+		}
+		return GetDocumentStorage(dsn, driver)
+	default:
+		return nil, fmt.Errorf("database driver %q not supported", driver)
+	}
+}
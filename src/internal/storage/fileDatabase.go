@@ -2,13 +2,15 @@ package storage
 
 import (
 	"database/sql"
-	"time"
+	"net/url"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// FileDatabase hält zwischen Open und Close einen Verbindungspool zur SQLite-Datei.
+// Die PRAGMAs werden über den DSN für jede neue Verbindung des Pools gesetzt.
 type FileDatabase struct {
-	baseDb   DatabaseStorage
+	sqlStore
 	filePath string
 }
 
@@ -20,131 +22,21 @@ func GetFileDatabase(pathToFile string) *FileDatabase {
 	return fileDB
 }
 
-func (s *FileDatabase) CreateDatabase() error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.createDatabase(db)
-	})
-}
-
-func (s *FileDatabase) Ping() error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.ping(db)
-	})
-}
-
-func (s *FileDatabase) AddTransaction(transaction *Transaction) error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.insertTransaction(db, transaction)
-	})
-}
-
-func (s *FileDatabase) LoadTransactionByParams(date time.Time, transType string, tickSymbol string) (*Transaction, error) {
-	var transaction *Transaction
-	err := s.withDatabase(func(db *sql.DB) error {
-		var errorSql error
-		transaction, errorSql = s.baseDb.loadTransactionByParams(db, date, transType, tickSymbol)
-		return errorSql
-	})
-	if err != nil {
-		return nil, err
-	}
-	return transaction, nil
-}
-
-func (s *FileDatabase) ReadAllTransactions() ([]Transaction, error) {
-	var transactions []Transaction
-
-	err := s.withDatabase(func(db *sql.DB) error {
-		var errorSql error
-		transactions, errorSql = s.baseDb.loadAllTransactions(db)
-		return errorSql
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return transactions, err
-}
-
-func (s *FileDatabase) AddUnclosedTransaction(asset Transaction) error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.insertUnclosedTransaction(db, asset)
-	})
-}
-
-func (s *FileDatabase) RemoveAllUnclosedTransactions() error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.deleteAllUnclosedTransaction(db)
-	})
-}
-
-func (s *FileDatabase) ReadAllUnclosedTickerSymbols() ([]string, error) {
-	var tickerSymbols []string
-
-	err := s.withDatabase(func(db *sql.DB) error {
-		var errorSql error
-		tickerSymbols, errorSql = s.baseDb.loadUnclosedTickerSymbols(db)
-		return errorSql
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return tickerSymbols, nil
-}
-
-func (s *FileDatabase) ReadAllUnclosedTransactions() (map[string][]Transaction, error) {
-	var unclosedTransactions map[string][]Transaction
-
-	err := s.withDatabase(func(db *sql.DB) error {
-		var errorSql error
-		unclosedTransactions, errorSql = s.baseDb.loadUnclosedTransactions(db)
-		return errorSql
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return unclosedTransactions, nil
-}
-
-func (s *FileDatabase) AddRealizedGain(realizedGain RealizedGain) error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.insertRealizedGain(db, &realizedGain)
-	})
-}
-
-func (s *FileDatabase) ReadAllRealizedGains() ([]RealizedGain, error) {
-	var realizedGains []RealizedGain
-
-	err := s.withDatabase(func(db *sql.DB) error {
-		var errorSql error
-		realizedGains, errorSql = s.baseDb.loadAllRealizedGains(db)
-		return errorSql
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return realizedGains, nil
-}
-
-func (s *FileDatabase) RemoveAllRealizedGains() error {
-	return s.withDatabase(func(db *sql.DB) error {
-		return s.baseDb.removeRealizedGains(db)
-	})
-}
-
-func (s *FileDatabase) withDatabase(action func(db *sql.DB) error) error {
-	dbPath := s.filePath
-
-	// Datenbankverbindung öffnen
-	db, err := sql.Open("sqlite3", dbPath)
+func (s *FileDatabase) Open() error {
+	var err error
+	s.db, err = sql.Open("sqlite3", fileDatabaseDSN(s.filePath))
 	if err != nil {
 		return err
 	}
-	defer db.Close() // Verbindung sicher schließen
+	return s.db.Ping()
+}
 
-	// Aktion mit der Datenbank ausführen
-	return action(db)
+// fileDatabaseDSN aktiviert für jede Verbindung die Fremdschlüssel (sonst greift ON DELETE CASCADE nicht),
+// das Write-Ahead-Log (Lesen während geschrieben wird) und wartet bei einer gesperrten Datenbank bis zu 5 s.
+func fileDatabaseDSN(filePath string) string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "on")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", "5000")
+	return "file:" + filePath + "?" + params.Encode()
 }
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileContent ist der neue Inhalt einer Datei eines dateibasierten Stores.
+type fileContent struct {
+	path    string
+	content []byte
+}
+
+// replaceLog ist die Protokolldatei von replaceFiles. Sie enthält die temporären Dateien mit ihrem Ziel und
+// die zu löschenden Dateien, jeweils relativ zum Verzeichnis der Protokolldatei.
+type replaceLog struct {
+	Files  []replaceLogFile `json:"files"`
+	Remove []string         `json:"remove"`
+}
+
+type replaceLogFile struct {
+	Temp string `json:"temp"`
+	Path string `json:"path"`
+}
+
+// replaceFiles schreibt jede Datei zuerst vollständig in eine temporäre Datei im selben Verzeichnis.
+// Ein Fehler dabei lässt die Dateien unverändert. Sind alle geschrieben, wird die Protokolldatei logPath
+// mit einem Rename angelegt. Ab dann gilt die Änderung: die temporären Dateien ersetzen die bisherigen,
+// die Dateien in remove werden gelöscht und zuletzt die Protokolldatei. Bricht das ab (z.B. bei einem
+// Absturz), stellt completeReplace die Änderung beim nächsten Öffnen fertig. So passen die Dateien
+// immer zusammen. Bei nur einer Datei ist der Rename selbst atomar, dann kann logPath leer sein.
+func replaceFiles(logPath string, files []fileContent, remove []string) error {
+	//Eine abgebrochene Änderung wird zuerst fertiggestellt, ihre Protokolldatei darf nicht überschrieben werden
+	if logPath != "" {
+		err := completeReplace(logPath)
+		if err != nil {
+			return err
+		}
+	}
+	log := replaceLog{Remove: remove}
+	removeTemps := func() {
+		for _, file := range log.Files {
+			os.Remove(file.Temp)
+		}
+	}
+	for _, file := range files {
+		tempPath, err := writeTempFile(file)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		log.Files = append(log.Files, replaceLogFile{Temp: tempPath, Path: file.path})
+	}
+	if len(log.Files)+len(log.Remove) == 0 {
+		return nil
+	}
+
+	if logPath != "" {
+		err := writeReplaceLog(logPath, log)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+	} else if len(log.Files)+len(log.Remove) > 1 {
+		removeTemps()
+		return errors.New("error at replace files. more than one file needs a replace log")
+	}
+
+	err := applyReplaceLog(log)
+	if err != nil {
+		if logPath != "" {
+			return fmt.Errorf("%w. the change is completed when the store is opened again", err)
+		}
+		removeTemps()
+		return err
+	}
+	if logPath != "" {
+		err = os.Remove(logPath)
+		if err != nil {
+			return fmt.Errorf("error at remove %s. %w", logPath, err)
+		}
+	}
+	return nil
+}
+
+// completeReplace stellt eine abgebrochene Änderung von replaceFiles fertig. Es wird beim Öffnen eines
+// dateibasierten Stores vor dem Lesen aufgerufen. Ohne Protokolldatei gibt es nichts zu tun.
+func completeReplace(logPath string) error {
+	content, err := os.ReadFile(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error at read %s. %w", logPath, err)
+	}
+	var log replaceLog
+	err = json.Unmarshal(content, &log)
+	if err != nil {
+		return fmt.Errorf("error at read %s. %w", logPath, err)
+	}
+	directory := filepath.Dir(logPath)
+	for i, file := range log.Files {
+		log.Files[i] = replaceLogFile{Temp: filepath.Join(directory, file.Temp), Path: filepath.Join(directory, file.Path)}
+	}
+	for i, path := range log.Remove {
+		log.Remove[i] = filepath.Join(directory, path)
+	}
+	err = applyReplaceLog(log)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(logPath)
+	if err != nil {
+		return fmt.Errorf("error at remove %s. %w", logPath, err)
+	}
+	return nil
+}
+
+// applyReplaceLog ersetzt die Dateien und löscht die Dateien in Remove. Eine fehlende temporäre Datei
+// wurde schon umbenannt, so kann eine abgebrochene Änderung noch einmal angewendet werden.
+func applyReplaceLog(log replaceLog) error {
+	for _, file := range log.Files {
+		if !fileExists(file.Temp) {
+			continue
+		}
+		err := os.Rename(file.Temp, file.Path)
+		if err != nil {
+			return fmt.Errorf("error at replace %s. %w", file.Path, err)
+		}
+	}
+	for _, path := range log.Remove {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error at remove %s. %w", path, err)
+		}
+	}
+	return nil
+}
+
+// writeReplaceLog legt die Protokolldatei mit einem Rename an, sie ist also ganz oder gar nicht da.
+// Die Pfade werden relativ zu ihrem Verzeichnis gespeichert.
+func writeReplaceLog(logPath string, log replaceLog) error {
+	directory := filepath.Dir(logPath)
+	relative := replaceLog{Files: make([]replaceLogFile, 0, len(log.Files)), Remove: make([]string, 0, len(log.Remove))}
+	for _, file := range log.Files {
+		temp, err := filepath.Rel(directory, file.Temp)
+		if err != nil {
+			return fmt.Errorf("error at write %s. %w", logPath, err)
+		}
+		path, err := filepath.Rel(directory, file.Path)
+		if err != nil {
+			return fmt.Errorf("error at write %s. %w", logPath, err)
+		}
+		relative.Files = append(relative.Files, replaceLogFile{Temp: temp, Path: path})
+	}
+	for _, remove := range log.Remove {
+		path, err := filepath.Rel(directory, remove)
+		if err != nil {
+			return fmt.Errorf("error at write %s. %w", logPath, err)
+		}
+		relative.Remove = append(relative.Remove, path)
+	}
+	content, err := json.Marshal(relative)
+	if err != nil {
+		return fmt.Errorf("error at write %s. %w", logPath, err)
+	}
+	tempPath, err := writeTempFile(fileContent{path: logPath, content: content})
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, logPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error at write %s. %w", logPath, err)
+	}
+	return nil
+}
+
+func writeTempFile(file fileContent) (string, error) {
+	temp, err := os.CreateTemp(filepath.Dir(file.path), filepath.Base(file.path)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("error at write %s. %w", file.path, err)
+	}
+	_, err = temp.Write(file.content)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("error at write %s. %w", file.path, err)
+	}
+	return temp.Name(), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// InstrumentStore wird von Stores mit Stammdaten der Wertpapiere implementiert. Die Instrumente
+// sind über die ISIN eindeutig, ein Tickersymbol gehört höchstens zu einem Instrument.
+type InstrumentStore interface {
+	AddInstrument(ctx context.Context, instrument *Instrument) error
+	UpdateInstrument(ctx context.Context, instrument *Instrument) error
+	RemoveInstrument(ctx context.Context, isin string) error
+	//LoadInstrument liefert nil ohne Fehler, wenn es die ISIN nicht gibt
+	LoadInstrument(ctx context.Context, isin string) (*Instrument, error)
+	LoadInstrumentByTicker(ctx context.Context, tickerSymbol string) (*Instrument, error)
+	SearchInstruments(ctx context.Context, query InstrumentQuery) ([]Instrument, error)
+}
+
+// Instrument sind die Stammdaten eines Wertpapiers.
+type Instrument struct {
+	Isin     string   `json:"isin" yaml:"isin"`
+	Wkn      string   `json:"wkn" yaml:"wkn"`         //Wertpapierkennnummer, kann leer sein
+	Tickers  []string `json:"tickers" yaml:"tickers"` //Das erste Tickersymbol wird für neue Transaktionen verwendet
+	Name     string   `json:"name" yaml:"name"`
+	Type     string   `json:"type" yaml:"type"` //Wie AssetType einer Transaktion: stock, etf, crypto, ...
+	Exchange string   `json:"exchange" yaml:"exchange"`
+	Currency string   `json:"currency" yaml:"currency"` //Handelswährung
+	Sector   string   `json:"sector" yaml:"sector"`
+	Country  string   `json:"country" yaml:"country"` //ISO 3166-1 Alpha-2, z.B. DE
+}
+
+// InstrumentQuery schränkt die Suche ein. Leere Felder werden nicht gefiltert.
+// Text wird ohne Beachtung der Groß- und Kleinschreibung in ISIN, WKN, Name und Tickersymbolen gesucht.
+type InstrumentQuery struct {
+	Text    string
+	Type    string
+	Country string
+	Sector  string
+	Limit   int
+}
+
+func (s *DatabaseStorage) insertInstrument(ctx context.Context, db dbExecutor, instrument *Instrument) error {
+	sqlStmt := "INSERT INTO instruments (isin, wkn, name, type, exchange, currency, sector, country) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err := db.ExecContext(ctx, sqlStmt,
+		instrument.Isin,
+		instrument.Wkn,
+		instrument.Name,
+		instrument.Type,
+		instrument.Exchange,
+		instrument.Currency,
+		instrument.Sector,
+		instrument.Country)
+	if err != nil {
+		return fmt.Errorf("error at insert instrument. %w", err)
+	}
+	return s.insertInstrumentTickers(ctx, db, instrument)
+}
+
+func (s *DatabaseStorage) insertInstrumentTickers(ctx context.Context, db dbExecutor, instrument *Instrument) error {
+	for i, tickerSymbol := range instrument.Tickers {
+		_, err := db.ExecContext(ctx, "INSERT INTO instrument_tickers (isin, tickerSymbol, position) VALUES (?, ?, ?);",
+			instrument.Isin, tickerSymbol, i)
+		if err != nil {
+			return fmt.Errorf("error at insert ticker symbol %s of instrument. %w", tickerSymbol, err)
+		}
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) updateInstrument(ctx context.Context, db dbExecutor, instrument *Instrument) error {
+	sqlStmt := "UPDATE instruments SET wkn = ?, name = ?, type = ?, exchange = ?, currency = ?, sector = ?, country = ? WHERE isin = ?;"
+	result, err := db.ExecContext(ctx, sqlStmt,
+		instrument.Wkn,
+		instrument.Name,
+		instrument.Type,
+		instrument.Exchange,
+		instrument.Currency,
+		instrument.Sector,
+		instrument.Country,
+		instrument.Isin)
+	if err != nil {
+		return fmt.Errorf("error at update instrument. %w", err)
+	}
+	err = checkFound(result, "instrument", instrument.Isin)
+	if err != nil {
+		return err
+	}
+	// Die Tickersymbole werden komplett ersetzt
+	_, err = db.ExecContext(ctx, "DELETE FROM instrument_tickers WHERE isin = ?;", instrument.Isin)
+	if err != nil {
+		return fmt.Errorf("error at delete ticker symbols of instrument. %w", err)
+	}
+	return s.insertInstrumentTickers(ctx, db, instrument)
+}
+
+func (s *DatabaseStorage) deleteInstrument(ctx context.Context, db dbExecutor, isin string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM instrument_tickers WHERE isin = ?;", isin)
+	if err != nil {
+		return fmt.Errorf("error at delete ticker symbols of instrument. %w", err)
+	}
+	result, err := db.ExecContext(ctx, "DELETE FROM instruments WHERE isin = ?;", isin)
+	if err != nil {
+		return fmt.Errorf("error at delete instrument. %w", err)
+	}
+	return checkFound(result, "instrument", isin)
+}
+
+func (s *DatabaseStorage) loadInstrument(ctx context.Context, db dbExecutor, isin string) (*Instrument, error) {
+	instruments, err := s.searchInstruments(ctx, db, "isin = ?", []any{isin}, 0)
+	if err != nil || len(instruments) == 0 {
+		return nil, err
+	}
+	return &instruments[0], nil
+}
+
+func (s *DatabaseStorage) loadInstrumentByTicker(ctx context.Context, db dbExecutor, tickerSymbol string) (*Instrument, error) {
+	instruments, err := s.searchInstruments(ctx, db, "isin IN (SELECT isin FROM instrument_tickers WHERE tickerSymbol = ?)",
+		[]any{tickerSymbol}, 0)
+	if err != nil || len(instruments) == 0 {
+		return nil, err
+	}
+	return &instruments[0], nil
+}
+
+func (s *DatabaseStorage) queryInstruments(ctx context.Context, db dbExecutor, query InstrumentQuery) ([]Instrument, error) {
+	var conditions []string
+	var args []any
+	if query.Text != "" {
+		pattern := "%" + strings.ToLower(query.Text) + "%"
+		conditions = append(conditions, "(LOWER(isin) LIKE ? OR LOWER(wkn) LIKE ? OR LOWER(name) LIKE ? OR "+
+			"isin IN (SELECT isin FROM instrument_tickers WHERE LOWER(tickerSymbol) LIKE ?))")
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	for _, filter := range []struct {
+		column string
+		value  string
+	}{{"type", query.Type}, {"country", query.Country}, {"sector", query.Sector}} {
+		if filter.value != "" {
+			conditions = append(conditions, filter.column+" = ?")
+			args = append(args, filter.value)
+		}
+	}
+	return s.searchInstruments(ctx, db, strings.Join(conditions, " AND "), args, query.Limit)
+}
+
+// searchInstruments liest die Instrumente, auf die condition zutrifft, sortiert nach Name,
+// und danach ihre Tickersymbole.
+func (s *DatabaseStorage) searchInstruments(ctx context.Context, db dbExecutor, condition string, args []any, limit int) ([]Instrument, error) {
+	sqlStmt := "SELECT isin, wkn, name, type, exchange, currency, sector, country FROM instruments"
+	if condition != "" {
+		sqlStmt += " WHERE " + condition
+	}
+	sqlStmt += " ORDER BY name, isin"
+	if limit > 0 {
+		sqlStmt += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error at read instruments. %w", err)
+	}
+	instruments := make([]Instrument, 0)
+	for rows.Next() {
+		var instrument Instrument
+		err = rows.Scan(&instrument.Isin, &instrument.Wkn, &instrument.Name, &instrument.Type, &instrument.Exchange,
+			&instrument.Currency, &instrument.Sector, &instrument.Country)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error at scan instrument. %w", err)
+		}
+		instrument.Tickers = []string{}
+		instruments = append(instruments, instrument)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error at read instruments. %w", err)
+	}
+
+	// Erst nach dem Schließen lesen, eine Transaktion hat nur eine Verbindung
+	for i := range instruments {
+		instruments[i].Tickers, err = s.loadInstrumentTickers(ctx, db, instruments[i].Isin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return instruments, nil
+}
+
+func (s *DatabaseStorage) loadInstrumentTickers(ctx context.Context, db dbExecutor, isin string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT tickerSymbol FROM instrument_tickers WHERE isin = ? ORDER BY position;", isin)
+	if err != nil {
+		return nil, fmt.Errorf("error at read ticker symbols of instrument. %w", err)
+	}
+	defer rows.Close()
+
+	tickers := make([]string, 0)
+	for rows.Next() {
+		var tickerSymbol string
+		err = rows.Scan(&tickerSymbol)
+		if err != nil {
+			return nil, fmt.Errorf("error at scan ticker symbol of instrument. %w", err)
+		}
+		tickers = append(tickers, tickerSymbol)
+	}
+	return tickers, rows.Err()
+}
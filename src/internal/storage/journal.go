@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Journal wird von Stores implementiert, die alle Änderungen an den Transaktionen als Ereignisse
+// in einem Journal speichern. Ereignisse werden nur angehängt, nie geändert oder gelöscht.
+// Snapshots enthalten den Stand nach einem Ereignis, damit beim Start nicht alle Ereignisse
+// neu angewendet werden müssen. AddSnapshot ersetzt einen Snapshot zum selben Ereignis und löscht
+// die älteren, gebraucht wird immer nur der letzte.
+type Journal interface {
+	AppendEvent(ctx context.Context, event *JournalEvent) error
+	ReadEvents(ctx context.Context, afterSequence int64) ([]JournalEvent, error)
+	AddSnapshot(ctx context.Context, snapshot *DepotSnapshot) error
+	LoadLatestSnapshot(ctx context.Context) (*DepotSnapshot, error)
+}
+
+// Arten der Ereignisse im Journal
+const (
+	EventTransactionAdded     = "transactionAdded"
+	EventTransactionCorrected = "transactionCorrected"
+	EventTransactionDeleted   = "transactionDeleted"
+	EventCorporateAction      = "corporateAction"
+)
+
+// JournalEvent ist ein Ereignis im Journal. Je nach Art ist Transaction (hinzugefügt, korrigiert),
+// TransactionId (gelöscht) oder CorporateAction gesetzt.
+type JournalEvent struct {
+	Sequence        int64            `json:"sequence"` //Wird beim Anhängen vom Store vergeben und ist fortlaufend
+	Type            string           `json:"type"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	Transaction     *Transaction     `json:"transaction,omitempty"`
+	TransactionId   uuid.UUID        `json:"transactionId"`
+	CorporateAction *CorporateAction `json:"corporateAction,omitempty"`
+}
+
+// CorporateAction ist eine Kapitalmaßnahme. Bisher wird nur der Aktiensplit unterstützt:
+// Ab dem Datum werden aus einem Stück Ratio Stücke, der Kaufpreis wird durch Ratio geteilt.
+type CorporateAction struct {
+	Type         string    `json:"type"` //split
+	TickerSymbol string    `json:"tickerSymbol"`
+	Date         time.Time `json:"date"`
+	Ratio        float64   `json:"ratio"`
+}
+
+// DepotSnapshot ist der Stand des Depots nach dem Ereignis EventSequence. Den Inhalt
+// (State) legt das Depot fest, der Store speichert ihn nur.
+type DepotSnapshot struct {
+	EventSequence int64     `json:"eventSequence"`
+	CreatedAt     time.Time `json:"createdAt"`
+	State         []byte    `json:"state"`
+}
+
+// journalPayload ist der Inhalt eines Ereignisses in der Spalte payload.
+type journalPayload struct {
+	Transaction     *Transaction     `json:"transaction,omitempty"`
+	TransactionId   uuid.UUID        `json:"transactionId"`
+	CorporateAction *CorporateAction `json:"corporateAction,omitempty"`
+}
+
+func (s *DatabaseStorage) insertJournalEvent(ctx context.Context, db dbExecutor, event *JournalEvent) error {
+	payload, err := json.Marshal(journalPayload{event.Transaction, event.TransactionId, event.CorporateAction})
+	if err != nil {
+		return fmt.Errorf("error at encode journal event. %w", err)
+	}
+	//Die Sequenznummer vergibt die Datenbank
+	err = db.QueryRowContext(ctx, "INSERT INTO journal_events (eventType, createdAt, payload) VALUES (?, ?, ?) RETURNING sequence;",
+		event.Type, event.CreatedAt, string(payload)).Scan(&event.Sequence)
+	if err != nil {
+		return fmt.Errorf("error at insert journal event. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) loadJournalEvents(ctx context.Context, db dbExecutor, afterSequence int64) ([]JournalEvent, error) {
+	events := make([]JournalEvent, 0)
+	rows, err := db.QueryContext(ctx, "SELECT sequence, eventType, createdAt, payload FROM journal_events WHERE sequence > ? ORDER BY sequence", afterSequence)
+	if err != nil {
+		return nil, fmt.Errorf("error at read journal events. %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event JournalEvent
+		var payload string
+		err = rows.Scan(&event.Sequence, &event.Type, &event.CreatedAt, &payload)
+		if err != nil {
+			return nil, fmt.Errorf("error at scan journal event. %w", err)
+		}
+		var content journalPayload
+		err = json.Unmarshal([]byte(payload), &content)
+		if err != nil {
+			return nil, fmt.Errorf("error at decode journal event %d. %w", event.Sequence, err)
+		}
+		event.Transaction = content.Transaction
+		event.TransactionId = content.TransactionId
+		event.CorporateAction = content.CorporateAction
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *DatabaseStorage) insertDepotSnapshot(ctx context.Context, db dbExecutor, snapshot *DepotSnapshot) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO depot_snapshots (eventSequence, createdAt, state) VALUES (?, ?, ?) "+
+		"ON CONFLICT (eventSequence) DO UPDATE SET createdAt = excluded.createdAt, state = excluded.state;",
+		snapshot.EventSequence, snapshot.CreatedAt, string(snapshot.State))
+	if err != nil {
+		return fmt.Errorf("error at insert depot snapshot. %w", err)
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM depot_snapshots WHERE eventSequence < ?;", snapshot.EventSequence)
+	if err != nil {
+		return fmt.Errorf("error at remove old depot snapshots. %w", err)
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) loadLatestDepotSnapshot(ctx context.Context, db dbExecutor) (*DepotSnapshot, error) {
+	var snapshot DepotSnapshot
+	var state string
+	err := db.QueryRowContext(ctx, "SELECT eventSequence, createdAt, state FROM depot_snapshots ORDER BY eventSequence DESC LIMIT 1").
+		Scan(&snapshot.EventSequence, &snapshot.CreatedAt, &state)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error at read depot snapshot. %w", err)
+	}
+	snapshot.State = []byte(state)
+	return &snapshot, nil
+}
@@ -0,0 +1,509 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryState hält alle Daten eines Stores im Speicher und verhält sich dabei wie die
+// SQL-Stores (Reihenfolge, Duplikate, Fremdschlüssel, ErrNotFound). Die dateibasierten
+// Stores lesen ihre Dateien beim Öffnen in einen memoryState und schreiben ihn nach Änderungen zurück.
+type memoryState struct {
+	transactions  []Transaction //In der Reihenfolge des Einfügens
+	unclosed      []Transaction //In der Reihenfolge des Einfügens
+	realizedGains []RealizedGain
+	savingsPlans  []SavingsPlan
+	pending       []PendingTransaction
+
+	//Wird bei jeder Änderung der Transaktionen gesetzt. Dateibasierte Stores schreiben
+	//die Transaktionen nur dann neu und setzen das Flag nach dem Schreiben zurück.
+	transactionsChanged bool
+
+	inTransaction bool
+	atBegin       *memoryState    //Stand bei Begin, wird bei Rollback wiederhergestellt
+	beginCtx      context.Context //Wird er abgebrochen, schlägt Commit fehl wie bei den SQL-Stores
+
+	//Wird nach jeder Änderung außerhalb einer Transaktion und bei Commit aufgerufen
+	changed func() error
+}
+
+func (m *memoryState) copyData() *memoryState {
+	return &memoryState{
+		transactions:  slices.Clone(m.transactions),
+		unclosed:      slices.Clone(m.unclosed),
+		realizedGains: slices.Clone(m.realizedGains),
+		savingsPlans:  slices.Clone(m.savingsPlans),
+		pending:       slices.Clone(m.pending),
+	}
+}
+
+func (m *memoryState) restoreData(data *memoryState) {
+	m.transactions = data.transactions
+	m.unclosed = data.unclosed
+	m.realizedGains = data.realizedGains
+	m.savingsPlans = data.savingsPlans
+	m.pending = data.pending
+}
+
+func (m *memoryState) Begin(ctx context.Context) error {
+	if m.inTransaction {
+		return errors.New("transaction already started")
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("error at begin transaction. %w", err)
+	}
+	m.inTransaction = true
+	m.atBegin = m.copyData()
+	m.beginCtx = ctx
+	return nil
+}
+
+func (m *memoryState) Commit() error {
+	if !m.inTransaction {
+		return errors.New("no transaction started")
+	}
+	m.inTransaction = false
+	if err := m.beginCtx.Err(); err != nil {
+		m.restoreData(m.atBegin)
+		m.atBegin = nil
+		return fmt.Errorf("error at commit transaction. %w", err)
+	}
+	if m.changed != nil {
+		err := m.changed()
+		if err != nil {
+			//Was nicht geschrieben werden konnte, ist auch im Speicher nicht passiert
+			m.restoreData(m.atBegin)
+			m.atBegin = nil
+			return fmt.Errorf("error at commit transaction. %w", err)
+		}
+	}
+	m.atBegin = nil
+	return nil
+}
+
+func (m *memoryState) Rollback() error {
+	if !m.inTransaction {
+		return errors.New("no transaction started")
+	}
+	m.inTransaction = false
+	m.restoreData(m.atBegin)
+	m.atBegin = nil
+	return nil
+}
+
+// modify führt eine Änderung aus. Außerhalb einer Transaktion wird sie sofort gespeichert
+// und bei einem Fehler beim Speichern zurückgenommen. Ist ctx abgebrochen, wird nichts geändert.
+func (m *memoryState) modify(ctx context.Context, action func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.inTransaction || m.changed == nil {
+		return action()
+	}
+	before := m.copyData()
+	err := action()
+	if err != nil {
+		return err
+	}
+	err = m.changed()
+	if err != nil {
+		m.restoreData(before)
+		return err
+	}
+	return nil
+}
+
+func (m *memoryState) AddTransaction(ctx context.Context, transaction *Transaction) error {
+	return m.modify(ctx, func() error {
+		m.transactionsChanged = true
+		for _, existing := range m.transactions {
+			if existing.Id == transaction.Id {
+				return fmt.Errorf("transaction %s already exists", transaction.Id)
+			}
+			if transaction.OrderNumber != "" && existing.Broker == transaction.Broker &&
+				existing.OrderNumber == transaction.OrderNumber && existing.ExecutionId == transaction.ExecutionId {
+				return fmt.Errorf("transaction with order number %s already exists", transaction.OrderNumber)
+			}
+		}
+		m.transactions = append(m.transactions, *transaction)
+		return nil
+	})
+}
+
+func (m *memoryState) UpdateTransaction(ctx context.Context, transaction *Transaction) error {
+	return m.modify(ctx, func() error {
+		m.transactionsChanged = true
+		for i, existing := range m.transactions {
+			if existing.Id == transaction.Id {
+				m.transactions[i] = *transaction
+				return nil
+			}
+		}
+		return fmt.Errorf("transaction %s %w", transaction.Id, ErrNotFound)
+	})
+}
+
+func (m *memoryState) RemoveTransaction(ctx context.Context, id uuid.UUID) error {
+	return m.modify(ctx, func() error {
+		m.transactionsChanged = true
+		index := slices.IndexFunc(m.transactions, func(t Transaction) bool { return t.Id == id })
+		if index < 0 {
+			return fmt.Errorf("transaction %s %w", id, ErrNotFound)
+		}
+		// Abhängige Abrechnungen und offene Positionen werden mit gelöscht
+		m.realizedGains = slices.DeleteFunc(m.realizedGains, func(g RealizedGain) bool {
+			return g.SellTransactionId == id || g.BuyTransactionId == id
+		})
+		m.unclosed = slices.DeleteFunc(m.unclosed, func(t Transaction) bool { return t.Id == id })
+		m.transactions = slices.Delete(m.transactions, index, index+1)
+		return nil
+	})
+}
+
+func (m *memoryState) LoadTransactionById(ctx context.Context, id uuid.UUID) (*Transaction, error) {
+	return m.findTransaction(func(t Transaction) bool { return t.Id == id }), nil
+}
+
+func (m *memoryState) LoadTransactionByParams(ctx context.Context, date time.Time, transType string, tickSymbol string) (*Transaction, error) {
+	return m.findTransaction(func(t Transaction) bool {
+		return t.Date.Equal(date) && t.TransactionType == transType && t.TickerSymbol == tickSymbol
+	}), nil
+}
+
+func (m *memoryState) LoadTransactionByOrderNumber(ctx context.Context, broker string, orderNumber string, executionId string) (*Transaction, error) {
+	return m.findTransaction(func(t Transaction) bool {
+		return t.Broker == broker && t.OrderNumber == orderNumber && t.ExecutionId == executionId
+	}), nil
+}
+
+func (m *memoryState) findTransaction(match func(t Transaction) bool) *Transaction {
+	for _, transaction := range m.transactions {
+		if match(transaction) {
+			return &transaction
+		}
+	}
+	return nil
+}
+
+func (m *memoryState) ReadTransactionsByTickerSymbol(ctx context.Context, tickSymbol string) ([]Transaction, error) {
+	transactions := make([]Transaction, 0)
+	for _, transaction := range m.sortedTransactions() {
+		if transaction.TickerSymbol == tickSymbol {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+func (m *memoryState) ReadAllTransactions(ctx context.Context) ([]Transaction, error) {
+	return m.sortedTransactions(), nil
+}
+
+// sortedTransactions sortiert wie die SQL-Stores chronologisch und bei gleichem Datum nach der Sequenznummer.
+func (m *memoryState) sortedTransactions() []Transaction {
+	transactions := slices.Clone(m.transactions)
+	if transactions == nil {
+		transactions = make([]Transaction, 0)
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if !transactions[i].Date.Equal(transactions[j].Date) {
+			return transactions[i].Date.Before(transactions[j].Date)
+		}
+		return transactions[i].Sequence < transactions[j].Sequence
+	})
+	return transactions
+}
+
+// QueryTransactions sortiert Transaktionen mit gleichem Datum und gleicher Sequenznummer in der Reihenfolge
+// des Einfügens, wie ReadAllTransactions. Der Cursor enthält dafür die Position in m.transactions.
+func (m *memoryState) QueryTransactions(ctx context.Context, query TransactionQuery) (TransactionPage, error) {
+	page := TransactionPage{Transactions: make([]Transaction, 0)}
+	err := query.validate()
+	if err != nil {
+		return page, err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return page, err
+	}
+	if cursor != nil {
+		//Ist die Transaktion des Cursors noch da, zählt ihre aktuelle Position
+		if index := slices.IndexFunc(m.transactions, func(t Transaction) bool { return t.Id == cursor.Id }); index >= 0 {
+			cursor.Position = index
+		}
+	}
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	var keys []queryCursor
+	for i, transaction := range m.transactions {
+		key := transactionCursor(transaction)
+		key.Position = i
+		if query.matches(transaction) && (cursor == nil || direction*query.compare(key, *cursor) > 0) {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b queryCursor) int {
+		return direction * query.compare(a, b)
+	})
+	if query.Limit > 0 && len(keys) > query.Limit {
+		keys = keys[:query.Limit]
+		page.NextCursor = keys[query.Limit-1].encode()
+	}
+	for _, key := range keys {
+		page.Transactions = append(page.Transactions, m.transactions[key.Position])
+	}
+	return page, nil
+}
+
+// IterateTransactions liest alle Treffer auf einmal, die Daten liegen ohnehin im Speicher. Wie bei den
+// SQL-Stores kann während der Iteration in den Store geschrieben werden.
+func (m *memoryState) IterateTransactions(ctx context.Context, query TransactionQuery) iter.Seq2[Transaction, error] {
+	return func(yield func(Transaction, error) bool) {
+		page, err := m.QueryTransactions(ctx, query)
+		if err != nil {
+			yield(Transaction{}, err)
+			return
+		}
+		for _, transaction := range page.Transactions {
+			if err := ctx.Err(); err != nil {
+				yield(Transaction{}, err)
+				return
+			}
+			if !yield(transaction, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *memoryState) AddUnclosedTransaction(ctx context.Context, trans Transaction) error {
+	return m.modify(ctx, func() error {
+		m.unclosed = append(m.unclosed, trans)
+		return nil
+	})
+}
+
+func (m *memoryState) UpdateUnclosedTransaction(ctx context.Context, trans Transaction) error {
+	return m.modify(ctx, func() error {
+		found := false
+		for i, existing := range m.unclosed {
+			if existing.Id == trans.Id {
+				m.unclosed[i] = trans
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unclosed transaction %s %w", trans.Id, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+func (m *memoryState) RemoveUnclosedTransaction(ctx context.Context, id uuid.UUID) error {
+	return m.modify(ctx, func() error {
+		count := len(m.unclosed)
+		m.unclosed = slices.DeleteFunc(m.unclosed, func(t Transaction) bool { return t.Id == id })
+		if len(m.unclosed) == count {
+			return fmt.Errorf("unclosed transaction %s %w", id, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+func (m *memoryState) RemoveAllUnclosedTransactions(ctx context.Context) error {
+	return m.modify(ctx, func() error {
+		m.unclosed = nil
+		return nil
+	})
+}
+
+func (m *memoryState) ReadAllUnclosedTickerSymbols(ctx context.Context) ([]string, error) {
+	tickerSymbols := make([]string, 0)
+	for _, transaction := range m.unclosed {
+		if !slices.Contains(tickerSymbols, transaction.TickerSymbol) {
+			tickerSymbols = append(tickerSymbols, transaction.TickerSymbol)
+		}
+	}
+	return tickerSymbols, nil
+}
+
+func (m *memoryState) ReadAllUnclosedTransactions(ctx context.Context) (map[string][]Transaction, error) {
+	unclosedTransactions := make(map[string][]Transaction)
+	for _, transaction := range m.unclosed {
+		unclosedTransactions[transaction.TickerSymbol] = append(unclosedTransactions[transaction.TickerSymbol], transaction)
+	}
+	return unclosedTransactions, nil
+}
+
+func (m *memoryState) AddRealizedGain(ctx context.Context, realizedGain RealizedGain) error {
+	return m.modify(ctx, func() error {
+		// Wie der Fremdschlüssel in der Datenbank
+		if m.findTransaction(func(t Transaction) bool { return t.Id == realizedGain.SellTransactionId }) == nil ||
+			m.findTransaction(func(t Transaction) bool { return t.Id == realizedGain.BuyTransactionId }) == nil {
+			return fmt.Errorf("transactions of realized gain %s do not exist", realizedGain.Id)
+		}
+		if slices.ContainsFunc(m.realizedGains, func(g RealizedGain) bool { return g.Id == realizedGain.Id }) {
+			return fmt.Errorf("realized gain %s already exists", realizedGain.Id)
+		}
+		m.realizedGains = append(m.realizedGains, realizedGain)
+		return nil
+	})
+}
+
+func (m *memoryState) ReadAllRealizedGains(ctx context.Context) ([]RealizedGain, error) {
+	realizedGains := slices.Clone(m.realizedGains)
+	if realizedGains == nil {
+		realizedGains = make([]RealizedGain, 0)
+	}
+	return realizedGains, nil
+}
+
+// QueryRealizedGains sortiert wie die SQL-Stores nach dem Datum des Verkaufs und der Id.
+func (m *memoryState) QueryRealizedGains(ctx context.Context, query RealizedGainQuery) (RealizedGainPage, error) {
+	page := RealizedGainPage{RealizedGains: make([]RealizedGain, 0)}
+	err := query.validate()
+	if err != nil {
+		return page, err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return page, err
+	}
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	compare := func(a queryCursor, b queryCursor) int {
+		result := a.Date.Compare(b.Date)
+		if result == 0 {
+			result = strings.Compare(a.Id.String(), b.Id.String())
+		}
+		return direction * result
+	}
+
+	keys := make(map[uuid.UUID]queryCursor)
+	for _, gain := range m.realizedGains {
+		sell := m.findTransaction(func(t Transaction) bool { return t.Id == gain.SellTransactionId })
+		if sell == nil || !query.matches(gain, *sell) {
+			continue
+		}
+		key := queryCursor{Date: sell.Date, Id: gain.Id}
+		if cursor == nil || compare(key, *cursor) > 0 {
+			keys[gain.Id] = key
+			page.RealizedGains = append(page.RealizedGains, gain)
+		}
+	}
+	slices.SortFunc(page.RealizedGains, func(a, b RealizedGain) int {
+		return compare(keys[a.Id], keys[b.Id])
+	})
+	if query.Limit > 0 && len(page.RealizedGains) > query.Limit {
+		page.RealizedGains = page.RealizedGains[:query.Limit]
+		page.NextCursor = keys[page.RealizedGains[query.Limit-1].Id].encode()
+	}
+	return page, nil
+}
+
+func (m *memoryState) RemoveAllRealizedGains(ctx context.Context) error {
+	return m.modify(ctx, func() error {
+		m.realizedGains = nil
+		return nil
+	})
+}
+
+func (m *memoryState) AddSavingsPlan(ctx context.Context, plan *SavingsPlan) error {
+	return m.modify(ctx, func() error {
+		if slices.ContainsFunc(m.savingsPlans, func(p SavingsPlan) bool { return p.Id == plan.Id }) {
+			return fmt.Errorf("savings plan %s already exists", plan.Id)
+		}
+		m.savingsPlans = append(m.savingsPlans, *plan)
+		return nil
+	})
+}
+
+func (m *memoryState) UpdateSavingsPlan(ctx context.Context, plan *SavingsPlan) error {
+	return m.modify(ctx, func() error {
+		for i, existing := range m.savingsPlans {
+			if existing.Id == plan.Id {
+				m.savingsPlans[i] = *plan
+				return nil
+			}
+		}
+		return fmt.Errorf("savings plan %s %w", plan.Id, ErrNotFound)
+	})
+}
+
+func (m *memoryState) RemoveSavingsPlan(ctx context.Context, id uuid.UUID) error {
+	return m.modify(ctx, func() error {
+		count := len(m.savingsPlans)
+		m.savingsPlans = slices.DeleteFunc(m.savingsPlans, func(p SavingsPlan) bool { return p.Id == id })
+		if len(m.savingsPlans) == count {
+			return fmt.Errorf("savings plan %s %w", id, ErrNotFound)
+		}
+		// Die offenen Transaktionen des Sparplans werden mit gelöscht.
+		m.pending = slices.DeleteFunc(m.pending, func(p PendingTransaction) bool { return p.SavingsPlanId == id })
+		return nil
+	})
+}
+
+func (m *memoryState) ReadAllSavingsPlans(ctx context.Context) ([]SavingsPlan, error) {
+	plans := slices.Clone(m.savingsPlans)
+	if plans == nil {
+		plans = make([]SavingsPlan, 0)
+	}
+	return plans, nil
+}
+
+func (m *memoryState) AddPendingTransaction(ctx context.Context, pending *PendingTransaction) error {
+	return m.modify(ctx, func() error {
+		if !slices.ContainsFunc(m.savingsPlans, func(p SavingsPlan) bool { return p.Id == pending.SavingsPlanId }) {
+			return fmt.Errorf("savings plan %s of pending transaction does not exist", pending.SavingsPlanId)
+		}
+		if slices.ContainsFunc(m.pending, func(p PendingTransaction) bool { return p.Id == pending.Id }) {
+			return fmt.Errorf("pending transaction %s already exists", pending.Id)
+		}
+		m.pending = append(m.pending, *pending)
+		return nil
+	})
+}
+
+func (m *memoryState) LoadPendingTransaction(ctx context.Context, id uuid.UUID) (*PendingTransaction, error) {
+	for _, pending := range m.pending {
+		if pending.Id == id {
+			return &pending, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryState) ReadAllPendingTransactions(ctx context.Context) ([]PendingTransaction, error) {
+	pendingTransactions := slices.Clone(m.pending)
+	if pendingTransactions == nil {
+		pendingTransactions = make([]PendingTransaction, 0)
+	}
+	sort.SliceStable(pendingTransactions, func(i, j int) bool {
+		return pendingTransactions[i].DueDate.Before(pendingTransactions[j].DueDate)
+	})
+	return pendingTransactions, nil
+}
+
+func (m *memoryState) RemovePendingTransaction(ctx context.Context, id uuid.UUID) error {
+	return m.modify(ctx, func() error {
+		count := len(m.pending)
+		m.pending = slices.DeleteFunc(m.pending, func(p PendingTransaction) bool { return p.Id == id })
+		if len(m.pending) == count {
+			return fmt.Errorf("pending transaction %s %w", id, ErrNotFound)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Migrator wird von Stores mit Datenbankschema implementiert. Das Schema wird über
+// nummerierte Migrationen aufgebaut. Die angewendeten Versionen stehen in der Tabelle schema_migrations.
+type Migrator interface {
+	MigrationStatus(ctx context.Context) ([]MigrationState, error)
+	MigrateUp(ctx context.Context) error
+	MigrateTo(ctx context.Context, version int) error
+}
+
+type MigrationState struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	AppliedAt   *time.Time `json:"appliedAt"`
+}
+
+type migration struct {
+	version     int
+	description string
+	up          []string
+	down        []string
+	//Ersetzen up und down bei PostgreSQL, wo sich eine Änderung nicht in SQLite-Syntax schreiben lässt
+	postgresUp   []string
+	postgresDown []string
+}
+
+func (m migration) upStatements(dialect sqlDialect) []string {
+	if _, ok := dialect.(postgresDialect); ok && m.postgresUp != nil {
+		return m.postgresUp
+	}
+	return m.up
+}
+
+func (m migration) downStatements(dialect sqlDialect) []string {
+	if _, ok := dialect.(postgresDialect); ok && m.postgresDown != nil {
+		return m.postgresDown
+	}
+	return m.down
+}
+
+// Neue Schemaänderungen werden immer als neue Migration am Ende angefügt.
+// Bereits veröffentlichte Migrationen dürfen nicht mehr geändert werden.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "transactions, unclosed transactions and realized gains",
+		up: []string{
+			"CREATE TABLE transactions (id TEXT(36) not null primary key, date DATETIME, transactionType TEXT, " +
+				"assetType TEXT, asset TEXT, tickerSymbol TEXT, quantity REAL, price REAL, fees REAL, currency TEXT);",
+			"CREATE UNIQUE INDEX idx_transactions_id ON transactions(id);",
+			// Unclosed_transactions
+			// 1:n asset -> unclosed_transactions
+			"CREATE TABLE unclosed_assets (asset_id INTEGER PRIMARY KEY AUTOINCREMENT, ticker_symbol TEXT UNIQUE NOT NULL);",
+			"CREATE TABLE unclosed_trans (unclosed_id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"asset_id INTEGER NOT NULL, " +
+				"transaction_id TEXT, date DATETIME, transactionType TEXT, " +
+				"assetType TEXT, asset TEXT, tickerSymbol TEXT, quantity REAL, price REAL, fees REAL, currency TEXT, " +
+				"FOREIGN KEY (asset_id) REFERENCES unclosed_assets(asset_id) ON DELETE CASCADE);",
+			"CREATE INDEX IF NOT EXISTS idx_nclosed_id ON unclosed_trans(unclosed_id)",
+			"CREATE TABLE realized_gains (id TEXT(36) not null primary key, sellTransactionId TEXT(36), buyTransactionId TEXT(36), " +
+				"asset TEXT, amount REAL, isProfit INTEGER, taxRate REAL, quantity REAL, buyPrice REAL, sellPrice REAL, currency TEXT, " +
+				"FOREIGN KEY (sellTransactionId) REFERENCES transactions(id) ON DELETE CASCADE, " +
+				"FOREIGN KEY (buyTransactionId) REFERENCES transactions(id) ON DELETE CASCADE);",
+		},
+		down: []string{
+			"DROP TABLE realized_gains;",
+			"DROP TABLE unclosed_trans;",
+			"DROP TABLE unclosed_assets;",
+			"DROP TABLE transactions;",
+		},
+	},
+	{
+		version:     2,
+		description: "savings plans and pending transactions",
+		up: []string{
+			"CREATE TABLE savings_plans (id TEXT(36) not null primary key, assetType TEXT, asset TEXT, tickerSymbol TEXT, " +
+				"amount REAL, currency TEXT, planInterval TEXT, executionDay INTEGER, startDate DATETIME, endDate DATETIME, lastDueDate DATETIME);",
+			// 1:n savings_plans -> pending_transactions
+			"CREATE TABLE pending_transactions (id TEXT(36) not null primary key, savingsPlanId TEXT(36) NOT NULL, dueDate DATETIME, " +
+				"assetType TEXT, asset TEXT, tickerSymbol TEXT, amount REAL, currency TEXT, " +
+				"FOREIGN KEY (savingsPlanId) REFERENCES savings_plans(id) ON DELETE CASCADE);",
+		},
+		down: []string{
+			"DROP TABLE pending_transactions;",
+			"DROP TABLE savings_plans;",
+		},
+	},
+	{
+		version:     3,
+		description: "sequence of transactions on the same day",
+		up: []string{
+			"ALTER TABLE transactions ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE unclosed_trans ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;",
+		},
+		down: []string{
+			"ALTER TABLE unclosed_trans DROP COLUMN sequence;",
+			"ALTER TABLE transactions DROP COLUMN sequence;",
+		},
+	},
+	{
+		version:     4,
+		description: "broker order numbers",
+		up: []string{
+			"ALTER TABLE transactions ADD COLUMN broker TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE transactions ADD COLUMN orderNumber TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE transactions ADD COLUMN executionId TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE unclosed_trans ADD COLUMN broker TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE unclosed_trans ADD COLUMN orderNumber TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE unclosed_trans ADD COLUMN executionId TEXT NOT NULL DEFAULT '';",
+			// Eine Ausführung eines Broker-Auftrags darf nur einmal gespeichert werden
+			"CREATE UNIQUE INDEX idx_transactions_order ON transactions(broker, orderNumber, executionId) WHERE orderNumber <> '';",
+			"CREATE INDEX idx_transactions_ticker ON transactions(tickerSymbol);",
+		},
+		down: []string{
+			"DROP INDEX idx_transactions_ticker;",
+			"DROP INDEX idx_transactions_order;",
+			"ALTER TABLE unclosed_trans DROP COLUMN executionId;",
+			"ALTER TABLE unclosed_trans DROP COLUMN orderNumber;",
+			"ALTER TABLE unclosed_trans DROP COLUMN broker;",
+			"ALTER TABLE transactions DROP COLUMN executionId;",
+			"ALTER TABLE transactions DROP COLUMN orderNumber;",
+			"ALTER TABLE transactions DROP COLUMN broker;",
+		},
+	},
+	{
+		version:     5,
+		description: "index of unclosed transactions by transaction id",
+		up: []string{
+			// Offene Positionen werden einzeln über die Id der Kauf-Transaktion geändert
+			"CREATE INDEX idx_unclosed_transaction_id ON unclosed_trans(transaction_id);",
+		},
+		down: []string{
+			"DROP INDEX idx_unclosed_transaction_id;",
+		},
+	},
+	{
+		version:     6,
+		description: "journal events and depot snapshots",
+		up: []string{
+			// Das Journal wird nur angehängt. Die Sequenznummer legt die Reihenfolge fest.
+			"CREATE TABLE journal_events (sequence INTEGER PRIMARY KEY AUTOINCREMENT, eventType TEXT NOT NULL, createdAt DATETIME, payload TEXT);",
+			"CREATE TABLE depot_snapshots (eventSequence INTEGER NOT NULL PRIMARY KEY, createdAt DATETIME, state TEXT);",
+		},
+		down: []string{
+			"DROP TABLE depot_snapshots;",
+			"DROP TABLE journal_events;",
+		},
+	},
+	{
+		version:     7,
+		description: "audit log",
+		up: []string{
+			// Vorher und nachher als JSON, NULL bei neuen bzw. gelöschten Einträgen
+			"CREATE TABLE audit_log (id INTEGER PRIMARY KEY AUTOINCREMENT, actor TEXT NOT NULL, createdAt DATETIME, operation TEXT NOT NULL, " +
+				"entity TEXT NOT NULL, entityId TEXT NOT NULL, valueBefore TEXT, valueAfter TEXT);",
+			"CREATE INDEX idx_audit_log_entity ON audit_log(entity, entityId);",
+			"CREATE INDEX idx_audit_log_created ON audit_log(createdAt);",
+		},
+		down: []string{
+			"DROP INDEX idx_audit_log_created;",
+			"DROP INDEX idx_audit_log_entity;",
+			"DROP TABLE audit_log;",
+		},
+	},
+	{
+		version:     8,
+		description: "indexes for transaction queries",
+		up: []string{
+			// Für QueryTransactions und QueryRealizedGains, jeweils mit allen Spalten der Sortierung
+			"DROP INDEX idx_transactions_ticker;",
+			"CREATE INDEX idx_transactions_ticker_date ON transactions(tickerSymbol, date, sequence, id);",
+			"CREATE INDEX idx_transactions_date ON transactions(date, sequence, id);",
+			"CREATE INDEX idx_realized_gains_sell ON realized_gains(sellTransactionId);",
+		},
+		down: []string{
+			"DROP INDEX idx_realized_gains_sell;",
+			"DROP INDEX idx_transactions_date;",
+			"DROP INDEX idx_transactions_ticker_date;",
+			"CREATE INDEX idx_transactions_ticker ON transactions(tickerSymbol);",
+		},
+	},
+	{
+		version:     9,
+		description: "instruments",
+		up: []string{
+			// Stammdaten der Wertpapiere. Transaktionen verweisen über die ISIN darauf, leer bei Transaktionen ohne Instrument.
+			"CREATE TABLE instruments (isin TEXT(12) not null primary key, wkn TEXT NOT NULL DEFAULT '', name TEXT NOT NULL, " +
+				"type TEXT NOT NULL, exchange TEXT NOT NULL DEFAULT '', currency TEXT NOT NULL DEFAULT '', " +
+				"sector TEXT NOT NULL DEFAULT '', country TEXT NOT NULL DEFAULT '');",
+			// 1:n instruments -> instrument_tickers, ein Tickersymbol gehört nur zu einem Instrument
+			"CREATE TABLE instrument_tickers (isin TEXT(12) NOT NULL, tickerSymbol TEXT NOT NULL UNIQUE, position INTEGER NOT NULL, " +
+				"FOREIGN KEY (isin) REFERENCES instruments(isin) ON DELETE CASCADE);",
+			"CREATE INDEX idx_instrument_tickers_isin ON instrument_tickers(isin);",
+			"ALTER TABLE transactions ADD COLUMN isin TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE unclosed_trans ADD COLUMN isin TEXT NOT NULL DEFAULT '';",
+			"CREATE INDEX idx_transactions_isin ON transactions(isin);",
+		},
+		down: []string{
+			"DROP INDEX idx_transactions_isin;",
+			"ALTER TABLE unclosed_trans DROP COLUMN isin;",
+			"ALTER TABLE transactions DROP COLUMN isin;",
+			"DROP INDEX idx_instrument_tickers_isin;",
+			"DROP TABLE instrument_tickers;",
+			"DROP TABLE instruments;",
+		},
+	},
+	{
+		version:     10,
+		description: "insertion order of transactions",
+		// Transaktionen am selben Tag mit gleicher Sequenz werden in der Reihenfolge des Einfügens
+		// sortiert, wie bei den dateibasierten Stores. Vorhandene Zeilen übernehmen die rowid.
+		// SQLite kann AUTOINCREMENT nicht nachträglich anlegen, daher vergibt ein Trigger die Position.
+		up: []string{
+			"ALTER TABLE transactions ADD COLUMN position INTEGER NOT NULL DEFAULT 0;",
+			"UPDATE transactions SET position = rowid;",
+			"CREATE TRIGGER trg_transactions_position AFTER INSERT ON transactions FOR EACH ROW WHEN NEW.position = 0 " +
+				"BEGIN UPDATE transactions SET position = (SELECT MAX(position) FROM transactions) + 1 WHERE rowid = NEW.rowid; END;",
+			"DROP INDEX idx_transactions_ticker_date;",
+			"DROP INDEX idx_transactions_date;",
+			"CREATE INDEX idx_transactions_ticker_date ON transactions(tickerSymbol, date, sequence, position);",
+			"CREATE INDEX idx_transactions_date ON transactions(date, sequence, position);",
+		},
+		down: []string{
+			"DROP INDEX idx_transactions_date;",
+			"DROP INDEX idx_transactions_ticker_date;",
+			"CREATE INDEX idx_transactions_ticker_date ON transactions(tickerSymbol, date, sequence, id);",
+			"CREATE INDEX idx_transactions_date ON transactions(date, sequence, id);",
+			"DROP TRIGGER trg_transactions_position;",
+			"ALTER TABLE transactions DROP COLUMN position;",
+		},
+		// BIGSERIAL nummeriert auch die vorhandenen Zeilen
+		postgresUp: []string{
+			"ALTER TABLE transactions ADD COLUMN position BIGSERIAL;",
+			"DROP INDEX idx_transactions_ticker_date;",
+			"DROP INDEX idx_transactions_date;",
+			"CREATE INDEX idx_transactions_ticker_date ON transactions(tickerSymbol, date, sequence, position);",
+			"CREATE INDEX idx_transactions_date ON transactions(date, sequence, position);",
+		},
+		postgresDown: []string{
+			"DROP INDEX idx_transactions_date;",
+			"DROP INDEX idx_transactions_ticker_date;",
+			"CREATE INDEX idx_transactions_ticker_date ON transactions(tickerSymbol, date, sequence, id);",
+			"CREATE INDEX idx_transactions_date ON transactions(date, sequence, id);",
+			"ALTER TABLE transactions DROP COLUMN position;",
+		},
+	},
+}
+
+// LatestSchemaVersion ist die Version, auf die MigrateUp das Schema bringt.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// transactional wird von allen Stores erfüllt. Damit wird jede Migration komplett oder gar nicht angewendet.
+type transactional interface {
+	Begin(ctx context.Context) error
+	Commit() error
+	Rollback() error
+}
+
+func withTransaction(ctx context.Context, store transactional, action func() error) error {
+	err := store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	err = action()
+	if err != nil {
+		rollbackErr := store.Rollback()
+		if rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return store.Commit()
+}
+
+func (s *DatabaseStorage) ensureMigrationTable(ctx context.Context, db dbExecutor) error {
+	hasMigrations, err := s.tableExists(ctx, db, "schema_migrations")
+	if err != nil {
+		return err
+	}
+	if hasMigrations {
+		return nil
+	}
+	hasTransactions, err := s.tableExists(ctx, db, "transactions")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, description TEXT, appliedAt DATETIME);")
+	if err != nil {
+		return fmt.Errorf("error at create table schema_migrations. %w", err)
+	}
+
+	// Datenbanken aus der Zeit vor den Migrationen haben das Schema der ersten Migration
+	if hasTransactions {
+		_, err = db.ExecContext(ctx, "INSERT INTO schema_migrations (version, description, appliedAt) VALUES (?, ?, ?);",
+			migrations[0].version, migrations[0].description, time.Now())
+		if err != nil {
+			return fmt.Errorf("error at insert baseline schema version. %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *DatabaseStorage) tableExists(ctx context.Context, db dbExecutor, name string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;"
+	if s.dialect != nil {
+		query = s.dialect.tableExistsQuery()
+	}
+	var count int
+	err := db.QueryRowContext(ctx, query, name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error at read schema. %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *DatabaseStorage) schemaVersion(ctx context.Context, db dbExecutor) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations;").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error at read schema version. %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func (s *DatabaseStorage) migrationStatus(ctx context.Context, db dbExecutor) ([]MigrationState, error) {
+	err := s.ensureMigrationTable(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]time.Time)
+	rows, err := db.QueryContext(ctx, "SELECT version, appliedAt FROM schema_migrations;")
+	if err != nil {
+		return nil, fmt.Errorf("error at read schema migrations. %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var applied time.Time
+		err = rows.Scan(&version, &applied)
+		if err != nil {
+			return nil, fmt.Errorf("error at scan schema migration. %w", err)
+		}
+		appliedAt[version] = applied
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.version, Description: m.description}
+		if applied, exists := appliedAt[m.version]; exists {
+			state.Applied = true
+			state.AppliedAt = &applied
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// migrateTo wendet alle Migrationen bis einschließlich target an oder nimmt alle
+// Migrationen oberhalb von target zurück.
+func (s *DatabaseStorage) migrateTo(ctx context.Context, db dbExecutor, target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("schema version %d not available, latest version is %d", target, LatestSchemaVersion())
+	}
+
+	err := s.ensureMigrationTable(ctx, db)
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("schema version %d of database is newer than the latest known version %d", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		for _, sqlStmt := range m.upStatements(s.dialect) {
+			_, err = db.ExecContext(ctx, sqlStmt)
+			if err != nil {
+				return fmt.Errorf("error at migration %d (%s). %w", m.version, m.description, err)
+			}
+		}
+		_, err = db.ExecContext(ctx, "INSERT INTO schema_migrations (version, description, appliedAt) VALUES (?, ?, ?);",
+			m.version, m.description, time.Now())
+		if err != nil {
+			return fmt.Errorf("error at insert schema version %d. %w", m.version, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		for _, sqlStmt := range m.downStatements(s.dialect) {
+			_, err = db.ExecContext(ctx, sqlStmt)
+			if err != nil {
+				return fmt.Errorf("error at revert migration %d (%s). %w", m.version, m.description, err)
+			}
+		}
+		_, err = db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?;", m.version)
+		if err != nil {
+			return fmt.Errorf("error at delete schema version %d. %w", m.version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sortierungen für TransactionQuery. Bei gleichem Wert wird nach Datum und Sequenznummer sortiert, danach
+// bei den SQL-Stores nach der Id und bei den dateibasierten Stores in der Reihenfolge des Einfügens.
+const (
+	SortByDate         = "date"
+	SortByTickerSymbol = "tickerSymbol"
+)
+
+// TransactionQuery filtert, sortiert und blättert Transaktionen. Leere Felder werden nicht gefiltert.
+// Mit Limit werden höchstens so viele Transaktionen geliefert. Die nächste Seite wird mit dem
+// NextCursor der vorherigen Seite und sonst gleicher Abfrage geholt.
+type TransactionQuery struct {
+	From            time.Time //Einschließlich
+	To              time.Time //Ausschließlich
+	TickerSymbol    string
+	TransactionType string
+	AssetType       string
+	Currency        string
+	SortBy          string //SortByDate (Standard) oder SortByTickerSymbol
+	Descending      bool
+	Limit           int //0 liefert alle Treffer
+	Cursor          string
+}
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"` //Leer auf der letzten Seite
+}
+
+// RealizedGainQuery filtert Abrechnungen über die Verkaufstransaktion. Sortiert wird nach dem Datum des Verkaufs.
+type RealizedGainQuery struct {
+	From         time.Time //Datum des Verkaufs, einschließlich
+	To           time.Time //Datum des Verkaufs, ausschließlich
+	TickerSymbol string
+	AssetType    string
+	Currency     string
+	Descending   bool
+	Limit        int //0 liefert alle Treffer
+	Cursor       string
+}
+
+type RealizedGainPage struct {
+	RealizedGains []RealizedGain `json:"realizedGains"`
+	NextCursor    string         `json:"nextCursor,omitempty"` //Leer auf der letzten Seite
+}
+
+// queryCursor ist die Sortierung des letzten Eintrags einer Seite. Die nächste Seite beginnt dahinter,
+// auch wenn inzwischen Einträge hinzugekommen oder gelöscht worden sind.
+type queryCursor struct {
+	Date         time.Time `json:"d"`
+	Sequence     int       `json:"s,omitempty"`
+	TickerSymbol string    `json:"t,omitempty"`
+	Id           uuid.UUID `json:"i"`
+	Position     int       `json:"p,omitempty"` //Reihenfolge des Einfügens: Index bei den dateibasierten Stores, Spalte position bei SQL
+}
+
+func (c queryCursor) encode() string {
+	content, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// decodeCursor liefert nil für einen leeren Cursor (erste Seite)
+func decodeCursor(value string) (*queryCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor. %w", err)
+	}
+	var cursor queryCursor
+	err = json.Unmarshal(content, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor. %w", err)
+	}
+	return &cursor, nil
+}
+
+func transactionCursor(transaction Transaction) queryCursor {
+	return queryCursor{Date: transaction.Date, Sequence: transaction.Sequence,
+		TickerSymbol: transaction.TickerSymbol, Id: transaction.Id}
+}
+
+func (q TransactionQuery) validate() error {
+	if q.SortBy != "" && q.SortBy != SortByDate && q.SortBy != SortByTickerSymbol {
+		return fmt.Errorf("sort by %q not supported, use %s or %s", q.SortBy, SortByDate, SortByTickerSymbol)
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("limit %d must not be negative", q.Limit)
+	}
+	return nil
+}
+
+func (q TransactionQuery) matches(transaction Transaction) bool {
+	return (q.From.IsZero() || !transaction.Date.Before(q.From)) &&
+		(q.To.IsZero() || transaction.Date.Before(q.To)) &&
+		(q.TickerSymbol == "" || transaction.TickerSymbol == q.TickerSymbol) &&
+		(q.TransactionType == "" || transaction.TransactionType == q.TransactionType) &&
+		(q.AssetType == "" || transaction.AssetType == q.AssetType) &&
+		(q.Currency == "" || transaction.Currency == q.Currency)
+}
+
+// compare vergleicht zwei Cursor in der Sortierung der Abfrage, ohne Berücksichtigung von Descending.
+// Bei gleichem Datum und gleicher Sequenznummer entscheidet die Reihenfolge des Einfügens.
+func (q TransactionQuery) compare(a queryCursor, b queryCursor) int {
+	result := 0
+	if q.SortBy == SortByTickerSymbol {
+		result = strings.Compare(a.TickerSymbol, b.TickerSymbol)
+	}
+	if result == 0 {
+		result = a.Date.Compare(b.Date)
+	}
+	if result == 0 {
+		result = cmp.Compare(a.Sequence, b.Sequence)
+	}
+	if result == 0 {
+		result = cmp.Compare(a.Position, b.Position)
+	}
+	return result
+}
+
+// sortColumns sind die Spalten für ORDER BY und den Vergleich mit dem Cursor, values die Werte des Cursors dazu
+func (q TransactionQuery) sortColumns(cursor *queryCursor) (columns []string, values []any) {
+	columns = []string{"date", "sequence", "position"}
+	if cursor != nil {
+		values = []any{cursor.Date, cursor.Sequence, cursor.Position}
+	}
+	if q.SortBy == SortByTickerSymbol {
+		columns = append([]string{"tickerSymbol"}, columns...)
+		if cursor != nil {
+			values = append([]any{cursor.TickerSymbol}, values...)
+		}
+	}
+	return columns, values
+}
+
+func (q RealizedGainQuery) validate() error {
+	if q.Limit < 0 {
+		return fmt.Errorf("limit %d must not be negative", q.Limit)
+	}
+	return nil
+}
+
+func (q RealizedGainQuery) matches(gain RealizedGain, sell Transaction) bool {
+	return (q.From.IsZero() || !sell.Date.Before(q.From)) &&
+		(q.To.IsZero() || sell.Date.Before(q.To)) &&
+		(q.TickerSymbol == "" || sell.TickerSymbol == q.TickerSymbol) &&
+		(q.AssetType == "" || sell.AssetType == q.AssetType) &&
+		(q.Currency == "" || gain.Currency == q.Currency)
+}
+
+// keysetCondition vergleicht die Sortierspalten als Zeilenwert mit dem Cursor, z.B. (date, sequence, position) > (?, ?, ?).
+// SQLite und PostgreSQL können den Index dafür verwenden.
+func keysetCondition(columns []string, descending bool) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	operator := ">"
+	if descending {
+		operator = "<"
+	}
+	return "(" + strings.Join(columns, ", ") + ") " + operator + " (" + placeholders + ")"
+}
+
+func orderBy(columns []string, descending bool) string {
+	direction := ""
+	if descending {
+		direction = " DESC"
+	}
+	return " ORDER BY " + strings.Join(columns, direction+", ") + direction
+}
+
+// IterationPageSize ist die Anzahl der Transaktionen, die PageTransactions auf einmal liest
+const IterationPageSize = 1000
+
+// PageTransactions liest die Transaktionen seitenweise mit read. Zwischen den Seiten ist keine Abfrage
+// offen, der Aufrufer kann also während der Iteration in den Store schreiben. Limit begrenzt die Anzahl insgesamt.
+// read kann z.B. um jede Seite eine Sperre halten.
+func PageTransactions(ctx context.Context, query TransactionQuery,
+	read func(ctx context.Context, query TransactionQuery) (TransactionPage, error)) iter.Seq2[Transaction, error] {
+	return func(yield func(Transaction, error) bool) {
+		remaining := query.Limit
+		for {
+			pageQuery := query
+			pageQuery.Limit = IterationPageSize
+			if remaining > 0 && remaining < IterationPageSize {
+				pageQuery.Limit = remaining
+			}
+			page, err := read(ctx, pageQuery)
+			if err != nil {
+				yield(Transaction{}, err)
+				return
+			}
+			for _, transaction := range page.Transactions {
+				if !yield(transaction, nil) {
+					return
+				}
+			}
+			if remaining > 0 {
+				remaining -= len(page.Transactions)
+				if remaining <= 0 {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+}
+
+func (s *DatabaseStorage) queryTransactions(ctx context.Context, db dbExecutor, query TransactionQuery) (TransactionPage, error) {
+	page := TransactionPage{Transactions: make([]Transaction, 0)}
+	err := query.validate()
+	if err != nil {
+		return page, err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return page, err
+	}
+
+	var conditions []string
+	var args []any
+	if !query.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "date < ?")
+		args = append(args, query.To)
+	}
+	for _, filter := range []struct{ column, value string }{{"tickerSymbol", query.TickerSymbol},
+		{"transactionType", query.TransactionType}, {"assetType", query.AssetType}, {"currency", query.Currency}} {
+		if filter.value != "" {
+			conditions = append(conditions, filter.column+" = ?")
+			args = append(args, filter.value)
+		}
+	}
+	columns, cursorValues := query.sortColumns(cursor)
+	if cursor != nil {
+		conditions = append(conditions, keysetCondition(columns, query.Descending))
+		args = append(args, cursorValues...)
+	}
+
+	sqlStmt := "SELECT id, " + transactionColumns + ", position FROM transactions"
+	if len(conditions) > 0 {
+		sqlStmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlStmt += orderBy(columns, query.Descending)
+	if query.Limit > 0 {
+		//Ein Eintrag mehr zeigt, ob es eine weitere Seite gibt
+		sqlStmt += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return page, fmt.Errorf("error at query transactions. %w", err)
+	}
+	defer rows.Close()
+	var positions []int
+	for rows.Next() {
+		var position int
+		transaction, err := scanTransaction(positionScanner{rows, &position})
+		if err != nil {
+			return page, fmt.Errorf("error at scan transaction. %w", err)
+		}
+		page.Transactions = append(page.Transactions, transaction)
+		positions = append(positions, position)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	if query.Limit > 0 && len(page.Transactions) > query.Limit {
+		page.Transactions = page.Transactions[:query.Limit]
+		next := transactionCursor(page.Transactions[query.Limit-1])
+		next.Position = positions[query.Limit-1]
+		page.NextCursor = next.encode()
+	}
+	return page, nil
+}
+
+// positionScanner liest nach den Spalten der Transaktion noch die Spalte position
+type positionScanner struct {
+	row      rowScanner
+	position *int
+}
+
+func (p positionScanner) Scan(dest ...any) error {
+	return p.row.Scan(append(dest, p.position)...)
+}
+
+func (s *DatabaseStorage) queryRealizedGains(ctx context.Context, db dbExecutor, query RealizedGainQuery) (RealizedGainPage, error) {
+	page := RealizedGainPage{RealizedGains: make([]RealizedGain, 0)}
+	err := query.validate()
+	if err != nil {
+		return page, err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return page, err
+	}
+
+	var conditions []string
+	var args []any
+	if !query.From.IsZero() {
+		conditions = append(conditions, "t.date >= ?")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "t.date < ?")
+		args = append(args, query.To)
+	}
+	for _, filter := range []struct{ column, value string }{{"t.tickerSymbol", query.TickerSymbol},
+		{"t.assetType", query.AssetType}, {"g.currency", query.Currency}} {
+		if filter.value != "" {
+			conditions = append(conditions, filter.column+" = ?")
+			args = append(args, filter.value)
+		}
+	}
+	columns := []string{"t.date", "g.id"}
+	if cursor != nil {
+		conditions = append(conditions, keysetCondition(columns, query.Descending))
+		args = append(args, cursor.Date, cursor.Id)
+	}
+
+	sqlStmt := "SELECT g.id, g.sellTransactionId, g.buyTransactionId, g.asset, g.amount, g.isProfit, g.taxRate, g.quantity, " +
+		"g.buyPrice, g.sellPrice, g.currency, t.date FROM realized_gains g JOIN transactions t ON t.id = g.sellTransactionId"
+	if len(conditions) > 0 {
+		sqlStmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlStmt += orderBy(columns, query.Descending)
+	if query.Limit > 0 {
+		sqlStmt += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return page, fmt.Errorf("error at query realized gains. %w", err)
+	}
+	defer rows.Close()
+	var sellDates []time.Time
+	for rows.Next() {
+		var gain RealizedGain
+		var sellDate time.Time
+		err = rows.Scan(&gain.Id, &gain.SellTransactionId, &gain.BuyTransactionId, &gain.Asset, &gain.Amount, &gain.IsProfit,
+			&gain.TaxRate, &gain.Quantity, &gain.BuyPrice, &gain.SellPrice, &gain.Currency, &sellDate)
+		if err != nil {
+			return page, fmt.Errorf("error at scan realized gain. %w", err)
+		}
+		page.RealizedGains = append(page.RealizedGains, gain)
+		sellDates = append(sellDates, sellDate)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	if query.Limit > 0 && len(page.RealizedGains) > query.Limit {
+		page.RealizedGains = page.RealizedGains[:query.Limit]
+		page.NextCursor = queryCursor{Date: sellDates[query.Limit-1], Id: page.RealizedGains[query.Limit-1].Id}.encode()
+	}
+	return page, nil
+}
@@ -3,15 +3,15 @@ package storage
 import "github.com/google/uuid"
 
 type RealizedGain struct {
-	Id                uuid.UUID `json:"id"`                // ID der Realisierung
-	SellTransactionId uuid.UUID `json:"sellTransactionId"` // ID der Verkaufstransaktion
-	BuyTransactionId  uuid.UUID `json:"buytransactionId"`  // ID der Kauftransaktion
-	Asset             string    // Asset-Name
-	Amount            float64   // Der Gewinn/Verlust-Betrag
-	IsProfit          bool      // true für Gewinn, false für Verlust
-	TaxRate           float64   // Anwendbarer Steuersatz
-	Quantity          float64
-	BuyPrice          float64
-	SellPrice         float64
-	Currency          string
+	Id                uuid.UUID `json:"id" yaml:"id"`                               // ID der Realisierung
+	SellTransactionId uuid.UUID `json:"sellTransactionId" yaml:"sellTransactionId"` // ID der Verkaufstransaktion
+	BuyTransactionId  uuid.UUID `json:"buytransactionId" yaml:"buyTransactionId"`   // ID der Kauftransaktion
+	Asset             string    `yaml:"asset"`                                      // Asset-Name
+	Amount            float64   `yaml:"amount"`                                     // Der Gewinn/Verlust-Betrag
+	IsProfit          bool      `yaml:"isProfit"`                                   // true für Gewinn, false für Verlust
+	TaxRate           float64   `yaml:"taxRate"`                                    // Anwendbarer Steuersatz
+	Quantity          float64   `yaml:"quantity"`
+	BuyPrice          float64   `yaml:"buyPrice"`
+	SellPrice         float64   `yaml:"sellPrice"`
+	Currency          string    `yaml:"currency"`
 }
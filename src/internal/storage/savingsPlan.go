@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SavingsPlan beschreibt einen Sparplan, der in einem festen Intervall ein Asset kauft.
+type SavingsPlan struct {
+	Id           uuid.UUID  `json:"id" yaml:"id"`
+	AssetType    string     `json:"assetType" yaml:"assetType" binding:"required"` //stock, crypto, forex
+	Asset        string     `json:"asset" yaml:"asset" binding:"required"`
+	TickerSymbol string     `json:"tickerSymbol" yaml:"tickerSymbol" binding:"required"`
+	Amount       float64    `json:"amount" yaml:"amount" binding:"required"` //Sparrate pro Ausführung
+	Currency     string     `json:"currency" yaml:"currency" binding:"required"`
+	Interval     string     `json:"interval" yaml:"interval" binding:"required"`         //monthly, quarterly, semiannually, yearly
+	ExecutionDay int        `json:"executionDay" yaml:"executionDay" binding:"required"` //Tag im Monat (1-31), wird auf das Monatsende begrenzt
+	StartDate    time.Time  `json:"startDate" yaml:"startDate" binding:"required"`
+	EndDate      *time.Time `json:"endDate" yaml:"endDate"`
+	LastDueDate  *time.Time `json:"lastDueDate" yaml:"lastDueDate"` //Letzte Fälligkeit, für die eine Transaktion erzeugt wurde
+}
+
+// PendingTransaction ist eine von einem Sparplan erzeugte Kauf-Transaktion, deren
+// Ausführungspreis und Gebühren noch bestätigt werden müssen.
+type PendingTransaction struct {
+	Id            uuid.UUID `json:"id" yaml:"id"`
+	SavingsPlanId uuid.UUID `json:"savingsPlanId" yaml:"savingsPlanId"`
+	DueDate       time.Time `json:"dueDate" yaml:"dueDate"`
+	AssetType     string    `json:"assetType" yaml:"assetType"`
+	Asset         string    `json:"asset" yaml:"asset"`
+	TickerSymbol  string    `json:"tickerSymbol" yaml:"tickerSymbol"`
+	Amount        float64   `json:"amount" yaml:"amount"`
+	Currency      string    `json:"currency" yaml:"currency"`
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sqlStore enthält alles, was die SQL-Stores gemeinsam haben. Die SQL-Anweisungen stehen
+// in DatabaseStorage. Die einzelnen Datenbanken unterscheiden sich nur beim Öffnen und im Dialekt.
+type sqlStore struct {
+	baseDb DatabaseStorage
+	db     *sql.DB
+	tx     *sql.Tx //Laufende Transaktion zwischen Begin und Commit / Rollback
+	//Context von Begin. Die Transaktion selbst ist nicht daran gebunden, weil database/sql sonst beim
+	//Abbruch die Verbindung verwirft und damit eine Datenbank im Speicher verloren wäre.
+	txCtx context.Context
+	actor string //Wird bei jeder Änderung ins Audit-Log geschrieben
+	//Wird nach jedem erfolgreichen Commit aufgerufen, z.B. um eine verschlüsselte Datenbank zu speichern
+	afterCommit func() error
+}
+
+func (s *sqlStore) CreateDatabase(ctx context.Context) error {
+	return s.baseDb.createDatabase(ctx, s.conn())
+}
+
+func (s *sqlStore) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	return s.baseDb.migrationStatus(ctx, s.conn())
+}
+
+func (s *sqlStore) MigrateUp(ctx context.Context) error {
+	return s.MigrateTo(ctx, LatestSchemaVersion())
+}
+
+func (s *sqlStore) MigrateTo(ctx context.Context, version int) error {
+	return withTransaction(ctx, s, func() error {
+		return s.baseDb.migrateTo(ctx, s.conn(), version)
+	})
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.baseDb.ping(ctx, s.db)
+}
+
+func (s *sqlStore) Begin(ctx context.Context) error {
+	if s.tx != nil {
+		return errors.New("transaction already started")
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("error at begin transaction. %w", err)
+	}
+	tx, err := s.db.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return fmt.Errorf("error at begin transaction. %w", err)
+	}
+	s.tx = tx
+	s.txCtx = ctx
+	return nil
+}
+
+func (s *sqlStore) Commit() error {
+	if s.tx == nil {
+		return errors.New("no transaction started")
+	}
+	if err := s.txCtx.Err(); err != nil {
+		s.tx.Rollback()
+		s.tx = nil
+		return fmt.Errorf("error at commit transaction. %w", err)
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	if err != nil {
+		return fmt.Errorf("error at commit transaction. %w", err)
+	}
+	if s.afterCommit != nil {
+		return s.afterCommit()
+	}
+	return nil
+}
+
+func (s *sqlStore) Rollback() error {
+	if s.tx == nil {
+		return errors.New("no transaction started")
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	if err != nil {
+		return fmt.Errorf("error at rollback transaction. %w", err)
+	}
+	return nil
+}
+
+// conn liefert die laufende Transaktion oder, wenn keine gestartet ist, die Datenbank.
+func (s *sqlStore) conn() dbExecutor {
+	var db dbExecutor = s.db
+	if s.tx != nil {
+		db = s.tx
+	}
+	if s.baseDb.dialect != nil {
+		return dialectExecutor{db: db, dialect: s.baseDb.dialect}
+	}
+	return db
+}
+
+func (s *sqlStore) Close() error {
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return errors.New("database instance is nil, cannot close")
+}
+
+func (s *sqlStore) AddTransaction(ctx context.Context, transaction *Transaction) error {
+	return s.audited(ctx, "AddTransaction", "transaction", transaction.Id.String(), nil, transaction, func(db dbExecutor) error {
+		return s.baseDb.insertTransaction(ctx, db, transaction)
+	})
+}
+
+func (s *sqlStore) UpdateTransaction(ctx context.Context, transaction *Transaction) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadTransactionById(ctx, db, transaction.Id)
+	}
+	return s.audited(ctx, "UpdateTransaction", "transaction", transaction.Id.String(), before, transaction, func(db dbExecutor) error {
+		return s.baseDb.updateTransaction(ctx, db, transaction)
+	})
+}
+
+func (s *sqlStore) RemoveTransaction(ctx context.Context, id uuid.UUID) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadTransactionById(ctx, db, id)
+	}
+	return s.audited(ctx, "RemoveTransaction", "transaction", id.String(), before, nil, func(db dbExecutor) error {
+		return s.baseDb.deleteTransaction(ctx, db, id)
+	})
+}
+
+func (s *sqlStore) LoadTransactionById(ctx context.Context, id uuid.UUID) (*Transaction, error) {
+	return s.baseDb.loadTransactionById(ctx, s.conn(), id)
+}
+
+func (s *sqlStore) LoadTransactionByParams(ctx context.Context, date time.Time, transType string, tickSymbol string) (*Transaction, error) {
+	return s.baseDb.loadTransactionByParams(ctx, s.conn(), date, transType, tickSymbol)
+}
+
+func (s *sqlStore) LoadTransactionByOrderNumber(ctx context.Context, broker string, orderNumber string, executionId string) (*Transaction, error) {
+	return s.baseDb.loadTransactionByOrderNumber(ctx, s.conn(), broker, orderNumber, executionId)
+}
+
+func (s *sqlStore) ReadTransactionsByTickerSymbol(ctx context.Context, tickSymbol string) ([]Transaction, error) {
+	return s.baseDb.loadTransactionsByTickerSymbol(ctx, s.conn(), tickSymbol)
+}
+
+func (s *sqlStore) ReadAllTransactions(ctx context.Context) ([]Transaction, error) {
+	return s.baseDb.loadAllTransactions(ctx, s.conn())
+}
+
+func (s *sqlStore) QueryTransactions(ctx context.Context, query TransactionQuery) (TransactionPage, error) {
+	return s.baseDb.queryTransactions(ctx, s.conn(), query)
+}
+
+func (s *sqlStore) IterateTransactions(ctx context.Context, query TransactionQuery) iter.Seq2[Transaction, error] {
+	return PageTransactions(ctx, query, s.QueryTransactions)
+}
+
+func (s *sqlStore) AddUnclosedTransaction(ctx context.Context, asset Transaction) error {
+	return s.audited(ctx, "AddUnclosedTransaction", "unclosedTransaction", asset.Id.String(), nil, asset, func(db dbExecutor) error {
+		return s.baseDb.insertUnclosedTransaction(ctx, db, asset)
+	})
+}
+
+func (s *sqlStore) ReadAllUnclosedTransactions(ctx context.Context) (map[string][]Transaction, error) {
+	return s.baseDb.loadUnclosedTransactions(ctx, s.conn())
+}
+
+func (s *sqlStore) UpdateUnclosedTransaction(ctx context.Context, trans Transaction) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadUnclosedTransaction(ctx, db, trans.Id)
+	}
+	return s.audited(ctx, "UpdateUnclosedTransaction", "unclosedTransaction", trans.Id.String(), before, trans, func(db dbExecutor) error {
+		return s.baseDb.updateUnclosedTransaction(ctx, db, trans)
+	})
+}
+
+func (s *sqlStore) RemoveUnclosedTransaction(ctx context.Context, id uuid.UUID) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadUnclosedTransaction(ctx, db, id)
+	}
+	return s.audited(ctx, "RemoveUnclosedTransaction", "unclosedTransaction", id.String(), before, nil, func(db dbExecutor) error {
+		return s.baseDb.deleteUnclosedTransaction(ctx, db, id)
+	})
+}
+
+func (s *sqlStore) RemoveAllUnclosedTransactions(ctx context.Context) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.summarize(ctx, db, "unclosed_trans")
+	}
+	return s.audited(ctx, "RemoveAllUnclosedTransactions", "unclosedTransaction", "", before, nil, func(db dbExecutor) error {
+		return s.baseDb.deleteAllUnclosedTransaction(ctx, db)
+	})
+}
+
+// Wird eigentlich nicht benötigt.
+func (s *sqlStore) ReadAllUnclosedTickerSymbols(ctx context.Context) ([]string, error) {
+	return s.baseDb.loadUnclosedTickerSymbols(ctx, s.conn())
+}
+
+func (s *sqlStore) AddRealizedGain(ctx context.Context, realizedGain RealizedGain) error {
+	return s.audited(ctx, "AddRealizedGain", "realizedGain", realizedGain.Id.String(), nil, realizedGain, func(db dbExecutor) error {
+		return s.baseDb.insertRealizedGain(ctx, db, &realizedGain)
+	})
+}
+
+func (s *sqlStore) ReadAllRealizedGains(ctx context.Context) ([]RealizedGain, error) {
+	return s.baseDb.loadAllRealizedGains(ctx, s.conn())
+}
+
+func (s *sqlStore) QueryRealizedGains(ctx context.Context, query RealizedGainQuery) (RealizedGainPage, error) {
+	return s.baseDb.queryRealizedGains(ctx, s.conn(), query)
+}
+
+func (s *sqlStore) RemoveAllRealizedGains(ctx context.Context) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.summarize(ctx, db, "realized_gains")
+	}
+	return s.audited(ctx, "RemoveAllRealizedGains", "realizedGain", "", before, nil, func(db dbExecutor) error {
+		return s.baseDb.removeRealizedGains(ctx, db)
+	})
+}
+
+func (s *sqlStore) AddSavingsPlan(ctx context.Context, plan *SavingsPlan) error {
+	return s.audited(ctx, "AddSavingsPlan", "savingsPlan", plan.Id.String(), nil, plan, func(db dbExecutor) error {
+		return s.baseDb.insertSavingsPlan(ctx, db, plan)
+	})
+}
+
+func (s *sqlStore) UpdateSavingsPlan(ctx context.Context, plan *SavingsPlan) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadSavingsPlan(ctx, db, plan.Id)
+	}
+	return s.audited(ctx, "UpdateSavingsPlan", "savingsPlan", plan.Id.String(), before, plan, func(db dbExecutor) error {
+		return s.baseDb.updateSavingsPlan(ctx, db, plan)
+	})
+}
+
+func (s *sqlStore) RemoveSavingsPlan(ctx context.Context, id uuid.UUID) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadSavingsPlan(ctx, db, id)
+	}
+	return s.audited(ctx, "RemoveSavingsPlan", "savingsPlan", id.String(), before, nil, func(db dbExecutor) error {
+		return s.baseDb.deleteSavingsPlan(ctx, db, id)
+	})
+}
+
+func (s *sqlStore) ReadAllSavingsPlans(ctx context.Context) ([]SavingsPlan, error) {
+	return s.baseDb.loadAllSavingsPlans(ctx, s.conn())
+}
+
+func (s *sqlStore) AddInstrument(ctx context.Context, instrument *Instrument) error {
+	return s.audited(ctx, "AddInstrument", "instrument", instrument.Isin, nil, instrument, func(db dbExecutor) error {
+		return s.baseDb.insertInstrument(ctx, db, instrument)
+	})
+}
+
+func (s *sqlStore) UpdateInstrument(ctx context.Context, instrument *Instrument) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadInstrument(ctx, db, instrument.Isin)
+	}
+	return s.audited(ctx, "UpdateInstrument", "instrument", instrument.Isin, before, instrument, func(db dbExecutor) error {
+		return s.baseDb.updateInstrument(ctx, db, instrument)
+	})
+}
+
+func (s *sqlStore) RemoveInstrument(ctx context.Context, isin string) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadInstrument(ctx, db, isin)
+	}
+	return s.audited(ctx, "RemoveInstrument", "instrument", isin, before, nil, func(db dbExecutor) error {
+		return s.baseDb.deleteInstrument(ctx, db, isin)
+	})
+}
+
+func (s *sqlStore) LoadInstrument(ctx context.Context, isin string) (*Instrument, error) {
+	return s.baseDb.loadInstrument(ctx, s.conn(), isin)
+}
+
+func (s *sqlStore) LoadInstrumentByTicker(ctx context.Context, tickerSymbol string) (*Instrument, error) {
+	return s.baseDb.loadInstrumentByTicker(ctx, s.conn(), tickerSymbol)
+}
+
+func (s *sqlStore) SearchInstruments(ctx context.Context, query InstrumentQuery) ([]Instrument, error) {
+	return s.baseDb.queryInstruments(ctx, s.conn(), query)
+}
+
+func (s *sqlStore) AddPendingTransaction(ctx context.Context, pending *PendingTransaction) error {
+	return s.audited(ctx, "AddPendingTransaction", "pendingTransaction", pending.Id.String(), nil, pending, func(db dbExecutor) error {
+		return s.baseDb.insertPendingTransaction(ctx, db, pending)
+	})
+}
+
+func (s *sqlStore) LoadPendingTransaction(ctx context.Context, id uuid.UUID) (*PendingTransaction, error) {
+	return s.baseDb.loadPendingTransaction(ctx, s.conn(), id)
+}
+
+func (s *sqlStore) ReadAllPendingTransactions(ctx context.Context) ([]PendingTransaction, error) {
+	return s.baseDb.loadAllPendingTransactions(ctx, s.conn())
+}
+
+func (s *sqlStore) RemovePendingTransaction(ctx context.Context, id uuid.UUID) error {
+	before := func(db dbExecutor) (any, error) {
+		return s.baseDb.loadPendingTransaction(ctx, db, id)
+	}
+	return s.audited(ctx, "RemovePendingTransaction", "pendingTransaction", id.String(), before, nil, func(db dbExecutor) error {
+		return s.baseDb.deletePendingTransaction(ctx, db, id)
+	})
+}
+
+func (s *sqlStore) AppendEvent(ctx context.Context, event *JournalEvent) error {
+	return s.baseDb.insertJournalEvent(ctx, s.conn(), event)
+}
+
+func (s *sqlStore) ReadEvents(ctx context.Context, afterSequence int64) ([]JournalEvent, error) {
+	return s.baseDb.loadJournalEvents(ctx, s.conn(), afterSequence)
+}
+
+func (s *sqlStore) AddSnapshot(ctx context.Context, snapshot *DepotSnapshot) error {
+	return s.baseDb.insertDepotSnapshot(ctx, s.conn(), snapshot)
+}
+
+func (s *sqlStore) LoadLatestSnapshot(ctx context.Context) (*DepotSnapshot, error) {
+	return s.baseDb.loadLatestDepotSnapshot(ctx, s.conn())
+}
+
+func (s *sqlStore) SetActor(actor string) {
+	s.actor = actor
+}
+
+func (s *sqlStore) AddAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if entry.Actor == "" {
+		entry.Actor = s.actor
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	return s.baseDb.insertAuditEntry(ctx, s.conn(), entry)
+}
+
+func (s *sqlStore) ReadAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	return s.baseDb.loadAuditEntries(ctx, s.conn(), filter)
+}
+
+// audited führt eine Änderung aus und schreibt sie ins Audit-Log. before liest den Stand vor der
+// Änderung, after ist der neue Stand. Ohne laufende Transaktion werden Änderung und Eintrag in einer
+// eigenen Transaktion gespeichert, damit es keine Änderung ohne Eintrag gibt.
+func (s *sqlStore) audited(ctx context.Context, operation string, entity string, entityId string, before func(db dbExecutor) (any, error),
+	after any, action func(db dbExecutor) error) error {
+	change := func() error {
+		db := s.conn()
+		var previous any
+		if before != nil && !withoutAudit(ctx) {
+			var err error
+			previous, err = before(db)
+			if err != nil {
+				return err
+			}
+		}
+		err := action(db)
+		if err != nil {
+			return err
+		}
+		if withoutAudit(ctx) {
+			return nil
+		}
+
+		entry := AuditEntry{Actor: s.actor, CreatedAt: time.Now(), Operation: operation, Entity: entity, EntityId: entityId}
+		for _, value := range []struct {
+			target *json.RawMessage
+			value  any
+		}{{&entry.Before, previous}, {&entry.After, after}} {
+			content, err := auditValue(value.value)
+			if err != nil {
+				return fmt.Errorf("error at encode audit entry. %w", err)
+			}
+			if content.Valid {
+				*value.target = json.RawMessage(content.String)
+			}
+		}
+		return s.baseDb.insertAuditEntry(ctx, db, &entry)
+	}
+	if s.tx != nil {
+		return change()
+	}
+	return withTransaction(ctx, s, change)
+}
@@ -1,18 +1,65 @@
 package storage
 
-import "time"
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
 
+	"github.com/google/uuid"
+)
+
+// ErrNotFound wird (eingepackt) zurückgegeben, wenn ein Eintrag geändert oder gelöscht
+// werden soll, den es nicht gibt. Load-Funktionen liefern dagegen nil ohne Fehler.
+var ErrNotFound = errors.New("not found")
+
+// Store speichert Transaktionen, offene Positionen, Gewinne und Sparpläne. Alle Aufrufe mit ctx
+// brechen mit dem Fehler von ctx ab, wenn der Context abgebrochen wird oder seine Deadline abläuft.
 type Store interface {
-	Ping() error
-	CreateDatabase() error
-	AddTransaction(transaction *Transaction) error
-	LoadTransactionByParams(date time.Time, transType string, tickSymbol string) (*Transaction, error)
-	ReadAllTransactions() ([]Transaction, error)
-	AddUnclosedTransaction(asset Transaction) error
-	RemoveAllUnclosedTransactions() error
-	ReadAllUnclosedTickerSymbols() ([]string, error)
-	ReadAllUnclosedTransactions() (map[string][]Transaction, error)
-	AddRealizedGain(realizedGain RealizedGain) error
-	ReadAllRealizedGains() ([]RealizedGain, error)
-	RemoveAllRealizedGains() error
+	//Open muss vor allen anderen Aufrufen erfolgen, Close gibt die Verbindung wieder frei
+	Open() error
+	Close() error
+	Ping(ctx context.Context) error
+	CreateDatabase(ctx context.Context) error
+	//Begin startet eine Transaktion. Alle folgenden Aufrufe werden erst mit Commit
+	//gespeichert und mit Rollback verworfen. Transaktionen können nicht geschachtelt werden.
+	//Wird ctx von Begin abgebrochen, wird die Transaktion zurückgerollt und Commit schlägt fehl.
+	Begin(ctx context.Context) error
+	Commit() error
+	Rollback() error
+	AddTransaction(ctx context.Context, transaction *Transaction) error
+	UpdateTransaction(ctx context.Context, transaction *Transaction) error
+	RemoveTransaction(ctx context.Context, id uuid.UUID) error
+	LoadTransactionById(ctx context.Context, id uuid.UUID) (*Transaction, error)
+	LoadTransactionByParams(ctx context.Context, date time.Time, transType string, tickSymbol string) (*Transaction, error)
+	LoadTransactionByOrderNumber(ctx context.Context, broker string, orderNumber string, executionId string) (*Transaction, error)
+	ReadTransactionsByTickerSymbol(ctx context.Context, tickSymbol string) ([]Transaction, error)
+	ReadAllTransactions(ctx context.Context) ([]Transaction, error)
+	//QueryTransactions liefert die gefilterten Transaktionen seitenweise
+	QueryTransactions(ctx context.Context, query TransactionQuery) (TransactionPage, error)
+	//IterateTransactions liefert die Transaktionen der Abfrage nacheinander, ohne alle auf einmal zu laden.
+	//Limit begrenzt die Anzahl insgesamt. Nach einem Fehler endet die Iteration.
+	IterateTransactions(ctx context.Context, query TransactionQuery) iter.Seq2[Transaction, error]
+	AddUnclosedTransaction(ctx context.Context, asset Transaction) error
+	UpdateUnclosedTransaction(ctx context.Context, trans Transaction) error
+	RemoveUnclosedTransaction(ctx context.Context, id uuid.UUID) error
+	RemoveAllUnclosedTransactions(ctx context.Context) error
+	ReadAllUnclosedTickerSymbols(ctx context.Context) ([]string, error)
+	ReadAllUnclosedTransactions(ctx context.Context) (map[string][]Transaction, error)
+	AddRealizedGain(ctx context.Context, realizedGain RealizedGain) error
+	ReadAllRealizedGains(ctx context.Context) ([]RealizedGain, error)
+	QueryRealizedGains(ctx context.Context, query RealizedGainQuery) (RealizedGainPage, error)
+	RemoveAllRealizedGains(ctx context.Context) error
+	SavingsPlanStore
+}
+
+type SavingsPlanStore interface {
+	AddSavingsPlan(ctx context.Context, plan *SavingsPlan) error
+	UpdateSavingsPlan(ctx context.Context, plan *SavingsPlan) error
+	RemoveSavingsPlan(ctx context.Context, id uuid.UUID) error
+	ReadAllSavingsPlans(ctx context.Context) ([]SavingsPlan, error)
+	AddPendingTransaction(ctx context.Context, pending *PendingTransaction) error
+	LoadPendingTransaction(ctx context.Context, id uuid.UUID) (*PendingTransaction, error)
+	ReadAllPendingTransactions(ctx context.Context) ([]PendingTransaction, error)
+	RemovePendingTransaction(ctx context.Context, id uuid.UUID) error
 }
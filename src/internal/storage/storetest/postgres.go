@@ -0,0 +1,55 @@
+package storetest
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+// PostgresDsnEnv ist die Umgebungsvariable mit dem DSN einer PostgreSQL-Datenbank.
+// Ohne sie werden die Tests gegen PostgreSQL übersprungen, außer in der CI.
+const PostgresDsnEnv = "STOCKPORTFOLIO_POSTGRES_DSN"
+
+// PostgresDSN liefert den DSN aus PostgresDsnEnv. Fehlt er, wird der Test übersprungen. Ist die
+// Umgebungsvariable CI gesetzt (z.B. in GitHub Actions), schlägt er fehl, damit die Tests gegen
+// PostgreSQL dort nicht unbemerkt wegfallen.
+func PostgresDSN(t *testing.T) string {
+	dsn := os.Getenv(PostgresDsnEnv)
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s must be set in CI", PostgresDsnEnv)
+		}
+		t.Skipf("%s not set", PostgresDsnEnv)
+	}
+	return dsn
+}
+
+// PostgresSchemaDSN legt für den Test ein eigenes Schema an, löscht es nach dem Test wieder
+// und liefert den DSN, der dieses Schema als search_path verwendet.
+func PostgresSchemaDSN(t *testing.T, dsn string) string {
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to postgres: %v", err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err = admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
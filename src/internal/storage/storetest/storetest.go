@@ -0,0 +1,958 @@
+// Package storetest enthält die gemeinsamen Konformitätstests für alle Implementierungen
+// von storage.Store. Jeder Store muss sich bei Reihenfolge, Duplikaten und Fehlern gleich verhalten.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fritzrepo/stockportfolio/internal/storage"
+	"github.com/google/uuid"
+)
+
+// NewStore liefert für jeden Test einen geöffneten, leeren Store mit angelegtem Schema.
+// Das Schließen und Aufräumen registriert die Funktion selbst mit t.Cleanup.
+type NewStore func(t *testing.T) storage.Store
+
+// Run führt alle Konformitätstests als Untertests gegen den Store aus.
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{"TransactionRoundTrip", testTransactionRoundTrip},
+		{"TransactionOrder", testTransactionOrder},
+		{"SameDayOrder", testSameDayOrder},
+		{"DuplicateTransactions", testDuplicateTransactions},
+		{"LoadMissingEntries", testLoadMissingEntries},
+		{"UpdateAndRemoveTransaction", testUpdateAndRemoveTransaction},
+		{"NotFound", testNotFound},
+		{"UnclosedTransactions", testUnclosedTransactions},
+		{"RealizedGains", testRealizedGains},
+		{"SavingsPlans", testSavingsPlans},
+		{"CommitAndRollback", testCommitAndRollback},
+		{"Migrations", testMigrations},
+		{"Journal", testJournal},
+		{"Instruments", testInstruments},
+		{"Queries", testQueries},
+		{"Iteration", testIteration},
+		{"Cancellation", testCancellation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func newTransaction(date time.Time, transactionType string, tickerSymbol string, sequence int) storage.Transaction {
+	return storage.Transaction{
+		Id:              uuid.New(),
+		Date:            date,
+		TransactionType: transactionType,
+		AssetType:       "stock",
+		Asset:           tickerSymbol + " Inc.",
+		TickerSymbol:    tickerSymbol,
+		Quantity:        10,
+		Price:           150.25,
+		Fees:            1.5,
+		Currency:        "USD",
+		Sequence:        sequence,
+	}
+}
+
+// sameTransaction vergleicht das Datum mit Equal, weil die Stores die Zeitzone unterschiedlich speichern.
+func sameTransaction(a storage.Transaction, b storage.Transaction) bool {
+	if !a.Date.Equal(b.Date) {
+		return false
+	}
+	a.Date, b.Date = time.Time{}, time.Time{}
+	return a == b
+}
+
+func ids(transactions []storage.Transaction) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(transactions))
+	for _, transaction := range transactions {
+		result = append(result, transaction.Id)
+	}
+	return result
+}
+
+func addTransactions(t *testing.T, store storage.Store, transactions ...storage.Transaction) {
+	ctx := context.Background()
+	t.Helper()
+	for _, transaction := range transactions {
+		if err := store.AddTransaction(ctx, &transaction); err != nil {
+			t.Fatalf("Failed to insert transaction: %v", err)
+		}
+	}
+}
+
+func testTransactionRoundTrip(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	transaction := newTransaction(time.Date(2023, 10, 1, 12, 30, 0, 0, time.UTC), "buy", "AAPL", 2)
+	transaction.Broker = "comdirect"
+	transaction.OrderNumber = "4711"
+	transaction.ExecutionId = "1"
+	transaction.Isin = "US0378331005"
+	addTransactions(t, store, transaction)
+
+	loaded, err := store.LoadTransactionById(ctx, transaction.Id)
+	if err != nil || loaded == nil || !sameTransaction(*loaded, transaction) {
+		t.Errorf("LoadTransactionById: expected %+v, but got %+v (%v)", transaction, loaded, err)
+	}
+	loaded, err = store.LoadTransactionByParams(ctx, transaction.Date, "buy", "AAPL")
+	if err != nil || loaded == nil || !sameTransaction(*loaded, transaction) {
+		t.Errorf("LoadTransactionByParams: expected %+v, but got %+v (%v)", transaction, loaded, err)
+	}
+	loaded, err = store.LoadTransactionByOrderNumber(ctx, "comdirect", "4711", "1")
+	if err != nil || loaded == nil || !sameTransaction(*loaded, transaction) {
+		t.Errorf("LoadTransactionByOrderNumber: expected %+v, but got %+v (%v)", transaction, loaded, err)
+	}
+
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if len(transactions) != 1 || !sameTransaction(transactions[0], transaction) {
+		t.Errorf("ReadAllTransactions: expected %+v, but got %+v", transaction, transactions)
+	}
+}
+
+func testTransactionOrder(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	day := time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC)
+	first := newTransaction(day.AddDate(0, 0, -1), "buy", "AAPL", 5)
+	second := newTransaction(day, "buy", "MSFT", 1)
+	third := newTransaction(day, "sell", "AAPL", 2)
+	fourth := newTransaction(day.AddDate(0, 0, 1), "buy", "AAPL", 0)
+	//Absichtlich nicht in chronologischer Reihenfolge eingefügt
+	addTransactions(t, store, fourth, third, first, second)
+
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	expected := []uuid.UUID{first.Id, second.Id, third.Id, fourth.Id}
+	if !slices.Equal(ids(transactions), expected) {
+		t.Errorf("Expected order %v, but got %v", expected, ids(transactions))
+	}
+
+	transactions, err = store.ReadTransactionsByTickerSymbol(ctx, "AAPL")
+	if err != nil {
+		t.Fatalf("Failed to read transactions by ticker symbol: %v", err)
+	}
+	expected = []uuid.UUID{first.Id, third.Id, fourth.Id}
+	if !slices.Equal(ids(transactions), expected) {
+		t.Errorf("Expected order %v, but got %v", expected, ids(transactions))
+	}
+
+	transactions, err = store.ReadTransactionsByTickerSymbol(ctx, "TSLA")
+	if err != nil || transactions == nil || len(transactions) != 0 {
+		t.Errorf("Expected empty list for unknown ticker symbol, but got %v (%v)", transactions, err)
+	}
+}
+
+// testSameDayOrder prüft, dass Transaktionen mit gleichem Zeitpunkt und gleicher Sequenz in der
+// Reihenfolge des Einfügens geliefert werden, auch seitenweise. Die zufälligen Ids dürfen keine Rolle spielen.
+func testSameDayOrder(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	day := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	var expected []uuid.UUID
+	for i := range 20 {
+		transactionType := "buy"
+		if i%2 == 1 {
+			transactionType = "sell"
+		}
+		transaction := newTransaction(day, transactionType, "AAPL", 0)
+		addTransactions(t, store, transaction)
+		expected = append(expected, transaction.Id)
+	}
+
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if !slices.Equal(ids(transactions), expected) {
+		t.Errorf("ReadAllTransactions: expected order %v, but got %v", expected, ids(transactions))
+	}
+	transactions, err = store.ReadTransactionsByTickerSymbol(ctx, "AAPL")
+	if err != nil {
+		t.Fatalf("Failed to read transactions by ticker symbol: %v", err)
+	}
+	if !slices.Equal(ids(transactions), expected) {
+		t.Errorf("ReadTransactionsByTickerSymbol: expected order %v, but got %v", expected, ids(transactions))
+	}
+	for _, sortBy := range []string{storage.SortByDate, storage.SortByTickerSymbol} {
+		got, _ := queryAll(t, store, storage.TransactionQuery{SortBy: sortBy, Limit: 3})
+		if !slices.Equal(got, expected) {
+			t.Errorf("QueryTransactions sorted by %s: expected order %v, but got %v", sortBy, expected, got)
+		}
+	}
+	var iterated []uuid.UUID
+	for transaction, err := range store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+		if err != nil {
+			t.Fatalf("Failed to iterate transactions: %v", err)
+		}
+		iterated = append(iterated, transaction.Id)
+	}
+	if !slices.Equal(iterated, expected) {
+		t.Errorf("IterateTransactions: expected order %v, but got %v", expected, iterated)
+	}
+}
+
+func testDuplicateTransactions(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	transaction := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	addTransactions(t, store, transaction)
+	if err := store.AddTransaction(ctx, &transaction); err == nil {
+		t.Error("Expected error when inserting the same id twice, but got none")
+	}
+
+	withOrder := newTransaction(transaction.Date, "buy", "AAPL", 1)
+	withOrder.Broker = "comdirect"
+	withOrder.OrderNumber = "4711"
+	addTransactions(t, store, withOrder)
+
+	sameOrder := newTransaction(transaction.Date, "buy", "AAPL", 2)
+	sameOrder.Broker = "comdirect"
+	sameOrder.OrderNumber = "4711"
+	if err := store.AddTransaction(ctx, &sameOrder); err == nil {
+		t.Error("Expected error when inserting the same order number twice, but got none")
+	}
+
+	//Ein anderer Teil desselben Auftrags und Transaktionen ohne Auftragsnummer sind erlaubt
+	otherExecution := sameOrder
+	otherExecution.ExecutionId = "2"
+	withoutOrder := newTransaction(transaction.Date, "buy", "AAPL", 3)
+	addTransactions(t, store, otherExecution, withoutOrder)
+
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if len(transactions) != 4 {
+		t.Errorf("Expected 4 transactions, but got %d", len(transactions))
+	}
+}
+
+func testLoadMissingEntries(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	transaction, err := store.LoadTransactionById(ctx, uuid.New())
+	if err != nil || transaction != nil {
+		t.Errorf("LoadTransactionById: expected nil without error, but got %+v (%v)", transaction, err)
+	}
+	transaction, err = store.LoadTransactionByParams(ctx, time.Now(), "buy", "AAPL")
+	if err != nil || transaction != nil {
+		t.Errorf("LoadTransactionByParams: expected nil without error, but got %+v (%v)", transaction, err)
+	}
+	transaction, err = store.LoadTransactionByOrderNumber(ctx, "comdirect", "4711", "")
+	if err != nil || transaction != nil {
+		t.Errorf("LoadTransactionByOrderNumber: expected nil without error, but got %+v (%v)", transaction, err)
+	}
+	pending, err := store.LoadPendingTransaction(ctx, uuid.New())
+	if err != nil || pending != nil {
+		t.Errorf("LoadPendingTransaction: expected nil without error, but got %+v (%v)", pending, err)
+	}
+
+	//Leere Stores liefern leere Listen und nicht nil
+	transactions, err := store.ReadAllTransactions(ctx)
+	if err != nil || transactions == nil || len(transactions) != 0 {
+		t.Errorf("ReadAllTransactions: expected empty list, but got %v (%v)", transactions, err)
+	}
+	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols(ctx)
+	if err != nil || len(tickerSymbols) != 0 {
+		t.Errorf("ReadAllUnclosedTickerSymbols: expected empty list, but got %v (%v)", tickerSymbols, err)
+	}
+	unclosed, err := store.ReadAllUnclosedTransactions(ctx)
+	if err != nil || len(unclosed) != 0 {
+		t.Errorf("ReadAllUnclosedTransactions: expected empty map, but got %v (%v)", unclosed, err)
+	}
+	gains, err := store.ReadAllRealizedGains(ctx)
+	if err != nil || len(gains) != 0 {
+		t.Errorf("ReadAllRealizedGains: expected empty list, but got %v (%v)", gains, err)
+	}
+	plans, err := store.ReadAllSavingsPlans(ctx)
+	if err != nil || len(plans) != 0 {
+		t.Errorf("ReadAllSavingsPlans: expected empty list, but got %v (%v)", plans, err)
+	}
+	pendingTransactions, err := store.ReadAllPendingTransactions(ctx)
+	if err != nil || len(pendingTransactions) != 0 {
+		t.Errorf("ReadAllPendingTransactions: expected empty list, but got %v (%v)", pendingTransactions, err)
+	}
+}
+
+func testUpdateAndRemoveTransaction(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	buy := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	sell := newTransaction(time.Date(2023, 10, 5, 0, 0, 0, 0, time.UTC), "sell", "AAPL", 0)
+	addTransactions(t, store, buy, sell)
+	if err := store.AddUnclosedTransaction(ctx, buy); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+	gain := storage.RealizedGain{Id: uuid.New(), SellTransactionId: sell.Id, BuyTransactionId: buy.Id,
+		Asset: buy.Asset, Amount: 10, IsProfit: true, Quantity: 5, BuyPrice: 150, SellPrice: 152, Currency: "USD"}
+	if err := store.AddRealizedGain(ctx, gain); err != nil {
+		t.Fatalf("Failed to insert realized gain: %v", err)
+	}
+
+	buy.Quantity = 20
+	buy.Price = 149.5
+	if err := store.UpdateTransaction(ctx, &buy); err != nil {
+		t.Fatalf("Failed to update transaction: %v", err)
+	}
+	loaded, err := store.LoadTransactionById(ctx, buy.Id)
+	if err != nil || loaded == nil || !sameTransaction(*loaded, buy) {
+		t.Errorf("Expected %+v after update, but got %+v (%v)", buy, loaded, err)
+	}
+
+	//Mit der Transaktion werden ihre Abrechnungen und offenen Positionen gelöscht
+	if err := store.RemoveTransaction(ctx, buy.Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	transactions, _ := store.ReadAllTransactions(ctx)
+	if !slices.Equal(ids(transactions), []uuid.UUID{sell.Id}) {
+		t.Errorf("Expected only the sell transaction, but got %+v", transactions)
+	}
+	gains, _ := store.ReadAllRealizedGains(ctx)
+	if len(gains) != 0 {
+		t.Errorf("Expected realized gains of the removed transaction to be deleted, but got %+v", gains)
+	}
+	unclosed, _ := store.ReadAllUnclosedTransactions(ctx)
+	if len(unclosed["AAPL"]) != 0 {
+		t.Errorf("Expected unclosed transaction of the removed transaction to be deleted, but got %+v", unclosed)
+	}
+}
+
+func testNotFound(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	missing := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	plan := &storage.SavingsPlan{Id: uuid.New(), Interval: "monthly", StartDate: missing.Date}
+
+	calls := map[string]func() error{
+		"UpdateTransaction":         func() error { return store.UpdateTransaction(ctx, &missing) },
+		"RemoveTransaction":         func() error { return store.RemoveTransaction(ctx, missing.Id) },
+		"UpdateUnclosedTransaction": func() error { return store.UpdateUnclosedTransaction(ctx, missing) },
+		"RemoveUnclosedTransaction": func() error { return store.RemoveUnclosedTransaction(ctx, missing.Id) },
+		"UpdateSavingsPlan":         func() error { return store.UpdateSavingsPlan(ctx, plan) },
+		"RemoveSavingsPlan":         func() error { return store.RemoveSavingsPlan(ctx, plan.Id) },
+		"RemovePendingTransaction":  func() error { return store.RemovePendingTransaction(ctx, uuid.New()) },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, but got %v", name, err)
+		}
+	}
+}
+
+func testUnclosedTransactions(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	first := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	second := newTransaction(time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	other := newTransaction(time.Date(2023, 10, 3, 0, 0, 0, 0, time.UTC), "buy", "MSFT", 0)
+	addTransactions(t, store, first, second, other)
+	for _, transaction := range []storage.Transaction{first, second, other} {
+		if err := store.AddUnclosedTransaction(ctx, transaction); err != nil {
+			t.Fatalf("Failed to insert unclosed transaction: %v", err)
+		}
+	}
+
+	tickerSymbols, err := store.ReadAllUnclosedTickerSymbols(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read unclosed ticker symbols: %v", err)
+	}
+	slices.Sort(tickerSymbols)
+	if !slices.Equal(tickerSymbols, []string{"AAPL", "MSFT"}) {
+		t.Errorf("Expected ticker symbols [AAPL MSFT], but got %v", tickerSymbols)
+	}
+
+	//Teilverkauf der ersten Position
+	first.Quantity = 4
+	if err = store.UpdateUnclosedTransaction(ctx, first); err != nil {
+		t.Fatalf("Failed to update unclosed transaction: %v", err)
+	}
+	unclosed, err := store.ReadAllUnclosedTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read unclosed transactions: %v", err)
+	}
+	//Die Positionen bleiben in der Reihenfolge des Einfügens (FIFO)
+	if len(unclosed["AAPL"]) != 2 || !sameTransaction(unclosed["AAPL"][0], first) || !sameTransaction(unclosed["AAPL"][1], second) {
+		t.Errorf("Expected %+v and %+v, but got %+v", first, second, unclosed["AAPL"])
+	}
+
+	//Ist die letzte Position eines Assets geschlossen, verschwindet auch das Asset
+	if err = store.RemoveUnclosedTransaction(ctx, other.Id); err != nil {
+		t.Fatalf("Failed to remove unclosed transaction: %v", err)
+	}
+	tickerSymbols, _ = store.ReadAllUnclosedTickerSymbols(ctx)
+	if !slices.Equal(tickerSymbols, []string{"AAPL"}) {
+		t.Errorf("Expected ticker symbols [AAPL], but got %v", tickerSymbols)
+	}
+
+	if err = store.RemoveAllUnclosedTransactions(ctx); err != nil {
+		t.Fatalf("Failed to remove all unclosed transactions: %v", err)
+	}
+	unclosed, _ = store.ReadAllUnclosedTransactions(ctx)
+	tickerSymbols, _ = store.ReadAllUnclosedTickerSymbols(ctx)
+	if len(unclosed) != 0 || len(tickerSymbols) != 0 {
+		t.Errorf("Expected no unclosed transactions, but got %+v and %v", unclosed, tickerSymbols)
+	}
+}
+
+func testRealizedGains(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	buy := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	sell := newTransaction(time.Date(2023, 10, 5, 0, 0, 0, 0, time.UTC), "sell", "AAPL", 0)
+	addTransactions(t, store, buy, sell)
+
+	gain := storage.RealizedGain{Id: uuid.New(), SellTransactionId: sell.Id, BuyTransactionId: buy.Id,
+		Asset: buy.Asset, Amount: 12.5, IsProfit: true, TaxRate: 0.25, Quantity: 5, BuyPrice: 150, SellPrice: 152.5, Currency: "USD"}
+	if err := store.AddRealizedGain(ctx, gain); err != nil {
+		t.Fatalf("Failed to insert realized gain: %v", err)
+	}
+	gains, err := store.ReadAllRealizedGains(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read realized gains: %v", err)
+	}
+	if len(gains) != 1 || gains[0] != gain {
+		t.Errorf("Expected %+v, but got %+v", gain, gains)
+	}
+
+	//Abrechnungen müssen auf gespeicherte Transaktionen verweisen
+	orphan := gain
+	orphan.Id = uuid.New()
+	orphan.SellTransactionId = uuid.New()
+	if err = store.AddRealizedGain(ctx, orphan); err == nil {
+		t.Error("Expected error when inserting a realized gain of an unknown transaction, but got none")
+	}
+
+	if err = store.RemoveAllRealizedGains(ctx); err != nil {
+		t.Fatalf("Failed to remove realized gains: %v", err)
+	}
+	gains, _ = store.ReadAllRealizedGains(ctx)
+	if len(gains) != 0 {
+		t.Errorf("Expected no realized gains, but got %+v", gains)
+	}
+}
+
+func testSavingsPlans(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	endDate := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	plan := &storage.SavingsPlan{
+		Id:           uuid.New(),
+		AssetType:    "etf",
+		Asset:        "MSCI World",
+		TickerSymbol: "EUNL",
+		Amount:       100,
+		Currency:     "EUR",
+		Interval:     "monthly",
+		ExecutionDay: 1,
+		StartDate:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:      &endDate,
+	}
+	if err := store.AddSavingsPlan(ctx, plan); err != nil {
+		t.Fatalf("Failed to insert savings plan: %v", err)
+	}
+	if err := store.AddSavingsPlan(ctx, plan); err == nil {
+		t.Error("Expected error when inserting the same savings plan twice, but got none")
+	}
+
+	lastDueDate := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	plan.LastDueDate = &lastDueDate
+	if err := store.UpdateSavingsPlan(ctx, plan); err != nil {
+		t.Fatalf("Failed to update savings plan: %v", err)
+	}
+	plans, err := store.ReadAllSavingsPlans(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read savings plans: %v", err)
+	}
+	if len(plans) != 1 || plans[0].Interval != "monthly" || plans[0].EndDate == nil || !plans[0].EndDate.Equal(endDate) ||
+		plans[0].LastDueDate == nil || !plans[0].LastDueDate.Equal(lastDueDate) {
+		t.Errorf("Expected %+v, but got %+v", plan, plans)
+	}
+
+	//Offene Transaktionen werden nach Fälligkeit sortiert
+	later := storage.PendingTransaction{Id: uuid.New(), SavingsPlanId: plan.Id, DueDate: lastDueDate,
+		AssetType: plan.AssetType, Asset: plan.Asset, TickerSymbol: plan.TickerSymbol, Amount: plan.Amount, Currency: plan.Currency}
+	earlier := later
+	earlier.Id = uuid.New()
+	earlier.DueDate = plan.StartDate
+	for _, pending := range []storage.PendingTransaction{later, earlier} {
+		if err = store.AddPendingTransaction(ctx, &pending); err != nil {
+			t.Fatalf("Failed to insert pending transaction: %v", err)
+		}
+	}
+	pendingTransactions, err := store.ReadAllPendingTransactions(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read pending transactions: %v", err)
+	}
+	if len(pendingTransactions) != 2 || pendingTransactions[0].Id != earlier.Id || pendingTransactions[1].Id != later.Id {
+		t.Errorf("Expected %v before %v, but got %+v", earlier.Id, later.Id, pendingTransactions)
+	}
+	loaded, err := store.LoadPendingTransaction(ctx, later.Id)
+	if err != nil || loaded == nil || loaded.SavingsPlanId != plan.Id || !loaded.DueDate.Equal(later.DueDate) || loaded.Amount != later.Amount {
+		t.Errorf("Expected %+v, but got %+v (%v)", later, loaded, err)
+	}
+
+	//Offene Transaktionen gehören zu einem gespeicherten Sparplan
+	orphan := later
+	orphan.Id = uuid.New()
+	orphan.SavingsPlanId = uuid.New()
+	if err = store.AddPendingTransaction(ctx, &orphan); err == nil {
+		t.Error("Expected error when inserting a pending transaction of an unknown savings plan, but got none")
+	}
+
+	if err = store.RemovePendingTransaction(ctx, earlier.Id); err != nil {
+		t.Fatalf("Failed to remove pending transaction: %v", err)
+	}
+	//Mit dem Sparplan werden auch die offenen Transaktionen gelöscht
+	if err = store.RemoveSavingsPlan(ctx, plan.Id); err != nil {
+		t.Fatalf("Failed to remove savings plan: %v", err)
+	}
+	pendingTransactions, _ = store.ReadAllPendingTransactions(ctx)
+	plans, _ = store.ReadAllSavingsPlans(ctx)
+	if len(pendingTransactions) != 0 || len(plans) != 0 {
+		t.Errorf("Expected no savings plans and pending transactions, but got %+v and %+v", plans, pendingTransactions)
+	}
+}
+
+func testCommitAndRollback(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	kept := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	addTransactions(t, store, kept)
+
+	if err := store.Commit(); err == nil {
+		t.Error("Expected error when committing without transaction, but got none")
+	}
+	if err := store.Rollback(); err == nil {
+		t.Error("Expected error when rolling back without transaction, but got none")
+	}
+
+	discarded := newTransaction(time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.Begin(ctx); err == nil {
+		t.Error("Expected error when beginning a nested transaction, but got none")
+	}
+	addTransactions(t, store, discarded)
+	if err := store.AddUnclosedTransaction(ctx, discarded); err != nil {
+		t.Fatalf("Failed to insert unclosed transaction: %v", err)
+	}
+	if err := store.RemoveTransaction(ctx, kept.Id); err != nil {
+		t.Fatalf("Failed to remove transaction: %v", err)
+	}
+	//Innerhalb der Transaktion sind die Änderungen sichtbar
+	transactions, _ := store.ReadAllTransactions(ctx)
+	if !slices.Equal(ids(transactions), []uuid.UUID{discarded.Id}) {
+		t.Errorf("Expected changes to be visible inside the transaction, but got %+v", transactions)
+	}
+	if err := store.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback transaction: %v", err)
+	}
+
+	transactions, _ = store.ReadAllTransactions(ctx)
+	unclosed, _ := store.ReadAllUnclosedTransactions(ctx)
+	if !slices.Equal(ids(transactions), []uuid.UUID{kept.Id}) || len(unclosed) != 0 {
+		t.Errorf("Expected state before the transaction after rollback, but got %+v and %+v", transactions, unclosed)
+	}
+
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	addTransactions(t, store, discarded)
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	transactions, _ = store.ReadAllTransactions(ctx)
+	if !slices.Equal(ids(transactions), []uuid.UUID{kept.Id, discarded.Id}) {
+		t.Errorf("Expected both transactions after commit, but got %+v", transactions)
+	}
+}
+
+// testMigrations gilt nur für Stores mit Datenbankschema.
+func testMigrations(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	migrator, ok := store.(storage.Migrator)
+	if !ok {
+		t.Skip("store has no schema migrations")
+	}
+
+	states, err := migrator.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read migration status: %v", err)
+	}
+	if len(states) != storage.LatestSchemaVersion() {
+		t.Fatalf("Expected %d migrations, but got %d", storage.LatestSchemaVersion(), len(states))
+	}
+	for _, state := range states {
+		if !state.Applied || state.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied after CreateDatabase", state.Version)
+		}
+	}
+	if err = migrator.MigrateUp(ctx); err != nil {
+		t.Errorf("Expected MigrateUp on the latest version to do nothing, but got %v", err)
+	}
+	if err = migrator.MigrateTo(ctx, storage.LatestSchemaVersion()+1); err == nil {
+		t.Error("Expected error when migrating to an unknown version, but got none")
+	}
+}
+
+func testJournal(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	journal, ok := store.(storage.Journal)
+	if !ok {
+		t.Skip("store has no journal")
+	}
+
+	if snapshot, err := journal.LoadLatestSnapshot(ctx); err != nil || snapshot != nil {
+		t.Fatalf("Expected no snapshot in an empty journal, but got %+v, %v", snapshot, err)
+	}
+	buy := newTransaction(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	split := storage.CorporateAction{Type: "split", TickerSymbol: "AAPL", Date: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), Ratio: 4}
+	events := []storage.JournalEvent{
+		{Type: storage.EventTransactionAdded, CreatedAt: time.Now(), Transaction: &buy, TransactionId: buy.Id},
+		{Type: storage.EventCorporateAction, CreatedAt: time.Now(), CorporateAction: &split},
+		{Type: storage.EventTransactionDeleted, CreatedAt: time.Now(), TransactionId: buy.Id},
+	}
+	for i := range events {
+		if err := journal.AppendEvent(ctx, &events[i]); err != nil {
+			t.Fatalf("Failed to append event: %v", err)
+		}
+		if i > 0 && events[i].Sequence <= events[i-1].Sequence {
+			t.Errorf("Expected increasing sequence numbers, but got %d after %d", events[i].Sequence, events[i-1].Sequence)
+		}
+	}
+
+	loaded, err := journal.ReadEvents(ctx, events[0].Sequence)
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Sequence != events[1].Sequence || loaded[1].Type != storage.EventTransactionDeleted {
+		t.Fatalf("Expected the last 2 events, but got %+v", loaded)
+	}
+	if loaded[0].CorporateAction == nil || loaded[0].CorporateAction.Ratio != 4 || !loaded[0].CorporateAction.Date.Equal(split.Date) {
+		t.Errorf("Expected corporate action %+v, but got %+v", split, loaded[0].CorporateAction)
+	}
+	if loaded[1].TransactionId != buy.Id {
+		t.Errorf("Expected deleted transaction %s, but got %s", buy.Id, loaded[1].TransactionId)
+	}
+	all, _ := journal.ReadEvents(ctx, 0)
+	if len(all) != 3 || all[0].Transaction == nil || !sameTransaction(*all[0].Transaction, buy) {
+		t.Errorf("Expected 3 events starting with %+v, but got %+v", buy, all)
+	}
+
+	for _, sequence := range []int64{events[0].Sequence, events[1].Sequence} {
+		snapshot := storage.DepotSnapshot{EventSequence: sequence, CreatedAt: time.Now(), State: []byte(fmt.Sprintf(`{"after":%d}`, sequence))}
+		if err = journal.AddSnapshot(ctx, &snapshot); err != nil {
+			t.Fatalf("Failed to add snapshot: %v", err)
+		}
+	}
+	snapshot, err := journal.LoadLatestSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if snapshot == nil || snapshot.EventSequence != events[1].Sequence || string(snapshot.State) != fmt.Sprintf(`{"after":%d}`, events[1].Sequence) {
+		t.Errorf("Expected latest snapshot after event %d, but got %+v", events[1].Sequence, snapshot)
+	}
+}
+
+func testInstruments(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	instruments, ok := store.(storage.InstrumentStore)
+	if !ok {
+		t.Skip("store has no instruments")
+	}
+
+	apple := storage.Instrument{Isin: "US0378331005", Wkn: "865985", Tickers: []string{"AAPL", "APC"}, Name: "Apple Inc.",
+		Type: "stock", Exchange: "NASDAQ", Currency: "USD", Sector: "Technology", Country: "US"}
+	basf := storage.Instrument{Isin: "DE000BASF111", Wkn: "BASF11", Tickers: []string{"BAS"}, Name: "BASF SE",
+		Type: "stock", Exchange: "XETRA", Currency: "EUR", Sector: "Chemicals", Country: "DE"}
+	for _, instrument := range []storage.Instrument{apple, basf} {
+		if err := instruments.AddInstrument(ctx, &instrument); err != nil {
+			t.Fatalf("Failed to add instrument: %v", err)
+		}
+	}
+	//Ein Tickersymbol gehört nur zu einem Instrument
+	other := storage.Instrument{Isin: "US5949181045", Tickers: []string{"AAPL"}, Name: "Microsoft", Type: "stock"}
+	if err := instruments.AddInstrument(ctx, &other); err == nil {
+		t.Error("Expected error for a ticker symbol of another instrument, but got none")
+	}
+
+	loaded, err := instruments.LoadInstrument(ctx, apple.Isin)
+	if err != nil || loaded == nil || !slices.Equal(loaded.Tickers, apple.Tickers) || loaded.Wkn != apple.Wkn || loaded.Country != "US" {
+		t.Errorf("LoadInstrument: expected %+v, but got %+v (%v)", apple, loaded, err)
+	}
+	loaded, err = instruments.LoadInstrumentByTicker(ctx, "APC")
+	if err != nil || loaded == nil || loaded.Isin != apple.Isin {
+		t.Errorf("LoadInstrumentByTicker: expected %s, but got %+v (%v)", apple.Isin, loaded, err)
+	}
+	if loaded, err = instruments.LoadInstrument(ctx, "US5949181045"); err != nil || loaded != nil {
+		t.Errorf("Expected no instrument, but got %+v (%v)", loaded, err)
+	}
+
+	for _, tt := range []struct {
+		query    storage.InstrumentQuery
+		expected []string
+	}{
+		{storage.InstrumentQuery{}, []string{apple.Isin, basf.Isin}},
+		{storage.InstrumentQuery{Text: "bas"}, []string{basf.Isin}},
+		{storage.InstrumentQuery{Text: "apc"}, []string{apple.Isin}},
+		{storage.InstrumentQuery{Text: "865985"}, []string{apple.Isin}},
+		{storage.InstrumentQuery{Country: "DE", Type: "stock"}, []string{basf.Isin}},
+		{storage.InstrumentQuery{Sector: "Energy"}, []string{}},
+		{storage.InstrumentQuery{Limit: 1}, []string{apple.Isin}},
+	} {
+		found, err := instruments.SearchInstruments(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("Failed to search instruments: %v", err)
+		}
+		isins := make([]string, 0, len(found))
+		for _, instrument := range found {
+			isins = append(isins, instrument.Isin)
+		}
+		if !slices.Equal(isins, tt.expected) {
+			t.Errorf("SearchInstruments(%+v): expected %v, but got %v", tt.query, tt.expected, isins)
+		}
+	}
+
+	apple.Tickers = []string{"APC"}
+	apple.Exchange = "XETRA"
+	if err = instruments.UpdateInstrument(ctx, &apple); err != nil {
+		t.Fatalf("Failed to update instrument: %v", err)
+	}
+	if loaded, _ = instruments.LoadInstrumentByTicker(ctx, "AAPL"); loaded != nil {
+		t.Errorf("Expected removed ticker symbol AAPL to find nothing, but got %+v", loaded)
+	}
+	if loaded, _ = instruments.LoadInstrument(ctx, apple.Isin); loaded == nil || loaded.Exchange != "XETRA" {
+		t.Errorf("Expected exchange XETRA after update, but got %+v", loaded)
+	}
+
+	if err = instruments.RemoveInstrument(ctx, basf.Isin); err != nil {
+		t.Fatalf("Failed to remove instrument: %v", err)
+	}
+	if err = instruments.RemoveInstrument(ctx, basf.Isin); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when removing twice, but got %v", err)
+	}
+	if err = instruments.UpdateInstrument(ctx, &basf); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when updating a removed instrument, but got %v", err)
+	}
+}
+
+// queryAll holt alle Seiten einer Abfrage
+func queryAll(t *testing.T, store storage.Store, query storage.TransactionQuery) ([]uuid.UUID, int) {
+	ctx := context.Background()
+	t.Helper()
+	var result []uuid.UUID
+	pages := 0
+	for {
+		page, err := store.QueryTransactions(ctx, query)
+		if err != nil {
+			t.Fatalf("Failed to query transactions: %v", err)
+		}
+		pages++
+		result = append(result, ids(page.Transactions)...)
+		if page.NextCursor == "" {
+			return result, pages
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func testQueries(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	first := newTransaction(day, "buy", "MSFT", 0)
+	second := newTransaction(day, "buy", "AAPL", 1)
+	third := newTransaction(day.AddDate(0, 0, 3), "buy", "AAPL", 0)
+	third.Currency = "EUR"
+	fourth := newTransaction(day.AddDate(0, 1, 0), "sell", "AAPL", 0)
+	fifth := newTransaction(day.AddDate(0, 2, 0), "buy", "BTC", 0)
+	fifth.AssetType = "crypto"
+	addTransactions(t, store, fifth, third, first, fourth, second)
+
+	for _, tt := range []struct {
+		name     string
+		query    storage.TransactionQuery
+		expected []uuid.UUID
+	}{
+		{"all", storage.TransactionQuery{}, []uuid.UUID{first.Id, second.Id, third.Id, fourth.Id, fifth.Id}},
+		{"date range", storage.TransactionQuery{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 2, 0)}, []uuid.UUID{third.Id, fourth.Id}},
+		{"ticker symbol", storage.TransactionQuery{TickerSymbol: "AAPL"}, []uuid.UUID{second.Id, third.Id, fourth.Id}},
+		{"transaction type", storage.TransactionQuery{TransactionType: "sell"}, []uuid.UUID{fourth.Id}},
+		{"asset type", storage.TransactionQuery{AssetType: "crypto"}, []uuid.UUID{fifth.Id}},
+		{"currency", storage.TransactionQuery{Currency: "EUR"}, []uuid.UUID{third.Id}},
+		{"descending", storage.TransactionQuery{Descending: true, Limit: 2}, []uuid.UUID{fifth.Id, fourth.Id, third.Id, second.Id, first.Id}},
+		{"ticker symbol order", storage.TransactionQuery{SortBy: storage.SortByTickerSymbol, Limit: 2},
+			[]uuid.UUID{second.Id, third.Id, fourth.Id, fifth.Id, first.Id}},
+		{"pages", storage.TransactionQuery{TickerSymbol: "AAPL", Limit: 1}, []uuid.UUID{second.Id, third.Id, fourth.Id}},
+	} {
+		result, pages := queryAll(t, store, tt.query)
+		if !slices.Equal(result, tt.expected) {
+			t.Errorf("%s: expected %v, but got %v", tt.name, tt.expected, result)
+		}
+		if tt.query.Limit > 0 && pages != (len(tt.expected)+tt.query.Limit-1)/tt.query.Limit {
+			t.Errorf("%s: expected %d transactions in pages of %d, but got %d pages", tt.name, len(tt.expected), tt.query.Limit, pages)
+		}
+	}
+
+	//Der Cursor bleibt gültig, wenn davor eingefügt wird
+	page, _ := store.QueryTransactions(ctx, storage.TransactionQuery{Limit: 2})
+	addTransactions(t, store, newTransaction(day.AddDate(0, 0, -1), "buy", "AAPL", 0))
+	page, err := store.QueryTransactions(ctx, storage.TransactionQuery{Limit: 2, Cursor: page.NextCursor})
+	if err != nil || !slices.Equal(ids(page.Transactions), []uuid.UUID{third.Id, fourth.Id}) {
+		t.Errorf("Expected second page %v, but got %v (%v)", []uuid.UUID{third.Id, fourth.Id}, ids(page.Transactions), err)
+	}
+	if _, err = store.QueryTransactions(ctx, storage.TransactionQuery{Cursor: "invalid"}); err == nil {
+		t.Error("Expected error for an invalid cursor, but got none")
+	}
+	if _, err = store.QueryTransactions(ctx, storage.TransactionQuery{SortBy: "price"}); err == nil {
+		t.Error("Expected error for an unknown sort order, but got none")
+	}
+
+	newGain := func(sell storage.Transaction, currency string) storage.RealizedGain {
+		gain := storage.RealizedGain{Id: uuid.New(), SellTransactionId: sell.Id, BuyTransactionId: second.Id,
+			Asset: sell.Asset, Amount: 10, IsProfit: true, Quantity: 1, BuyPrice: 150, SellPrice: 160, Currency: currency}
+		if err := store.AddRealizedGain(ctx, gain); err != nil {
+			t.Fatalf("Failed to insert realized gain: %v", err)
+		}
+		return gain
+	}
+	later := newTransaction(day.AddDate(0, 3, 0), "sell", "AAPL", 0)
+	addTransactions(t, store, later)
+	laterGain := newGain(later, "USD")
+	firstGain := newGain(fourth, "USD")
+	euroGain := newGain(fourth, "EUR")
+
+	gains, err := store.QueryRealizedGains(ctx, storage.RealizedGainQuery{To: day.AddDate(0, 2, 0), Currency: "EUR"})
+	if err != nil || len(gains.RealizedGains) != 1 || gains.RealizedGains[0] != euroGain {
+		t.Errorf("Expected %+v, but got %+v (%v)", euroGain, gains, err)
+	}
+	var gainIds []uuid.UUID
+	query := storage.RealizedGainQuery{TickerSymbol: "AAPL", Limit: 1}
+	for {
+		gains, err = store.QueryRealizedGains(ctx, query)
+		if err != nil {
+			t.Fatalf("Failed to query realized gains: %v", err)
+		}
+		for _, gain := range gains.RealizedGains {
+			gainIds = append(gainIds, gain.Id)
+		}
+		if gains.NextCursor == "" {
+			break
+		}
+		query.Cursor = gains.NextCursor
+	}
+	if len(gainIds) != 3 || gainIds[2] != laterGain.Id || !slices.Contains(gainIds[:2], firstGain.Id) || !slices.Contains(gainIds[:2], euroGain.Id) {
+		t.Errorf("Expected realized gains ordered by sell date, but got %v", gainIds)
+	}
+}
+
+func testIteration(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	//Mehr als eine Seite von IterateTransactions
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var expected []uuid.UUID
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	for i := range 2100 {
+		transaction := newTransaction(day.AddDate(0, 0, i/3), "buy", "BTC", i%3)
+		addTransactions(t, store, transaction)
+		expected = append(expected, transaction.Id)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	//Während der Iteration kann in den Store geschrieben werden
+	var result []uuid.UUID
+	for transaction, err := range store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+		if err != nil {
+			t.Fatalf("Failed to iterate transactions: %v", err)
+		}
+		result = append(result, transaction.Id)
+		if len(result)%1000 == 0 {
+			if err = store.AddUnclosedTransaction(ctx, transaction); err != nil {
+				t.Fatalf("Failed to insert unclosed transaction during iteration: %v", err)
+			}
+		}
+	}
+	if !slices.Equal(result, expected) {
+		t.Errorf("Expected %d transactions in chronological order, but got %d", len(expected), len(result))
+	}
+	unclosed, _ := store.ReadAllUnclosedTransactions(ctx)
+	if len(unclosed["BTC"]) != 2 {
+		t.Errorf("Expected 2 unclosed transactions written during iteration, but got %d", len(unclosed["BTC"]))
+	}
+
+	count := 0
+	for _, err := range store.IterateTransactions(ctx, storage.TransactionQuery{From: day.AddDate(0, 0, 100), Limit: 1200}) {
+		if err != nil {
+			t.Fatalf("Failed to iterate transactions: %v", err)
+		}
+		count++
+	}
+	if count != 1200 {
+		t.Errorf("Expected 1200 transactions with limit, but got %d", count)
+	}
+	for range store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+		break
+	}
+	var iterationErr error
+	for _, err := range store.IterateTransactions(ctx, storage.TransactionQuery{Cursor: "invalid"}) {
+		iterationErr = err
+	}
+	if iterationErr == nil {
+		t.Error("Expected error for an invalid cursor, but got none")
+	}
+}
+
+func testCancellation(t *testing.T, store storage.Store) {
+	kept := newTransaction(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	addTransactions(t, store, kept)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	discarded := newTransaction(time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC), "buy", "AAPL", 0)
+	if err := store.AddTransaction(ctx, &discarded); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled when adding with a cancelled context, but got %v", err)
+	}
+	for _, err := range store.IterateTransactions(ctx, storage.TransactionQuery{}) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled when iterating with a cancelled context, but got %v", err)
+		}
+		break
+	}
+	if err := store.Begin(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled when beginning with a cancelled context, but got %v", err)
+	}
+
+	//Wird der Context während der Transaktion abgebrochen, wird nichts gespeichert
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := store.Begin(ctx); err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := store.AddTransaction(ctx, &discarded); err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	cancel()
+	if err := store.Commit(); err == nil {
+		t.Error("Expected error when committing after the context was cancelled, but got none")
+	}
+	if err := store.Rollback(); err == nil {
+		t.Error("Expected no open transaction after the failed commit, but rollback succeeded")
+	}
+
+	transactions, err := store.ReadAllTransactions(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read transactions: %v", err)
+	}
+	if !slices.Equal(ids(transactions), []uuid.UUID{kept.Id}) {
+		t.Errorf("Expected only the transaction before the cancelled ones, but got %+v", transactions)
+	}
+}
@@ -6,17 +6,23 @@ import (
 	"github.com/google/uuid"
 )
 
+// Transaction wird beim Binden nicht geprüft, Pflichtfelder und Werte prüft portfolio.Validator.
 type Transaction struct {
-	Id              uuid.UUID
-	Date            time.Time `json:"date" xml:"dat" binding:"required"`
-	TransactionType string    `json:"transactionType" xml:"transactionType" binding:"required"` // buy, sell
-	AssetType       string    `json:"assetType" xml:"assetType" binding:"required"`             //stock, crypto, forex
-	Asset           string    `json:"asset" xml:"asset" binding:"required"`
-	TickerSymbol    string    `json:"tickerSymbol" xml:"tickerSymbol" binding:"required"`
-	Quantity        float64   `json:"quantity" xml:"quantity" binding:"required"` //float64, um kombatibel mit der SQLite Datenbank zu sein.
-	Price           float64   `json:"price" xml:"price" binding:"required"`
-	Fees            float64   `json:"fees" xml:"fees" binding:"required"`
-	Currency        string    `json:"currency" xml:"currency" binding:"required"`
+	Id              uuid.UUID `yaml:"id"`
+	Date            time.Time `json:"date" xml:"dat" yaml:"date"`
+	TransactionType string    `json:"transactionType" xml:"transactionType" yaml:"transactionType"` // buy, sell
+	AssetType       string    `json:"assetType" xml:"assetType" yaml:"assetType"`                   //stock, crypto, forex
+	Asset           string    `json:"asset" xml:"asset" yaml:"asset"`
+	TickerSymbol    string    `json:"tickerSymbol" xml:"tickerSymbol" yaml:"tickerSymbol"`
+	Quantity        float64   `json:"quantity" xml:"quantity" yaml:"quantity"` //float64, um kombatibel mit der SQLite Datenbank zu sein.
+	Price           float64   `json:"price" xml:"price" yaml:"price"`
+	Fees            float64   `json:"fees" xml:"fees" yaml:"fees"`
+	Currency        string    `json:"currency" xml:"currency" yaml:"currency"`
+	Sequence        int       `json:"sequence" xml:"sequence" yaml:"sequence"`          //Reihenfolge von Transaktionen mit gleichem Datum
+	Broker          string    `json:"broker" xml:"broker" yaml:"broker"`                //Depotbank / Broker
+	OrderNumber     string    `json:"orderNumber" xml:"orderNumber" yaml:"orderNumber"` //Auftragsnummer des Brokers
+	ExecutionId     string    `json:"executionId" xml:"executionId" yaml:"executionId"` //Ausführungs-Id, falls ein Auftrag in mehreren Teilen ausgeführt wird
+	Isin            string    `json:"isin" xml:"isin" yaml:"isin"`                      //Verweis auf das Instrument, leer ohne Stammdaten
 }
 
 // TotalPrice berechnet und gibt den Gesamtpreis zurück